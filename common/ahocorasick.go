@@ -0,0 +1,127 @@
+/**
+ * Copyright (c) 2014-2015, GoBelieve
+ * All rights reserved.
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place, Suite 330, Boston, MA  02111-1307  USA
+ */
+
+package common
+
+import "unicode"
+
+type acNode struct {
+	children map[rune]*acNode
+	fail     *acNode
+	//以此节点结尾的词在words中的下标
+	outputs  []int
+}
+
+func newACNode() *acNode {
+	return &acNode{children: make(map[rune]*acNode)}
+}
+
+//多模式匹配, 按rune匹配且忽略大小写
+type AhoCorasick struct {
+	root  *acNode
+	words [][]rune
+}
+
+type ACMatch struct {
+	Index int //words中的下标
+	Start int //rune位置
+	End   int //不包含
+}
+
+func NewAhoCorasick(words []string) *AhoCorasick {
+	ac := &AhoCorasick{root: newACNode()}
+	for _, w := range words {
+		runes := []rune(w)
+		if len(runes) == 0 {
+			continue
+		}
+		node := ac.root
+		for _, r := range runes {
+			r = unicode.ToLower(r)
+			child, ok := node.children[r]
+			if !ok {
+				child = newACNode()
+				node.children[r] = child
+			}
+			node = child
+		}
+		node.outputs = append(node.outputs, len(ac.words))
+		ac.words = append(ac.words, runes)
+	}
+	ac.build()
+	return ac
+}
+
+//广度优先计算失败指针
+func (ac *AhoCorasick) build() {
+	queue := make([]*acNode, 0)
+	for _, child := range ac.root.children {
+		child.fail = ac.root
+		queue = append(queue, child)
+	}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for r, child := range node.children {
+			f := node.fail
+			for f != nil {
+				if next, ok := f.children[r]; ok {
+					child.fail = next
+					break
+				}
+				f = f.fail
+			}
+			if child.fail == nil {
+				child.fail = ac.root
+			}
+			child.outputs = append(child.outputs, child.fail.outputs...)
+			queue = append(queue, child)
+		}
+	}
+}
+
+func (ac *AhoCorasick) Len() int {
+	return len(ac.words)
+}
+
+func (ac *AhoCorasick) Word(index int) string {
+	return string(ac.words[index])
+}
+
+func (ac *AhoCorasick) FindAll(text []rune) []ACMatch {
+	var matches []ACMatch
+	node := ac.root
+	for i, r := range text {
+		r = unicode.ToLower(r)
+		for node != ac.root {
+			if _, ok := node.children[r]; ok {
+				break
+			}
+			node = node.fail
+		}
+		if next, ok := node.children[r]; ok {
+			node = next
+		}
+		for _, index := range node.outputs {
+			n := len(ac.words[index])
+			matches = append(matches, ACMatch{Index: index, Start: i + 1 - n, End: i + 1})
+		}
+	}
+	return matches
+}
@@ -0,0 +1,46 @@
+package common
+
+import "testing"
+
+func Test_ACMatch(t *testing.T) {
+	ac := NewAhoCorasick([]string{"he", "she", "his", "hers", ""})
+	if ac.Len() != 4 {
+		t.Fatal("empty word isn't skipped:", ac.Len())
+	}
+
+	matches := ac.FindAll([]rune("ushers"))
+	expect := []ACMatch{{1, 1, 4}, {0, 2, 4}, {3, 2, 6}}
+	if len(matches) != len(expect) {
+		t.Fatalf("matches:%v expect:%v", matches, expect)
+	}
+	for i, m := range matches {
+		if m != expect[i] {
+			t.Errorf("match:%v expect:%v", m, expect[i])
+		}
+	}
+}
+
+func Test_ACUnicode(t *testing.T) {
+	ac := NewAhoCorasick([]string{"测试", "敏感词"})
+	text := []rune("这是一个测试, 包含敏感词")
+	matches := ac.FindAll(text)
+	if len(matches) != 2 {
+		t.Fatal("matches:", matches)
+	}
+	if string(text[matches[0].Start:matches[0].End]) != "测试" {
+		t.Error("invalid position:", matches[0])
+	}
+	if string(text[matches[1].Start:matches[1].End]) != "敏感词" {
+		t.Error("invalid position:", matches[1])
+	}
+}
+
+func Test_ACIgnoreCase(t *testing.T) {
+	ac := NewAhoCorasick([]string{"Spam"})
+	if len(ac.FindAll([]rune("SPAM and spam"))) != 2 {
+		t.Error("match isn't case insensitive")
+	}
+	if len(ac.FindAll([]rune("spa m"))) != 0 {
+		t.Error("unexpected match")
+	}
+}
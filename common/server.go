@@ -0,0 +1,34 @@
+/**
+ * Copyright (c) 2014-2015, GoBelieve
+ * All rights reserved.
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place, Suite 330, Boston, MA  02111-1307  USA
+ */
+
+
+package common
+
+//im_server和dispatcher共用的定义
+
+//上报负载的im服务器集合
+const IM_SERVERS_KEY = "im_servers"
+
+//负载上报的间隔(秒), 超过3个间隔没有上报的服务器由dispatcher忽略
+const LOAD_REPORT_INTERVAL = 5
+
+//平台号
+const PLATFORM_IOS = 1
+const PLATFORM_ANDROID = 2
+const PLATFORM_WEB = 3
@@ -0,0 +1,200 @@
+/**
+ * Copyright (c) 2014-2015, GoBelieve
+ * All rights reserved.
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place, Suite 330, Boston, MA  02111-1307  USA
+ */
+
+package common
+
+import "os"
+import "net"
+import "sync"
+import "time"
+import "errors"
+import "io/ioutil"
+import "crypto/tls"
+import "crypto/x509"
+import log "github.com/golang/glog"
+
+//证书文件的检查间隔
+const CERT_RELOAD_INTERVAL = 10
+
+const TLS_HANDSHAKE_TIMEOUT = 10 * time.Second
+
+//服务之间必须双向认证, 没有配置证书时返回nil使用明文连接
+func NewClusterCertReloader(cert_file, key_file, ca_file string) *CertReloader {
+	if len(cert_file) == 0 {
+		return nil
+	}
+	if len(key_file) == 0 || len(ca_file) == 0 {
+		log.Fatal("cluster tls need cert, key and ca file")
+	}
+	r, err := NewCertReloader(cert_file, key_file, ca_file)
+	if err != nil {
+		log.Fatal("load cluster certificate error:", err)
+	}
+	return r
+}
+
+//证书和ca文件修改后自动重新加载, 已建立的连接不受影响
+type CertReloader struct {
+	cert_file string
+	key_file  string
+	ca_file   string
+
+	mutex sync.Mutex
+	cert  *tls.Certificate
+	pool  *x509.CertPool
+	mtime time.Time
+}
+
+//ca_file为空时不验证对端证书
+func NewCertReloader(cert_file, key_file, ca_file string) (*CertReloader, error) {
+	r := &CertReloader{cert_file:cert_file, key_file:key_file, ca_file:ca_file}
+	err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	go r.Run()
+	return r, nil
+}
+
+func (r *CertReloader) modTime() time.Time {
+	var mtime time.Time
+	for _, f := range []string{r.cert_file, r.key_file, r.ca_file} {
+		if len(f) == 0 {
+			continue
+		}
+		info, err := os.Stat(f)
+		if err != nil {
+			continue
+		}
+		if info.ModTime().After(mtime) {
+			mtime = info.ModTime()
+		}
+	}
+	return mtime
+}
+
+func (r *CertReloader) load() error {
+	mtime := r.modTime()
+	cert, err := tls.LoadX509KeyPair(r.cert_file, r.key_file)
+	if err != nil {
+		return err
+	}
+
+	var pool *x509.CertPool
+	if len(r.ca_file) > 0 {
+		data, err := ioutil.ReadFile(r.ca_file)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return errors.New("no certificate in ca file:" + r.ca_file)
+		}
+	}
+
+	r.mutex.Lock()
+	r.cert = &cert
+	r.pool = pool
+	r.mtime = mtime
+	r.mutex.Unlock()
+	return nil
+}
+
+//加载失败时继续使用原来的证书
+func (r *CertReloader) Run() {
+	for {
+		time.Sleep(CERT_RELOAD_INTERVAL * time.Second)
+
+		r.mutex.Lock()
+		mtime := r.mtime
+		r.mutex.Unlock()
+		if !r.modTime().After(mtime) {
+			continue
+		}
+
+		err := r.load()
+		if err != nil {
+			log.Warning("reload certificate error:", err)
+			continue
+		}
+		log.Infof("reload certificate:%s", r.cert_file)
+	}
+}
+
+func (r *CertReloader) Certificate() (*tls.Certificate, *x509.CertPool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.cert, r.pool
+}
+
+//服务端配置, ca_file不为空时要求客户端提供由该ca签发的证书
+func (r *CertReloader) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := r.Certificate()
+			c := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+			}
+			if pool != nil {
+				c.ClientCAs = pool
+				c.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return c, nil
+		},
+	}
+}
+
+//客户端配置, 使用ca_file验证服务端证书并提供自己的证书
+func (r *CertReloader) ClientConfig(server_name string) *tls.Config {
+	cert, pool := r.Certificate()
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		ServerName:   server_name,
+		Certificates: []tls.Certificate{*cert},
+		RootCAs:      pool,
+	}
+}
+
+//在tcp连接上完成tls握手, 握手失败时关闭连接
+func ClientHandshake(conn net.Conn, config *tls.Config) (net.Conn, error) {
+	tconn := tls.Client(conn, config)
+	tconn.SetDeadline(time.Now().Add(TLS_HANDSHAKE_TIMEOUT))
+	err := tconn.Handshake()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tconn.SetDeadline(time.Time{})
+	return tconn, nil
+}
+
+//服务端握手, 对端证书不是配置的ca签发时握手失败
+func ServerHandshake(conn net.Conn, config *tls.Config) (net.Conn, error) {
+	tconn := tls.Server(conn, config)
+	tconn.SetDeadline(time.Now().Add(TLS_HANDSHAKE_TIMEOUT))
+	err := tconn.Handshake()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tconn.SetDeadline(time.Time{})
+	return tconn, nil
+}
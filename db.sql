@@ -1,14 +1,265 @@
 CREATE DATABASE IF NOT EXISTS im DEFAULT CHARACTER SET utf8;
 use im;
 
+#所有表都按appid区分, 已有数据库升级见migrate_appid.sql
 CREATE TABLE IF NOT EXISTS `group`(
 	   id BIGINT AUTO_INCREMENT PRIMARY KEY,
-           appid  BIGINT,
-	   master BIGINT,
-           super TINYINT,
-	   name VARCHAR(255));
+           appid BIGINT NOT NULL,
+           title VARCHAR(255),
+           `desc` VARCHAR(1024),
+           owner BIGINT,
+           gouhao VARCHAR(64),
+           isPrivate TINYINT DEFAULT 0,
+           isAllowInvite TINYINT DEFAULT 1,
+           isDeleted TINYINT DEFAULT 0,
+           type TINYINT DEFAULT 1,
+           create_time INT,
+           KEY(appid));
 
-CREATE TABLE IF NOT EXISTS group_member(group_id BIGINT, uid BIGINT, PRIMARY KEY(group_id, uid));
+CREATE TABLE IF NOT EXISTS user_app(
+	   id BIGINT NOT NULL,
+           appid BIGINT NOT NULL,
+           username VARCHAR(255),
+           access_token VARCHAR(255),
+           PRIMARY KEY(appid, id),
+           KEY(access_token));
+
+CREATE TABLE IF NOT EXISTS user_blacks(
+	   appid BIGINT NOT NULL,
+           user_id BIGINT NOT NULL,
+           blacks TEXT,
+           update_time INT,
+           PRIMARY KEY(appid, user_id));
+
+CREATE TABLE IF NOT EXISTS group_members_00(
+	   appid BIGINT NOT NULL,
+           group_id BIGINT NOT NULL,
+           user_id BIGINT NOT NULL,
+           create_time INT,
+           update_time INT,
+           PRIMARY KEY(appid, group_id, user_id));
+
+CREATE TABLE IF NOT EXISTS group_members_01(
+	   appid BIGINT NOT NULL,
+           group_id BIGINT NOT NULL,
+           user_id BIGINT NOT NULL,
+           create_time INT,
+           update_time INT,
+           PRIMARY KEY(appid, group_id, user_id));
+
+CREATE TABLE IF NOT EXISTS group_members_02(
+	   appid BIGINT NOT NULL,
+           group_id BIGINT NOT NULL,
+           user_id BIGINT NOT NULL,
+           create_time INT,
+           update_time INT,
+           PRIMARY KEY(appid, group_id, user_id));
+
+CREATE TABLE IF NOT EXISTS group_members_03(
+	   appid BIGINT NOT NULL,
+           group_id BIGINT NOT NULL,
+           user_id BIGINT NOT NULL,
+           create_time INT,
+           update_time INT,
+           PRIMARY KEY(appid, group_id, user_id));
+
+CREATE TABLE IF NOT EXISTS group_members_04(
+	   appid BIGINT NOT NULL,
+           group_id BIGINT NOT NULL,
+           user_id BIGINT NOT NULL,
+           create_time INT,
+           update_time INT,
+           PRIMARY KEY(appid, group_id, user_id));
+
+CREATE TABLE IF NOT EXISTS group_members_05(
+	   appid BIGINT NOT NULL,
+           group_id BIGINT NOT NULL,
+           user_id BIGINT NOT NULL,
+           create_time INT,
+           update_time INT,
+           PRIMARY KEY(appid, group_id, user_id));
+
+CREATE TABLE IF NOT EXISTS group_members_06(
+	   appid BIGINT NOT NULL,
+           group_id BIGINT NOT NULL,
+           user_id BIGINT NOT NULL,
+           create_time INT,
+           update_time INT,
+           PRIMARY KEY(appid, group_id, user_id));
+
+CREATE TABLE IF NOT EXISTS group_members_07(
+	   appid BIGINT NOT NULL,
+           group_id BIGINT NOT NULL,
+           user_id BIGINT NOT NULL,
+           create_time INT,
+           update_time INT,
+           PRIMARY KEY(appid, group_id, user_id));
+
+CREATE TABLE IF NOT EXISTS group_members_08(
+	   appid BIGINT NOT NULL,
+           group_id BIGINT NOT NULL,
+           user_id BIGINT NOT NULL,
+           create_time INT,
+           update_time INT,
+           PRIMARY KEY(appid, group_id, user_id));
+
+CREATE TABLE IF NOT EXISTS group_members_09(
+	   appid BIGINT NOT NULL,
+           group_id BIGINT NOT NULL,
+           user_id BIGINT NOT NULL,
+           create_time INT,
+           update_time INT,
+           PRIMARY KEY(appid, group_id, user_id));
+
+CREATE TABLE IF NOT EXISTS user_groups_00(
+	   appid BIGINT NOT NULL,
+           group_id BIGINT NOT NULL,
+           user_id BIGINT NOT NULL,
+           isOwner TINYINT DEFAULT 0,
+           type TINYINT DEFAULT 1,
+           PRIMARY KEY(appid, user_id, group_id));
+
+CREATE TABLE IF NOT EXISTS user_groups_01(
+	   appid BIGINT NOT NULL,
+           group_id BIGINT NOT NULL,
+           user_id BIGINT NOT NULL,
+           isOwner TINYINT DEFAULT 0,
+           type TINYINT DEFAULT 1,
+           PRIMARY KEY(appid, user_id, group_id));
+
+CREATE TABLE IF NOT EXISTS user_groups_02(
+	   appid BIGINT NOT NULL,
+           group_id BIGINT NOT NULL,
+           user_id BIGINT NOT NULL,
+           isOwner TINYINT DEFAULT 0,
+           type TINYINT DEFAULT 1,
+           PRIMARY KEY(appid, user_id, group_id));
+
+CREATE TABLE IF NOT EXISTS user_groups_03(
+	   appid BIGINT NOT NULL,
+           group_id BIGINT NOT NULL,
+           user_id BIGINT NOT NULL,
+           isOwner TINYINT DEFAULT 0,
+           type TINYINT DEFAULT 1,
+           PRIMARY KEY(appid, user_id, group_id));
+
+CREATE TABLE IF NOT EXISTS user_groups_04(
+	   appid BIGINT NOT NULL,
+           group_id BIGINT NOT NULL,
+           user_id BIGINT NOT NULL,
+           isOwner TINYINT DEFAULT 0,
+           type TINYINT DEFAULT 1,
+           PRIMARY KEY(appid, user_id, group_id));
+
+CREATE TABLE IF NOT EXISTS user_groups_05(
+	   appid BIGINT NOT NULL,
+           group_id BIGINT NOT NULL,
+           user_id BIGINT NOT NULL,
+           isOwner TINYINT DEFAULT 0,
+           type TINYINT DEFAULT 1,
+           PRIMARY KEY(appid, user_id, group_id));
+
+CREATE TABLE IF NOT EXISTS user_groups_06(
+	   appid BIGINT NOT NULL,
+           group_id BIGINT NOT NULL,
+           user_id BIGINT NOT NULL,
+           isOwner TINYINT DEFAULT 0,
+           type TINYINT DEFAULT 1,
+           PRIMARY KEY(appid, user_id, group_id));
+
+CREATE TABLE IF NOT EXISTS user_groups_07(
+	   appid BIGINT NOT NULL,
+           group_id BIGINT NOT NULL,
+           user_id BIGINT NOT NULL,
+           isOwner TINYINT DEFAULT 0,
+           type TINYINT DEFAULT 1,
+           PRIMARY KEY(appid, user_id, group_id));
+
+CREATE TABLE IF NOT EXISTS user_groups_08(
+	   appid BIGINT NOT NULL,
+           group_id BIGINT NOT NULL,
+           user_id BIGINT NOT NULL,
+           isOwner TINYINT DEFAULT 0,
+           type TINYINT DEFAULT 1,
+           PRIMARY KEY(appid, user_id, group_id));
+
+CREATE TABLE IF NOT EXISTS user_groups_09(
+	   appid BIGINT NOT NULL,
+           group_id BIGINT NOT NULL,
+           user_id BIGINT NOT NULL,
+           isOwner TINYINT DEFAULT 0,
+           type TINYINT DEFAULT 1,
+           PRIMARY KEY(appid, user_id, group_id));
+
+CREATE TABLE IF NOT EXISTS user_friends_00(
+	   appid BIGINT NOT NULL,
+           user_id BIGINT NOT NULL,
+           friend_id BIGINT NOT NULL,
+           create_time INT,
+           PRIMARY KEY(appid, user_id, friend_id));
+
+CREATE TABLE IF NOT EXISTS user_friends_01(
+	   appid BIGINT NOT NULL,
+           user_id BIGINT NOT NULL,
+           friend_id BIGINT NOT NULL,
+           create_time INT,
+           PRIMARY KEY(appid, user_id, friend_id));
+
+CREATE TABLE IF NOT EXISTS user_friends_02(
+	   appid BIGINT NOT NULL,
+           user_id BIGINT NOT NULL,
+           friend_id BIGINT NOT NULL,
+           create_time INT,
+           PRIMARY KEY(appid, user_id, friend_id));
+
+CREATE TABLE IF NOT EXISTS user_friends_03(
+	   appid BIGINT NOT NULL,
+           user_id BIGINT NOT NULL,
+           friend_id BIGINT NOT NULL,
+           create_time INT,
+           PRIMARY KEY(appid, user_id, friend_id));
+
+CREATE TABLE IF NOT EXISTS user_friends_04(
+	   appid BIGINT NOT NULL,
+           user_id BIGINT NOT NULL,
+           friend_id BIGINT NOT NULL,
+           create_time INT,
+           PRIMARY KEY(appid, user_id, friend_id));
+
+CREATE TABLE IF NOT EXISTS user_friends_05(
+	   appid BIGINT NOT NULL,
+           user_id BIGINT NOT NULL,
+           friend_id BIGINT NOT NULL,
+           create_time INT,
+           PRIMARY KEY(appid, user_id, friend_id));
+
+CREATE TABLE IF NOT EXISTS user_friends_06(
+	   appid BIGINT NOT NULL,
+           user_id BIGINT NOT NULL,
+           friend_id BIGINT NOT NULL,
+           create_time INT,
+           PRIMARY KEY(appid, user_id, friend_id));
+
+CREATE TABLE IF NOT EXISTS user_friends_07(
+	   appid BIGINT NOT NULL,
+           user_id BIGINT NOT NULL,
+           friend_id BIGINT NOT NULL,
+           create_time INT,
+           PRIMARY KEY(appid, user_id, friend_id));
+
+CREATE TABLE IF NOT EXISTS user_friends_08(
+	   appid BIGINT NOT NULL,
+           user_id BIGINT NOT NULL,
+           friend_id BIGINT NOT NULL,
+           create_time INT,
+           PRIMARY KEY(appid, user_id, friend_id));
+
+CREATE TABLE IF NOT EXISTS user_friends_09(
+	   appid BIGINT NOT NULL,
+           user_id BIGINT NOT NULL,
+           friend_id BIGINT NOT NULL,
+           create_time INT,
+           PRIMARY KEY(appid, user_id, friend_id));
 
 
 SHOW TABLES;
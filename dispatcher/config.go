@@ -0,0 +1,88 @@
+/**
+ * Copyright (c) 2014-2015, GoBelieve
+ * All rights reserved.
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place, Suite 330, Boston, MA  02111-1307  USA
+ */
+
+
+package main
+
+import "log"
+import "strconv"
+import "strings"
+import "github.com/richmonkey/cfg"
+
+type DispatcherConfig struct {
+	http_listen_address string
+	redis_address       string
+	redis_password      string
+
+	//返回给客户端的服务器数量
+	dispatch_count int
+	//客户端没有指定区域时使用
+	default_region string
+	//连接数相同时cpu的权重, score = connections * (1 + cpu_weight * cpu / 100)
+	cpu_weight     float64
+	//分数和最低分相差在这个百分比之内的服务器随机排列
+	dispatch_tolerance float64
+}
+
+func get_string(app_cfg map[string]string, key string) string {
+	concurrency, present := app_cfg[key]
+	if !present {
+		log.Fatalf("key:%s non exist", key)
+	}
+	return concurrency
+}
+
+func get_opt_string(app_cfg map[string]string, key string) string {
+	concurrency, present := app_cfg[key]
+	if !present {
+		return ""
+	}
+	return concurrency
+}
+
+func get_opt_int(app_cfg map[string]string, key string, def int) int {
+	concurrency, present := app_cfg[key]
+	if !present {
+		return def
+	}
+	n, err := strconv.Atoi(concurrency)
+	if err != nil {
+		log.Fatalf("key:%s is't integer", key)
+	}
+	return n
+}
+
+func read_dispatcher_cfg(cfg_path string) *DispatcherConfig {
+	config := new(DispatcherConfig)
+	app_cfg := make(map[string]string)
+	err := cfg.Load(cfg_path, app_cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	config.http_listen_address = get_string(app_cfg, "http_listen_address")
+	config.redis_address = get_string(app_cfg, "redis_address")
+	config.redis_password = get_opt_string(app_cfg, "redis_password")
+
+	config.dispatch_count = get_opt_int(app_cfg, "dispatch_count", 3)
+	config.default_region = strings.TrimSpace(get_opt_string(app_cfg, "default_region"))
+	config.cpu_weight = float64(get_opt_int(app_cfg, "cpu_weight", 1))
+	config.dispatch_tolerance = float64(get_opt_int(app_cfg, "dispatch_tolerance", 20))
+	return config
+}
@@ -0,0 +1,166 @@
+/**
+ * Copyright (c) 2014-2015, GoBelieve
+ * All rights reserved.
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place, Suite 330, Boston, MA  02111-1307  USA
+ */
+
+
+package main
+
+import "fmt"
+import "flag"
+import "time"
+import "runtime"
+import "math/rand"
+import "strconv"
+import "net/http"
+import "encoding/json"
+import log "github.com/golang/glog"
+import "github.com/garyburd/redigo/redis"
+import "im_service/common"
+
+var config *DispatcherConfig
+var redis_pool *redis.Pool
+
+func NewRedisPool(server, password string) *redis.Pool {
+	return &redis.Pool{
+		MaxIdle:     10,
+		MaxActive:   100,
+		IdleTimeout: 480 * time.Second,
+		Dial: func() (redis.Conn, error) {
+			c, err := redis.Dial("tcp", server)
+			if err != nil {
+				return nil, err
+			}
+			if len(password) > 0 {
+				if _, err := c.Do("AUTH", password); err != nil {
+					c.Close()
+					return nil, err
+				}
+			}
+			return c, err
+		},
+	}
+}
+
+func WriteHttpError(status int, err string, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	obj := make(map[string]interface{})
+	meta := make(map[string]interface{})
+	meta["code"] = status
+	meta["message"] = err
+	obj["meta"] = meta
+	b, _ := json.Marshal(obj)
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+func WriteHttpObj(data map[string]interface{}, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	obj := make(map[string]interface{})
+	obj["data"] = data
+	b, _ := json.Marshal(obj)
+	w.Write(b)
+}
+
+//GET /dispatch?region=xx&platform_id=1
+//返回按优先级排序的服务器地址, 客户端依次尝试
+func Dispatch(w http.ResponseWriter, req *http.Request) {
+	region := req.URL.Query().Get("region")
+	if len(region) == 0 {
+		region = config.default_region
+	}
+	platform_id := 0
+	if s := req.URL.Query().Get("platform_id"); len(s) > 0 {
+		var err error
+		platform_id, err = strconv.Atoi(s)
+		if err != nil {
+			WriteHttpError(400, "invalid param", w)
+			return
+		}
+	}
+
+	ranked := RankServers(region, platform_id)
+	if len(ranked) == 0 {
+		log.Warningf("no server for region:%s platform:%d", region, platform_id)
+		WriteHttpError(503, "no available server", w)
+		return
+	}
+
+	array := make([]interface{}, 0, len(ranked))
+	for _, s := range ranked {
+		obj := make(map[string]interface{})
+		obj["server_id"] = s.server_id
+		obj["region"] = s.region
+		if platform_id == common.PLATFORM_WEB {
+			obj["socket_io_address"] = s.socket_io_address
+			obj["ws_address"] = s.ws_address
+		} else {
+			obj["address"] = s.address
+			obj["tls_address"] = s.tls_address
+			if platform_id == 0 {
+				obj["socket_io_address"] = s.socket_io_address
+				obj["ws_address"] = s.ws_address
+			}
+		}
+		array = append(array, obj)
+	}
+
+	data := make(map[string]interface{})
+	data["servers"] = array
+	WriteHttpObj(data, w)
+}
+
+//所有服务器的负载, 运维查看
+func Servers(w http.ResponseWriter, req *http.Request) {
+	array := make([]interface{}, 0)
+	for _, s := range GetServers() {
+		obj := make(map[string]interface{})
+		obj["server_id"] = s.server_id
+		obj["region"] = s.region
+		obj["connections"] = s.connections
+		obj["cpu"] = s.cpu
+		obj["draining"] = s.draining
+		obj["timestamp"] = s.timestamp
+		array = append(array, obj)
+	}
+	data := make(map[string]interface{})
+	data["servers"] = array
+	WriteHttpObj(data, w)
+}
+
+func main() {
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	rand.Seed(time.Now().UnixNano())
+	flag.Parse()
+	if len(flag.Args()) == 0 {
+		fmt.Println("usage: dispatcher config")
+		return
+	}
+
+	config = read_dispatcher_cfg(flag.Args()[0])
+	log.Infof("listen:%s redis:%s\n", config.http_listen_address, config.redis_address)
+
+	redis_pool = NewRedisPool(config.redis_address, config.redis_password)
+	go LoadServersLoop()
+
+	http.HandleFunc("/dispatch", Dispatch)
+	http.HandleFunc("/servers", Servers)
+	err := http.ListenAndServe(config.http_listen_address, nil)
+	if err != nil {
+		log.Fatal("listen error:", err)
+	}
+}
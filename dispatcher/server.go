@@ -0,0 +1,199 @@
+/**
+ * Copyright (c) 2014-2015, GoBelieve
+ * All rights reserved.
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place, Suite 330, Boston, MA  02111-1307  USA
+ */
+
+
+package main
+
+import "fmt"
+import "sort"
+import "math/rand"
+import "sync"
+import "time"
+import "strconv"
+import log "github.com/golang/glog"
+import "github.com/garyburd/redigo/redis"
+import "im_service/common"
+
+//im_server上报的负载
+type IMServer struct {
+	server_id         string
+	region            string
+	address           string
+	tls_address       string
+	socket_io_address string
+	ws_address        string
+	connections       int64
+	cpu               float64
+	draining          bool
+	timestamp         int64
+}
+
+func (s *IMServer) Score() float64 {
+	return float64(s.connections) * (1 + config.cpu_weight * s.cpu / 100)
+}
+
+//web客户端只能使用socket.io或者websocket, 其它平台使用tcp
+func (s *IMServer) Support(platform_id int) bool {
+	if platform_id == common.PLATFORM_WEB {
+		return len(s.socket_io_address) > 0 || len(s.ws_address) > 0
+	}
+	return len(s.address) > 0 || len(s.tls_address) > 0
+}
+
+var servers_mutex sync.Mutex
+var servers []*IMServer
+
+func GetServers() []*IMServer {
+	servers_mutex.Lock()
+	defer servers_mutex.Unlock()
+	return servers
+}
+
+func LoadServer(conn redis.Conn, server_id string) (*IMServer, error) {
+	key := fmt.Sprintf("im_server_load_%s", server_id)
+	reply, err := redis.Values(conn.Do("HMGET", key, "region", "address", "tls_address", "socket_io_address",
+		"ws_address", "connections", "cpu", "draining", "timestamp"))
+	if err != nil {
+		return nil, err
+	}
+	if reply[len(reply) - 1] == nil {
+		//超时没有上报
+		return nil, nil
+	}
+
+	s := &IMServer{server_id:server_id}
+	var cpu string
+	var draining int
+	_, err = redis.Scan(reply, &s.region, &s.address, &s.tls_address, &s.socket_io_address,
+		&s.ws_address, &s.connections, &cpu, &draining, &s.timestamp)
+	if err != nil {
+		return nil, err
+	}
+	s.cpu, _ = strconv.ParseFloat(cpu, 64)
+	s.draining = draining != 0
+	return s, nil
+}
+
+//读取所有im服务器的负载, 过期的服务器从集合中删除
+func LoadServers() ([]*IMServer, error) {
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	ids, err := redis.Strings(conn.Do("SMEMBERS", common.IM_SERVERS_KEY))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	result := make([]*IMServer, 0, len(ids))
+	for _, id := range ids {
+		s, err := LoadServer(conn, id)
+		if err != nil {
+			log.Warningf("load server:%s error:%s", id, err)
+			continue
+		}
+		if s == nil {
+			log.Infof("server:%s expired", id)
+			conn.Do("SREM", common.IM_SERVERS_KEY, id)
+			continue
+		}
+		if now - s.timestamp > common.LOAD_REPORT_INTERVAL * 3 {
+			continue
+		}
+		result = append(result, s)
+	}
+	return result, nil
+}
+
+func LoadServersLoop() {
+	for {
+		s, err := LoadServers()
+		if err != nil {
+			log.Warning("load servers error:", err)
+		} else {
+			servers_mutex.Lock()
+			servers = s
+			servers_mutex.Unlock()
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+//同一区域的服务器优先, 再按负载从低到高排序, 负载接近的随机排列, 下线中的服务器不分配
+func RankServers(region string, platform_id int) []*IMServer {
+	candidates := make([]*IMServer, 0)
+	for _, s := range GetServers() {
+		if s.draining || !s.Support(platform_id) {
+			continue
+		}
+		candidates = append(candidates, s)
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if len(region) > 0 && (a.region == region) != (b.region == region) {
+			return a.region == region
+		}
+		return a.Score() < b.Score()
+	})
+	ShuffleNearEqual(region, candidates)
+
+	if len(candidates) > config.dispatch_count {
+		candidates = candidates[:config.dispatch_count]
+	}
+	return candidates
+}
+
+//和最低分相差不超过dispatch_tolerance%的服务器按负载加权随机排列,
+//避免同一时刻的客户端都分配到同一台服务器
+func ShuffleNearEqual(region string, candidates []*IMServer) {
+	if len(candidates) < 2 {
+		return
+	}
+	best := candidates[0]
+	limit := best.Score() * (1 + config.dispatch_tolerance / 100) + 1
+	n := 1
+	for ; n < len(candidates); n++ {
+		s := candidates[n]
+		if len(region) > 0 && (s.region == region) != (best.region == region) {
+			break
+		}
+		if s.Score() > limit {
+			break
+		}
+	}
+
+	//负载越低权重越大, 不放回地依次抽取
+	near := candidates[:n]
+	for i := 0; i < len(near) - 1; i++ {
+		total := 0.0
+		for _, s := range near[i:] {
+			total += 1 / (1 + s.Score())
+		}
+		r := rand.Float64() * total
+		j := i
+		for ; j < len(near) - 1; j++ {
+			r -= 1 / (1 + near[j].Score())
+			if r < 0 {
+				break
+			}
+		}
+		near[i], near[j] = near[j], near[i]
+	}
+}
@@ -0,0 +1,881 @@
+/**
+ * Copyright (c) 2014-2015, GoBelieve
+ * All rights reserved.
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place, Suite 330, Boston, MA  02111-1307  USA
+ */
+
+package main
+
+import "net/http"
+import "encoding/json"
+import "crypto/subtle"
+import "database/sql"
+import "strconv"
+import "net/url"
+import "sync/atomic"
+import "time"
+import log "github.com/golang/glog"
+import "github.com/prometheus/client_golang/prometheus/promhttp"
+
+//第三方应用服务器调用的接口
+type AppHandler func(appid int64, w http.ResponseWriter, req *http.Request)
+
+type loggingHandler struct {
+	handler http.Handler
+}
+
+func (h loggingHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	log.Infof("http request:%s %s %s", req.RemoteAddr, req.Method, req.URL)
+	h.handler.ServeHTTP(w, req)
+}
+
+//basic auth, username:appid password:app的密钥
+func AuthApp(req *http.Request) (int64, bool) {
+	username, password, ok := req.BasicAuth()
+	if !ok {
+		return 0, false
+	}
+	appid, err := strconv.ParseInt(username, 10, 64)
+	if err != nil || appid <= 0 {
+		return 0, false
+	}
+	secret := GetAppSecret(appid)
+	if len(secret) == 0 {
+		return 0, false
+	}
+	if subtle.ConstantTimeCompare([]byte(password), secret) != 1 {
+		return 0, false
+	}
+	return appid, true
+}
+
+//运维接口, basic auth, username:admin password:admin_secret
+type AdminHandler func(w http.ResponseWriter, req *http.Request)
+
+func AuthAdmin(req *http.Request) bool {
+	if len(config.admin_secret) == 0 {
+		return false
+	}
+	username, password, ok := req.BasicAuth()
+	if !ok || username != "admin" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(password), []byte(config.admin_secret)) == 1
+}
+
+func (f AdminHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !AuthAdmin(req) {
+		log.Warningf("unauthorized admin request:%s %s", req.RemoteAddr, req.URL)
+		WriteHttpError(401, "unauthorized", w)
+		return
+	}
+	f(w, req)
+}
+
+func (f AppHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	appid, ok := AuthApp(req)
+	if !ok {
+		WriteHttpError(401, "unauthorized", w)
+		return
+	}
+	f(appid, w, req)
+}
+
+func ReadHttpBody(w http.ResponseWriter, req *http.Request, obj interface{}) bool {
+	if req.Method != "POST" {
+		WriteHttpError(405, "method not allowed", w)
+		return false
+	}
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(obj)
+	if err != nil {
+		log.Info("decode body error:", err)
+		WriteHttpError(400, "invalid json body", w)
+		return false
+	}
+	return true
+}
+
+func OpenAppDB(w http.ResponseWriter) *sql.DB {
+	db, err := sql.Open("mysql", config.mysqldb_appdatasource)
+	if err != nil {
+		log.Info("error:", err)
+		WriteHttpError(500, "server internal error", w)
+		return nil
+	}
+	return db
+}
+
+//构造一条透传消息,保存到接收者的离线队列
+func SaveCallbackMessage(appid int64, sender int64, receiver int64, cmd int, to int64, content string) {
+	obj := make(map[string]interface{})
+	obj["cmd"] = cmd
+	obj["from"] = sender
+	obj["to"] = to
+	obj["msg"] = content
+
+	b, err := json.Marshal(obj)
+	if err != nil {
+		log.Info("json marshal:", err)
+		return
+	}
+
+	msg := &IMMessage{}
+	msg.sender = sender
+	msg.receiver = receiver
+	msg.timestamp = int32(time.Now().Unix())
+	msg.content = string(b)
+	m := &Message{cmd: MSG_TRANSMIT_USER, version:DEFAULT_VERSION, body: msg}
+	SaveMessage(appid, receiver, 0, m)
+}
+
+type PostIMMessage struct {
+	Sender   int64  `json:"sender"`
+	Receiver int64  `json:"receiver"`
+	Content  string `json:"content"`
+}
+
+func PostPeerMessage(appid int64, w http.ResponseWriter, req *http.Request) {
+	var obj PostIMMessage
+	if !ReadHttpBody(w, req, &obj) {
+		return
+	}
+	if obj.Sender == 0 || obj.Receiver == 0 || len(obj.Content) == 0 {
+		WriteHttpError(400, "invalid param", w)
+		return
+	}
+
+	im := &IMMessage{}
+	im.sender = obj.Sender
+	im.receiver = obj.Receiver
+	im.timestamp = int32(time.Now().Unix())
+	im.content = obj.Content
+	m := &Message{cmd: MSG_IM, version:DEFAULT_VERSION, body: im}
+
+	msgid, err := SaveMessage(appid, im.receiver, 0, m)
+	if err != nil {
+		WriteHttpError(500, "save message error", w)
+		return
+	}
+	//发送者的其它登录点也能收到
+	SaveMessage(appid, im.sender, 0, m)
+
+	atomic.AddInt64(&server_summary.in_message_count, 1)
+	log.Infof("post peer message sender:%d receiver:%d msgid:%d\n", im.sender, im.receiver, msgid)
+	PostMessageEvent(appid, WEBHOOK_EVENT_PEER_MESSAGE, im, msgid)
+
+	data := make(map[string]interface{})
+	data["msgid"] = msgid
+	WriteHttpObj(data, w)
+}
+
+func PostGroupMessage(appid int64, w http.ResponseWriter, req *http.Request) {
+	var obj PostIMMessage
+	if !ReadHttpBody(w, req, &obj) {
+		return
+	}
+	if obj.Sender == 0 || obj.Receiver == 0 || len(obj.Content) == 0 {
+		WriteHttpError(400, "invalid param", w)
+		return
+	}
+
+	group := OpGetGroup(appid, obj.Receiver)
+	if group == nil || group.owner == 0 {
+		WriteHttpError(404, "group not found", w)
+		return
+	}
+
+	im := &IMMessage{}
+	im.sender = obj.Sender
+	im.receiver = obj.Receiver
+	im.timestamp = int32(time.Now().Unix())
+	im.content = obj.Content
+	m := &Message{cmd: MSG_GROUP_IM, version:DEFAULT_VERSION, body: im}
+
+	msgid, err := SaveGroupMessage(appid, im.receiver, 0, m)
+	if err != nil {
+		WriteHttpError(500, "save message error", w)
+		return
+	}
+
+	atomic.AddInt64(&server_summary.in_message_count, 1)
+	log.Infof("post group message sender:%d group id:%d msgid:%d\n", im.sender, im.receiver, msgid)
+	PostMessageEvent(appid, WEBHOOK_EVENT_GROUP_MESSAGE, im, msgid)
+
+	data := make(map[string]interface{})
+	data["msgid"] = msgid
+	WriteHttpObj(data, w)
+}
+
+//系统消息不保存,只发送给在线用户
+func PostSystemMessage(appid int64, w http.ResponseWriter, req *http.Request) {
+	var obj struct {
+		Receiver int64  `json:"receiver"`
+		Content  string `json:"content"`
+	}
+	if !ReadHttpBody(w, req, &obj) {
+		return
+	}
+	if obj.Receiver == 0 || len(obj.Content) == 0 {
+		WriteHttpError(400, "invalid param", w)
+		return
+	}
+
+	sys := &SystemMessage{obj.Content}
+	m := &Message{cmd: MSG_SYSTEM, version:DEFAULT_VERSION, body: sys}
+	Send0Message(appid, obj.Receiver, m)
+
+	WriteHttpObj(make(map[string]interface{}), w)
+}
+
+func PostCreateGroup(appid int64, w http.ResponseWriter, req *http.Request) {
+	var obj struct {
+		Owner         int64   `json:"owner"`
+		Title         string  `json:"title"`
+		Desc          string  `json:"desc"`
+		IsPrivate     int     `json:"is_private"`
+		IsAllowInvite int     `json:"is_allow_invite"`
+		Members       []int64 `json:"members"`
+	}
+	if !ReadHttpBody(w, req, &obj) {
+		return
+	}
+	if obj.Owner == 0 || len(obj.Title) == 0 {
+		WriteHttpError(400, "invalid param", w)
+		return
+	}
+	if GetAppConfig(appid).IsGroupFull(len(obj.Members) + 1) {
+		WriteHttpError(400, "too many members", w)
+		return
+	}
+
+	db := OpenAppDB(w)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+
+	gid := GenerateGroupUUID(obj.Owner)
+	if !OpCreateGroup(db, appid, gid, obj.Title, obj.Desc, obj.IsPrivate, obj.IsAllowInvite, obj.Owner, 0) {
+		WriteHttpError(500, "create group error", w)
+		return
+	}
+
+	OpAddGroupMember(db, appid, gid, obj.Owner, 1)
+	joined := []int64{obj.Owner}
+	for _, member := range obj.Members {
+		if member == obj.Owner {
+			continue
+		}
+		if OpAddGroupMember(db, appid, gid, member, 0) {
+			joined = append(joined, member)
+			SaveCallbackMessage(appid, obj.Owner, member, CMD_CALLBACK_GROUP_JOIN, gid, "")
+		}
+	}
+	PostGroupEvent(appid, WEBHOOK_EVENT_GROUP_CREATE, gid, obj.Owner, joined)
+
+	data := make(map[string]interface{})
+	data["group_id"] = gid
+	WriteHttpObj(data, w)
+}
+
+//只修改请求中带上的字段
+func PostUpdateGroup(appid int64, w http.ResponseWriter, req *http.Request) {
+	var obj struct {
+		GroupID       int64   `json:"group_id"`
+		Title         *string `json:"title"`
+		Desc          *string `json:"desc"`
+		IsPrivate     *int    `json:"is_private"`
+		IsAllowInvite *int    `json:"is_allow_invite"`
+	}
+	if !ReadHttpBody(w, req, &obj) {
+		return
+	}
+
+	group := OpGetGroup(appid, obj.GroupID)
+	if group == nil || group.owner == 0 {
+		WriteHttpError(404, "group not found", w)
+		return
+	}
+	if obj.Title != nil {
+		group.title = *obj.Title
+	}
+	if obj.Desc != nil {
+		group.desc = *obj.Desc
+	}
+	if obj.IsPrivate != nil {
+		group.is_private = *obj.IsPrivate
+	}
+	if obj.IsAllowInvite != nil {
+		group.is_allow_invite = *obj.IsAllowInvite
+	}
+
+	db := OpenAppDB(w)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+
+	if !OpUpdateGroup(db, appid, group.gid, group.title, group.desc, group.is_private, group.is_allow_invite) {
+		WriteHttpError(500, "update group error", w)
+		return
+	}
+	WriteHttpObj(make(map[string]interface{}), w)
+}
+
+func PostDismissGroup(appid int64, w http.ResponseWriter, req *http.Request) {
+	var obj struct {
+		GroupID int64 `json:"group_id"`
+	}
+	if !ReadHttpBody(w, req, &obj) {
+		return
+	}
+
+	group := OpGetGroup(appid, obj.GroupID)
+	if group == nil || group.owner == 0 {
+		WriteHttpError(404, "group not found", w)
+		return
+	}
+
+	db := OpenAppDB(w)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+
+	members := OpGetGroupMembers(appid, group.gid)
+	for _, member := range members {
+		OpRemoveGroupMember(db, appid, group.gid, member)
+		SaveCallbackMessage(appid, group.owner, member, CMD_CALLBACK_GROUP_DEL, group.gid, "")
+	}
+	OpDelGroup(db, appid, group.gid)
+	PostGroupEvent(appid, WEBHOOK_EVENT_GROUP_DISSOLVE, group.gid, group.owner, members)
+
+	WriteHttpObj(make(map[string]interface{}), w)
+}
+
+type PostGroupMembers struct {
+	GroupID int64   `json:"group_id"`
+	Members []int64 `json:"members"`
+}
+
+func PostAddGroupMember(appid int64, w http.ResponseWriter, req *http.Request) {
+	var obj PostGroupMembers
+	if !ReadHttpBody(w, req, &obj) {
+		return
+	}
+
+	group := OpGetGroup(appid, obj.GroupID)
+	if group == nil || group.owner == 0 {
+		WriteHttpError(404, "group not found", w)
+		return
+	}
+	if GetAppConfig(appid).IsGroupFull(OpGetGroupMemberNumber(appid, group.gid) + len(obj.Members)) {
+		WriteHttpError(400, "too many members", w)
+		return
+	}
+
+	db := OpenAppDB(w)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+
+	joined := make([]int64, 0, len(obj.Members))
+	for _, member := range obj.Members {
+		if OpIsGroupMember(appid, group.gid, member) {
+			continue
+		}
+		if OpAddGroupMember(db, appid, group.gid, member, 0) {
+			joined = append(joined, member)
+			SaveCallbackMessage(appid, group.owner, member, CMD_CALLBACK_GROUP_JOIN, group.gid, "")
+		}
+	}
+	if len(joined) > 0 {
+		PostGroupEvent(appid, WEBHOOK_EVENT_GROUP_JOIN, group.gid, group.owner, joined)
+	}
+	WriteHttpObj(make(map[string]interface{}), w)
+}
+
+func PostRemoveGroupMember(appid int64, w http.ResponseWriter, req *http.Request) {
+	var obj PostGroupMembers
+	if !ReadHttpBody(w, req, &obj) {
+		return
+	}
+
+	group := OpGetGroup(appid, obj.GroupID)
+	if group == nil || group.owner == 0 {
+		WriteHttpError(404, "group not found", w)
+		return
+	}
+
+	db := OpenAppDB(w)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+
+	removed := make([]int64, 0, len(obj.Members))
+	for _, member := range obj.Members {
+		//群主不能被移除
+		if member == group.owner {
+			continue
+		}
+		if OpRemoveGroupMember(db, appid, group.gid, member) {
+			removed = append(removed, member)
+			SaveCallbackMessage(appid, group.owner, member, CMD_CALLBACK_GROUP_REMOVE, group.gid, "")
+		}
+	}
+	if len(removed) > 0 {
+		PostGroupEvent(appid, WEBHOOK_EVENT_GROUP_LEAVE, group.gid, group.owner, removed)
+	}
+	WriteHttpObj(make(map[string]interface{}), w)
+}
+
+type PostUserPair struct {
+	UID  int64 `json:"uid"`
+	Peer int64 `json:"peer"`
+}
+
+//好友和黑名单的修改
+func HandleUserPair(appid int64, w http.ResponseWriter, req *http.Request, op func(*sql.DB, int64, int64, int64) bool) (int64, int64, bool) {
+	var obj PostUserPair
+	if !ReadHttpBody(w, req, &obj) {
+		return 0, 0, false
+	}
+	if obj.UID == 0 || obj.Peer == 0 || obj.UID == obj.Peer {
+		WriteHttpError(400, "invalid param", w)
+		return 0, 0, false
+	}
+
+	db := OpenAppDB(w)
+	if db == nil {
+		return 0, 0, false
+	}
+	defer db.Close()
+
+	if !op(db, appid, obj.UID, obj.Peer) {
+		WriteHttpError(500, "server internal error", w)
+		return 0, 0, false
+	}
+	WriteHttpObj(make(map[string]interface{}), w)
+	return obj.UID, obj.Peer, true
+}
+
+func PostAddFriend(appid int64, w http.ResponseWriter, req *http.Request) {
+	uid, fid, ok := HandleUserPair(appid, w, req, OpAddUserFriend)
+	if ok {
+		SaveCallbackMessage(appid, uid, fid, CMD_CALLBACK_FRIEND_ADD, fid, "")
+		SaveCallbackMessage(appid, fid, uid, CMD_CALLBACK_FRIEND_ADD, uid, "")
+		PostFriendEvent(appid, WEBHOOK_EVENT_FRIEND_ADD, uid, fid)
+	}
+}
+
+func PostRemoveFriend(appid int64, w http.ResponseWriter, req *http.Request) {
+	uid, fid, ok := HandleUserPair(appid, w, req, OpRemoveUserFriend)
+	if ok {
+		SaveCallbackMessage(appid, uid, fid, CMD_CALLBACK_FRIEND_DEL, fid, "")
+		PostFriendEvent(appid, WEBHOOK_EVENT_FRIEND_REMOVE, uid, fid)
+	}
+}
+
+func PostAddBlack(appid int64, w http.ResponseWriter, req *http.Request) {
+	HandleUserPair(appid, w, req, OpAddUserBlack)
+}
+
+func PostRemoveBlack(appid int64, w http.ResponseWriter, req *http.Request) {
+	HandleUserPair(appid, w, req, OpRemoveUserBlack)
+}
+
+//踢出用户的所有连接
+func PostKickUser(appid int64, w http.ResponseWriter, req *http.Request) {
+	var obj struct {
+		UID int64 `json:"uid"`
+	}
+	if !ReadHttpBody(w, req, &obj) {
+		return
+	}
+	if obj.UID == 0 {
+		WriteHttpError(400, "invalid param", w)
+		return
+	}
+
+	m := &Message{cmd: MSG_KICK, version:DEFAULT_VERSION, body:&Kick{reason:KICK_REASON_API}}
+	Send0Message(appid, obj.UID, m)
+	WriteHttpObj(make(map[string]interface{}), w)
+}
+
+func GetOnlineState(appid int64, w http.ResponseWriter, req *http.Request) {
+	uid, err := strconv.ParseInt(req.URL.Query().Get("uid"), 10, 64)
+	if err != nil || uid == 0 {
+		WriteHttpError(400, "invalid param", w)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["uid"] = uid
+	data["online"] = OpIsUserOnline(appid, uid)
+	WriteHttpObj(data, w)
+}
+
+//用户在本机上每个连接的发送队列长度
+func GetClientQueues(appid int64, w http.ResponseWriter, req *http.Request) {
+	uid, err := strconv.ParseInt(req.URL.Query().Get("uid"), 10, 64)
+	if err != nil || uid == 0 {
+		WriteHttpError(400, "invalid param", w)
+		return
+	}
+
+	clients := make([]interface{}, 0)
+	for c := range route.FindClientSet(appid, uid) {
+		wt, ewt := c.QueueDepth()
+		obj := make(map[string]interface{})
+		obj["platform_id"] = c.platform_id
+		obj["device_id"] = c.device_id
+		obj["rt_queue"] = wt
+		obj["im_queue"] = ewt
+		obj["capacity"] = cap(c.wt)
+		clients = append(clients, obj)
+	}
+
+	data := make(map[string]interface{})
+	data["uid"] = uid
+	data["clients"] = clients
+	WriteHttpObj(data, w)
+}
+
+//before_id为空时从最新的消息开始
+func GetRoomHistoryMessages(appid int64, w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	room_id, err := strconv.ParseInt(q.Get("room_id"), 10, 64)
+	if err != nil || room_id == 0 {
+		WriteHttpError(400, "invalid param", w)
+		return
+	}
+	var before_id int64
+	if len(q.Get("before_id")) > 0 {
+		before_id, err = strconv.ParseInt(q.Get("before_id"), 10, 64)
+		if err != nil {
+			WriteHttpError(400, "invalid param", w)
+			return
+		}
+	}
+	limit := config.room_history_enter
+	if len(q.Get("limit")) > 0 {
+		limit, err = strconv.Atoi(q.Get("limit"))
+		if err != nil {
+			WriteHttpError(400, "invalid param", w)
+			return
+		}
+	}
+
+	h := GetRoomHistory(appid, room_id, before_id, limit)
+	messages := make([]interface{}, 0, len(h.messages))
+	for _, item := range h.messages {
+		obj := make(map[string]interface{})
+		obj["id"] = item.id
+		obj["timestamp"] = item.timestamp
+		obj["sender"] = item.sender
+		obj["content"] = item.content
+		messages = append(messages, obj)
+	}
+
+	data := make(map[string]interface{})
+	data["room_id"] = room_id
+	data["more"] = h.more
+	data["messages"] = messages
+	WriteHttpObj(data, w)
+}
+
+type PostDeviceToken struct {
+	UID        int64  `json:"uid"`
+	PlatformID int8   `json:"platform_id"`
+	DeviceID   string `json:"device_id"`
+	Provider   string `json:"provider"`
+	Token      string `json:"token"`
+}
+
+//绑定离线推送的设备token, provider为空时由平台决定(ios:apns android:fcm)
+func PostBindDeviceToken(appid int64, w http.ResponseWriter, req *http.Request) {
+	var obj PostDeviceToken
+	if !ReadHttpBody(w, req, &obj) {
+		return
+	}
+	if obj.UID == 0 || obj.PlatformID == 0 || len(obj.DeviceID) == 0 || len(obj.Token) == 0 {
+		WriteHttpError(400, "invalid param", w)
+		return
+	}
+
+	if !OpBindDeviceToken(appid, obj.UID, obj.PlatformID, obj.DeviceID, obj.Provider, obj.Token) {
+		WriteHttpError(500, "server internal error", w)
+		return
+	}
+	WriteHttpObj(make(map[string]interface{}), w)
+}
+
+func PostUnbindDeviceToken(appid int64, w http.ResponseWriter, req *http.Request) {
+	var obj PostDeviceToken
+	if !ReadHttpBody(w, req, &obj) {
+		return
+	}
+	if obj.UID == 0 || obj.PlatformID == 0 || len(obj.DeviceID) == 0 {
+		WriteHttpError(400, "invalid param", w)
+		return
+	}
+
+	if !OpUnbindDeviceToken(appid, obj.UID, obj.PlatformID, obj.DeviceID) {
+		WriteHttpError(500, "server internal error", w)
+		return
+	}
+	WriteHttpObj(make(map[string]interface{}), w)
+}
+
+//设置app的webhook, events为空时发送所有事件
+func PostSetWebhook(appid int64, w http.ResponseWriter, req *http.Request) {
+	var obj struct {
+		URL    string   `json:"url"`
+		Secret string   `json:"secret"`
+		Events []string `json:"events"`
+	}
+	if !ReadHttpBody(w, req, &obj) {
+		return
+	}
+	u, err := url.Parse(obj.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(obj.Secret) == 0 {
+		WriteHttpError(400, "invalid param", w)
+		return
+	}
+
+	if !OpSetWebhook(appid, obj.URL, obj.Secret, obj.Events) {
+		WriteHttpError(500, "server internal error", w)
+		return
+	}
+	WriteHttpObj(make(map[string]interface{}), w)
+}
+
+func PostDeleteWebhook(appid int64, w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		WriteHttpError(405, "method not allowed", w)
+		return
+	}
+	if !OpDelWebhook(appid) {
+		WriteHttpError(500, "server internal error", w)
+		return
+	}
+	WriteHttpObj(make(map[string]interface{}), w)
+}
+
+func PostSetLoginPolicy(appid int64, w http.ResponseWriter, req *http.Request) {
+	var obj struct {
+		Policy string `json:"policy"`
+	}
+	if !ReadHttpBody(w, req, &obj) {
+		return
+	}
+	if !IsLoginPolicy(obj.Policy) {
+		WriteHttpError(400, "invalid param", w)
+		return
+	}
+
+	if !OpSetLoginPolicy(appid, obj.Policy) {
+		WriteHttpError(500, "server internal error", w)
+		return
+	}
+	WriteHttpObj(make(map[string]interface{}), w)
+}
+
+//app的配额只能由运维设置
+func PostSetAppConfig(w http.ResponseWriter, req *http.Request) {
+	var obj struct {
+		AppID            int64 `json:"appid"`
+		MaxConnections   int   `json:"max_connections"`
+		MaxGroupMembers  int   `json:"max_group_members"`
+		MaxContentLength int   `json:"max_content_length"`
+	}
+	if !ReadHttpBody(w, req, &obj) {
+		return
+	}
+	if obj.AppID <= 0 || obj.MaxConnections < 0 || obj.MaxGroupMembers < 0 || obj.MaxContentLength < 0 {
+		WriteHttpError(400, "invalid param", w)
+		return
+	}
+
+	c := &AppConfig{
+		max_connections : obj.MaxConnections,
+		max_group_members : obj.MaxGroupMembers,
+		max_content_length : obj.MaxContentLength,
+	}
+	if !OpSetAppConfig(obj.AppID, c) {
+		WriteHttpError(500, "server internal error", w)
+		return
+	}
+	WriteHttpObj(make(map[string]interface{}), w)
+}
+
+//app只能查询自己的配额
+func GetAppConfigInfo(appid int64, w http.ResponseWriter, req *http.Request) {
+	c := GetAppConfig(appid)
+	obj := make(map[string]interface{})
+	obj["max_connections"] = c.max_connections
+	obj["max_group_members"] = c.max_group_members
+	obj["max_content_length"] = c.max_content_length
+	WriteHttpObj(obj, w)
+}
+
+//吊销token, expires为token的过期时间
+func PostRevokeToken(appid int64, w http.ResponseWriter, req *http.Request) {
+	var obj struct {
+		Token   string `json:"token"`
+		Expires int64  `json:"expires"`
+	}
+	if !ReadHttpBody(w, req, &obj) {
+		return
+	}
+	if len(obj.Token) == 0 {
+		WriteHttpError(400, "invalid param", w)
+		return
+	}
+
+	if !OpRevokeToken(appid, obj.Token, obj.Expires) {
+		WriteHttpError(500, "server internal error", w)
+		return
+	}
+	WriteHttpObj(make(map[string]interface{}), w)
+}
+
+//设置app调用接口的密钥, 其它im服务器在缓存过期后生效
+func PostSetAppSecret(w http.ResponseWriter, req *http.Request) {
+	var obj struct {
+		AppID  int64  `json:"appid"`
+		Secret string `json:"secret"`
+	}
+	if !ReadHttpBody(w, req, &obj) {
+		return
+	}
+	if obj.AppID <= 0 || len(obj.Secret) < 16 {
+		WriteHttpError(400, "invalid param", w)
+		return
+	}
+
+	if !OpSetAppSecret(obj.AppID, obj.Secret) {
+		WriteHttpError(500, "server internal error", w)
+		return
+	}
+	WriteHttpObj(make(map[string]interface{}), w)
+}
+
+//设置app的推送证书, 覆盖之前的设置, route_server在缓存过期后生效
+func PostSetAppPush(w http.ResponseWriter, req *http.Request) {
+	var obj struct {
+		AppID              int64  `json:"appid"`
+		APNSKey            string `json:"apns_key"`
+		APNSKeyID          string `json:"apns_key_id"`
+		APNSTeamID         string `json:"apns_team_id"`
+		APNSTopic          string `json:"apns_topic"`
+		APNSSandbox        bool   `json:"apns_sandbox"`
+		FCMServiceAccount  string `json:"fcm_service_account"`
+		XiaomiAppSecret    string `json:"xiaomi_app_secret"`
+		XiaomiPackageName  string `json:"xiaomi_package_name"`
+	}
+	if !ReadHttpBody(w, req, &obj) {
+		return
+	}
+	if obj.AppID <= 0 {
+		WriteHttpError(400, "invalid param", w)
+		return
+	}
+
+	fields := make(map[string]string)
+	fields["apns_key"] = obj.APNSKey
+	fields["apns_key_id"] = obj.APNSKeyID
+	fields["apns_team_id"] = obj.APNSTeamID
+	fields["apns_topic"] = obj.APNSTopic
+	if obj.APNSSandbox {
+		fields["apns_sandbox"] = "1"
+	}
+	fields["fcm_service_account"] = obj.FCMServiceAccount
+	fields["xiaomi_app_secret"] = obj.XiaomiAppSecret
+	fields["xiaomi_package_name"] = obj.XiaomiPackageName
+	if !OpSetAppPush(obj.AppID, fields) {
+		WriteHttpError(500, "server internal error", w)
+		return
+	}
+	WriteHttpObj(make(map[string]interface{}), w)
+}
+
+//设置jwt token的签名密钥
+func PostSetAuthKey(appid int64, w http.ResponseWriter, req *http.Request) {
+	var obj struct {
+		Key string `json:"key"`
+	}
+	if !ReadHttpBody(w, req, &obj) {
+		return
+	}
+	if len(obj.Key) < 16 {
+		WriteHttpError(400, "invalid param", w)
+		return
+	}
+
+	if !OpSetAppAuthKey(appid, obj.Key) {
+		WriteHttpError(500, "server internal error", w)
+		return
+	}
+	WriteHttpObj(make(map[string]interface{}), w)
+}
+
+func StartHttpServer(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/summary", AdminHandler(Summary))
+	mux.Handle("/stack", AdminHandler(Stack))
+	mux.Handle("/drain", AdminHandler(PostDrain))
+	mux.Handle("/set_app_secret", AdminHandler(PostSetAppSecret))
+	mux.Handle("/set_app_config", AdminHandler(PostSetAppConfig))
+	mux.Handle("/set_app_push", AdminHandler(PostSetAppPush))
+	mux.Handle("/metrics", promhttp.Handler())
+
+	mux.Handle("/post_peer_message", AppHandler(PostPeerMessage))
+	mux.Handle("/post_group_message", AppHandler(PostGroupMessage))
+	mux.Handle("/post_system_message", AppHandler(PostSystemMessage))
+
+	mux.Handle("/create_group", AppHandler(PostCreateGroup))
+	mux.Handle("/update_group", AppHandler(PostUpdateGroup))
+	mux.Handle("/dismiss_group", AppHandler(PostDismissGroup))
+	mux.Handle("/add_group_member", AppHandler(PostAddGroupMember))
+	mux.Handle("/remove_group_member", AppHandler(PostRemoveGroupMember))
+
+	mux.Handle("/add_friend", AppHandler(PostAddFriend))
+	mux.Handle("/remove_friend", AppHandler(PostRemoveFriend))
+	mux.Handle("/add_black", AppHandler(PostAddBlack))
+	mux.Handle("/remove_black", AppHandler(PostRemoveBlack))
+
+	mux.Handle("/kick_user", AppHandler(PostKickUser))
+	mux.Handle("/get_online_state", AppHandler(GetOnlineState))
+	mux.Handle("/get_client_queues", AppHandler(GetClientQueues))
+	mux.Handle("/get_room_history", AppHandler(GetRoomHistoryMessages))
+
+	mux.Handle("/bind_device_token", AppHandler(PostBindDeviceToken))
+	mux.Handle("/unbind_device_token", AppHandler(PostUnbindDeviceToken))
+
+	mux.Handle("/set_webhook", AppHandler(PostSetWebhook))
+	mux.Handle("/delete_webhook", AppHandler(PostDeleteWebhook))
+	mux.Handle("/set_login_policy", AppHandler(PostSetLoginPolicy))
+	mux.Handle("/get_app_config", AppHandler(GetAppConfigInfo))
+	mux.Handle("/revoke_token", AppHandler(PostRevokeToken))
+	mux.Handle("/set_auth_key", AppHandler(PostSetAuthKey))
+
+	handler := loggingHandler{mux}
+	HTTPService(addr, handler)
+}
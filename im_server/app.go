@@ -0,0 +1,204 @@
+/**
+ * Copyright (c) 2014-2015, GoBelieve
+ * All rights reserved.
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place, Suite 330, Boston, MA  02111-1307  USA
+ */
+
+package main
+
+import "fmt"
+import "sync"
+import "time"
+import log "github.com/golang/glog"
+import "github.com/garyburd/redigo/redis"
+
+//app配置缓存时间
+const APP_CONFIG_CACHE_TIMEOUT = 60
+
+//每个app的配额, 0表示不限制
+type AppConfig struct {
+	max_connections    int //单台im服务器上的连接数
+	max_group_members  int
+	max_content_length int
+
+	tm time.Time
+}
+
+var app_config_mutex sync.Mutex
+var app_configs map[int64]*AppConfig
+
+func init() {
+	app_configs = make(map[int64]*AppConfig)
+}
+
+func DefaultAppConfig() *AppConfig {
+	return &AppConfig{
+		max_connections : config.app_max_connections,
+		max_group_members : config.app_max_group_members,
+		max_content_length : config.app_max_content_length,
+	}
+}
+
+func OpSetAppConfig(appid int64, c *AppConfig) bool {
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	key := fmt.Sprintf("app_config_%d", appid)
+	_, err := conn.Do("HMSET", key, "max_connections", c.max_connections,
+		"max_group_members", c.max_group_members, "max_content_length", c.max_content_length)
+	if err != nil {
+		log.Infoln(err)
+		return false
+	}
+
+	app_config_mutex.Lock()
+	delete(app_configs, appid)
+	app_config_mutex.Unlock()
+	return true
+}
+
+//hash中没有的字段使用配置文件中的默认值
+func LoadAppConfig(appid int64) *AppConfig {
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	c := DefaultAppConfig()
+	key := fmt.Sprintf("app_config_%d", appid)
+	reply, err := redis.Values(conn.Do("HMGET", key, "max_connections", "max_group_members", "max_content_length"))
+	if err != nil {
+		log.Info("hmget error:", err)
+		return c
+	}
+
+	fields := []*int{&c.max_connections, &c.max_group_members, &c.max_content_length}
+	for i, v := range reply {
+		if v == nil {
+			continue
+		}
+		n, err := redis.Int(v, nil)
+		if err != nil {
+			log.Warningf("invalid app config appid:%d error:%s", appid, err)
+			continue
+		}
+		*fields[i] = n
+	}
+	c.tm = time.Now()
+	return c
+}
+
+func GetAppConfig(appid int64) *AppConfig {
+	app_config_mutex.Lock()
+	c, ok := app_configs[appid]
+	app_config_mutex.Unlock()
+	if ok && time.Since(c.tm) < APP_CONFIG_CACHE_TIMEOUT * time.Second {
+		return c
+	}
+
+	c = LoadAppConfig(appid)
+	app_config_mutex.Lock()
+	app_configs[appid] = c
+	app_config_mutex.Unlock()
+	return c
+}
+
+func (c *AppConfig) IsGroupFull(count int) bool {
+	return c.max_group_members > 0 && count > c.max_group_members
+}
+
+//count是本机上app已有的连接数
+func (c *AppConfig) IsConnectionFull(count int) bool {
+	return c.max_connections > 0 && count >= c.max_connections
+}
+
+func (c *AppConfig) IsContentTooLong(content string) bool {
+	return c.max_content_length > 0 && len(content) > c.max_content_length
+}
+
+//app调用http接口的密钥
+type AppSecret struct {
+	secret []byte
+	tm     time.Time
+}
+
+var app_secret_mutex sync.Mutex
+var app_secrets map[int64]*AppSecret = make(map[int64]*AppSecret)
+
+func OpSetAppSecret(appid int64, secret string) bool {
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("SET", fmt.Sprintf("app_secret_%d", appid), secret)
+	if err != nil {
+		log.Infoln(err)
+		return false
+	}
+
+	app_secret_mutex.Lock()
+	delete(app_secrets, appid)
+	app_secret_mutex.Unlock()
+	return true
+}
+
+//app的推送证书, route_server按appid读取, 为空的字段不写入
+//apns_key为p8私钥内容, fcm_service_account为service account json内容
+func OpSetAppPush(appid int64, fields map[string]string) bool {
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	key := fmt.Sprintf("app_push_%d", appid)
+	args := redis.Args{}.Add(key)
+	for k, v := range fields {
+		if len(v) > 0 {
+			args = args.Add(k, v)
+		}
+	}
+
+	conn.Send("MULTI")
+	conn.Send("DEL", key)
+	if len(args) > 1 {
+		conn.Send("HMSET", args...)
+	}
+	_, err := conn.Do("EXEC")
+	if err != nil {
+		log.Infoln(err)
+		return false
+	}
+	return true
+}
+
+//没有设置密钥的app不能调用接口
+func GetAppSecret(appid int64) []byte {
+	app_secret_mutex.Lock()
+	s, ok := app_secrets[appid]
+	app_secret_mutex.Unlock()
+	if ok && time.Since(s.tm) < APP_CONFIG_CACHE_TIMEOUT * time.Second {
+		return s.secret
+	}
+
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	secret, err := redis.Bytes(conn.Do("GET", fmt.Sprintf("app_secret_%d", appid)))
+	if err != nil && err != redis.ErrNil {
+		log.Info("get app secret error:", err)
+		return nil
+	}
+
+	app_secret_mutex.Lock()
+	app_secrets[appid] = &AppSecret{secret, time.Now()}
+	app_secret_mutex.Unlock()
+	return secret
+}
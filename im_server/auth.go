@@ -0,0 +1,364 @@
+/**
+ * Copyright (c) 2014-2015, GoBelieve
+ * All rights reserved.
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place, Suite 330, Boston, MA  02111-1307  USA
+ */
+
+package main
+
+import "fmt"
+import "bytes"
+import "errors"
+import "strings"
+import "sync"
+import "time"
+import "net/http"
+import "crypto/hmac"
+import "crypto/sha256"
+import "crypto/subtle"
+import "encoding/hex"
+import "encoding/json"
+import "encoding/base64"
+import log "github.com/golang/glog"
+import "github.com/garyburd/redigo/redis"
+
+var ErrTokenExpired = errors.New("token expired")
+var ErrTokenRevoked = errors.New("token revoked")
+var ErrInvalidToken = errors.New("invalid token")
+
+//token格式不属于当前的认证方式,交给下一个
+var ErrUnknownToken = errors.New("unknown token")
+
+//没有过期时间的token, 吊销记录保留的时间
+const REVOKE_DEFAULT_TTL = 30 * 24 * 3600
+
+//app密钥和http回调结果的缓存时间
+const AUTH_CACHE_TIMEOUT = 60
+const AUTH_CACHE_LIMIT = 100000
+
+const AUTH_CALLBACK_TIMEOUT = 5 * time.Second
+
+type AuthInfo struct {
+	appid   int64
+	uid     int64
+	uname   string
+	expires int64 //unix时间, 0表示不过期
+}
+
+type Authenticator interface {
+	Name() string
+	//appid为客户端提供的appid, 0表示未提供
+	Authenticate(appid int64, token string) (*AuthInfo, error)
+}
+
+var authenticators []Authenticator
+
+func NewAuthenticator(name string) Authenticator {
+	switch name {
+	case "redis":
+		return &RedisAuthenticator{}
+	case "jwt":
+		return NewJWTAuthenticator()
+	case "http":
+		if len(config.auth_callback_url) == 0 {
+			log.Fatal("auth_callback_url is empty")
+		}
+		return NewHTTPAuthenticator(config.auth_callback_url)
+	default:
+		log.Fatal("unknown authenticator:", name)
+		return nil
+	}
+}
+
+func InitAuthenticators() {
+	for _, name := range config.authenticators {
+		authenticators = append(authenticators, NewAuthenticator(name))
+	}
+}
+
+//依次尝试配置的认证方式, 并检查过期和吊销
+func Authenticate(appid int64, token string) (*AuthInfo, error) {
+	if len(token) == 0 {
+		return nil, ErrInvalidToken
+	}
+	if OpIsTokenRevoked(token) {
+		return nil, ErrTokenRevoked
+	}
+
+	err := ErrUnknownToken
+	for _, a := range authenticators {
+		var info *AuthInfo
+		info, err = a.Authenticate(appid, token)
+		if err == ErrUnknownToken {
+			continue
+		}
+		if err != nil {
+			log.Infof("%s authenticate error:%s", a.Name(), err)
+			return nil, err
+		}
+		if appid != 0 && info.appid != appid {
+			return nil, ErrInvalidToken
+		}
+		if info.expires > 0 && time.Now().Unix() >= info.expires {
+			return nil, ErrTokenExpired
+		}
+		return info, nil
+	}
+	return nil, err
+}
+
+func TokenDigest(token string) string {
+	digest := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(digest[:])
+}
+
+//吊销记录在token过期之后删除
+func OpRevokeToken(appid int64, token string, expires int64) bool {
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	ttl := int64(REVOKE_DEFAULT_TTL)
+	if expires > 0 {
+		ttl = expires - time.Now().Unix()
+		if ttl <= 0 {
+			return true
+		}
+	}
+
+	key := fmt.Sprintf("revoked_token_%s", TokenDigest(token))
+	_, err := conn.Do("SET", key, 1, "EX", ttl)
+	if err != nil {
+		log.Infoln(err)
+		return false
+	}
+	//redis中缓存的token同时删除
+	_, err = conn.Do("DEL", fmt.Sprintf("access_token_%d_%s", appid, token), fmt.Sprintf("access_token_%s", token))
+	if err != nil {
+		log.Infoln(err)
+	}
+	return true
+}
+
+func OpIsTokenRevoked(token string) bool {
+	return OpIsTokenDigestRevoked(TokenDigest(token))
+}
+
+//恢复会话时只保存了token的摘要
+func OpIsTokenDigestRevoked(digest string) bool {
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	key := fmt.Sprintf("revoked_token_%s", digest)
+	exists, err := redis.Bool(conn.Do("EXISTS", key))
+	if err != nil {
+		log.Info("exists error:", err)
+		return false
+	}
+	return exists
+}
+
+//原有的redis缓存加mysql查询
+type RedisAuthenticator struct {
+}
+
+func (a *RedisAuthenticator) Name() string {
+	return "redis"
+}
+
+func (a *RedisAuthenticator) Authenticate(appid int64, token string) (*AuthInfo, error) {
+	return OpLoadUserAccessToken(appid, token)
+}
+
+//HS256签名的jwt, claims:{appid, uid, name, exp}, 每个app使用各自的密钥
+type JWTAuthenticator struct {
+	mutex sync.Mutex
+	keys  map[int64]*AppAuthKey
+}
+
+type AppAuthKey struct {
+	key []byte
+	tm  time.Time
+}
+
+func NewJWTAuthenticator() *JWTAuthenticator {
+	return &JWTAuthenticator{keys: make(map[int64]*AppAuthKey)}
+}
+
+func (a *JWTAuthenticator) Name() string {
+	return "jwt"
+}
+
+func OpSetAppAuthKey(appid int64, key string) bool {
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("SET", fmt.Sprintf("app_auth_key_%d", appid), key)
+	if err != nil {
+		log.Infoln(err)
+		return false
+	}
+	return true
+}
+
+func (a *JWTAuthenticator) GetKey(appid int64) []byte {
+	a.mutex.Lock()
+	k, ok := a.keys[appid]
+	a.mutex.Unlock()
+	if ok && time.Since(k.tm) < AUTH_CACHE_TIMEOUT * time.Second {
+		return k.key
+	}
+
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	key, err := redis.Bytes(conn.Do("GET", fmt.Sprintf("app_auth_key_%d", appid)))
+	if err != nil && err != redis.ErrNil {
+		log.Info("get auth key error:", err)
+		return nil
+	}
+
+	a.mutex.Lock()
+	a.keys[appid] = &AppAuthKey{key, time.Now()}
+	a.mutex.Unlock()
+	return key
+}
+
+func (a *JWTAuthenticator) Authenticate(appid int64, token string) (*AuthInfo, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrUnknownToken
+	}
+
+	h, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	err = json.Unmarshal(h, &header)
+	if err != nil || header.Alg != "HS256" {
+		return nil, ErrInvalidToken
+	}
+
+	c, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var claims struct {
+		AppID   int64  `json:"appid"`
+		UID     int64  `json:"uid"`
+		Name    string `json:"name"`
+		Expires int64  `json:"exp"`
+	}
+	err = json.Unmarshal(c, &claims)
+	if err != nil || claims.AppID == 0 || claims.UID == 0 {
+		return nil, ErrInvalidToken
+	}
+	if appid != 0 && claims.AppID != appid {
+		return nil, ErrInvalidToken
+	}
+
+	//先根据未验证的appid找到密钥,再验证签名
+	key := a.GetKey(claims.AppID)
+	if len(key) == 0 {
+		return nil, ErrInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if subtle.ConstantTimeCompare(mac.Sum(nil), sig) != 1 {
+		return nil, ErrInvalidToken
+	}
+
+	return &AuthInfo{claims.AppID, claims.UID, claims.Name, claims.Expires}, nil
+}
+
+//回调app后台验证token, POST {"token":...} 返回 {"appid","uid","name","expires"}
+type HTTPAuthenticator struct {
+	url    string
+	client *http.Client
+
+	mutex sync.Mutex
+	cache map[string]*CachedAuthInfo
+}
+
+type CachedAuthInfo struct {
+	info *AuthInfo
+	tm   time.Time
+}
+
+func NewHTTPAuthenticator(url string) *HTTPAuthenticator {
+	a := &HTTPAuthenticator{url: url}
+	a.client = &http.Client{Timeout: AUTH_CALLBACK_TIMEOUT}
+	a.cache = make(map[string]*CachedAuthInfo)
+	return a
+}
+
+func (a *HTTPAuthenticator) Name() string {
+	return "http"
+}
+
+func (a *HTTPAuthenticator) Authenticate(appid int64, token string) (*AuthInfo, error) {
+	a.mutex.Lock()
+	c, ok := a.cache[token]
+	a.mutex.Unlock()
+	if ok && time.Since(c.tm) < AUTH_CACHE_TIMEOUT * time.Second {
+		return c.info, nil
+	}
+
+	body, err := json.Marshal(map[string]string{"token": token})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := a.client.Post(a.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == 401 || resp.StatusCode == 403 {
+		return nil, ErrInvalidToken
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("auth callback status:%d", resp.StatusCode)
+	}
+
+	var r struct {
+		AppID   int64  `json:"appid"`
+		UID     int64  `json:"uid"`
+		Name    string `json:"name"`
+		Expires int64  `json:"expires"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&r)
+	if err != nil {
+		return nil, err
+	}
+	if r.AppID == 0 || r.UID == 0 {
+		return nil, ErrInvalidToken
+	}
+
+	info := &AuthInfo{r.AppID, r.UID, r.Name, r.Expires}
+	a.mutex.Lock()
+	if len(a.cache) >= AUTH_CACHE_LIMIT {
+		a.cache = make(map[string]*CachedAuthInfo)
+	}
+	a.cache[token] = &CachedAuthInfo{info, time.Now()}
+	a.mutex.Unlock()
+	return info, nil
+}
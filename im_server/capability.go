@@ -0,0 +1,95 @@
+/**
+ * Copyright (c) 2014-2015, GoBelieve
+ * All rights reserved.
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place, Suite 330, Boston, MA  02111-1307  USA
+ */
+
+package main
+
+import log "github.com/golang/glog"
+
+//服务器已经支持的能力, 新功能上线后加入
+const SERVER_CAPABILITIES = CAP_KICK | CAP_ACK_STATUS | CAP_FRAGMENT | CAP_COMPRESSION | CAP_RESUME |
+	CAP_RECONNECT | CAP_ROOM_HISTORY
+
+//客户端声明的最大帧长度不能小于这个值
+const MIN_FRAME_SIZE = 1024
+
+//需要客户端支持对应能力的消息, 不支持时不发送
+var message_capabilities map[int]int32
+
+func init() {
+	message_capabilities = make(map[int]int32)
+	message_capabilities[MSG_KICK] = CAP_KICK
+	message_capabilities[MSG_RECONNECT] = CAP_RECONNECT
+	message_capabilities[MSG_ROOM_HISTORY] = CAP_ROOM_HISTORY
+}
+
+//旧版本客户端不带能力字段, 保持原来的行为
+func (client *Connection) NegotiateCapabilities(login *AuthenticationToken) {
+	if login.capabilities == 0 && login.max_frame_size == 0 {
+		if client.version >= ACK_STATUS_VERSION {
+			client.capabilities = CAP_ACK_STATUS
+		}
+		return
+	}
+	client.capabilities = login.capabilities & SERVER_CAPABILITIES
+	//json文本没有头部, 无法标记压缩
+	if client.Codec() == CODEC_JSON || config.compression_threshold < 0 {
+		client.capabilities &^= CAP_COMPRESSION
+	}
+	if config.resume_grace <= 0 {
+		client.capabilities &^= CAP_RESUME
+	}
+	if config.room_history_size <= 0 {
+		client.capabilities &^= CAP_ROOM_HISTORY
+	}
+	client.max_frame_size = MAX_FRAME_SIZE
+	if login.max_frame_size > 0 && login.max_frame_size < MAX_FRAME_SIZE {
+		client.max_frame_size = login.max_frame_size
+		if client.max_frame_size < MIN_FRAME_SIZE {
+			client.max_frame_size = MIN_FRAME_SIZE
+		}
+	}
+}
+
+func (client *Connection) HasCapability(capability int32) bool {
+	return client.capabilities & capability == capability
+}
+
+//返回nil表示客户端无法识别, 丢弃该消息
+func (client *Connection) Downgrade(msg *Message) *Message {
+	if capability, ok := message_capabilities[msg.cmd]; ok && !client.HasCapability(capability) {
+		log.Infof("uid:%d skip msg:%s without capability:%x", client.uid, Command(msg.cmd), capability)
+		return nil
+	}
+
+	switch msg.cmd {
+	case MSG_ACK:
+		ack := msg.body.(*MessageACK)
+		if ack.status == ACK_SUCCESS || client.HasCapability(CAP_ACK_STATUS) {
+			break
+		}
+		//旧版本客户端只认识seq, 消息已经发送时回复成功,
+		//消息未发送时不回复ack, 让客户端超时后重发
+		if ack.status == ACK_MASKED || ack.status == ACK_FLAGGED {
+			return &Message{msg.cmd, msg.seq, msg.version, &MessageACK{ack.seq, ACK_SUCCESS}}
+		}
+		log.Infof("uid:%d skip ack seq:%d status:%d", client.uid, ack.seq, ack.status)
+		return nil
+	}
+	return msg
+}
@@ -22,6 +22,7 @@ package main
 import "net"
 import "time"
 import "sync"
+import "sync/atomic"
 import log "github.com/golang/glog"
 
 type Subscriber struct {
@@ -45,6 +46,8 @@ type Channel struct {
 
 	dispatch        func(*AppMessage)
 
+	//连接成功并且注册后为1
+	connected       int32
 }
 
 func NewChannel(addr string, f func(*AppMessage)) *Channel {
@@ -56,7 +59,8 @@ func NewChannel(addr string, f func(*AppMessage)) *Channel {
 	return channel
 }
 
-func (channel *Channel) Register() {
+//每次连接都需要重新注册, route_server根据server_id投递消息
+func (channel *Channel) Register(conn net.Conn) error {
 	msg := &ServerID{
 		serverid : config.server_id,
 	}
@@ -64,8 +68,13 @@ func (channel *Channel) Register() {
 	m := &Message{}
 	m.cmd = MSG_SERVER_REGISTER
 	m.body = msg
-	
-	channel.wt <- m
+
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return SendMessage(conn, m)
+}
+
+func (channel *Channel) IsConnected() bool {
+	return atomic.LoadInt32(&channel.connected) == 1
 }
 
 func (channel *Channel) Publish(amsg *AppMessage) {
@@ -82,15 +91,23 @@ func (channel *Channel) PublishRoom(amsg *AppMessage) {
 	channel.wt <- msg
 }
 
-func (channel *Channel) RunOnce(conn *net.TCPConn) {
+func (channel *Channel) RunOnce(conn net.Conn) {
 	defer conn.Close()
 
+	err := channel.Register(conn)
+	if err != nil {
+		log.Info("channel register error:", err)
+		return
+	}
+	atomic.StoreInt32(&channel.connected, 1)
+	defer atomic.StoreInt32(&channel.connected, 0)
+
 	closed_ch := make(chan bool)
 	seq := 0
 
 	go func() {
 		for {
-			msg := ReceiveMessage(conn)
+			msg := ReceiveServerMessage(conn)
 			if msg == nil {
 				close(closed_ch)
 				return
@@ -101,6 +118,11 @@ func (channel *Channel) RunOnce(conn *net.TCPConn) {
 				if channel.dispatch != nil {
 					channel.dispatch(amsg)
 				}
+			} else if msg.cmd == MSG_PUBLISH_MULTI {
+				mamsg := msg.body.(*MultiAppMessage)
+				if channel.dispatch != nil {
+					channel.DispatchMulti(mamsg)
+				}
 			} else {
 				log.Error("unknown message cmd:", msg.cmd)
 			}
@@ -129,7 +151,7 @@ func (channel *Channel) RunOnce(conn *net.TCPConn) {
 func (channel *Channel) Run() {
 	nsleep := 100
 	for {
-		conn, err := net.Dial("tcp", channel.addr)
+		conn, err := DialCluster(channel.addr)
 		if err != nil {
 			mutex.Lock()
 			 _, ok := route_channels_map[channel.addr]
@@ -147,12 +169,24 @@ func (channel *Channel) Run() {
 			time.Sleep(time.Duration(nsleep) * time.Millisecond)
 			continue
 		}
-		tconn := conn.(*net.TCPConn)
-		tconn.SetKeepAlive(true)
-		tconn.SetKeepAlivePeriod(time.Duration(10 * 60 * time.Second))
 		log.Info("channel connected")
 		nsleep = 100
-		channel.RunOnce(tconn)
+		channel.RunOnce(conn)
+		metric_route_reconnects.WithLabelValues(channel.addr).Inc()
+	}
+}
+
+//每个接收者共享同一个消息
+func (channel *Channel) DispatchMulti(mamsg *MultiAppMessage) {
+	for _, receiver := range mamsg.receivers {
+		amsg := &AppMessage{
+			appid:     mamsg.appid,
+			receiver:  receiver,
+			msgid:     mamsg.msgid,
+			device_id: mamsg.device_id,
+			msg:       mamsg.msg,
+		}
+		channel.dispatch(amsg)
 	}
 }
 
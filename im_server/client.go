@@ -23,6 +23,7 @@ import "net"
 import "time"
 import "sync/atomic"
 import log "github.com/golang/glog"
+import "github.com/gorilla/websocket"
 
 type Client struct {
 	Connection//必须放在结构体首部
@@ -30,29 +31,50 @@ type Client struct {
 	*RoomClient
 	*VOIPClient
 	public_ip int32
+
+	limiter ConnLimiter
+	kicked  bool
+
+	//断线后恢复会话使用, 挂起期间suspended为1
+	resume_token string
+	suspended    int32
+
+	//恢复会话时检查认证用的token是否过期或者被吊销
+	//login_tm是使用token认证的时间, 恢复会话时不变
+	token_digest  string
+	token_expires int64
+	login_tm      int64
 }
 
 func NewClient(conn interface{}) *Client {
 	client := new(Client)
 
 	//初始化Connection
-	client.conn = conn // conn is net.Conn or engineio.Conn
+	client.conn = conn // conn is net.Conn, engineio.Conn or *websocket.Conn
 
+	var addr net.Addr
 	if net_conn, ok := conn.(net.Conn); ok {
-		addr := net_conn.LocalAddr()
+		addr = net_conn.LocalAddr()
+	} else if ws_conn, ok := conn.(*websocket.Conn); ok {
+		addr = ws_conn.LocalAddr()
+	}
+	if addr != nil {
 		if taddr, ok := addr.(*net.TCPAddr); ok {
 			ip4 := taddr.IP.To4()
 			client.public_ip = int32(ip4[0]) << 24 | int32(ip4[1]) << 16 | int32(ip4[2]) << 8 | int32(ip4[3])
 		}
 	}
 
-	client.wt = make(chan *Message, 10)
-	client.ewt = make(chan *EMessage, 10)
-	client.owt = make(chan *EMessage, 10)
+	client.wt = make(chan *Message, config.client_queue_size)
+	client.ewt = make(chan *EMessage, config.client_queue_size)
+	client.owt = make(chan *EMessage, config.client_queue_size)
 
 	client.unacks = make(map[int]int64)
 	client.unackMessages = make(map[int]*EMessage)
+	client.retransmits = make(map[int]*Retransmit)
+	client.pending_groups = make(map[int64]struct{})
 	atomic.AddInt64(&server_summary.nconnections, 1)
+	metric_connections.WithLabelValues(PlatformName(0)).Inc()
 
 	client.IMClient = &IMClient{&client.Connection}
 	client.RoomClient = &RoomClient{Connection:&client.Connection}
@@ -65,6 +87,10 @@ func (client *Client) Read() {
 	for {
 		msg := client.read()
 		if msg == nil {
+			if client.IsIdleTimeout() {
+				log.Infof("client:%d platform:%d idle timeout", client.uid, client.platform_id)
+				metric_idle_reaped.WithLabelValues(PlatformName(client.platform_id)).Inc()
+			}
 			client.HandleRemoveClient()
 			break
 		}
@@ -74,25 +100,43 @@ func (client *Client) Read() {
 
 func (client *Client) HandleRemoveClient() {
 	client.wt <- nil
+	if client.SuspendSession() {
+		return
+	}
+	client.Cleanup(false)
+}
+
+//resumed表示会话已经在其它连接上恢复, 登录点由新的连接继续使用
+func (client *Client) Cleanup(resumed bool) {
 	route.RemoveClient(client)
 
 	client.RoomClient.Logout(route)
 	client.IMClient.Logout()
 	
-	OpRemoveUserLoginPoint(client.uid, client.platform_id, client.device_id)
+	if !resumed {
+		OpRemoveUserLoginPoint(client.appid, client.uid, client.platform_id, client.device_id)
+	}
 	
-	if !route.IsOnline(client.uid) {
-		OpRemoveUserServer(client.uid, server_id)
+	if !route.IsOnline(client.appid, client.uid) {
+		OpRemoveUserServer(client.appid, client.uid, server_id)
+	}
+	
+	if !resumed && !client.tm.IsZero() {
+		PostUserEvent(client.appid, WEBHOOK_EVENT_USER_OFFLINE, client.uid, client.platform_id, client.device_id)
 	}
 }
 
 func (client *Client) HandleMessage(msg *Message) {
 	log.Info("msg cmd:", Command(msg.cmd))
+	metric_messages_in.WithLabelValues(CommandName(msg.cmd)).Inc()
+	if !client.AllowMessage(msg) {
+		return
+	}
 	switch msg.cmd {
 	case MSG_AUTH_TOKEN:
 		client.HandleAuthToken(msg.body.(*AuthenticationToken), msg.version)
 	case MSG_HEARTBEAT:
-		// nothing to do
+		//读超时在每次收到消息后重置
 	case MSG_PING:
 		client.HandlePing()
 	}
@@ -105,15 +149,20 @@ func (client *Client) HandleMessage(msg *Message) {
 
 func (client *Client) SendLoginPoint() {
 	//写入客户端连接机器id
-	OpAddUserServer(client.uid, server_id)
+	OpAddUserServer(client.appid, client.uid, server_id)
 	
 	//设置登录设备信息
-	OpAddUserLoginPoint(client.uid, client.platform_id, client.device_id)
+	OpAddUserLoginPoint(client.appid, client.uid, client.platform_id, client.device_id)
 }
 
-func (client *Client) AuthToken(token string) (int64, int64, error) {
-	appid, uid, _, err := OpLoadUserAccessToken(token)
-	return appid, uid, err
+func (client *Client) AuthToken(appid int64, token string) (int64, int64, error) {
+	info, err := Authenticate(appid, token)
+	if err != nil {
+		return 0, 0, err
+	}
+	client.token_digest = TokenDigest(token)
+	client.token_expires = info.expires
+	return info.appid, info.uid, nil
 }
 
 
@@ -123,17 +172,29 @@ func (client *Client) HandleAuthToken(login *AuthenticationToken, version int) {
 		return
 	}
 
+	if len(login.resume_token) > 0 && client.ResumeSession(login, version) {
+		return
+	}
+
 	var err error
-	client.appid, client.uid, err = client.AuthToken(login.token)
+	client.appid, client.uid, err = client.AuthToken(login.appid, login.token)
 	if err != nil {
 		log.Info("auth token err:", err)
-		msg := &Message{cmd: MSG_AUTH_STATUS, version:version, body: &AuthenticationStatus{1, 0}}
+		msg := &Message{cmd: MSG_AUTH_STATUS, version:version, body: &AuthenticationStatus{status:1}}
 		client.wt <- msg
 		return
 	}
 	if  client.uid == 0 {
 		log.Info("auth token uid==0")
-		msg := &Message{cmd: MSG_AUTH_STATUS, version:version, body: &AuthenticationStatus{1, 0}}
+		msg := &Message{cmd: MSG_AUTH_STATUS, version:version, body: &AuthenticationStatus{status:1}}
+		client.wt <- msg
+		return
+	}
+
+	if GetAppConfig(client.appid).IsConnectionFull(route.AppClientCount(client.appid)) {
+		log.Warningf("appid:%d connections exceed quota", client.appid)
+		client.appid, client.uid = 0, 0
+		msg := &Message{cmd: MSG_AUTH_STATUS, version:version, body: &AuthenticationStatus{status:1}}
 		client.wt <- msg
 		return
 	}
@@ -142,7 +203,7 @@ func (client *Client) HandleAuthToken(login *AuthenticationToken, version int) {
 		client.device_ID, err = GetDeviceID(login.device_id, int(login.platform_id))
 		if err != nil {
 			log.Info("auth token uid==0")
-			msg := &Message{cmd: MSG_AUTH_STATUS, version:version, body: &AuthenticationStatus{1, 0}}
+			msg := &Message{cmd: MSG_AUTH_STATUS, version:version, body: &AuthenticationStatus{status:1}}
 			client.wt <- msg
 			return
 		}
@@ -152,14 +213,25 @@ func (client *Client) HandleAuthToken(login *AuthenticationToken, version int) {
 	client.device_id = login.device_id
 	client.platform_id = login.platform_id
 	client.tm = time.Now()
-	log.Infof("auth token:%s appid:%d uid:%d device id:%s:%d", 
-		login.token, client.appid, client.uid, client.device_id, client.device_ID)
+	client.login_tm = client.tm.Unix()
+	client.NegotiateCapabilities(login)
+	if client.HasCapability(CAP_RESUME) {
+		client.resume_token = NewResumeToken()
+	}
+	log.Infof("auth token:%s appid:%d uid:%d device id:%s:%d capabilities:%x", 
+		login.token, client.appid, client.uid, client.device_id, client.device_ID, client.capabilities)
 
-	msg := &Message{cmd: MSG_AUTH_STATUS, version:version, body: &AuthenticationStatus{0, client.public_ip}}
+	status := &AuthenticationStatus{0, client.public_ip, client.capabilities, client.max_frame_size, client.resume_token}
+	msg := &Message{cmd: MSG_AUTH_STATUS, version:version, body: status}
 	client.wt <- msg
 
 	client.SendLoginPoint()
+	//登录点带有设备token时记录下来, 用户离线后route_server推送到该设备
+	if login.platform_id != PLATFORM_WEB && len(login.device_id) > 0 && len(login.device_token) > 0 {
+		OpBindDeviceToken(client.appid, client.uid, client.platform_id, client.device_id, "", login.device_token)
+	}
 	client.AddClient()
+	client.HandleLoginPolicy()
 
 	client.IMClient.Login()
 	
@@ -167,7 +239,11 @@ func (client *Client) HandleAuthToken(login *AuthenticationToken, version int) {
 	log.Infof("offline loaded:%d", client.uid)
 
 	CountDAU(client.appid, client.uid)
+	PostUserEvent(client.appid, WEBHOOK_EVENT_USER_ONLINE, client.uid, client.platform_id, client.device_id)
 	atomic.AddInt64(&server_summary.nclients, 1)
+	metric_connections.WithLabelValues(PlatformName(0)).Dec()
+	metric_connections.WithLabelValues(PlatformName(client.platform_id)).Inc()
+	metric_clients.WithLabelValues(PlatformName(client.platform_id)).Inc()
 }
 
 func (client *Client) AddClient() {
@@ -200,6 +276,7 @@ func (client *Client) Write() {
 				if client.uid > 0 {
 					atomic.AddInt64(&server_summary.nclients, -1)
 				}
+				client.RemoveMetrics()
 				running = false
 				log.Infof("client:%d socket closed", client.uid)
 				break
@@ -212,6 +289,14 @@ func (client *Client) Write() {
 			//以当前客户端所用版本号发送消息
 			vmsg := &Message{msg.cmd, seq, client.version, msg.body}
 			client.send(vmsg)
+			metric_messages_out.WithLabelValues(CommandName(msg.cmd)).Inc()
+			if msg.cmd == MSG_KICK {
+				//关闭socket, 由读协程完成后续清理
+				client.DisableResume()
+				client.close()
+			} else if msg.cmd == MSG_RECONNECT {
+				client.close()
+			}
 		case emsg, ok := <- client.owt:
 			if !ok {
 				//离线消息读取完毕
@@ -220,8 +305,8 @@ func (client *Client) Write() {
 			}
 			seq++
 
-			emsg.msg.seq = seq
-			client.AddUnAckMessage(emsg)
+			//emsg.msg可能被多个连接共享, seq只记录在当前连接中
+			client.AddUnAckMessage(emsg, seq)
 
 			//以当前客户端所用版本号发送消息
 			msg := &Message{emsg.msg.cmd, seq, client.version, emsg.msg.body}
@@ -229,10 +314,12 @@ func (client *Client) Write() {
 				atomic.AddInt64(&server_summary.out_message_count, 1)
 			}
 			client.send(msg)
+			metric_messages_out.WithLabelValues(CommandName(msg.cmd)).Inc()
 		}
 	}
 	
 	//发送在线消息
+	retransmit_ch := client.NextRetransmit()
 	for running {
 		select {
 		case msg := <-client.wt:
@@ -242,6 +329,7 @@ func (client *Client) Write() {
 				if client.uid > 0 {
 					atomic.AddInt64(&server_summary.nclients, -1)
 				}
+				client.RemoveMetrics()
 				running = false
 				log.Infof("client:%d socket closed", client.uid)
 				break
@@ -254,11 +342,19 @@ func (client *Client) Write() {
 			//以当前客户端所用版本号发送消息
 			vmsg := &Message{msg.cmd, seq, client.version, msg.body}
 			client.send(vmsg)
+			metric_messages_out.WithLabelValues(CommandName(msg.cmd)).Inc()
+			if msg.cmd == MSG_KICK {
+				//关闭socket, 由读协程完成后续清理
+				client.DisableResume()
+				client.close()
+			} else if msg.cmd == MSG_RECONNECT {
+				client.close()
+			}
 		case emsg := <- client.ewt:
 			seq++
 
-			emsg.msg.seq = seq
-			client.AddUnAckMessage(emsg)
+			//emsg.msg可能被多个连接共享, seq只记录在当前连接中
+			client.AddUnAckMessage(emsg, seq)
 
 			//以当前客户端所用版本号发送消息
 			msg := &Message{cmd:emsg.msg.cmd, seq:seq, version:client.version, body:emsg.msg.body}
@@ -266,10 +362,33 @@ func (client *Client) Write() {
 				atomic.AddInt64(&server_summary.out_message_count, 1)
 			}
 			client.send(msg)
+			metric_messages_out.WithLabelValues(CommandName(msg.cmd)).Inc()
+			if retransmit_ch == nil {
+				retransmit_ch = client.NextRetransmit()
+			}
+		case <- retransmit_ch:
+			if !client.Retransmit() {
+				//关闭socket, 由读协程完成后续清理, 未ack的消息重连后作为离线消息加载
+				client.close()
+				retransmit_ch = nil
+				break
+			}
+			retransmit_ch = client.NextRetransmit()
 		}
 	}
 }
 
+//连接关闭时扣减对应平台的计数
+func (client *Client) RemoveMetrics() {
+	//tm在认证成功时设置
+	if !client.tm.IsZero() {
+		metric_connections.WithLabelValues(PlatformName(client.platform_id)).Dec()
+		metric_clients.WithLabelValues(PlatformName(client.platform_id)).Dec()
+	} else {
+		metric_connections.WithLabelValues(PlatformName(0)).Dec()
+	}
+}
+
 func (client *Client) Run() {
 	go client.Write()
 	go client.Read()
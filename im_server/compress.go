@@ -0,0 +1,67 @@
+/**
+ * Copyright (c) 2014-2015, GoBelieve
+ * All rights reserved.
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place, Suite 330, Boston, MA  02111-1307  USA
+ */
+
+
+package main
+
+import "sync"
+import "bytes"
+import "encoding/binary"
+import "compress/flate"
+
+//消息头部的长度
+const HEADER_SIZE = 16
+
+var flate_writers = sync.Pool{
+	New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return w
+	},
+}
+
+//压缩消息帧的消息体, 在头部的保留字节中设置压缩标志
+//消息体太小或者压缩后没有变小时返回原来的帧
+func CompressFrame(b []byte) []byte {
+	body := b[HEADER_SIZE:]
+	if len(body) < config.compression_threshold || len(body) == 0 {
+		metric_compression_frames.WithLabelValues("small").Inc()
+		return b
+	}
+
+	buffer := bytes.NewBuffer(make([]byte, HEADER_SIZE, HEADER_SIZE + len(body)))
+	w := flate_writers.Get().(*flate.Writer)
+	w.Reset(buffer)
+	w.Write(body)
+	w.Close()
+	flate_writers.Put(w)
+
+	frame := buffer.Bytes()
+	if len(frame) >= len(b) {
+		metric_compression_frames.WithLabelValues("incompressible").Inc()
+		return b
+	}
+	copy(frame, b[:HEADER_SIZE])
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(frame) - HEADER_SIZE))
+	frame[13] |= MESSAGE_FLAG_COMPRESSED
+
+	metric_compression_frames.WithLabelValues("compressed").Inc()
+	metric_compression_raw_bytes.Add(float64(len(body)))
+	metric_compression_compressed_bytes.Add(float64(len(frame) - HEADER_SIZE))
+	return frame
+}
@@ -19,6 +19,7 @@
 
 package main
 
+import "fmt"
 import "strconv"
 import "log"
 import "strings"
@@ -33,10 +34,102 @@ type Config struct {
 	redis_address       string
 	redis_password		string
 	http_listen_address string
+	//运维接口(/drain, /set_app_secret)的密码, 为空时只能通过信号操作
+	admin_secret        string
 	socket_io_address   string
 
 	storage_addrs       []string
 	route_addrs         []string
+
+	webhook_workers     int
+	webhook_max_retries int
+
+	sensitive_words_file   string
+	sensitive_words_action string
+	moderation_audit_log   string
+
+	//rate_limit_<class>_<scope> = rate/burst
+	rate_limits [LIMIT_SCOPE_COUNT][LIMIT_CLASS_COUNT]RateLimit
+	//一分钟内被限流的次数超过阈值时断开连接, 0表示不断开
+	rate_limit_kick_threshold int
+
+	//app未设置时的默认登录策略
+	login_policy string
+
+	//按顺序尝试的认证方式: redis jwt http
+	authenticators    []string
+	auth_callback_url string
+
+	//app未单独设置时的默认配额
+	app_max_connections    int
+	app_max_group_members  int
+	app_max_content_length int
+
+	//分片拼接后单个消息的最大长度和每个连接缓存分片的总长度
+	max_message_size    int
+	max_fragment_buffer int
+
+	//消息体小于这个长度时不压缩, 小于0表示不支持压缩
+	compression_threshold int
+
+	//每个连接发送队列的长度和队列满时的处理策略
+	client_queue_size     int
+	queue_overflow_policy string
+
+	//等待ack的重发间隔(秒)和最大重发次数, 0表示不重发
+	retransmit_interval int
+	retransmit_max      int
+
+	//断线后保留会话的时间(秒), 0表示不支持恢复会话
+	//从认证开始可以恢复会话的最长时间(秒), 超过后必须使用token重新认证
+	resume_grace   int
+	resume_max_age int
+
+	//聊天室保留的消息数和保留时间(秒), 进入聊天室时发送的消息数
+	//room_history_size为0时不保存
+	room_history_size  int
+	room_history_age   int
+	room_history_enter int
+
+	//下线时通知客户端重连的分散时间(秒), 等待消息发送完成的超时(秒)
+	//客户端重连前随机等待的最大毫秒数和重连的地址
+	drain_jitter      int
+	drain_timeout     int
+	reconnect_backoff int
+	drain_redirect    string
+
+	//客户端空闲超时(秒), 超时未收到任何消息时断开连接
+	auth_timeout           int
+	client_timeout         int
+	client_timeout_ios     int
+	client_timeout_android int
+	client_timeout_web     int
+
+	//客户端tls端口, 0表示不开启
+	tls_port              int
+	tls_socket_io_address string
+	tls_cert_file         string
+	tls_key_file          string
+
+	//websocket地址, 为空时不开启
+	ws_address         string
+	wss_address        string
+	//允许的Origin, 为空时不检查
+	ws_allowed_origins []string
+
+	//im_server, route_server, storage_server之间的双向tls认证
+	cluster_cert_file string
+	cluster_key_file  string
+	cluster_ca_file   string
+	//校验route,storage证书时使用的名称, 为空时使用地址中的host
+	cluster_server_name string
+
+	//客户端使用的地址, 发布到redis供dispatcher分配, public_address为空时不发布
+	region                   string
+	public_address           string
+	public_tls_address       string
+	public_socket_io_address string
+	public_ws_address        string
 }
 
 func get_int(app_cfg map[string]string, key string) int {
@@ -59,6 +152,34 @@ func get_string(app_cfg map[string]string, key string) string {
 	return concurrency
 }
 
+func get_opt_int(app_cfg map[string]string, key string, def int) int {
+	concurrency, present := app_cfg[key]
+	if !present {
+		return def
+	}
+	n, err := strconv.Atoi(concurrency)
+	if err != nil {
+		log.Fatalf("key:%s is't integer", key)
+	}
+	return n
+}
+
+func get_opt_string(app_cfg map[string]string, key string) string {
+	concurrency, present := app_cfg[key]
+	if !present {
+		return ""
+	}
+	return concurrency
+}
+
+func get_opt_rate_limit(app_cfg map[string]string, key string) RateLimit {
+	l, err := ParseRateLimit(get_opt_string(app_cfg, key))
+	if err != nil {
+		log.Fatalf("key:%s error:%s", key, err)
+	}
+	return l
+}
+
 func read_cfg(cfg_path string) *Config {
 	config := new(Config)
 	app_cfg := make(map[string]string)
@@ -71,13 +192,107 @@ func read_cfg(cfg_path string) *Config {
 	config.server_id = get_string(app_cfg, "server_id")
 	config.port = get_int(app_cfg, "port")
 	config.http_listen_address = get_string(app_cfg, "http_listen_address")
+	config.admin_secret = get_opt_string(app_cfg, "admin_secret")
 	config.redis_address = get_string(app_cfg, "redis_address")
 	config.redis_password = get_string(app_cfg, "redis_password")
 	config.mysqldb_datasource = get_string(app_cfg, "mysqldb_source")
 	config.mysqldb_appdatasource = get_string(app_cfg, "mysqldb_appsource")
 	config.socket_io_address = get_string(app_cfg, "socket_io_address")
+	config.webhook_workers = get_opt_int(app_cfg, "webhook_workers", 4)
+	config.webhook_max_retries = get_opt_int(app_cfg, "webhook_max_retries", 8)
+	config.sensitive_words_file = get_opt_string(app_cfg, "sensitive_words_file")
+	config.sensitive_words_action = get_opt_string(app_cfg, "sensitive_words_action")
+	config.moderation_audit_log = get_opt_string(app_cfg, "moderation_audit_log")
+
+	for scope, scope_name := range limit_scope_names {
+		for class, class_name := range limit_class_names {
+			key := fmt.Sprintf("rate_limit_%s_%s", class_name, scope_name)
+			config.rate_limits[scope][class] = get_opt_rate_limit(app_cfg, key)
+		}
+	}
+	config.rate_limit_kick_threshold = get_opt_int(app_cfg, "rate_limit_kick_threshold", 0)
+
+	str := get_opt_string(app_cfg, "authenticators")
+	if len(str) == 0 {
+		str = "redis"
+	}
+	config.authenticators = strings.Fields(str)
+	config.auth_callback_url = get_opt_string(app_cfg, "auth_callback_url")
+	config.app_max_connections = get_opt_int(app_cfg, "app_max_connections", 0)
+	config.app_max_group_members = get_opt_int(app_cfg, "app_max_group_members", 500)
+	config.app_max_content_length = get_opt_int(app_cfg, "app_max_content_length", 0)
+
+	config.max_message_size = get_opt_int(app_cfg, "max_message_size", 1024*1024)
+	config.max_fragment_buffer = get_opt_int(app_cfg, "max_fragment_buffer", 4*1024*1024)
+	config.compression_threshold = get_opt_int(app_cfg, "compression_threshold", 256)
+
+	config.client_queue_size = get_opt_int(app_cfg, "client_queue_size", 64)
+	config.queue_overflow_policy = get_opt_string(app_cfg, "queue_overflow_policy")
+	if len(config.queue_overflow_policy) == 0 {
+		config.queue_overflow_policy = QUEUE_OVERFLOW_DROP
+	}
+	if !IsQueueOverflowPolicy(config.queue_overflow_policy) {
+		log.Fatal("invalid queue_overflow_policy:", config.queue_overflow_policy)
+	}
+
+	config.retransmit_interval = get_opt_int(app_cfg, "retransmit_interval", 10)
+	config.retransmit_max = get_opt_int(app_cfg, "retransmit_max", 3)
+	if config.retransmit_interval <= 0 {
+		config.retransmit_max = 0
+	}
+
+	config.resume_grace = get_opt_int(app_cfg, "resume_grace", 60)
+	config.resume_max_age = get_opt_int(app_cfg, "resume_max_age", 24 * 3600)
+
+	config.room_history_size = get_opt_int(app_cfg, "room_history_size", 50)
+	config.room_history_age = get_opt_int(app_cfg, "room_history_age", 3600)
+	config.room_history_enter = get_opt_int(app_cfg, "room_history_enter", 20)
+	if config.room_history_age <= 0 {
+		config.room_history_size = 0
+	}
+
+	config.drain_jitter = get_opt_int(app_cfg, "drain_jitter", 30)
+	config.drain_timeout = get_opt_int(app_cfg, "drain_timeout", 60)
+	config.reconnect_backoff = get_opt_int(app_cfg, "reconnect_backoff", 5000)
+	config.drain_redirect = get_opt_string(app_cfg, "drain_redirect")
+
+	config.auth_timeout = get_opt_int(app_cfg, "auth_timeout", 60)
+	config.client_timeout = get_opt_int(app_cfg, "client_timeout", CLIENT_TIMEOUT)
+	config.client_timeout_ios = get_opt_int(app_cfg, "client_timeout_ios", config.client_timeout)
+	config.client_timeout_android = get_opt_int(app_cfg, "client_timeout_android", config.client_timeout)
+	config.client_timeout_web = get_opt_int(app_cfg, "client_timeout_web", config.client_timeout)
+
+	config.tls_port = get_opt_int(app_cfg, "tls_port", 0)
+	config.tls_socket_io_address = get_opt_string(app_cfg, "tls_socket_io_address")
+	config.tls_cert_file = get_opt_string(app_cfg, "tls_cert_file")
+	config.tls_key_file = get_opt_string(app_cfg, "tls_key_file")
+	config.ws_address = get_opt_string(app_cfg, "ws_address")
+	config.wss_address = get_opt_string(app_cfg, "wss_address")
+	config.ws_allowed_origins = strings.Fields(get_opt_string(app_cfg, "ws_allowed_origins"))
+	if (config.tls_port > 0 || len(config.tls_socket_io_address) > 0 || len(config.wss_address) > 0) &&
+		(len(config.tls_cert_file) == 0 || len(config.tls_key_file) == 0) {
+		log.Fatal("tls need cert and key file")
+	}
+
+	config.cluster_cert_file = get_opt_string(app_cfg, "cluster_cert_file")
+	config.cluster_key_file = get_opt_string(app_cfg, "cluster_key_file")
+	config.cluster_ca_file = get_opt_string(app_cfg, "cluster_ca_file")
+	config.cluster_server_name = get_opt_string(app_cfg, "cluster_server_name")
+
+	config.region = get_opt_string(app_cfg, "region")
+	config.public_address = get_opt_string(app_cfg, "public_address")
+	config.public_tls_address = get_opt_string(app_cfg, "public_tls_address")
+	config.public_socket_io_address = get_opt_string(app_cfg, "public_socket_io_address")
+	config.public_ws_address = get_opt_string(app_cfg, "public_ws_address")
+
+	config.login_policy = get_opt_string(app_cfg, "login_policy")
+	if len(config.login_policy) == 0 {
+		config.login_policy = LOGIN_POLICY_ALL
+	} else if !IsLoginPolicy(config.login_policy) {
+		log.Fatalf("invalid login policy:%s", config.login_policy)
+	}
 
-	str := get_string(app_cfg, "storage_pool")
+	str = get_string(app_cfg, "storage_pool")
     array := strings.Split(str, " ")
 	config.storage_addrs = array
 	if len(config.storage_addrs) == 0 {
@@ -20,19 +20,42 @@
 package main
 
 import "net"
+import "bufio"
 import "time"
 import "sync"
+import "sync/atomic"
+import "bytes"
 import log "github.com/golang/glog"
 import "github.com/googollee/go-engine.io"
+import "github.com/gorilla/websocket"
 
 const CLIENT_TIMEOUT = (60 * 6)
 
+//tcp连接上一行json的最大长度
+const JSON_MAX_LINE = 64*1024
+
 type Connection struct {
 	conn   interface{}
+	reader *bufio.Reader
+
+	//发送消息使用的编码, 认证时由读协程设置, 通过Codec()读取
+	codec  int32
+	//认证时协商的能力, 旧版本客户端为0
+	capabilities   int32
+	max_frame_size int32
+
+	//分片的拼接和发送的分片id
+	assembler   FragmentAssembler
+	fragment_id int32
+
+	//当前读操作的超时时间
+	deadline time.Time
 
 	wt     chan *Message
 	ewt    chan *EMessage //在线消息
 	owt    chan *EMessage //离线消息
+	//发送队列溢出后已经关闭连接
+	overflowed int32
 
 	//客户端协议版本号
 	version int
@@ -46,33 +69,217 @@ type Connection struct {
 
 	unackMessages map[int]*EMessage
 	unacks map[int]int64
+	//等待ack的消息的重发状态, 以seq为key
+	retransmits map[int]*Retransmit
+	//投递过存储消息的群组
+	pending_groups map[int64]struct{}
+	//最后ack的点对点消息, 恢复会话时只加载之后的离线消息
+	last_acked_msgid int64
+	//为1时断线后不保留会话
+	no_resume int32
 	mutex  sync.Mutex
 }
 
+func (client *Connection) Codec() int {
+	return int(atomic.LoadInt32(&client.codec))
+}
+
 func (client *Connection) SendMessage(uid int64, msg *Message) bool {
 	return Send0Message(client.appid, uid, msg)
 }
 
-// 根据连接类型获取消息
+// 根据连接类型获取消息, 认证消息的格式决定之后发送消息的编码
 func (client *Connection) read() *Message {
+	for {
+		msg, codec := client.readMessage()
+		if msg == nil {
+			return nil
+		}
+		if msg.cmd == MSG_FRAGMENT {
+			var complete bool
+			msg, complete = client.reassemble(msg.body.(*Fragment), codec)
+			if !complete {
+				continue
+			}
+			if msg == nil {
+				return nil
+			}
+		}
+		if msg.cmd == MSG_AUTH_TOKEN || msg.cmd == MSG_AUTH {
+			atomic.StoreInt32(&client.codec, int32(codec))
+		}
+		return msg
+	}
+}
+
+//分片未收全时complete为false, 拼接或解析失败时返回的消息为nil
+func (client *Connection) reassemble(f *Fragment, codec int) (*Message, bool) {
+	b, err := client.assembler.Add(f)
+	if err != nil {
+		log.Infof("uid:%d fragment:%d error:%s", client.uid, f.id, err)
+		return nil, true
+	}
+	if b == nil {
+		return nil, false
+	}
+
+	var msg *Message
+	if codec == CODEC_JSON {
+		msg = DecodeJSONMessage(b)
+	} else {
+		msg = ReceiveLimitMessage(bytes.NewReader(b), config.max_message_size)
+	}
+	if msg != nil && msg.cmd == MSG_FRAGMENT {
+		log.Info("recursive fragment")
+		return nil, true
+	}
+	return msg, true
+}
+
+//未认证的连接使用较短的超时, 认证后按平台区分
+func (client *Connection) IdleTimeout() time.Duration {
+	timeout := config.client_timeout
+	if client.uid == 0 {
+		timeout = config.auth_timeout
+	} else if client.platform_id == PLATFORM_IOS {
+		timeout = config.client_timeout_ios
+	} else if client.platform_id == PLATFORM_ANDROID {
+		timeout = config.client_timeout_android
+	} else if client.platform_id == PLATFORM_WEB {
+		timeout = config.client_timeout_web
+	}
+	return time.Duration(timeout) * time.Second
+}
+
+//读超时表示连接空闲时间过长, 半开的连接也会在超时后被清理
+func (client *Connection) IsIdleTimeout() bool {
+	return !client.deadline.IsZero() && time.Now().After(client.deadline)
+}
+
+//engine.io连接没有读超时, 超时后关闭连接使NextReader返回
+func (client *Connection) readMessage() (*Message, int) {
 	if conn, ok := client.conn.(net.Conn); ok {
-		conn.SetDeadline(time.Now().Add(CLIENT_TIMEOUT * time.Second))
-		return ReceiveMessage(conn)
+		if client.reader == nil {
+			client.reader = bufio.NewReaderSize(conn, JSON_MAX_LINE)
+		}
+		client.deadline = time.Now().Add(client.IdleTimeout())
+		conn.SetDeadline(client.deadline)
+		return ReadTCPMessage(client.reader)
 	} else if conn, ok := client.conn.(engineio.Conn); ok {
+		timeout := client.IdleTimeout()
+		client.deadline = time.Now().Add(timeout)
+		timer := time.AfterFunc(timeout, func() {
+			conn.Close()
+		})
+		defer timer.Stop()
 		return ReadEngineIOMessage(conn)
+	} else if conn, ok := client.conn.(*websocket.Conn); ok {
+		client.deadline = time.Now().Add(client.IdleTimeout())
+		return ReadWebSocketMessage(conn, client.deadline)
+	}
+	return nil, CODEC_BINARY
+}
+
+//二进制消息的头部是长度, 第一个字节不可能是'{', 以此区分json
+func ReadTCPMessage(reader *bufio.Reader) (*Message, int) {
+	for {
+		b, err := reader.Peek(1)
+		if err != nil {
+			log.Info("sock read error:", err)
+			return nil, CODEC_BINARY
+		}
+		if b[0] != '{' && b[0] != '\n' && b[0] != '\r' {
+			return ReceiveMessage(reader), CODEC_BINARY
+		}
+
+		line, err := reader.ReadSlice('\n')
+		if err != nil {
+			log.Info("read json line error:", err)
+			return nil, CODEC_JSON
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		return DecodeJSONMessage(line), CODEC_JSON
 	}
-	return nil
 }
 
-// 根据连接类型发送消息
+func (client *Connection) encode(msg *Message) []byte {
+	if client.Codec() == CODEC_JSON {
+		b, err := EncodeJSONMessage(msg)
+		if err != nil {
+			log.Info("encode json message error:", err)
+			return nil
+		}
+		return b
+	}
+	buffer := new(bytes.Buffer)
+	WriteMessage(buffer, msg)
+	return buffer.Bytes()
+}
+
+// 根据连接类型发送消息, 客户端不支持的消息降级或者丢弃
 func (client *Connection) send(msg *Message) {
+	msg = client.Downgrade(msg)
+	if msg == nil {
+		return
+	}
+	b := client.encode(msg)
+	if b == nil {
+		return
+	}
+	if client.HasCapability(CAP_COMPRESSION) {
+		b = CompressFrame(b)
+	}
+
+	limit := int(client.max_frame_size)
+	if limit == 0 {
+		limit = MAX_FRAME_SIZE
+	}
+	if len(b) <= limit {
+		client.write(msg.cmd, b)
+		return
+	}
+
+	if !client.HasCapability(CAP_FRAGMENT) {
+		log.Warningf("uid:%d msg:%s size:%d exceed max frame size:%d",
+			client.uid, Command(msg.cmd), len(b), limit)
+		return
+	}
+	client.fragment_id++
+	fragments := SplitFrame(client.fragment_id, b, limit, client.Codec())
+	if fragments == nil {
+		log.Warningf("uid:%d msg:%s size:%d can't be fragmented", client.uid, Command(msg.cmd), len(b))
+		return
+	}
+	for _, f := range fragments {
+		m := &Message{cmd:MSG_FRAGMENT, seq:msg.seq, version:msg.version, body:f}
+		client.write(msg.cmd, client.encode(m))
+	}
+}
+
+func (client *Connection) write(cmd int, b []byte) {
 	if conn, ok := client.conn.(net.Conn); ok {
-		err := SendMessage(conn, msg)
+		if client.Codec() == CODEC_JSON {
+			b = append(b, '\n')
+		}
+		_, err := conn.Write(b)
 		if err != nil {
-			log.Info("send msg:", Command(msg.cmd),  " tcp err:", err)
+			log.Info("send msg:", Command(cmd),  " tcp err:", err)
 		}
 	} else if conn, ok := client.conn.(engineio.Conn); ok {
-		SendEngineIOBinaryMessage(conn, msg)
+		t := engineio.MessageBinary
+		if client.Codec() == CODEC_JSON {
+			t = engineio.MessageText
+		}
+		SendEngineIOMessage(conn, t, b)
+	} else if conn, ok := client.conn.(*websocket.Conn); ok {
+		t := websocket.BinaryMessage
+		if client.Codec() == CODEC_JSON {
+			t = websocket.TextMessage
+		}
+		SendWebSocketMessage(conn, t, b)
 	}
 }
 
@@ -82,6 +289,8 @@ func (client *Connection) close() {
 		conn.Close()
 	} else if conn, ok := client.conn.(engineio.Conn); ok {
 		conn.Close()
+	} else if conn, ok := client.conn.(*websocket.Conn); ok {
+		conn.Close()
 	}
 }
 
@@ -103,16 +312,17 @@ func (client *Connection) RemoveUnAckMessage(ack *MessageACK) *EMessage {
 		msg = emsg.msg
 		delete(client.unackMessages, seq)
 	}
+	client.removeRetransmit(seq)
 
 	return &EMessage{msgid:msgid, msg:msg}
 }
 
-func (client *Connection) AddUnAckMessage(emsg *EMessage) {
+func (client *Connection) AddUnAckMessage(emsg *EMessage, seq int) {
 	client.mutex.Lock()
 	defer client.mutex.Unlock()
-	seq := emsg.msg.seq
 	client.unacks[seq] = emsg.msgid
 	if emsg.msg.cmd == MSG_IM || emsg.msg.cmd == MSG_GROUP_IM {
 		client.unackMessages[seq] = emsg
 	}
+	client.addRetransmit(emsg, seq)
 }
@@ -0,0 +1,98 @@
+/**
+ * Copyright (c) 2014-2015, GoBelieve
+ * All rights reserved.
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place, Suite 330, Boston, MA  02111-1307  USA
+ */
+
+
+package main
+
+import "time"
+import "sync"
+import "math/rand"
+import "sync/atomic"
+import log "github.com/golang/glog"
+
+//下线过程中为1, 不再接受新的连接
+var draining int32
+
+//管理接口触发下线, 由waitSignal处理
+var drain_ch = make(chan bool, 1)
+
+func IsDraining() bool {
+	return atomic.LoadInt32(&draining) != 0
+}
+
+func RequestDrain() {
+	select {
+	case drain_ch <- true:
+	default:
+	}
+}
+
+//关闭客户端端口, 在drain_jitter时间内分散通知客户端重连, 避免所有客户端同时重连
+//等待连接全部关闭或者超时后返回
+func Drain() {
+	if !atomic.CompareAndSwapInt32(&draining, 0, 1) {
+		return
+	}
+	log.Info("drain begin")
+	shutdownExcept(config.http_listen_address)
+	//dispatcher不再分配这台服务器
+	PublishLoad()
+
+	clients := route.AllClients()
+	jitter := int64(config.drain_jitter) * int64(time.Second)
+	var wg sync.WaitGroup
+	for _, c := range clients {
+		delay := time.Duration(rand.Int63n(jitter + 1))
+		wg.Add(1)
+		go func(c *Client) {
+			defer wg.Done()
+			time.Sleep(delay)
+			c.Redirect()
+		}(c)
+	}
+	wg.Wait()
+
+	deadline := time.Now().Add(time.Duration(config.drain_timeout) * time.Second)
+	for atomic.LoadInt64(&server_summary.nconnections) > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Second)
+	}
+	log.Infof("drain end, remain connections:%d", atomic.LoadInt64(&server_summary.nconnections))
+}
+
+func (client *Client) HasPendingMessages() bool {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return len(client.retransmits) > 0 || len(client.wt) > 0 || len(client.ewt) > 0
+}
+
+//等待未ack的消息发送完成后通知客户端重连, 写协程发送后关闭连接
+func (client *Client) Redirect() {
+	if client.IsSuspended() {
+		return
+	}
+	deadline := time.Now().Add(time.Duration(config.drain_timeout) * time.Second)
+	for client.HasPendingMessages() && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	r := &Reconnect{delay: int32(rand.Intn(config.reconnect_backoff + 1)), address: config.drain_redirect}
+	if !client.EnqueueMessage(&Message{cmd: MSG_RECONNECT, body: r}) {
+		client.close()
+	}
+}
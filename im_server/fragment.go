@@ -0,0 +1,153 @@
+/**
+ * Copyright (c) 2014-2015, GoBelieve
+ * All rights reserved.
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place, Suite 330, Boston, MA  02111-1307  USA
+ */
+
+package main
+
+import "bytes"
+import "errors"
+import "time"
+import log "github.com/golang/glog"
+
+//未收全的分片保留的时间
+const FRAGMENT_TIMEOUT = 60
+
+//每个连接同时未收全的消息数
+const MAX_FRAGMENT_IDS = 16
+
+//每个分片在parts中占用的长度, 计入max_fragment_buffer
+const FRAGMENT_PART_SIZE = 24
+
+//分片头部和json编码的额外长度
+const FRAGMENT_OVERHEAD = 16 + 8
+const FRAGMENT_JSON_OVERHEAD = 128
+
+type FragmentBuffer struct {
+	parts    [][]byte
+	received int
+	size     int //已收到的数据长度
+	tm       time.Time
+}
+
+//占用的总长度
+func (buf *FragmentBuffer) Cost() int {
+	return buf.size + len(buf.parts) * FRAGMENT_PART_SIZE
+}
+
+//只在读协程中使用, 不需要加锁
+type FragmentAssembler struct {
+	buffers map[int32]*FragmentBuffer
+	//所有未完成消息占用的总长度
+	size    int
+}
+
+//返回拼接好的消息帧, 分片未收全时返回nil
+func (a *FragmentAssembler) Add(f *Fragment) ([]byte, error) {
+	if f.count <= 0 || f.index < 0 || f.index >= f.count {
+		return nil, errors.New("invalid fragment index")
+	}
+	if a.buffers == nil {
+		a.buffers = make(map[int32]*FragmentBuffer)
+	}
+	a.expire()
+
+	buf, ok := a.buffers[f.id]
+	if !ok {
+		if len(a.buffers) >= MAX_FRAGMENT_IDS {
+			return nil, errors.New("too many fragmented messages")
+		}
+		cost := int(f.count) * FRAGMENT_PART_SIZE
+		if a.size + cost > config.max_fragment_buffer {
+			return nil, errors.New("fragment buffer full")
+		}
+		buf = &FragmentBuffer{parts:make([][]byte, f.count), tm:time.Now()}
+		a.buffers[f.id] = buf
+		a.size += cost
+	}
+	if len(buf.parts) != int(f.count) {
+		a.remove(f.id)
+		return nil, errors.New("fragment count mismatch")
+	}
+	if buf.parts[f.index] != nil {
+		//重复的分片
+		return nil, nil
+	}
+
+	if buf.size + len(f.data) > config.max_message_size {
+		a.remove(f.id)
+		return nil, errors.New("message too large")
+	}
+	if a.size + len(f.data) > config.max_fragment_buffer {
+		a.remove(f.id)
+		return nil, errors.New("fragment buffer full")
+	}
+
+	buf.parts[f.index] = f.data
+	buf.received++
+	buf.size += len(f.data)
+	a.size += len(f.data)
+	if buf.received < len(buf.parts) {
+		return nil, nil
+	}
+
+	a.remove(f.id)
+	return bytes.Join(buf.parts, nil), nil
+}
+
+func (a *FragmentAssembler) remove(id int32) {
+	if buf, ok := a.buffers[id]; ok {
+		a.size -= buf.Cost()
+		delete(a.buffers, id)
+	}
+}
+
+func (a *FragmentAssembler) expire() {
+	for id, buf := range a.buffers {
+		if time.Since(buf.tm) > FRAGMENT_TIMEOUT * time.Second {
+			log.Infof("fragment:%d expired, received:%d/%d", id, buf.received, len(buf.parts))
+			a.remove(id)
+		}
+	}
+}
+
+//把编码后的消息帧拆分成多个分片, 每个分片编码后不超过size
+func SplitFrame(id int32, b []byte, size int, codec int) []*Fragment {
+	n := size - FRAGMENT_OVERHEAD
+	if codec == CODEC_JSON {
+		//data使用base64编码
+		n = (size - FRAGMENT_JSON_OVERHEAD) * 3 / 4
+	}
+	if n <= 0 {
+		return nil
+	}
+
+	count := (len(b) + n - 1) / n
+	if count > 0x7fff {
+		return nil
+	}
+	fragments := make([]*Fragment, 0, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * n
+		if end > len(b) {
+			end = len(b)
+		}
+		f := &Fragment{id:id, index:int16(i), count:int16(count), data:b[i*n:end]}
+		fragments = append(fragments, f)
+	}
+	return fragments
+}
@@ -0,0 +1,143 @@
+package main
+
+import "bytes"
+import "time"
+import "testing"
+
+func fragment(id int32, index int16, count int16, data string) *Fragment {
+	return &Fragment{id:id, index:index, count:count, data:[]byte(data)}
+}
+
+func Test_FragmentAssemble(t *testing.T) {
+	config = &Config{max_message_size:1024, max_fragment_buffer:4096}
+	a := &FragmentAssembler{}
+
+	//乱序到达, 重复的分片被忽略
+	for _, f := range []*Fragment{fragment(1, 2, 3, "cc"), fragment(1, 0, 3, "aa"), fragment(1, 2, 3, "xx")} {
+		b, err := a.Add(f)
+		if b != nil || err != nil {
+			t.Fatal("incomplete message:", b, err)
+		}
+	}
+	b, err := a.Add(fragment(1, 1, 3, "bb"))
+	if err != nil || string(b) != "aabbcc" {
+		t.Fatal("assemble error:", string(b), err)
+	}
+	if len(a.buffers) != 0 || a.size != 0 {
+		t.Error("buffer isn't released:", len(a.buffers), a.size)
+	}
+}
+
+func Test_FragmentInvalid(t *testing.T) {
+	config = &Config{max_message_size:1024, max_fragment_buffer:4096}
+	a := &FragmentAssembler{}
+
+	for _, f := range []*Fragment{fragment(1, 0, 0, "a"), fragment(1, -1, 2, "a"), fragment(1, 2, 2, "a")} {
+		if _, err := a.Add(f); err == nil {
+			t.Error("invalid index accepted:", f.index, f.count)
+		}
+	}
+
+	a.Add(fragment(1, 0, 2, "a"))
+	if _, err := a.Add(fragment(1, 1, 3, "b")); err == nil {
+		t.Error("count mismatch accepted")
+	}
+	if len(a.buffers) != 0 || a.size != 0 {
+		t.Error("mismatched buffer isn't removed:", len(a.buffers), a.size)
+	}
+}
+
+func Test_FragmentLimit(t *testing.T) {
+	config = &Config{max_message_size:4, max_fragment_buffer:4096}
+	a := &FragmentAssembler{}
+	a.Add(fragment(1, 0, 3, "aaa"))
+	if _, err := a.Add(fragment(1, 1, 3, "bb")); err == nil {
+		t.Error("message size exceeded")
+	}
+	if a.size != 0 {
+		t.Error("buffer isn't released:", a.size)
+	}
+
+	//预分配的parts也计入缓冲区
+	config = &Config{max_message_size:1024, max_fragment_buffer:FRAGMENT_PART_SIZE*4}
+	a = &FragmentAssembler{}
+	if _, err := a.Add(fragment(1, 0, 5, "a")); err == nil {
+		t.Error("parts exceed fragment buffer")
+	}
+	a.Add(fragment(1, 0, 2, "a"))
+	if _, err := a.Add(fragment(2, 0, 2, "b")); err == nil {
+		t.Error("data exceed fragment buffer")
+	}
+
+	config = &Config{max_message_size:1024, max_fragment_buffer:1024*1024}
+	a = &FragmentAssembler{}
+	for i := 0; i < MAX_FRAGMENT_IDS; i++ {
+		if _, err := a.Add(fragment(int32(i), 0, 2, "a")); err != nil {
+			t.Fatal("add fragment error:", err)
+		}
+	}
+	if _, err := a.Add(fragment(MAX_FRAGMENT_IDS, 0, 2, "a")); err == nil {
+		t.Error("too many fragmented messages")
+	}
+	//已有的消息仍然可以继续接收
+	if b, err := a.Add(fragment(0, 1, 2, "b")); err != nil || string(b) != "ab" {
+		t.Error("assemble error:", string(b), err)
+	}
+}
+
+func Test_FragmentExpire(t *testing.T) {
+	config = &Config{max_message_size:1024, max_fragment_buffer:4096}
+	a := &FragmentAssembler{}
+	a.Add(fragment(1, 0, 2, "aaaa"))
+	size := a.size
+	a.buffers[1].tm = time.Now().Add(-(FRAGMENT_TIMEOUT + 1) * time.Second)
+
+	a.Add(fragment(2, 0, 2, "b"))
+	if _, ok := a.buffers[1]; ok {
+		t.Fatal("fragment isn't expired")
+	}
+	if a.size != a.buffers[2].Cost() || a.size >= size {
+		t.Error("expired buffer isn't released:", a.size)
+	}
+}
+
+func Test_SplitFrame(t *testing.T) {
+	b := make([]byte, 1000)
+	for i := range b {
+		b[i] = byte(i)
+	}
+
+	for _, size := range []int{100, 512} {
+	for _, codec := range []int{CODEC_BINARY, CODEC_JSON} {
+		n := size - FRAGMENT_OVERHEAD
+		if codec == CODEC_JSON {
+			n = (size - FRAGMENT_JSON_OVERHEAD) * 3 / 4
+		}
+		if n <= 0 {
+			if SplitFrame(1, b, size, codec) != nil {
+				t.Error("split with no room for data")
+			}
+			continue
+		}
+
+		fragments := SplitFrame(1, b, size, codec)
+		if len(fragments) != (len(b) + n - 1) / n {
+			t.Fatal("fragment count error:", len(fragments))
+		}
+		parts := make([][]byte, 0, len(fragments))
+		for i, f := range fragments {
+			if f.id != 1 || int(f.index) != i || int(f.count) != len(fragments) || len(f.data) > n {
+				t.Fatal("fragment error:", f.id, f.index, f.count, len(f.data))
+			}
+			parts = append(parts, f.data)
+		}
+		if !bytes.Equal(bytes.Join(parts, nil), b) {
+			t.Error("fragments don't rejoin to frame")
+		}
+	}
+	}
+
+	if SplitFrame(1, make([]byte, 0x8000), FRAGMENT_OVERHEAD + 1, CODEC_BINARY) != nil {
+		t.Error("fragment count exceeds limit")
+	}
+}
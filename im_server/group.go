@@ -39,15 +39,15 @@ type Group struct {
 }
 
 
-func OpCreateGroup(db *sql.DB, gid int64, title string, desc string, is_private int, is_allow_invite int, owner int64, gouhao int) bool {
+func OpCreateGroup(db *sql.DB, appid int64, gid int64, title string, desc string, is_private int, is_allow_invite int, owner int64, gouhao int) bool {
 	conn := redis_pool.Get()
 	defer conn.Close()
 	
-	if !CreateGroup(db, gid, title, desc, is_private, is_allow_invite, owner, gouhao) {
+	if !CreateGroup(db, appid, gid, title, desc, is_private, is_allow_invite, owner, gouhao) {
 		return false
 	}
 	
-	key := fmt.Sprintf("group_%d", gid)
+	key := fmt.Sprintf("group_%d_%d", appid, gid)
 	_, err := conn.Do("HMSET", key, "title", title, "desc", desc, "is_private", is_private, "is_allow_invite", is_allow_invite, "owner", owner, "gouhao", gouhao)
 	if err != nil {
 		log.Infoln(err)
@@ -57,11 +57,11 @@ func OpCreateGroup(db *sql.DB, gid int64, title string, desc string, is_private
 	return true
 }
 
-func OpGetGroup(gid int64) *Group{
+func OpGetGroup(appid int64, gid int64) *Group{
 	conn := redis_pool.Get()
 	defer conn.Close()
 	
-	key := fmt.Sprintf("group_%d", gid)
+	key := fmt.Sprintf("group_%d_%d", appid, gid)
 	reply, err := redis.Values(conn.Do("HMGET", key, "title", "desc", "is_private", "is_allow_invite", "owner", "gouhao"))
 	if err != nil {
 		log.Info("hmget error:", err)
@@ -83,6 +83,7 @@ func OpGetGroup(gid int64) *Group{
 	return &Group{
 		gid: gid,
 		title : title,
+		desc : desc,
 		is_private : is_private,
 		is_allow_invite: is_allow_invite,
 		owner: owner,
@@ -90,22 +91,40 @@ func OpGetGroup(gid int64) *Group{
 	}
 }
 
-func OpDelGroup(db *sql.DB, gid int64) bool {
+func OpUpdateGroup(db *sql.DB, appid int64, gid int64, title string, desc string, is_private int, is_allow_invite int) bool {
 	conn := redis_pool.Get()
 	defer conn.Close()
 	
-	if !DeleteGroup(db, gid) {
+	if !UpdateGroup(db, appid, gid, title, desc, is_private, is_allow_invite) {
 		return false
 	}
 	
-	key := fmt.Sprintf("group_%d", gid)
+	key := fmt.Sprintf("group_%d_%d", appid, gid)
+	_, err := conn.Do("HMSET", key, "title", title, "desc", desc, "is_private", is_private, "is_allow_invite", is_allow_invite)
+	if err != nil {
+		log.Infoln(err)
+		return false
+	}
+	
+	return true
+}
+
+func OpDelGroup(db *sql.DB, appid int64, gid int64) bool {
+	conn := redis_pool.Get()
+	defer conn.Close()
+	
+	if !DeleteGroup(db, appid, gid) {
+		return false
+	}
+	
+	key := fmt.Sprintf("group_%d_%d", appid, gid)
 	_, err := conn.Do("DEL", key)
 	if err != nil {
 		log.Warning("del error:", err)
 		return true
 	}
 	
-	key = fmt.Sprintf("group_members_%d", gid)
+	key = fmt.Sprintf("group_members_%d_%d", appid, gid)
 	_, err = conn.Do("DEL", key)
 	if err != nil {
 		log.Warning("del error:", err)
@@ -115,11 +134,11 @@ func OpDelGroup(db *sql.DB, gid int64) bool {
 	return true
 }
 
-func OpGetGroupMemberNumber(gid int64) int {
+func OpGetGroupMemberNumber(appid int64, gid int64) int {
 	conn := redis_pool.Get()
 	defer conn.Close()
 	
-	key := fmt.Sprintf("group_members_%d", gid)
+	key := fmt.Sprintf("group_members_%d_%d", appid, gid)
 	number, err := redis.Int(conn.Do("SCARD", key))
 	if err != nil {
 		return 0
@@ -128,11 +147,11 @@ func OpGetGroupMemberNumber(gid int64) int {
 	return number
 }
 
-func OpIsGroupMember(gid int64, uid int64) bool {
+func OpIsGroupMember(appid int64, gid int64, uid int64) bool {
 	conn := redis_pool.Get()
 	defer conn.Close()
 	
-	key := fmt.Sprintf("group_members_%d", gid)
+	key := fmt.Sprintf("group_members_%d_%d", appid, gid)
 	isMember, err := redis.Bool(conn.Do("SISMEMBER", key, uid))
 	if err != nil {
 		return false
@@ -141,13 +160,13 @@ func OpIsGroupMember(gid int64, uid int64) bool {
 	return isMember
 }
 
-func OpGetGroupMembers(gid int64) []int64 {
+func OpGetGroupMembers(appid int64, gid int64) []int64 {
 	conn := redis_pool.Get()
 	defer conn.Close()
 	
-	uids := make([]int64, 4, 10)
+	uids := make([]int64, 0, 10)
 	
-	key := fmt.Sprintf("group_members_%d", gid)
+	key := fmt.Sprintf("group_members_%d_%d", appid, gid)
 	members, err := redis.Ints(conn.Do("SMEMBERS", key))
 	if err != nil {
 		return uids
@@ -161,7 +180,7 @@ func OpGetGroupMembers(gid int64) []int64 {
 	defer db.Close()
 	
 	if len(members) == 0 {
-		ms, err := LoadGroupMember(db, gid)
+		ms, err := LoadGroupMember(db, appid, gid)
 		if err != nil {
 			return uids
 		}
@@ -183,12 +202,12 @@ func OpGetGroupMembers(gid int64) []int64 {
 	return uids
 }
 
-func OpGetRoomMembers(gid int64) []int64 {
+func OpGetRoomMembers(appid int64, gid int64) []int64 {
 	conn := redis_pool.Get()
 	defer conn.Close()
 	
-	uids := make([]int64, 4, 10)
-	key := fmt.Sprintf("room_members_%d", gid)
+	uids := make([]int64, 0, 10)
+	key := fmt.Sprintf("room_members_%d_%d", appid, gid)
 	members, err := redis.Ints(conn.Do("SMEMBERS", key))
 	if err != nil {
 		return uids
@@ -201,11 +220,11 @@ func OpGetRoomMembers(gid int64) []int64 {
 	return uids
 }
 
-func OpAddRoomMember(gid int64, uid int64) bool {
+func OpAddRoomMember(appid int64, gid int64, uid int64) bool {
 	conn := redis_pool.Get()
 	defer conn.Close()
 	
-	key := fmt.Sprintf("room_members_%d", gid)
+	key := fmt.Sprintf("room_members_%d_%d", appid, gid)
 	_, err := conn.Do("SADD", key, uid)
 	if err != nil {
 		log.Infoln(err)
@@ -215,11 +234,11 @@ func OpAddRoomMember(gid int64, uid int64) bool {
 	return true
 }
 
-func OpRemoveRoomMember(gid int64, uid int64) bool {
+func OpRemoveRoomMember(appid int64, gid int64, uid int64) bool {
 	conn := redis_pool.Get()
 	defer conn.Close()
 	
-	key := fmt.Sprintf("room_members_%d", gid)
+	key := fmt.Sprintf("room_members_%d_%d", appid, gid)
 	_, err := conn.Do("SREM", key, uid)
 	if err != nil {
 		log.Infoln(err)
@@ -229,21 +248,21 @@ func OpRemoveRoomMember(gid int64, uid int64) bool {
 	return true
 }
 
-func OpAddGroupMember(db *sql.DB, gid int64, uid int64, isOwner int) bool {
-	if !AddGroupMember(db, gid, uid, isOwner) {
+func OpAddGroupMember(db *sql.DB, appid int64, gid int64, uid int64, isOwner int) bool {
+	if !AddGroupMember(db, appid, gid, uid, isOwner) {
 		return false
 	}
 	
 	conn := redis_pool.Get()
 	defer conn.Close()
 	
-	key := fmt.Sprintf("group_members_%d", gid)
+	key := fmt.Sprintf("group_members_%d_%d", appid, gid)
 	_, err := conn.Do("SADD", key, uid)
 	if err != nil {
 		log.Infoln(err)
 	}
 	
-	key = fmt.Sprintf("user_groups_%d", uid)
+	key = fmt.Sprintf("user_groups_%d_%d", appid, uid)
 	_, err = conn.Do("SADD", key, gid)
 	if err != nil {
 		log.Infoln(err)
@@ -252,21 +271,21 @@ func OpAddGroupMember(db *sql.DB, gid int64, uid int64, isOwner int) bool {
 	return true
 }
 
-func OpRemoveGroupMember(db *sql.DB, gid int64, uid int64) bool {
-	if !RemoveGroupMember(db, gid, uid) {
+func OpRemoveGroupMember(db *sql.DB, appid int64, gid int64, uid int64) bool {
+	if !RemoveGroupMember(db, appid, gid, uid) {
 		return false
 	}
 	
 	conn := redis_pool.Get()
 	defer conn.Close()
 	
-	key := fmt.Sprintf("group_members_%d", gid)
+	key := fmt.Sprintf("group_members_%d_%d", appid, gid)
 	_, err := conn.Do("SREM", key, uid)
 	if err != nil {
 		log.Infoln(err)
 	}
 	
-	key = fmt.Sprintf("user_groups_%d", uid)
+	key = fmt.Sprintf("user_groups_%d_%d", appid, uid)
 	_, err = conn.Do("SREM", key, gid)
 	if err != nil {
 		log.Infoln(err)
@@ -275,7 +294,7 @@ func OpRemoveGroupMember(db *sql.DB, gid int64, uid int64) bool {
 	return true
 }
 
-func AddGroupMember(db *sql.DB, group_id int64, uid int64, isOwner int) bool {
+func AddGroupMember(db *sql.DB, appid int64, group_id int64, uid int64, isOwner int) bool {
 	var stmt1, stmt2 *sql.Stmt
 
 	tx, err := db.Begin()
@@ -284,8 +303,8 @@ func AddGroupMember(db *sql.DB, group_id int64, uid int64, isOwner int) bool {
 		return false
 	}
 
-	sql := fmt.Sprintf("INSERT INTO `group_members_0%d` ( `group_id`, `user_id`, `create_time`, `update_time`) select '%d', %d, %d, %d from dual where not exists(select * from group_members_0%d where group_id='%d' and user_id=%d)",
-			group_id % 10, group_id, uid, time.Now().Unix(), time.Now().Unix(), group_id % 10, group_id, uid)
+	sql := fmt.Sprintf("INSERT INTO `group_members_0%d` ( `appid`, `group_id`, `user_id`, `create_time`, `update_time`) select %d, '%d', %d, %d, %d from dual where not exists(select * from group_members_0%d where appid=%d and group_id='%d' and user_id=%d)",
+			group_id % 10, appid, group_id, uid, time.Now().Unix(), time.Now().Unix(), group_id % 10, appid, group_id, uid)
 	stmt1, err = tx.Prepare(sql)
 	if err != nil {
 		log.Info("error:", err)
@@ -298,8 +317,8 @@ func AddGroupMember(db *sql.DB, group_id int64, uid int64, isOwner int) bool {
 		goto ROLLBACK
 	}
 
-	sql = fmt.Sprintf("INSERT INTO `user_groups_0%d` ( `group_id`, `user_id`, `isOwner`, `type`) select '%d', %d, %d, %d from dual where not exists(select * from user_groups_0%d where type=1 and group_id='%d' and user_id=%d)",
-			uid % 10, group_id, uid, isOwner, 1, uid % 10, group_id, uid)
+	sql = fmt.Sprintf("INSERT INTO `user_groups_0%d` ( `appid`, `group_id`, `user_id`, `isOwner`, `type`) select %d, '%d', %d, %d, %d from dual where not exists(select * from user_groups_0%d where type=1 and appid=%d and group_id='%d' and user_id=%d)",
+			uid % 10, appid, group_id, uid, isOwner, 1, uid % 10, appid, group_id, uid)
 	stmt2, err = tx.Prepare(sql)
 	if err != nil {
 		log.Info("error:", err)
@@ -320,7 +339,7 @@ ROLLBACK:
 	return false
 }
 
-func RemoveGroupMember(db *sql.DB, group_id int64, uid int64) bool {	
+func RemoveGroupMember(db *sql.DB, appid int64, group_id int64, uid int64) bool {	
 	var stmt1, stmt2 *sql.Stmt
 
 	tx, err := db.Begin()
@@ -329,27 +348,27 @@ func RemoveGroupMember(db *sql.DB, group_id int64, uid int64) bool {
 		return false
 	}
 
-	sql := fmt.Sprintf("delete from group_members_0%d where group_id=? and user_id=?", group_id % 10);
+	sql := fmt.Sprintf("delete from group_members_0%d where appid=? and group_id=? and user_id=?", group_id % 10);
 	stmt1, err = tx.Prepare(sql)
 	if err != nil {
 		log.Info("error:", err)
 		goto ROLLBACK
 	}
 	defer stmt1.Close()
-	_, err = stmt1.Exec(group_id, uid)
+	_, err = stmt1.Exec(appid, group_id, uid)
 	if err != nil {
 		log.Info("error:", err)
 		goto ROLLBACK
 	}
 
-	sql = fmt.Sprintf("delete from user_groups_0%d where type=1 and group_id=? and user_id=?", uid % 10);
+	sql = fmt.Sprintf("delete from user_groups_0%d where type=1 and appid=? and group_id=? and user_id=?", uid % 10);
 	stmt2, err = tx.Prepare(sql)
 	if err != nil {
 		log.Info("error:", err)
 		goto ROLLBACK
 	}
 	defer stmt2.Close()
-	_, err = stmt2.Exec(group_id, uid)
+	_, err = stmt2.Exec(appid, group_id, uid)
 	if err != nil {
 		log.Info("error:", err)
 		goto ROLLBACK
@@ -363,8 +382,8 @@ ROLLBACK:
 	return false
 }
 
-func LoadGroupMember(db *sql.DB, group_id int64) ([]int64, error) {	
-	sql := fmt.Sprintf("SELECT user_id FROM group_members_0%d WHERE group_id=?", group_id % 10)
+func LoadGroupMember(db *sql.DB, appid int64, group_id int64) ([]int64, error) {	
+	sql := fmt.Sprintf("SELECT user_id FROM group_members_0%d WHERE appid=? AND group_id=?", group_id % 10)
 	stmtIns, err := db.Prepare(sql)
 	if err != nil {
 		log.Info("error:", err)
@@ -373,7 +392,7 @@ func LoadGroupMember(db *sql.DB, group_id int64) ([]int64, error) {
 
 	defer stmtIns.Close()
 	members := make([]int64, 0, 4)
-	rows, err := stmtIns.Query(group_id)
+	rows, err := stmtIns.Query(appid, group_id)
 	for rows.Next() {
 		var uid int64
 		rows.Scan(&uid)
@@ -382,8 +401,26 @@ func LoadGroupMember(db *sql.DB, group_id int64) ([]int64, error) {
 	return members, nil
 }
 
-func CreateGroup(db *sql.DB, id int64, title string, desc string, isPrivate int, isAllowInvite int, owner int64, gouhao int) bool {	
-	stmt, err := db.Prepare("INSERT INTO `group` (`id`, `title`, `desc`, `owner`, `gouhao`, `isPrivate`, `isAllowInvite`, `create_time`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)")
+func CreateGroup(db *sql.DB, appid int64, id int64, title string, desc string, isPrivate int, isAllowInvite int, owner int64, gouhao int) bool {	
+	stmt, err := db.Prepare("INSERT INTO `group` (`id`, `appid`, `title`, `desc`, `owner`, `gouhao`, `isPrivate`, `isAllowInvite`, `create_time`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		log.Info("error:", err)
+		return false
+	}
+	
+	defer stmt.Close()
+	
+	_, err = stmt.Exec(id, appid, title, desc, owner, gouhao, isPrivate, isAllowInvite, time.Now().Unix())
+	if err != nil {
+		log.Info("error:", err)
+		return false
+	}
+	
+	return true
+}
+
+func UpdateGroup(db *sql.DB, appid int64, id int64, title string, desc string, isPrivate int, isAllowInvite int) bool {
+	stmt, err := db.Prepare("UPDATE `group` SET `title`=?, `desc`=?, `isPrivate`=?, `isAllowInvite`=? WHERE appid=? AND id=?")
 	if err != nil {
 		log.Info("error:", err)
 		return false
@@ -391,7 +428,7 @@ func CreateGroup(db *sql.DB, id int64, title string, desc string, isPrivate int,
 	
 	defer stmt.Close()
 	
-	_, err = stmt.Exec(id, title, desc, owner, gouhao, isPrivate, isAllowInvite, time.Now().Unix())
+	_, err = stmt.Exec(title, desc, isPrivate, isAllowInvite, appid, id)
 	if err != nil {
 		log.Info("error:", err)
 		return false
@@ -400,8 +437,8 @@ func CreateGroup(db *sql.DB, id int64, title string, desc string, isPrivate int,
 	return true
 }
 
-func DeleteGroup(db *sql.DB, id int64) bool {
-	stmt, err := db.Prepare("UPDATE `group` SET isDeleted=1 WHERE id=?")
+func DeleteGroup(db *sql.DB, appid int64, id int64) bool {
+	stmt, err := db.Prepare("UPDATE `group` SET isDeleted=1 WHERE appid=? AND id=?")
 	if err != nil {
 		log.Info("error:", err)
 		return false
@@ -409,7 +446,7 @@ func DeleteGroup(db *sql.DB, id int64) bool {
 	
 	defer stmt.Close()
 	
-	_, err = stmt.Exec(id)
+	_, err = stmt.Exec(appid, id)
 	if err != nil {
 		log.Info("error:", err)
 		return false
@@ -418,8 +455,9 @@ func DeleteGroup(db *sql.DB, id int64) bool {
 	return true
 }
 
+//每个群组按照所属的appid加载
 func OpLoadAllGroup(db *sql.DB) {
-	stmtIns, err := db.Prepare("select `id`, `title`, `desc`, `isPrivate`, `isAllowInvite`, `owner`, `gouhao` from `group` where isDeleted=0 and type=1")
+	stmtIns, err := db.Prepare("select `appid`, `id`, `title`, `desc`, `isPrivate`, `isAllowInvite`, `owner`, `gouhao` from `group` where isDeleted=0 and type=1")
 	if err != nil {
 		log.Info("error:", err)
 		return
@@ -432,6 +470,7 @@ func OpLoadAllGroup(db *sql.DB) {
 	
 	rows, err := stmtIns.Query()
 	for rows.Next() {
+		var appid int64
 		var gid int64
 		var title string
 		var desc string
@@ -440,10 +479,10 @@ func OpLoadAllGroup(db *sql.DB) {
 		var owner int64
 		var gouhao int
 		
-		rows.Scan(&gid, &title, &desc, &is_private, &is_allow_invite, &owner, &gouhao)
+		rows.Scan(&appid, &gid, &title, &desc, &is_private, &is_allow_invite, &owner, &gouhao)
 
 		//建立群信息
-		key := fmt.Sprintf("group_%d", gid)
+		key := fmt.Sprintf("group_%d_%d", appid, gid)
 		b, err := redis.Bool(conn.Do("EXISTS", key))
 		if err != nil {
 			continue
@@ -461,20 +500,20 @@ func OpLoadAllGroup(db *sql.DB) {
 		
 		//群成员
 		//成员群关系
-		members, err := LoadGroupMember(db, gid)
+		members, err := LoadGroupMember(db, appid, gid)
 		if err != nil {
 			log.Info("error:", err)
 			continue
 		}
 		
 		for _, uid := range members {
-			key = fmt.Sprintf("group_members_%d", gid)
+			key = fmt.Sprintf("group_members_%d_%d", appid, gid)
 			_, err := conn.Do("SADD", key, uid)
 			if err != nil {
 				log.Infoln(err)
 			}
 			
-			key = fmt.Sprintf("user_groups_%d", uid)
+			key = fmt.Sprintf("user_groups_%d_%d", appid, uid)
 			_, err = conn.Do("SADD", key, gid)
 			if err != nil {
 				log.Infoln(err)
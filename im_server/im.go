@@ -31,6 +31,8 @@ import log "github.com/golang/glog"
 import "database/sql"
 import _ "github.com/go-sql-driver/mysql"
 import "math/rand"
+import "crypto/tls"
+import "im_service/common"
 
 var server_id string
 
@@ -54,6 +56,10 @@ var redis_pool *redis.Pool
 var config *Config
 var server_summary *ServerSummary
 
+//客户端端口和服务之间连接使用的证书
+var tls_certs *common.CertReloader
+var cluster_certs *common.CertReloader
+
 func init() {
 	route = NewRoute()
 	server_summary = NewServerSummary()
@@ -71,6 +77,48 @@ func Listen(f func(net.Conn), port int) {
 }
 func ListenClient() {
 	Listen(handle_client, config.port)
+	if config.tls_port > 0 {
+		addr := fmt.Sprintf("0.0.0.0:%d", config.tls_port)
+		TLSService(addr, handle_client, tls_certs.ServerConfig())
+	}
+}
+
+func InitTLS() {
+	if len(config.tls_cert_file) > 0 {
+		var err error
+		tls_certs, err = common.NewCertReloader(config.tls_cert_file, config.tls_key_file, "")
+		if err != nil {
+			log.Fatal("load tls certificate error:", err)
+		}
+	}
+	cluster_certs = common.NewClusterCertReloader(config.cluster_cert_file,
+		config.cluster_key_file, config.cluster_ca_file)
+}
+
+func ClusterTLSConfig(addr string) *tls.Config {
+	server_name := config.cluster_server_name
+	if len(server_name) == 0 {
+		host, _, err := net.SplitHostPort(addr)
+		if err == nil {
+			server_name = host
+		}
+	}
+	return cluster_certs.ClientConfig(server_name)
+}
+
+//连接route,storage, 配置了证书时使用双向tls
+func DialCluster(addr string) (net.Conn, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	tconn := conn.(*net.TCPConn)
+	tconn.SetKeepAlive(true)
+	tconn.SetKeepAlivePeriod(time.Duration(10 * 60 * time.Second))
+	if cluster_certs == nil {
+		return conn, nil
+	}
+	return common.ClientHandshake(conn, ClusterTLSConfig(addr))
 }
 
 func NewRedisPool(server, password string) *redis.Pool {
@@ -115,8 +163,18 @@ func GetRouteChannel() *Channel{
 	defer mutex.Unlock()
 	
 	rand.Seed(time.Now().Unix())
-	index := rand.Intn(len(route_channels))
-	return route_channels[index]
+	//优先使用已连接的route_server, 都断开时消息留在队列中等待重连
+	channels := make([]*Channel, 0, len(route_channels))
+	for _, channel := range route_channels {
+		if channel.IsConnected() {
+			channels = append(channels, channel)
+		}
+	}
+	if len(channels) == 0 {
+		channels = route_channels
+	}
+	index := rand.Intn(len(channels))
+	return channels[index]
 }
 
 func SaveGroupMessage(appid int64, gid int64, device_id int64, m *Message) (int64, error) {
@@ -182,12 +240,19 @@ func SendAppMessage(amsg *AppMessage) bool {
 func DispatchAppMessage(amsg *AppMessage) {
 	log.Info("dispatch app message:", Command(amsg.msg.cmd))
 
-	clients := route.FindClientSet(amsg.receiver)
+	clients := route.FindClientSet(amsg.appid, amsg.receiver)
 	if len(clients) == 0 {
 		log.Warningf("can't dispatch app message, appid:%d uid:%d cmd:%s", amsg.appid, amsg.receiver, Command(amsg.msg.cmd))
 		return
 	}
 	for c, _ := range(clients) {
+		if !AcceptDeviceMessage(c, amsg.msg) {
+			continue
+		}
+		if c.IsSuspended() {
+			c.HandleSuspendedMessage(amsg)
+			continue
+		}
 		//自己在同一台设备上发出的消息，不再发送回去
 		if amsg.msg.cmd == MSG_IM || amsg.msg.cmd == MSG_GROUP_IM || amsg.msg.cmd == MSG_ROOM_IM {
 			m := amsg.msg.body.(*IMMessage)
@@ -197,9 +262,9 @@ func DispatchAppMessage(amsg *AppMessage) {
 		}
 
 		if amsg.msgid > 0 {
-			c.ewt <- &EMessage{msgid:amsg.msgid, msg:amsg.msg}
+			c.EnqueueEMessage(&EMessage{msgid:amsg.msgid, msg:amsg.msg})
 		} else {
-			c.wt <- amsg.msg
+			c.EnqueueMessage(amsg.msg)
 		}
 	}
 }
@@ -330,7 +395,6 @@ func ConfigLoop() {
 				} else {
 					channel := NewChannel(addr, DispatchAppMessage)
 					channel.Start()
-					channel.Register()
 					route_channels_new = append(route_channels_new, channel)
 					route_channels_map_new[addr] = channel
 				}
@@ -353,7 +417,8 @@ func LoadDBData() {
 		return
 	}
 	defer db.Close()
-	
+
+	//按照每行数据的appid写入redis
 	//加载好友数据
 	OpLoadAllFriends(db)
 	
@@ -388,6 +453,7 @@ func main() {
 	log.Info("route addressed:", config.route_addrs)
 
 	redis_pool = NewRedisPool(config.redis_address, config.redis_password)
+	InitTLS()
 
 	storage_pools = make([]*StorageConnPool, 0)
 	storage_pools_map = make(map[string]*StorageConnPool)
@@ -413,16 +479,27 @@ func main() {
 	for _, addr := range(config.route_addrs) {
 		channel := NewChannel(addr, DispatchAppMessage)
 		channel.Start()
-		channel.Register()
 		route_channels = append(route_channels, channel)
 		route_channels_map[addr] = channel
 	}
 	
 	LoadDBData()
 	
+	StartWebhook()
+	StartModeration()
+	InitAuthenticators()
+	go RateLimitGCLoop()
+	
 	go ConfigLoop()
+	go FlushSessionGroupsLoop()
+	StartLease()
+
+	go StartSocketIO(config.socket_io_address, config.tls_socket_io_address)
+	StartWebSocket(config.ws_address, config.wss_address)
 
-	go StartSocketIO(config.socket_io_address)
+	if len(config.http_listen_address) > 0 {
+		StartHttpServer(config.http_listen_address)
+	}
 	ListenClient()
 	Wait()
 }
@@ -60,7 +60,15 @@ func (client *IMClient) LoadGroupOffline() {
 		return
 	}
 
-	gids := OpGetUserGroups(client.uid)
+	gids := OpGetUserGroups(client.appid, client.uid)
+	client.LoadGroupsOffline(gids)
+}
+
+func (client *IMClient) LoadGroupsOffline(gids []int64) {
+	if client.device_ID == 0 {
+		return
+	}
+
 	for _, gid := range gids {
 		messages, err := client.LoadGroupOfflineMessage(gid)
 		if err != nil {
@@ -68,6 +76,9 @@ func (client *IMClient) LoadGroupOffline() {
 			continue
 		}
 
+		if len(messages) > 0 {
+			client.AddPendingGroup(gid)
+		}
 		for _, emsg := range messages {
 			client.owt <- emsg
 		}
@@ -75,6 +86,11 @@ func (client *IMClient) LoadGroupOffline() {
 }
 
 func (client *IMClient) LoadOffline() {
+	client.LoadOfflineAfter(0)
+}
+
+//只加载last_msgid之后的离线消息, 为0时加载全部
+func (client *IMClient) LoadOfflineAfter(last_msgid int64) {
 	if client.device_ID == 0 {
 		return
 	}
@@ -86,7 +102,7 @@ func (client *IMClient) LoadOffline() {
 	}
 	defer storage_pool.Release(storage)
 
-	messages, err := storage.LoadOfflineMessage(client.appid, client.uid, client.device_ID)
+	messages, err := storage.LoadOfflineMessage(client.appid, client.uid, client.device_ID, last_msgid)
 	if err != nil {
 		log.Errorf("load offline message err:%d %s", client.uid, err)
 		return
@@ -108,11 +124,18 @@ func (client *IMClient) HandleIMMessage(msg *IMMessage, seq int) {
 	}
 	
 	//判断黑名单
-	if OpIsUserBlack(msg.receiver, msg.sender) {
-		client.wt <- &Message{cmd: MSG_ACK, body: &MessageACK{int32(seq)}}
+	if OpIsUserBlack(client.appid, msg.receiver, msg.sender) {
+		client.wt <- &Message{cmd: MSG_ACK, body: &MessageACK{int32(seq), ACK_SUCCESS}}
 		return
 	}
 	
+	content, status := InterceptMessage(client.appid, MSG_IM, msg.sender, msg.receiver, msg.content)
+	if status == ACK_REJECTED {
+		client.wt <- &Message{cmd: MSG_ACK, body: &MessageACK{int32(seq), status}}
+		return
+	}
+	msg.content = content
+
 	msg.timestamp = int32(time.Now().Unix())
 	m := &Message{cmd: MSG_IM, version:DEFAULT_VERSION, body: msg}
 
@@ -124,10 +147,11 @@ func (client *IMClient) HandleIMMessage(msg *IMMessage, seq int) {
 	//保存到自己的消息队列，这样用户的其它登陆点也能接受到自己发出的消息
 	SaveMessage(client.appid, msg.sender, client.device_ID, m)
 
-	client.wt <- &Message{cmd: MSG_ACK, body: &MessageACK{int32(seq)}}
+	client.wt <- &Message{cmd: MSG_ACK, body: &MessageACK{int32(seq), status}}
 
 	atomic.AddInt64(&server_summary.in_message_count, 1)
 	log.Infof("peer message sender:%d receiver:%d msgid:%d\n", msg.sender, msg.receiver, msgid)
+	PostMessageEvent(client.appid, WEBHOOK_EVENT_PEER_MESSAGE, msg, msgid)
 }
 
 func (client *IMClient) HandleGroupIMMessage(msg *IMMessage, seq int) {
@@ -139,20 +163,28 @@ func (client *IMClient) HandleGroupIMMessage(msg *IMMessage, seq int) {
 	msg.timestamp = int32(time.Now().Unix())
 	m := &Message{cmd: MSG_GROUP_IM, version:DEFAULT_VERSION, body: msg}
 
-	group := OpGetGroup(msg.receiver)
+	group := OpGetGroup(client.appid, msg.receiver)
 	if group == nil {
 		log.Warning("can't find group:", msg.receiver)
 		return
 	}
+
+	content, status := InterceptMessage(client.appid, MSG_GROUP_IM, msg.sender, msg.receiver, msg.content)
+	if status == ACK_REJECTED {
+		client.wt <- &Message{cmd: MSG_ACK, body: &MessageACK{int32(seq), status}}
+		return
+	}
+	msg.content = content
 	
 	msgid, err := SaveGroupMessage(client.appid, msg.receiver, client.device_ID, m)
 	if err != nil {
 		return
 	}
 	
-	client.wt <- &Message{cmd: MSG_ACK, body: &MessageACK{int32(seq)}}
+	client.wt <- &Message{cmd: MSG_ACK, body: &MessageACK{int32(seq), status}}
 	atomic.AddInt64(&server_summary.in_message_count, 1)
 	log.Infof("group message sender:%d group id:%d msgid:%d", msg.sender, msg.receiver, msgid)
+	PostMessageEvent(client.appid, WEBHOOK_EVENT_GROUP_MESSAGE, msg, msgid)
 }
 
 func (client *IMClient) HandleInputing(inputing *MessageInputing) {
@@ -171,12 +203,13 @@ func (client *IMClient) HandleACK(ack *MessageACK) {
 	msg := emsg.msg
 	if msg != nil && msg.cmd == MSG_GROUP_IM {
 		im := emsg.msg.body.(*IMMessage)
-		group := OpGetGroup(im.receiver)
+		group := OpGetGroup(client.appid, im.receiver)
 		if group != nil{
 			client.DequeueGroupMessage(emsg.msgid, im.receiver)
 		}
 	} else {
 		client.DequeueMessage(emsg.msgid)
+		client.SetLastAcked(emsg.msgid)
 	}
 
 	if msg == nil {
@@ -224,8 +257,8 @@ func (client *IMClient) HandleTransmitUser(msg *IMMessage, seq int) {
 	}
 	
 	//判断黑名单
-	if OpIsUserBlack(msg.receiver, msg.sender) {
-		client.wt <- &Message{cmd: MSG_ACK, body: &MessageACK{int32(seq)}}
+	if OpIsUserBlack(client.appid, msg.receiver, msg.sender) {
+		client.wt <- &Message{cmd: MSG_ACK, body: &MessageACK{int32(seq), ACK_SUCCESS}}
 		return
 	}
 	
@@ -237,7 +270,7 @@ func (client *IMClient) HandleTransmitUser(msg *IMMessage, seq int) {
 		return
 	}
 
-	client.wt <- &Message{cmd: MSG_ACK, body: &MessageACK{int32(seq)}}
+	client.wt <- &Message{cmd: MSG_ACK, body: &MessageACK{int32(seq), ACK_SUCCESS}}
 
 	atomic.AddInt64(&server_summary.in_message_count, 1)
 	log.Infof("peer transmit message sender:%d receiver:%d msgid:%d\n", msg.sender, msg.receiver, msgid)
@@ -257,7 +290,7 @@ func (client *IMClient) HandleTransmitGroup(msg *IMMessage, seq int) {
 		return
 	}
 	
-	client.wt <- &Message{cmd: MSG_ACK, body: &MessageACK{int32(seq)}}
+	client.wt <- &Message{cmd: MSG_ACK, body: &MessageACK{int32(seq), ACK_SUCCESS}}
 	atomic.AddInt64(&server_summary.in_message_count, 1)
 	log.Infof("group message sender:%d group id:%d msgid:%d\n", msg.sender, msg.receiver, msgid)
 }
@@ -308,7 +341,7 @@ func (client *IMClient) HandleMessage(msg *Message) {
 }
 
 func (client *IMClient) handlerGroupDel(groupDel *GroupDel) {
-	group := OpGetGroup(groupDel.gid)
+	group := OpGetGroup(client.appid, groupDel.gid)
 	
 	if group == nil {
 		msg := &Message{cmd: MSG_GROUP_DEL_RESP, version:DEFAULT_VERSION, body: &SimpleResp{1}}
@@ -331,10 +364,10 @@ func (client *IMClient) handlerGroupDel(groupDel *GroupDel) {
 	}
 	defer db.Close()
 	
-	members := OpGetGroupMembers(groupDel.gid)
+	members := OpGetGroupMembers(client.appid, groupDel.gid)
 	
 	for _, member := range members {
-		OpRemoveGroupMember(db, groupDel.gid, member)
+		OpRemoveGroupMember(db, client.appid, groupDel.gid, member)
 		
 		//构造一条透传发送群解散透传
 		obj := make(map[string]interface{})
@@ -357,14 +390,15 @@ func (client *IMClient) handlerGroupDel(groupDel *GroupDel) {
 		SaveMessage(client.appid, msg.receiver, client.device_ID, m)
 	}
 	
-	OpDelGroup(db, groupDel.gid)
+	OpDelGroup(db, client.appid, groupDel.gid)
+	PostGroupEvent(client.appid, WEBHOOK_EVENT_GROUP_DISSOLVE, groupDel.gid, client.uid, members)
 	
 	msg := &Message{cmd: MSG_GROUP_DEL_RESP, version:DEFAULT_VERSION, body: &SimpleResp{0}}
 	client.wt <- msg
 }
 
 func (client *IMClient) handlerGroupQuit(groupQuit *GroupQuit) {
-	group := OpGetGroup(groupQuit.gid)
+	group := OpGetGroup(client.appid, groupQuit.gid)
 	
 	if group == nil {
 		msg := &Message{cmd: MSG_GROUP_QUIT_RESP, version:DEFAULT_VERSION, body: &SimpleResp{1}}
@@ -387,19 +421,21 @@ func (client *IMClient) handlerGroupQuit(groupQuit *GroupQuit) {
 	}
 	defer db.Close()
 	
-	if !RemoveGroupMember(db, group.gid, client.uid) {
+	if !RemoveGroupMember(db, client.appid, group.gid, client.uid) {
 		msg := &Message{cmd: MSG_GROUP_QUIT_RESP, version:DEFAULT_VERSION, body: &SimpleResp{3}}
 		client.wt <- msg
 			
 		return
 	}
 	
+	PostGroupEvent(client.appid, WEBHOOK_EVENT_GROUP_LEAVE, group.gid, client.uid, []int64{client.uid})
+
 	msg := &Message{cmd: MSG_GROUP_QUIT_RESP, version:DEFAULT_VERSION, body: &SimpleResp{0}}
 	client.wt <- msg
 }
 
 func (client *IMClient) handlerGroupRemove(groupRemove *GroupRemove) {
-	group := OpGetGroup(groupRemove.gid)
+	group := OpGetGroup(client.appid, groupRemove.gid)
 	
 	if group == nil {
 		msg := &Message{cmd: MSG_GROUP_REMOVE_RESP, version:DEFAULT_VERSION, body: &SimpleResp{1}}
@@ -429,7 +465,7 @@ func (client *IMClient) handlerGroupRemove(groupRemove *GroupRemove) {
 	}
 	defer db.Close()
 	
-	if !OpRemoveGroupMember(db, group.gid, groupRemove.uid) {
+	if !OpRemoveGroupMember(db, client.appid, group.gid, groupRemove.uid) {
 		msg := &Message{cmd: MSG_GROUP_REMOVE_RESP, version:DEFAULT_VERSION, body: &SimpleResp{4}}
 		client.wt <- msg
 			
@@ -456,12 +492,14 @@ func (client *IMClient) handlerGroupRemove(groupRemove *GroupRemove) {
 
 	SaveMessage(client.appid, msg.receiver, client.device_ID, m)
 	
+	PostGroupEvent(client.appid, WEBHOOK_EVENT_GROUP_LEAVE, group.gid, client.uid, []int64{groupRemove.uid})
+	
 	msgResp := &Message{cmd: MSG_GROUP_REMOVE_RESP, version:DEFAULT_VERSION, body: &SimpleResp{0}}
 	client.wt <- msgResp
 }
 
 func (client *IMClient) handlerGroupInviteJoin(groupInviteJoin *GroupInviteJoin) {
-	group := OpGetGroup(groupInviteJoin.gid)
+	group := OpGetGroup(client.appid, groupInviteJoin.gid)
 	
 	if group == nil {
 		msg := &Message{cmd: MSG_GROUP_INVITE_JOIN_RESP, version:DEFAULT_VERSION, body: &SimpleResp{1}}
@@ -477,14 +515,14 @@ func (client *IMClient) handlerGroupInviteJoin(groupInviteJoin *GroupInviteJoin)
 		return
 	}
 	
-	if !OpIsGroupMember(group.gid, client.uid) {
+	if !OpIsGroupMember(client.appid, group.gid, client.uid) {
 		msg := &Message{cmd: MSG_GROUP_INVITE_JOIN_RESP, version:DEFAULT_VERSION, body: &SimpleResp{3}}
 		client.wt <- msg
 			
 		return
 	}
 	
-	if (OpGetGroupMemberNumber(group.gid)+len(groupInviteJoin.members)) > 500 {
+	if GetAppConfig(client.appid).IsGroupFull(OpGetGroupMemberNumber(client.appid, group.gid)+len(groupInviteJoin.members)) {
 		msg := &Message{cmd: MSG_GROUP_INVITE_JOIN_RESP, version:DEFAULT_VERSION, body: &SimpleResp{4}}
 		client.wt <- msg
 			
@@ -498,14 +536,16 @@ func (client *IMClient) handlerGroupInviteJoin(groupInviteJoin *GroupInviteJoin)
 	}
 	defer db.Close()
 	
+	joined := make([]int64, 0, len(groupInviteJoin.members))
 	for _, member := range groupInviteJoin.members {
-		if OpIsGroupMember(group.gid, member) {
+		if OpIsGroupMember(client.appid, group.gid, member) {
 			continue
 		}
 		
-		if !OpAddGroupMember(db, group.gid, member, 0) {
+		if !OpAddGroupMember(db, client.appid, group.gid, member, 0) {
 			continue
 		}
+		joined = append(joined, member)
 		
 		//构造一条透传发送被拉入群
 		obj := make(map[string]interface{})
@@ -528,12 +568,16 @@ func (client *IMClient) handlerGroupInviteJoin(groupInviteJoin *GroupInviteJoin)
 		SaveMessage(client.appid, msg.receiver, client.device_ID, m)
 	}
 	
+	if len(joined) > 0 {
+		PostGroupEvent(client.appid, WEBHOOK_EVENT_GROUP_JOIN, group.gid, client.uid, joined)
+	}
+	
 	msg := &Message{cmd: MSG_GROUP_INVITE_JOIN_RESP, version:DEFAULT_VERSION, body: &SimpleResp{0}}
 	client.wt <- msg
 }
 
 func (client *IMClient) handlerGroupSelfJoin(groupSelfJoin *GroupSelfJoin) {
-	group := OpGetGroup(groupSelfJoin.gid)
+	group := OpGetGroup(client.appid, groupSelfJoin.gid)
 	
 	if group == nil {
 		msg := &Message{cmd: MSG_GROUP_SELF_JOIN_RESP, version:DEFAULT_VERSION, body: &SimpleResp{1}}
@@ -556,7 +600,7 @@ func (client *IMClient) handlerGroupSelfJoin(groupSelfJoin *GroupSelfJoin) {
 		return
 	}
 	
-	if OpGetGroupMemberNumber(groupSelfJoin.gid) >= 500 {
+	if GetAppConfig(client.appid).IsGroupFull(OpGetGroupMemberNumber(client.appid, groupSelfJoin.gid) + 1) {
 		msg := &Message{cmd: MSG_GROUP_SELF_JOIN_RESP, version:DEFAULT_VERSION, body: &SimpleResp{4}}
 		client.wt <- msg
 			
@@ -570,13 +614,14 @@ func (client *IMClient) handlerGroupSelfJoin(groupSelfJoin *GroupSelfJoin) {
 	}
 	defer db.Close()
 	
-	if OpIsGroupMember(group.gid, client.uid) {
-		if !OpAddGroupMember(db, group.gid, client.uid, 0) {
+	if !OpIsGroupMember(client.appid, group.gid, client.uid) {
+		if !OpAddGroupMember(db, client.appid, group.gid, client.uid, 0) {
 			msg := &Message{cmd: MSG_GROUP_SELF_JOIN_RESP, version:DEFAULT_VERSION, body: &SimpleResp{5}}
 			client.wt <- msg
 				
 			return
 		}
+		PostGroupEvent(client.appid, WEBHOOK_EVENT_GROUP_JOIN, group.gid, client.uid, []int64{client.uid})
 	}
 	
 	msg := &Message{cmd: MSG_GROUP_SELF_JOIN_RESP, version:DEFAULT_VERSION, body: &SimpleResp{0}}
@@ -584,7 +629,7 @@ func (client *IMClient) handlerGroupSelfJoin(groupSelfJoin *GroupSelfJoin) {
 }
 
 func (client *IMClient) handlerGroupCreate(groupCreate *GroupCreate) {
-	if len(groupCreate.members) + 1 > 500 {
+	if GetAppConfig(client.appid).IsGroupFull(len(groupCreate.members) + 1) {
 		msg := &Message{cmd: MSG_GROUP_CREATE_RESP, version:DEFAULT_VERSION, body: &GroupCreateResp{1, 0}}
 		client.wt <- msg
 			
@@ -609,20 +654,22 @@ func (client *IMClient) handlerGroupCreate(groupCreate *GroupCreate) {
 	gid := GenerateGroupUUID(client.uid)
 
 	
-	if !OpCreateGroup(db, gid, groupCreate.title, groupCreate.desc, int(groupCreate.is_private), int(groupCreate.is_allow_invite), client.uid, gouhao) {
+	if !OpCreateGroup(db, client.appid, gid, groupCreate.title, groupCreate.desc, int(groupCreate.is_private), int(groupCreate.is_allow_invite), client.uid, gouhao) {
 		msg := &Message{cmd: MSG_GROUP_CREATE_RESP, version:DEFAULT_VERSION, body: &GroupCreateResp{2, 0}}
 		client.wt <- msg
 			
 		return
 	}
 	
-	OpAddGroupMember(db, gid, client.uid, 1)
+	OpAddGroupMember(db, client.appid, gid, client.uid, 1)
+	joined := []int64{client.uid}
 	for _, member := range groupCreate.members {
 		if member == client.uid {
 			continue
 		}
 		
-		if OpAddGroupMember(db, gid, member, 0) {
+		if OpAddGroupMember(db, client.appid, gid, member, 0) {
+			joined = append(joined, member)
 			//构造一条透传发送被拉入群
 			obj := make(map[string]interface{})
 			obj["cmd"] = CMD_CALLBACK_GROUP_JOIN
@@ -645,6 +692,8 @@ func (client *IMClient) handlerGroupCreate(groupCreate *GroupCreate) {
 		}
 	}
 	
+	PostGroupEvent(client.appid, WEBHOOK_EVENT_GROUP_CREATE, gid, client.uid, joined)
+	
 	msg := &Message{cmd: MSG_GROUP_CREATE_RESP, version:DEFAULT_VERSION, body: &GroupCreateResp{0, gid}}
 	client.wt <- msg
 }
@@ -667,7 +716,7 @@ func (client *IMClient) HandleContactBlack(contactBlack *ContactBlack) {
 	defer db.Close()
 	
 	//拉入黑名单
-	if !OpAddUserBlack(db, contactBlack.sender, contactBlack.receiver) {
+	if !OpAddUserBlack(db, client.appid, contactBlack.sender, contactBlack.receiver) {
 		msg := &Message{cmd: MSG_CONTACT_BLACK_RESP, version:DEFAULT_VERSION, body: &ContactBlackResp{3, contactBlack.sender, contactBlack.receiver}}
 		client.wt <- msg
 			
@@ -696,7 +745,7 @@ func (client *IMClient) HandleContactUnBlack(contactUnBlack *ContactUnBlack) {
 	defer db.Close()
 	
 	//解除黑名单
-	if !OpRemoveUserBlack(db, contactUnBlack.sender, contactUnBlack.receiver) {
+	if !OpRemoveUserBlack(db, client.appid, contactUnBlack.sender, contactUnBlack.receiver) {
 		msg := &Message{cmd: MSG_CONTACT_UNBLACK_RESP, version:DEFAULT_VERSION, body: &ContactUnBlackResp{2, contactUnBlack.sender, contactUnBlack.receiver}}
 		client.wt <- msg
 			
@@ -718,7 +767,7 @@ func (client *IMClient) HandleContactInvite(contactInvite *ContactInvite) {
 		return
 	}
 	
-	if OpIsUserFriend(contactInvite.sender, contactInvite.receiver) {
+	if OpIsUserFriend(client.appid, contactInvite.sender, contactInvite.receiver) {
 		
 		msg := &Message{cmd: MSG_CONTACT_INVITE_RESP, version:DEFAULT_VERSION, body: &ContactInviteResp{2, contactInvite.sender, contactInvite.receiver}}
 		client.wt <- msg
@@ -726,7 +775,7 @@ func (client *IMClient) HandleContactInvite(contactInvite *ContactInvite) {
 		return
 	}
 	
-	if OpIsUserBlack(contactInvite.receiver, contactInvite.sender) {
+	if OpIsUserBlack(client.appid, contactInvite.receiver, contactInvite.sender) {
 		msg := &Message{cmd: MSG_CONTACT_INVITE_RESP, version:DEFAULT_VERSION, body: &ContactInviteResp{3, contactInvite.sender, contactInvite.receiver}}
 		client.wt <- msg
 		
@@ -740,7 +789,7 @@ func (client *IMClient) HandleContactInvite(contactInvite *ContactInvite) {
 	}
 	defer db.Close()
 	
-	if !OpHasUserInfoById(db, contactInvite.receiver) {
+	if !OpHasUserInfoById(db, client.appid, contactInvite.receiver) {
 		
 		msg := &Message{cmd: MSG_CONTACT_INVITE_RESP, version:DEFAULT_VERSION, body: &ContactInviteResp{4, contactInvite.sender, contactInvite.receiver}}
 		client.wt <- msg
@@ -749,7 +798,7 @@ func (client *IMClient) HandleContactInvite(contactInvite *ContactInvite) {
 	}
 	
 	//如果在黑名单中，自动解除黑名单
-	OpRemoveUserBlack(db, contactInvite.sender, contactInvite.receiver)
+	OpRemoveUserBlack(db, client.appid, contactInvite.sender, contactInvite.receiver)
 	
 	//构造一条透传发送好友申请
 	obj := make(map[string]interface{})
@@ -785,7 +834,7 @@ func (client *IMClient) HandleContactAccept(contactAccept *ContactAccept) {
 		return
 	}
 	
-	if OpIsUserFriend(contactAccept.sender, contactAccept.receiver) {
+	if OpIsUserFriend(client.appid, contactAccept.sender, contactAccept.receiver) {
 		
 		msg := &Message{cmd: MSG_CONTACT_ACCEPT_RESP, version:DEFAULT_VERSION, body: &ContactAcceptResp{2, contactAccept.sender, contactAccept.receiver}}
 		client.wt <- msg
@@ -800,7 +849,7 @@ func (client *IMClient) HandleContactAccept(contactAccept *ContactAccept) {
 	}
 	defer db.Close()
 	
-	if !OpHasUserInfoById(db, contactAccept.receiver) {
+	if !OpHasUserInfoById(db, client.appid, contactAccept.receiver) {
 		
 		msg := &Message{cmd: MSG_CONTACT_ACCEPT_RESP, version:DEFAULT_VERSION, body: &ContactAcceptResp{4, contactAccept.sender, contactAccept.receiver}}
 		client.wt <- msg
@@ -809,16 +858,16 @@ func (client *IMClient) HandleContactAccept(contactAccept *ContactAccept) {
 	}
 	
 	//如果在黑名单中，自动解除黑名单
-	if OpIsUserBlack(contactAccept.sender, contactAccept.receiver) {
-		OpRemoveUserBlack(db, contactAccept.sender, contactAccept.receiver)
+	if OpIsUserBlack(client.appid, contactAccept.sender, contactAccept.receiver) {
+		OpRemoveUserBlack(db, client.appid, contactAccept.sender, contactAccept.receiver)
 	}
 	
-	if OpIsUserBlack(contactAccept.receiver, contactAccept.sender) {
-		OpRemoveUserBlack(db, contactAccept.receiver, contactAccept.sender)
+	if OpIsUserBlack(client.appid, contactAccept.receiver, contactAccept.sender) {
+		OpRemoveUserBlack(db, client.appid, contactAccept.receiver, contactAccept.sender)
 	}
 	
 	//建立好友关系
-	if !OpAddUserFriend(db, contactAccept.sender, contactAccept.receiver) {
+	if !OpAddUserFriend(db, client.appid, contactAccept.sender, contactAccept.receiver) {
 		return
 	}
 	
@@ -862,6 +911,8 @@ func (client *IMClient) HandleContactAccept(contactAccept *ContactAccept) {
 
 	SaveMessage(client.appid, msg.receiver, client.device_ID, m)
 	
+	PostFriendEvent(client.appid, WEBHOOK_EVENT_FRIEND_ADD, contactAccept.sender, contactAccept.receiver)
+	
 	respMsg := &Message{cmd: MSG_CONTACT_ACCEPT_RESP, version:DEFAULT_VERSION, body: &ContactAcceptResp{0, contactAccept.sender, contactAccept.receiver}}
 	client.wt <- respMsg
 }
@@ -876,7 +927,7 @@ func (client *IMClient) HandleContactRefuse(contactRefuse *ContactRefuse) {
 		return
 	}
 	
-	if OpIsUserFriend(contactRefuse.sender, contactRefuse.receiver) {
+	if OpIsUserFriend(client.appid, contactRefuse.sender, contactRefuse.receiver) {
 		
 		msg := &Message{cmd: MSG_CONTACT_REFUSE_RESP, version:DEFAULT_VERSION, body: &ContactRefuseResp{2, contactRefuse.sender, contactRefuse.receiver}}
 		client.wt <- msg
@@ -884,7 +935,7 @@ func (client *IMClient) HandleContactRefuse(contactRefuse *ContactRefuse) {
 		return
 	}
 	
-	if OpIsUserBlack(contactRefuse.receiver, contactRefuse.sender) {
+	if OpIsUserBlack(client.appid, contactRefuse.receiver, contactRefuse.sender) {
 		msg := &Message{cmd: MSG_CONTACT_REFUSE_RESP, version:DEFAULT_VERSION, body: &ContactRefuseResp{3, contactRefuse.sender, contactRefuse.receiver}}
 		client.wt <- msg
 		
@@ -924,7 +975,7 @@ func (client *IMClient) HandleContactDel(contactDel *ContactDel) {
 		return
 	}
 	
-	if !OpIsUserFriend(contactDel.sender, contactDel.receiver) {
+	if !OpIsUserFriend(client.appid, contactDel.sender, contactDel.receiver) {
 		
 		msg := &Message{cmd: MSG_CONTACT_DEL_RESP, version:DEFAULT_VERSION, body: &ContactDelResp{2, contactDel.sender, contactDel.receiver}}
 		client.wt <- msg
@@ -940,7 +991,7 @@ func (client *IMClient) HandleContactDel(contactDel *ContactDel) {
 	defer db.Close()
 	
 	//删除好友关系
-	if !OpRemoveUserFriend(db, contactDel.sender, contactDel.receiver) {
+	if !OpRemoveUserFriend(db, client.appid, contactDel.sender, contactDel.receiver) {
 		return
 	}
 	
@@ -964,6 +1015,8 @@ func (client *IMClient) HandleContactDel(contactDel *ContactDel) {
 
 	SaveMessage(client.appid, msg.receiver, client.device_ID, m)
 	
+	PostFriendEvent(client.appid, WEBHOOK_EVENT_FRIEND_REMOVE, contactDel.sender, contactDel.receiver)
+	
 	respMsg := &Message{cmd: MSG_CONTACT_DEL_RESP, version:DEFAULT_VERSION, body: &ContactDelResp{0, contactDel.sender, contactDel.receiver}}
 	client.wt <- respMsg
 }
@@ -0,0 +1,136 @@
+/**
+ * Copyright (c) 2014-2015, GoBelieve
+ * All rights reserved.
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place, Suite 330, Boston, MA  02111-1307  USA
+ */
+
+
+package main
+
+import "os"
+import "fmt"
+import "strings"
+import "strconv"
+import "sync/atomic"
+import log "github.com/golang/glog"
+import "im_service/common"
+import "github.com/garyburd/redigo/redis"
+
+//租约由PublishLoad和负载一起续约, route_server忽略租约过期的服务器
+const SERVER_LEASE_TTL = common.LOAD_REPORT_INTERVAL * 3
+
+//持有过租约的im服务器, janitor从这里找到过期的服务器
+const IM_SERVER_LEASES_KEY = "im_server_leases"
+
+//退出时释放租约后不再续约
+var lease_released int32
+
+func IsLeaseReleased() bool {
+	return atomic.LoadInt32(&lease_released) != 0
+}
+
+func OpReleaseLease() {
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	key := fmt.Sprintf("im_server_lease_%s", server_id)
+	_, err := conn.Do("DEL", key)
+	if err != nil {
+		log.Infoln(err)
+	}
+}
+
+//删除本服务器上次运行时留下的用户记录
+func OpPurgeServerUsers(id string) int {
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	key := fmt.Sprintf("im_server_users_%s", id)
+	members, err := redis.Strings(conn.Do("SMEMBERS", key))
+	if err != nil {
+		log.Infoln(err)
+		return 0
+	}
+
+	count := 0
+	for _, member := range members {
+		ids := strings.SplitN(member, "_", 2)
+		if len(ids) != 2 {
+			continue
+		}
+		appid, err1 := strconv.ParseInt(ids[0], 10, 64)
+		uid, err2 := strconv.ParseInt(ids[1], 10, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		conn.Send("SREM", fmt.Sprintf("user_servers_%d_%d", appid, uid), id)
+		count++
+	}
+	conn.Send("DEL", key)
+	err = conn.Flush()
+	if err != nil {
+		log.Infoln(err)
+		return 0
+	}
+	for i := 0; i <= count; i++ {
+		conn.Receive()
+	}
+	return count
+}
+
+//租约过期后janitor会清除本服务器的用户, 续约时重新登记所有在线用户
+func OpRestoreServerUsers(users []UserKey) bool {
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	users_key := fmt.Sprintf("im_server_users_%s", server_id)
+	conn.Send("MULTI")
+	for _, u := range users {
+		if u.uid == 0 {
+			continue
+		}
+		conn.Send("SADD", fmt.Sprintf("user_servers_%d_%d", u.appid, u.uid), server_id)
+		conn.Send("SADD", users_key, fmt.Sprintf("%d_%d", u.appid, u.uid))
+	}
+	_, err := conn.Do("EXEC")
+	if err != nil {
+		log.Infoln(err)
+		return false
+	}
+	return true
+}
+
+//平滑重启时新旧进程使用同一个server_id, 旧进程上的用户仍然在线
+func StartLease() {
+	if len(os.Getenv(Graceful)) == 0 {
+		n := OpPurgeServerUsers(server_id)
+		if n > 0 {
+			log.Infof("purge %d stale users of server:%s", n, server_id)
+		}
+	}
+	//处理请求之前先续约
+	PublishLoad()
+	go PublishLoadLoop()
+}
+
+//进程退出后不用等到租约过期
+func ReleaseLease() {
+	//等待正在进行的续约完成
+	load_mutex.Lock()
+	atomic.StoreInt32(&lease_released, 1)
+	load_mutex.Unlock()
+	OpReleaseLease()
+}
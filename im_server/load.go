@@ -0,0 +1,118 @@
+/**
+ * Copyright (c) 2014-2015, GoBelieve
+ * All rights reserved.
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place, Suite 330, Boston, MA  02111-1307  USA
+ */
+
+
+package main
+
+import "fmt"
+import "time"
+import "sync"
+import "runtime"
+import "syscall"
+import "sync/atomic"
+import log "github.com/golang/glog"
+import "im_service/common"
+
+//PublishLoadLoop和Drain都会上报
+var load_mutex sync.Mutex
+var last_cpu_time time.Duration
+var last_report_time time.Time
+//租约曾经过期, 在线用户还没有重新登记
+var lease_lost bool
+
+//两次上报之间进程占用的cpu, 100表示占满所有核
+func CPUUsage() float64 {
+	var usage syscall.Rusage
+	err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage)
+	if err != nil {
+		log.Info("getrusage error:", err)
+		return 0
+	}
+	cpu_time := time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+	now := time.Now()
+	var percent float64
+	if !last_report_time.IsZero() {
+		elapsed := now.Sub(last_report_time)
+		percent = float64(cpu_time - last_cpu_time) / float64(elapsed) / float64(runtime.NumCPU()) * 100
+	}
+	last_cpu_time = cpu_time
+	last_report_time = now
+	return percent
+}
+
+//同时续约租约, 没有配置public_address时只续约
+func PublishLoad() {
+	load_mutex.Lock()
+	defer load_mutex.Unlock()
+
+	if IsLeaseReleased() {
+		return
+	}
+
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	//租约不存在时janitor可能已经清除了本服务器的用户
+	lease_key := fmt.Sprintf("im_server_lease_%s", server_id)
+	reply, err := conn.Do("SET", lease_key, time.Now().Unix(), "EX", SERVER_LEASE_TTL, "NX")
+	if err != nil {
+		log.Info("renew lease error:", err)
+		return
+	}
+	if reply != nil {
+		lease_lost = true
+	}
+	//重新登记之前不上报负载, 下次上报时重试
+	if lease_lost {
+		users := route.Users()
+		if !OpRestoreServerUsers(users) {
+			return
+		}
+		lease_lost = false
+		log.Infof("lease of server:%s acquired, restore %d users", server_id, len(users))
+	}
+
+	conn.Send("MULTI")
+	conn.Send("SET", lease_key, time.Now().Unix(), "EX", SERVER_LEASE_TTL)
+	conn.Send("SADD", IM_SERVER_LEASES_KEY, server_id)
+	if len(config.public_address) > 0 {
+		draining := 0
+		if IsDraining() {
+			draining = 1
+		}
+		key := fmt.Sprintf("im_server_load_%s", config.server_id)
+		conn.Send("HMSET", key, "region", config.region, "address", config.public_address,
+			"tls_address", config.public_tls_address, "socket_io_address", config.public_socket_io_address,
+			"ws_address", config.public_ws_address, "connections", atomic.LoadInt64(&server_summary.nconnections),
+			"cpu", fmt.Sprintf("%.1f", CPUUsage()), "draining", draining, "timestamp", time.Now().Unix())
+		conn.Send("EXPIRE", key, common.LOAD_REPORT_INTERVAL * 3)
+		conn.Send("SADD", common.IM_SERVERS_KEY, config.server_id)
+	}
+	_, err = conn.Do("EXEC")
+	if err != nil {
+		log.Info("publish load error:", err)
+	}
+}
+
+func PublishLoadLoop() {
+	for {
+		time.Sleep(common.LOAD_REPORT_INTERVAL * time.Second)
+		PublishLoad()
+	}
+}
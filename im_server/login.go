@@ -0,0 +1,185 @@
+/**
+ * Copyright (c) 2014-2015, GoBelieve
+ * All rights reserved.
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place, Suite 330, Boston, MA  02111-1307  USA
+ */
+
+package main
+
+import "fmt"
+import "sync"
+import "time"
+import "strings"
+import "strconv"
+import log "github.com/golang/glog"
+import "github.com/garyburd/redigo/redis"
+
+//多设备登录策略
+const LOGIN_POLICY_ALL = "all"               //不限制
+const LOGIN_POLICY_PLATFORM = "platform"     //每个平台只能登录一台设备
+const LOGIN_POLICY_MOBILE_WEB = "mobile_web" //一台手机加一个web
+
+//app配置缓存时间
+const LOGIN_POLICY_CACHE_TIMEOUT = 60
+
+type LoginPolicy struct {
+	policy string
+	tm     time.Time
+}
+
+var login_policy_mutex sync.Mutex
+var login_policies map[int64]*LoginPolicy
+
+func init() {
+	login_policies = make(map[int64]*LoginPolicy)
+}
+
+func IsLoginPolicy(policy string) bool {
+	return policy == LOGIN_POLICY_ALL || policy == LOGIN_POLICY_PLATFORM || policy == LOGIN_POLICY_MOBILE_WEB
+}
+
+func OpSetLoginPolicy(appid int64, policy string) bool {
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	key := fmt.Sprintf("app_login_policy_%d", appid)
+	_, err := conn.Do("SET", key, policy)
+	if err != nil {
+		log.Infoln(err)
+		return false
+	}
+
+	login_policy_mutex.Lock()
+	delete(login_policies, appid)
+	login_policy_mutex.Unlock()
+	return true
+}
+
+//未设置时使用配置文件中的默认策略
+func GetLoginPolicy(appid int64) string {
+	login_policy_mutex.Lock()
+	p, ok := login_policies[appid]
+	login_policy_mutex.Unlock()
+	if ok && time.Since(p.tm) < LOGIN_POLICY_CACHE_TIMEOUT * time.Second {
+		return p.policy
+	}
+
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	key := fmt.Sprintf("app_login_policy_%d", appid)
+	policy, err := redis.String(conn.Do("GET", key))
+	if err != nil && err != redis.ErrNil {
+		log.Info("get login policy error:", err)
+		return config.login_policy
+	}
+	if !IsLoginPolicy(policy) {
+		policy = config.login_policy
+	}
+
+	login_policy_mutex.Lock()
+	login_policies[appid] = &LoginPolicy{policy, time.Now()}
+	login_policy_mutex.Unlock()
+	return policy
+}
+
+func IsMobilePlatform(platform_id int8) bool {
+	return platform_id == PLATFORM_IOS || platform_id == PLATFORM_ANDROID
+}
+
+//新登录的设备是否和已登录的设备冲突, 同一设备重连不算冲突
+func IsLoginConflict(policy string, platform_id int8, device_id string, p int8, d string) bool {
+	if platform_id == p && device_id == d {
+		return false
+	}
+	switch policy {
+	case LOGIN_POLICY_PLATFORM:
+		return platform_id == p
+	case LOGIN_POLICY_MOBILE_WEB:
+		if IsMobilePlatform(platform_id) {
+			return IsMobilePlatform(p)
+		}
+		return platform_id == p
+	default:
+		return false
+	}
+}
+
+func OpGetUserLoginPoints(appid int64, uid int64) []*LoginPoint {
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	key := fmt.Sprintf("user_loginpoints_%d_%d", appid, uid)
+	members, err := redis.Strings(conn.Do("SMEMBERS", key))
+	if err != nil {
+		log.Info("smembers error:", err)
+		return nil
+	}
+
+	points := make([]*LoginPoint, 0, len(members))
+	for _, m := range members {
+		s := strings.SplitN(m, "_", 2)
+		if len(s) != 2 {
+			continue
+		}
+		platform_id, err := strconv.Atoi(s[0])
+		if err != nil {
+			continue
+		}
+		points = append(points, &LoginPoint{platform_id:int8(platform_id), device_id:s[1]})
+	}
+	return points
+}
+
+//按照app的登录策略踢出冲突的设备,并通知其它设备有新的登录
+func (client *Client) HandleLoginPolicy() {
+	policy := GetLoginPolicy(client.appid)
+	points := OpGetUserLoginPoints(client.appid, client.uid)
+	for _, p := range points {
+		if !IsLoginConflict(policy, client.platform_id, client.device_id, p.platform_id, p.device_id) {
+			continue
+		}
+		log.Infof("kick uid:%d platform:%d device:%s, login from platform:%d device:%s",
+			client.uid, p.platform_id, p.device_id, client.platform_id, client.device_id)
+		OpRemoveUserLoginPoint(client.appid, client.uid, p.platform_id, p.device_id)
+		//被踢出的设备已经退出登录, 不再推送
+		OpUnbindDeviceToken(client.appid, client.uid, p.platform_id, p.device_id)
+		kick := &Kick{reason:KICK_REASON_LOGIN, platform_id:p.platform_id, device_id:p.device_id}
+		m := &Message{cmd: MSG_KICK, version:DEFAULT_VERSION, body:kick}
+		client.SendMessage(client.uid, m)
+	}
+
+	point := &LoginPoint{int32(client.tm.Unix()), client.platform_id, client.device_id}
+	m := &Message{cmd: MSG_LOGIN_POINT, version:DEFAULT_VERSION, body:point}
+	client.SendMessage(client.uid, m)
+}
+
+//踢出消息和登录通知只发给对应的设备
+func AcceptDeviceMessage(c *Client, msg *Message) bool {
+	switch msg.cmd {
+	case MSG_KICK:
+		kick, ok := msg.body.(*Kick)
+		if !ok || len(kick.device_id) == 0 {
+			return true
+		}
+		return c.platform_id == kick.platform_id && c.device_id == kick.device_id
+	case MSG_LOGIN_POINT:
+		point := msg.body.(*LoginPoint)
+		return c.platform_id != point.platform_id || c.device_id != point.device_id
+	default:
+		return true
+	}
+}
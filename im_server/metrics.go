@@ -0,0 +1,226 @@
+/**
+ * Copyright (c) 2014-2015, GoBelieve
+ * All rights reserved.
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place, Suite 330, Boston, MA  02111-1307  USA
+ */
+
+package main
+
+import "time"
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	metric_connections = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "im_connections",
+		Help: "Number of client connections, platform is unknown before authentication.",
+	}, []string{"platform"})
+
+	metric_clients = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "im_clients",
+		Help: "Number of authenticated clients.",
+	}, []string{"platform"})
+
+	metric_messages_in = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "im_messages_in_total",
+		Help: "Messages received from clients.",
+	}, []string{"cmd"})
+
+	metric_messages_out = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "im_messages_out_total",
+		Help: "Messages sent to clients.",
+	}, []string{"cmd"})
+
+	metric_storage_rpc_duration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "im_storage_rpc_duration_seconds",
+		Help:    "Latency of storage server requests.",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+	}, []string{"op", "addr"})
+
+	metric_storage_rpc_errors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "im_storage_rpc_errors_total",
+		Help: "Failed storage server requests.",
+	}, []string{"op", "addr"})
+
+	metric_route_reconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "im_route_channel_reconnects_total",
+		Help: "Times the route channel lost its connection.",
+	}, []string{"addr"})
+
+	metric_moderation = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "im_moderation_total",
+		Help: "Messages intercepted by the moderation chain.",
+	}, []string{"interceptor", "action"})
+
+	metric_throttled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "im_throttled_total",
+		Help: "Messages rejected by rate limits.",
+	}, []string{"class", "scope"})
+
+	metric_webhook_dropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "im_webhook_dropped_total",
+		Help: "Webhook events lost, reason is marshal, spill_full or dead.",
+	}, []string{"reason"})
+
+	metric_rate_limit_kicks = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "im_rate_limit_kicks_total",
+		Help: "Connections closed for repeatedly exceeding rate limits.",
+	})
+
+	metric_queue_overflow = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "im_client_queue_overflow_total",
+		Help: "Messages that found the client send queue full.",
+	}, []string{"policy"})
+
+	metric_ack_latency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "im_ack_latency_seconds",
+		Help:    "Time from sending a stored message to receiving its ack.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 14),
+	})
+
+	metric_retransmits = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "im_retransmits_total",
+		Help: "Unacked messages sent again.",
+	})
+
+	metric_retransmit_timeouts = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "im_retransmit_timeouts_total",
+		Help: "Connections closed after exhausting retransmits.",
+	})
+
+	metric_sessions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "im_sessions_total",
+		Help: "Session resume events, result is suspended, resumed, rejected or expired.",
+	}, []string{"result"})
+
+	metric_idle_reaped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "im_idle_reaped_total",
+		Help: "Connections closed after the idle timeout.",
+	}, []string{"platform"})
+
+	metric_compression_frames = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "im_compression_frames_total",
+		Help: "Frames considered for compression, result is compressed, incompressible or small.",
+	}, []string{"result"})
+
+	metric_compression_raw_bytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "im_compression_raw_bytes_total",
+		Help: "Body bytes of compressed frames before compression.",
+	})
+
+	metric_compression_compressed_bytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "im_compression_compressed_bytes_total",
+		Help: "Body bytes of compressed frames after compression.",
+	})
+
+	desc_storage_pool_active = prometheus.NewDesc("im_storage_pool_active_connections",
+		"Storage connections allocated by the pool.", []string{"addr"}, nil)
+	desc_storage_pool_idle = prometheus.NewDesc("im_storage_pool_idle_connections",
+		"Idle storage connections in the pool.", []string{"addr"}, nil)
+	desc_route_queue = prometheus.NewDesc("im_route_channel_queue_length",
+		"Messages waiting to be written to the route server.", []string{"addr"}, nil)
+	desc_storage_queue = prometheus.NewDesc("im_storage_channel_queue_length",
+		"Messages waiting to be written to the storage server.", []string{"addr"}, nil)
+	desc_client_queue_max = prometheus.NewDesc("im_client_queue_max_length",
+		"Longest client send queue on this server.", []string{"queue"}, nil)
+)
+
+func init() {
+	prometheus.MustRegister(metric_connections)
+	prometheus.MustRegister(metric_clients)
+	prometheus.MustRegister(metric_messages_in)
+	prometheus.MustRegister(metric_messages_out)
+	prometheus.MustRegister(metric_storage_rpc_duration)
+	prometheus.MustRegister(metric_storage_rpc_errors)
+	prometheus.MustRegister(metric_route_reconnects)
+	prometheus.MustRegister(metric_moderation)
+	prometheus.MustRegister(metric_throttled)
+	prometheus.MustRegister(metric_webhook_dropped)
+	prometheus.MustRegister(metric_rate_limit_kicks)
+	prometheus.MustRegister(metric_queue_overflow)
+	prometheus.MustRegister(metric_ack_latency)
+	prometheus.MustRegister(metric_retransmits)
+	prometheus.MustRegister(metric_retransmit_timeouts)
+	prometheus.MustRegister(metric_sessions)
+	prometheus.MustRegister(metric_idle_reaped)
+	prometheus.MustRegister(metric_compression_frames)
+	prometheus.MustRegister(metric_compression_raw_bytes)
+	prometheus.MustRegister(metric_compression_compressed_bytes)
+	prometheus.MustRegister(&ChannelCollector{})
+}
+
+func PlatformName(platform_id int8) string {
+	switch platform_id {
+	case PLATFORM_IOS:
+		return "ios"
+	case PLATFORM_ANDROID:
+		return "android"
+	case PLATFORM_WEB:
+		return "web"
+	case 0:
+		return "unknown"
+	default:
+		return "other"
+	}
+}
+
+//客户端可以发送任意的cmd,未定义的cmd归为一类
+func CommandName(cmd int) string {
+	if desc, ok := message_descriptions[cmd]; ok {
+		return desc
+	}
+	return "unknown"
+}
+
+func ObserveStorageRPC(op int, addr string, begin time.Time, err error) {
+	name := CommandName(op)
+	metric_storage_rpc_duration.WithLabelValues(name, addr).Observe(time.Since(begin).Seconds())
+	if err != nil {
+		metric_storage_rpc_errors.WithLabelValues(name, addr).Inc()
+	}
+}
+
+//采集时读取连接池和channel的状态
+type ChannelCollector struct {
+}
+
+func (c *ChannelCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- desc_storage_pool_active
+	ch <- desc_storage_pool_idle
+	ch <- desc_route_queue
+	ch <- desc_storage_queue
+	ch <- desc_client_queue_max
+}
+
+func (c *ChannelCollector) Collect(ch chan<- prometheus.Metric) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for addr, pool := range storage_pools_map {
+		active, idle := pool.Stats()
+		ch <- prometheus.MustNewConstMetric(desc_storage_pool_active, prometheus.GaugeValue, float64(active), addr)
+		ch <- prometheus.MustNewConstMetric(desc_storage_pool_idle, prometheus.GaugeValue, float64(idle), addr)
+	}
+	for addr, channel := range route_channels_map {
+		ch <- prometheus.MustNewConstMetric(desc_route_queue, prometheus.GaugeValue, float64(len(channel.wt)), addr)
+	}
+	for addr, sc := range storage_channels_map {
+		ch <- prometheus.MustNewConstMetric(desc_storage_queue, prometheus.GaugeValue, float64(len(sc.wt)), addr)
+	}
+
+	max_wt, max_ewt := route.MaxQueueDepth()
+	ch <- prometheus.MustNewConstMetric(desc_client_queue_max, prometheus.GaugeValue, float64(max_wt), "rt")
+	ch <- prometheus.MustNewConstMetric(desc_client_queue_max, prometheus.GaugeValue, float64(max_ewt), "im")
+}
@@ -0,0 +1,375 @@
+/**
+ * Copyright (c) 2014-2015, GoBelieve
+ * All rights reserved.
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place, Suite 330, Boston, MA  02111-1307  USA
+ */
+
+package main
+
+import "os"
+import "bufio"
+import "strings"
+import "sync"
+import "time"
+import "encoding/json"
+import log "github.com/golang/glog"
+import "im_service/common"
+
+const MODERATION_PASS = 0
+const MODERATION_MASK = 1
+const MODERATION_FLAG = 2
+const MODERATION_REJECT = 3
+
+//敏感词文件检查修改的间隔
+const SENSITIVE_WORDS_RELOAD_INTERVAL = 10
+
+var moderation_actions = map[string]int{
+	"mask": MODERATION_MASK,
+	"flag": MODERATION_FLAG,
+	"reject": MODERATION_REJECT,
+}
+
+var moderation_names = map[int]string{
+	MODERATION_PASS: "pass",
+	MODERATION_MASK: "mask",
+	MODERATION_FLAG: "flag",
+	MODERATION_REJECT: "reject",
+}
+
+//待检查的消息
+type ModerationMessage struct {
+	appid    int64
+	cmd      int
+	sender   int64
+	receiver int64
+	content  string
+}
+
+type ModerationResult struct {
+	action  int
+	content string   //mask之后的内容
+	words   []string //命中的词
+}
+
+//消息拦截器, 在消息保存或者发布之前调用
+type MessageInterceptor interface {
+	Name() string
+	Intercept(m *ModerationMessage) *ModerationResult
+}
+
+var interceptors []MessageInterceptor
+
+func AddMessageInterceptor(i MessageInterceptor) {
+	interceptors = append(interceptors, i)
+}
+
+//依次执行拦截器,返回处理后的内容和ack状态
+//被mask的内容传给下一个拦截器, reject时立即返回
+func InterceptMessage(appid int64, cmd int, sender int64, receiver int64, content string) (string, int8) {
+	//超过app配额的消息直接拒绝
+	if GetAppConfig(appid).IsContentTooLong(content) {
+		log.Infof("content too long appid:%d sender:%d length:%d", appid, sender, len(content))
+		return content, ACK_REJECTED
+	}
+
+	m := &ModerationMessage{appid, cmd, sender, receiver, content}
+	action := MODERATION_PASS
+	for _, i := range interceptors {
+		r := i.Intercept(m)
+		if r == nil || r.action == MODERATION_PASS {
+			continue
+		}
+
+		WriteModerationAudit(i.Name(), m, r)
+		metric_moderation.WithLabelValues(i.Name(), moderation_names[r.action]).Inc()
+		if r.action > action {
+			action = r.action
+		}
+		if r.action == MODERATION_REJECT {
+			break
+		}
+		if r.action == MODERATION_MASK {
+			m.content = r.content
+		}
+	}
+
+	//内容被修改过时返回masked, 即使之后的拦截器flag了这条消息
+	switch {
+	case action == MODERATION_REJECT:
+		return content, ACK_REJECTED
+	case m.content != content:
+		return m.content, ACK_MASKED
+	case action == MODERATION_FLAG:
+		return m.content, ACK_FLAGGED
+	default:
+		return content, ACK_SUCCESS
+	}
+}
+
+type SensitiveWords struct {
+	ac      *common.AhoCorasick
+	actions []int
+}
+
+//敏感词过滤, 词典文件每行一个词, 可以用tab分隔指定动作(mask/flag/reject)
+type SensitiveWordFilter struct {
+	path           string
+	default_action int
+
+	mutex sync.RWMutex
+	words *SensitiveWords
+	mtime time.Time
+}
+
+func NewSensitiveWordFilter(path string, default_action string) *SensitiveWordFilter {
+	f := &SensitiveWordFilter{path: path, default_action: MODERATION_MASK}
+	if action, ok := moderation_actions[default_action]; ok {
+		f.default_action = action
+	}
+	f.Reload()
+	return f
+}
+
+func (f *SensitiveWordFilter) Name() string {
+	return "sensitive_word"
+}
+
+func (f *SensitiveWordFilter) Load() (*SensitiveWords, error) {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	words := make([]string, 0)
+	actions := make([]int, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		action := f.default_action
+		fields := strings.Split(line, "\t")
+		if len(fields) > 1 {
+			if a, ok := moderation_actions[strings.TrimSpace(fields[1])]; ok {
+				action = a
+			}
+		}
+		word := strings.TrimSpace(fields[0])
+		if len(word) == 0 {
+			continue
+		}
+		words = append(words, word)
+		actions = append(actions, action)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &SensitiveWords{common.NewAhoCorasick(words), actions}, nil
+}
+
+//文件修改之后重新加载, 加载失败时保留原来的词典
+func (f *SensitiveWordFilter) Reload() {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		log.Warning("stat sensitive words error:", err)
+		return
+	}
+	f.mutex.RLock()
+	mtime := f.mtime
+	f.mutex.RUnlock()
+	if info.ModTime().Equal(mtime) {
+		return
+	}
+
+	words, err := f.Load()
+	if err != nil {
+		log.Warning("load sensitive words error:", err)
+		return
+	}
+
+	f.mutex.Lock()
+	f.words = words
+	f.mtime = info.ModTime()
+	f.mutex.Unlock()
+	log.Infof("load sensitive words:%d from %s", words.ac.Len(), f.path)
+}
+
+func (f *SensitiveWordFilter) ReloadLoop() {
+	for {
+		time.Sleep(SENSITIVE_WORDS_RELOAD_INTERVAL * time.Second)
+		f.Reload()
+	}
+}
+
+func (f *SensitiveWordFilter) Filter(text string) (int, string, []string) {
+	f.mutex.RLock()
+	words := f.words
+	f.mutex.RUnlock()
+	if words == nil {
+		return MODERATION_PASS, text, nil
+	}
+
+	runes := []rune(text)
+	matches := words.ac.FindAll(runes)
+	if len(matches) == 0 {
+		return MODERATION_PASS, text, nil
+	}
+
+	action := MODERATION_PASS
+	hits := make([]string, 0, len(matches))
+	for _, m := range matches {
+		a := words.actions[m.Index]
+		if a > action {
+			action = a
+		}
+		hits = append(hits, words.ac.Word(m.Index))
+		if a == MODERATION_MASK {
+			for i := m.Start; i < m.End; i++ {
+				runes[i] = '*'
+			}
+		}
+	}
+	return action, string(runes), hits
+}
+
+//json内容中的每个key和字符串值分别检查
+type JSONFilter struct {
+	f      *SensitiveWordFilter
+	action int
+	hits   []string
+	masked bool
+}
+
+func (jf *JSONFilter) FilterString(text string) string {
+	action, masked, hits := jf.f.Filter(text)
+	if action > jf.action {
+		jf.action = action
+	}
+	jf.hits = append(jf.hits, hits...)
+	if masked != text {
+		jf.masked = true
+	}
+	return masked
+}
+
+//返回mask之后的值, 嵌套的对象和数组递归检查
+func (jf *JSONFilter) FilterValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return jf.FilterString(v)
+	case []interface{}:
+		for i, e := range v {
+			v[i] = jf.FilterValue(e)
+		}
+		return v
+	case map[string]interface{}:
+		obj := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			obj[jf.FilterString(k)] = jf.FilterValue(e)
+		}
+		return obj
+	default:
+		return v
+	}
+}
+
+//json格式的内容检查所有的字符串, mask之后仍是合法的json
+func (f *SensitiveWordFilter) Intercept(m *ModerationMessage) *ModerationResult {
+	if json.Valid([]byte(m.content)) {
+		var obj interface{}
+		decoder := json.NewDecoder(strings.NewReader(m.content))
+		//保留数字的原始格式
+		decoder.UseNumber()
+		err := decoder.Decode(&obj)
+		if err != nil {
+			log.Info("json decode:", err)
+			return nil
+		}
+
+		jf := &JSONFilter{f:f}
+		obj = jf.FilterValue(obj)
+		if jf.action == MODERATION_PASS {
+			return nil
+		}
+		content := m.content
+		if jf.masked {
+			b, err := json.Marshal(obj)
+			if err != nil {
+				log.Info("json marshal:", err)
+				return nil
+			}
+			content = string(b)
+		}
+		return &ModerationResult{jf.action, content, jf.hits}
+	}
+
+	action, masked, hits := f.Filter(m.content)
+	if action == MODERATION_PASS {
+		return nil
+	}
+	return &ModerationResult{action, masked, hits}
+}
+
+var audit_mutex sync.Mutex
+var audit_file *os.File
+
+//审计日志,每行一条json
+func WriteModerationAudit(name string, m *ModerationMessage, r *ModerationResult) {
+	obj := make(map[string]interface{})
+	obj["time"] = time.Now().Unix()
+	obj["interceptor"] = name
+	obj["action"] = moderation_names[r.action]
+	obj["appid"] = m.appid
+	obj["cmd"] = Command(m.cmd).String()
+	obj["sender"] = m.sender
+	obj["receiver"] = m.receiver
+	obj["words"] = r.words
+	obj["content"] = m.content
+	b, err := json.Marshal(obj)
+	if err != nil {
+		log.Info("json marshal:", err)
+		return
+	}
+
+	audit_mutex.Lock()
+	defer audit_mutex.Unlock()
+	if audit_file == nil {
+		log.Info("moderation audit:", string(b))
+		return
+	}
+	_, err = audit_file.Write(append(b, '\n'))
+	if err != nil {
+		log.Warning("write audit log error:", err)
+	}
+}
+
+func StartModeration() {
+	if len(config.moderation_audit_log) > 0 {
+		file, err := os.OpenFile(config.moderation_audit_log, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			log.Fatal("open audit log error:", err)
+		}
+		audit_file = file
+	}
+
+	if len(config.sensitive_words_file) > 0 {
+		f := NewSensitiveWordFilter(config.sensitive_words_file, config.sensitive_words_action)
+		AddMessageInterceptor(f)
+		go f.ReloadLoop()
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import "time"
+import "strings"
+import "testing"
+import "encoding/json"
+import "im_service/common"
+
+func NewTestFilter(actions map[string]int) *SensitiveWordFilter {
+	words := make([]string, 0, len(actions))
+	a := make([]int, 0, len(actions))
+	for w, action := range actions {
+		words = append(words, w)
+		a = append(a, action)
+	}
+	f := &SensitiveWordFilter{default_action:MODERATION_MASK}
+	f.words = &SensitiveWords{common.NewAhoCorasick(words), a}
+	return f
+}
+
+func Test_InterceptText(t *testing.T) {
+	f := NewTestFilter(map[string]int{"bad":MODERATION_MASK})
+	r := f.Intercept(&ModerationMessage{content:"a bad word"})
+	if r == nil || r.action != MODERATION_MASK || r.content != "a *** word" {
+		t.Fatal("mask text error:", r)
+	}
+	if f.Intercept(&ModerationMessage{content:"good"}) != nil {
+		t.Error("clean text intercepted")
+	}
+}
+
+func Test_InterceptJSON(t *testing.T) {
+	f := NewTestFilter(map[string]int{"bad":MODERATION_MASK})
+
+	//任意key, 嵌套的对象和数组都要检查
+	contents := []string{
+		`{"t":"bad"}`,
+		`{"text":"ok", "ext":{"title":["x", "bad"]}}`,
+		`["bad"]`,
+		`"bad"`,
+		`{"bad":1}`,
+	}
+	for _, content := range contents {
+		r := f.Intercept(&ModerationMessage{content:content})
+		if r == nil || r.action != MODERATION_MASK {
+			t.Fatal("json isn't masked:", content)
+		}
+		if strings.Contains(r.content, "bad") || !json.Valid([]byte(r.content)) {
+			t.Error("mask json error:", content, r.content)
+		}
+	}
+
+	//没有命中时保持原样, 数字不丢失精度
+	if f.Intercept(&ModerationMessage{content:`{"id":9007199254740993}`}) != nil {
+		t.Error("clean json intercepted")
+	}
+	r := f.Intercept(&ModerationMessage{content:`{"id":9007199254740993, "t":"bad"}`})
+	if r == nil || !strings.Contains(r.content, "9007199254740993") {
+		t.Error("json number changed:", r)
+	}
+}
+
+func Test_InterceptStatus(t *testing.T) {
+	config = &Config{}
+	app_configs[1] = DefaultAppConfig()
+	app_configs[1].tm = time.Now()
+	saved := interceptors
+	defer func() { interceptors = saved }()
+
+	//mask之后又被flag, 客户端需要知道内容被修改
+	interceptors = []MessageInterceptor{
+		NewTestFilter(map[string]int{"bad":MODERATION_MASK}),
+		NewTestFilter(map[string]int{"word":MODERATION_FLAG}),
+	}
+	content, status := InterceptMessage(1, MSG_IM, 1, 2, "bad word")
+	if status != ACK_MASKED || content != "*** word" {
+		t.Error("masked content isn't reported:", content, status)
+	}
+	content, status = InterceptMessage(1, MSG_IM, 1, 2, "good word")
+	if status != ACK_FLAGGED || content != "good word" {
+		t.Error("flag error:", content, status)
+	}
+}
@@ -40,6 +40,18 @@ func Summary(rw http.ResponseWriter, req *http.Request) {
 	return
 }
 
+//停止接受新连接, 通知客户端重连后退出
+//没有配置admin_secret时使用SIGUSR1
+func PostDrain(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		WriteHttpError(405, "method not allowed", rw)
+		return
+	}
+	log.Info("drain requested from ", req.RemoteAddr)
+	RequestDrain()
+	WriteHttpObj(make(map[string]interface{}), rw)
+}
+
 func Stack(rw http.ResponseWriter, req *http.Request) {
 	pprof.Lookup("goroutine").WriteTo(os.Stderr, 1)
 	rw.WriteHeader(200)
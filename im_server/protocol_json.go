@@ -0,0 +1,129 @@
+/**
+ * Copyright (c) 2014-2015, GoBelieve
+ * All rights reserved.
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place, Suite 330, Boston, MA  02111-1307  USA
+ */
+
+package main
+
+import "fmt"
+import "bytes"
+import "errors"
+import "encoding/json"
+import log "github.com/golang/glog"
+
+//连接使用的编码方式, 由认证消息的格式决定
+const CODEC_BINARY = 0
+const CODEC_JSON = 1
+
+//json格式: {"cmd":4, "seq":1, "version":1, "body":{"sender":1, "receiver":2, ...}}
+//cmd可以是数字或者消息名称, body由消息的ToJSON/FromJSON编码, 字段名和protocol.go中结构体的字段名相同
+//一个json对象对应一个二进制消息帧
+
+func EncodeJSONMessage(msg *Message) ([]byte, error) {
+	obj := make(map[string]interface{})
+	obj["cmd"] = msg.cmd
+	obj["seq"] = msg.seq
+	obj["version"] = msg.version
+	if msg.body != nil {
+		m, ok := msg.body.(IJSONMessage)
+		if !ok {
+			return nil, fmt.Errorf("cmd:%d doesn't support json", msg.cmd)
+		}
+		obj["body"] = m.ToJSON()
+	}
+	return json.Marshal(obj)
+}
+
+func DecodeJSONMessage(b []byte) *Message {
+	var obj interface{}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	err := decoder.Decode(&obj)
+	if err != nil {
+		log.Info("decode json message error:", err)
+		return nil
+	}
+	msg, err := parseJSONMessage(obj)
+	if err != nil {
+		log.Info("parse json message error:", err)
+		return nil
+	}
+	return msg
+}
+
+func parseJSONCommand(v interface{}) (int, error) {
+	switch c := v.(type) {
+	case json.Number:
+		n, err := c.Int64()
+		return int(n), err
+	case string:
+		for cmd, desc := range message_descriptions {
+			if desc == c {
+				return cmd, nil
+			}
+		}
+		return 0, fmt.Errorf("unknown cmd:%s", c)
+	default:
+		return 0, errors.New("invalid cmd")
+	}
+}
+
+func parseJSONMessage(v interface{}) (*Message, error) {
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("message isn't object")
+	}
+
+	msg := &Message{}
+	cmd, err := parseJSONCommand(obj["cmd"])
+	if err != nil {
+		return nil, err
+	}
+	seq, err := ParseJSONInt(obj["seq"], 64)
+	if err != nil {
+		return nil, err
+	}
+	version, err := ParseJSONInt(obj["version"], 64)
+	if err != nil {
+		return nil, err
+	}
+	msg.cmd = cmd
+	msg.seq = int(seq)
+	msg.version = int(version)
+
+	var body interface{}
+	if creator, ok := message_creators[cmd]; ok {
+		body = creator()
+	} else if creator, ok := vmessage_creators[cmd]; ok {
+		body = creator()
+	} else {
+		return msg, nil
+	}
+
+	//服务器之间的消息没有json编码
+	m, ok := body.(IJSONMessage)
+	if !ok {
+		return nil, fmt.Errorf("cmd:%d doesn't support json", cmd)
+	}
+	//body和二进制格式一样, 缺少的字段为零值
+	err = m.FromJSON(obj["body"])
+	if err != nil {
+		return nil, fmt.Errorf("cmd:%d %s", cmd, err)
+	}
+	msg.body = body
+	return msg, nil
+}
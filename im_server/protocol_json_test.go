@@ -0,0 +1,107 @@
+package main
+
+import "bytes"
+import "reflect"
+import "testing"
+
+func jsonRoundTrip(t *testing.T, msg *Message) *Message {
+	b, err := EncodeJSONMessage(msg)
+	if err != nil {
+		t.Fatal("encode error:", err)
+	}
+	msg2 := DecodeJSONMessage(b)
+	if msg2 == nil {
+		t.Fatal("decode error:", string(b))
+	}
+	if msg2.cmd != msg.cmd || msg2.seq != msg.seq || msg2.version != msg.version {
+		t.Fatalf("header mismatch:%+v %+v", msg, msg2)
+	}
+	return msg2
+}
+
+func Test_JSONRoundTrip(t *testing.T) {
+	bodies := map[int]interface{}{
+		MSG_IM : &IMMessage{sender:1, receiver:2, timestamp:3, msgid:4, content:"test"},
+		MSG_AUTH_TOKEN : &AuthenticationToken{token:"token", platform_id:PLATFORM_WEB, device_id:"device", capabilities:1, max_frame_size:1024, resume_token:"resume", appid:7, device_token:"apns"},
+		MSG_FRAGMENT : &Fragment{id:1, index:2, count:3, data:[]byte{0, 1, 2, 0xff}},
+		MSG_VOIP_CONTROL : &VOIPControl{sender:1, receiver:2, content:[]byte("voip")},
+		MSG_SUBSCRIBE_ONLINE_STATE : &MessageSubscribeState{uids:[]int64{1, 2, 3}},
+		MSG_GROUP_CREATE : &GroupCreate{is_private:1, members:[]int64{1, 2}, title:"title", desc:"desc"},
+		MSG_ROOM_HISTORY : &RoomHistory{room_id:1, more:true, messages:[]*RoomHistoryItem{{1, 2, 3, "a"}, {4, 5, 6, "b"}}},
+		MSG_ROOM_IM : &RoomMessage{&RTMessage{sender:1, receiver:2, content:"room"}},
+	}
+	for cmd, body := range bodies {
+		msg := &Message{cmd:cmd, seq:10, version:1, body:body}
+		msg2 := jsonRoundTrip(t, msg)
+		if !reflect.DeepEqual(msg.body, msg2.body) {
+			t.Errorf("cmd:%s body mismatch:%+v %+v", Command(cmd), msg.body, msg2.body)
+		}
+	}
+}
+
+//fcm的token超过127字节, appid为0时也要写入device_token
+func Test_AuthTokenData(t *testing.T) {
+	auth := &AuthenticationToken{token:"token", platform_id:PLATFORM_ANDROID, device_id:"device", device_token:string(bytes.Repeat([]byte("f"), 200))}
+	auth2 := &AuthenticationToken{}
+	if !auth2.FromData(auth.ToData()) || !reflect.DeepEqual(auth, auth2) {
+		t.Errorf("auth token mismatch:%+v %+v", auth, auth2)
+	}
+
+	old := &AuthenticationToken{token:"token", platform_id:PLATFORM_IOS, device_id:"device"}
+	auth2 = &AuthenticationToken{}
+	if !auth2.FromData(old.ToData()) || len(auth2.device_token) != 0 {
+		t.Errorf("old auth token mismatch:%+v", auth2)
+	}
+}
+
+func Test_JSONRoom(t *testing.T) {
+	room := Room(100)
+	msg2 := jsonRoundTrip(t, &Message{cmd:MSG_ENTER_ROOM, body:&room})
+	if *msg2.body.(*Room) != room {
+		t.Error("room mismatch:", *msg2.body.(*Room))
+	}
+}
+
+func Test_JSONEmptySlice(t *testing.T) {
+	b, err := EncodeJSONMessage(&Message{cmd:MSG_GROUP_INVITE_JOIN, body:&GroupInviteJoin{gid:1}})
+	if err != nil {
+		t.Fatal("encode error:", err)
+	}
+	if !bytes.Contains(b, []byte(`"members":[]`)) {
+		t.Error("nil slice isn't encoded as empty array:", string(b))
+	}
+}
+
+func Test_JSONDecode(t *testing.T) {
+	msg := DecodeJSONMessage([]byte(`{"cmd":"MSG_IM", "seq":1, "body":{"sender":1, "receiver":2}}`))
+	if msg == nil || msg.cmd != MSG_IM {
+		t.Fatal("decode command name error")
+	}
+	im := msg.body.(*IMMessage)
+	if im.sender != 1 || im.receiver != 2 || im.msgid != 0 || im.content != "" {
+		t.Errorf("missing fields aren't zero:%+v", im)
+	}
+
+	invalid := []string{
+		`{"cmd":4, "body":{"sender":"1"}}`,
+		`{"cmd":4, "body":{"timestamp":4294967296}}`,
+		`{"cmd":4, "body":[]}`,
+		`{"cmd":"MSG_UNKNOWN"}`,
+		`{"cmd":15, "body":{"platform_id":128}}`,
+		`{"cmd":28, "body":{"data":"!"}}`,
+		`{"cmd":130, "body":{"appid":1, "uid":2}}`,
+	}
+	for _, s := range invalid {
+		if DecodeJSONMessage([]byte(s)) != nil {
+			t.Error("invalid message decoded:", s)
+		}
+	}
+}
+
+func Test_JSONServerMessage(t *testing.T) {
+	amsg := &AppMessage{appid:1, receiver:2, msg:&Message{cmd:MSG_IM, body:&IMMessage{}}}
+	_, err := EncodeJSONMessage(&Message{cmd:MSG_PUBLISH, body:amsg})
+	if err == nil {
+		t.Error("server message encoded as json")
+	}
+}
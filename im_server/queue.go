@@ -0,0 +1,86 @@
+/**
+ * Copyright (c) 2014-2015, GoBelieve
+ * All rights reserved.
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place, Suite 330, Boston, MA  02111-1307  USA
+ */
+
+
+package main
+
+import "sync/atomic"
+import log "github.com/golang/glog"
+
+//发送队列满时实时消息的处理策略
+//drop: 丢弃实时消息
+//disconnect: 断开连接, 客户端重连后加载离线消息
+//offline: 同drop, 保留旧的配置名
+//存储过的消息不能丢弃: 客户端ack之后的消息时storage会移动已接收的位置,
+//丢弃的消息不会再作为离线消息加载, 所以总是断开连接
+const QUEUE_OVERFLOW_DROP = "drop"
+const QUEUE_OVERFLOW_DISCONNECT = "disconnect"
+const QUEUE_OVERFLOW_OFFLINE = "offline"
+
+//投递其它连接的消息时不能阻塞, 一个慢客户端会阻塞整个route channel
+func (client *Connection) EnqueueMessage(msg *Message) bool {
+	select {
+	case client.wt <- msg:
+		return true
+	default:
+		client.HandleQueueOverflow(msg, false)
+		return false
+	}
+}
+
+func (client *Connection) EnqueueEMessage(emsg *EMessage) bool {
+	if emsg.msg.cmd == MSG_GROUP_IM {
+		client.AddPendingGroup(emsg.msg.body.(*IMMessage).receiver)
+	}
+	select {
+	case client.ewt <- emsg:
+		return true
+	default:
+		client.HandleQueueOverflow(emsg.msg, true)
+		return false
+	}
+}
+
+func (client *Connection) HandleQueueOverflow(msg *Message, stored bool) {
+	policy := config.queue_overflow_policy
+	metric_queue_overflow.WithLabelValues(policy).Inc()
+	log.Warningf("uid:%d platform:%d queue full, policy:%s drop msg:%s",
+		client.uid, client.platform_id, policy, Command(msg.cmd))
+	if stored {
+		//恢复会话不会重新加载离线消息
+		client.DisableResume()
+	}
+
+	if policy == QUEUE_OVERFLOW_DISCONNECT || stored {
+		//只关闭一次, 由读协程完成后续清理
+		if atomic.CompareAndSwapInt32(&client.overflowed, 0, 1) {
+			client.close()
+		}
+	}
+}
+
+//实时消息和在线消息队列的长度
+func (client *Connection) QueueDepth() (int, int) {
+	return len(client.wt), len(client.ewt)
+}
+
+func IsQueueOverflowPolicy(policy string) bool {
+	return policy == QUEUE_OVERFLOW_DROP || policy == QUEUE_OVERFLOW_DISCONNECT ||
+		policy == QUEUE_OVERFLOW_OFFLINE
+}
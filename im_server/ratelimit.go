@@ -0,0 +1,246 @@
+/**
+ * Copyright (c) 2014-2015, GoBelieve
+ * All rights reserved.
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place, Suite 330, Boston, MA  02111-1307  USA
+ */
+
+package main
+
+import "fmt"
+import "sync"
+import "time"
+import "strings"
+import "strconv"
+import log "github.com/golang/glog"
+
+//限流的命令分类
+const LIMIT_CLASS_MESSAGE = 0
+const LIMIT_CLASS_RT = 1
+const LIMIT_CLASS_INPUTING = 2
+const LIMIT_CLASS_CONTACT = 3
+const LIMIT_CLASS_COUNT = 4
+
+var limit_class_names = []string{"message", "rt", "inputing", "contact"}
+
+const LIMIT_SCOPE_CONN = 0
+const LIMIT_SCOPE_UID = 1
+const LIMIT_SCOPE_APP = 2
+const LIMIT_SCOPE_COUNT = 3
+
+var limit_scope_names = []string{"conn", "uid", "app"}
+
+//统计被限流次数的时间窗口
+const THROTTLE_WINDOW = 60
+
+//空闲的uid,appid令牌桶的回收间隔
+const LIMITER_GC_INTERVAL = 60
+
+func LimitClass(cmd int) int {
+	switch cmd {
+	case MSG_IM, MSG_GROUP_IM, MSG_ROOM_IM, MSG_TRANSMIT_USER, MSG_TRANSMIT_GROUP, MSG_TRANSMIT_ROOM:
+		return LIMIT_CLASS_MESSAGE
+	case MSG_RT, MSG_VOIP_CONTROL:
+		return LIMIT_CLASS_RT
+	case MSG_INPUTING:
+		return LIMIT_CLASS_INPUTING
+	case MSG_CONTACT_INVITE, MSG_CONTACT_ACCEPT, MSG_CONTACT_REFUSE,
+		MSG_CONTACT_DEL, MSG_CONTACT_BLACK, MSG_CONTACT_UNBLACK:
+		return LIMIT_CLASS_CONTACT
+	default:
+		return -1
+	}
+}
+
+//每秒rate个令牌,最多累积burst个, rate为0时不限制
+type RateLimit struct {
+	rate  float64
+	burst float64
+}
+
+//配置格式: rate/burst, 如 "10/20"
+func ParseRateLimit(s string) (RateLimit, error) {
+	l := RateLimit{}
+	if len(s) == 0 {
+		return l, nil
+	}
+	fields := strings.SplitN(s, "/", 2)
+	rate, err := strconv.ParseFloat(strings.TrimSpace(fields[0]), 64)
+	if err != nil {
+		return l, err
+	}
+	burst := rate
+	if len(fields) == 2 {
+		burst, err = strconv.ParseFloat(strings.TrimSpace(fields[1]), 64)
+		if err != nil {
+			return l, err
+		}
+	}
+	if rate < 0 || burst < 1 && rate > 0 {
+		return l, fmt.Errorf("invalid rate limit:%s", s)
+	}
+	l.rate = rate
+	l.burst = burst
+	return l, nil
+}
+
+type TokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *TokenBucket) Allow(l RateLimit, now time.Time) bool {
+	if b.last.IsZero() {
+		b.tokens = l.burst
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * l.rate
+		if b.tokens > l.burst {
+			b.tokens = l.burst
+		}
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+//令牌已经补满,可以回收
+func (b *TokenBucket) Full(l RateLimit, now time.Time) bool {
+	return b.tokens + now.Sub(b.last).Seconds() * l.rate >= l.burst
+}
+
+type BucketKey struct {
+	appid int64
+	uid   int64
+	class int
+}
+
+//同一个uid或appid的所有连接共享的令牌桶
+type SharedBuckets struct {
+	mutex   sync.Mutex
+	buckets map[BucketKey]*TokenBucket
+	scope   int
+}
+
+func NewSharedBuckets(scope int) *SharedBuckets {
+	return &SharedBuckets{buckets: make(map[BucketKey]*TokenBucket), scope: scope}
+}
+
+func (s *SharedBuckets) Allow(key BucketKey, now time.Time) bool {
+	l := config.rate_limits[s.scope][key.class]
+	if l.rate == 0 {
+		return true
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &TokenBucket{}
+		s.buckets[key] = b
+	}
+	return b.Allow(l, now)
+}
+
+func (s *SharedBuckets) GC(now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for key, b := range s.buckets {
+		if b.Full(config.rate_limits[s.scope][key.class], now) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+var uid_buckets = NewSharedBuckets(LIMIT_SCOPE_UID)
+var app_buckets = NewSharedBuckets(LIMIT_SCOPE_APP)
+
+//单个连接的令牌桶, 只在读协程中访问
+type ConnLimiter struct {
+	buckets [LIMIT_CLASS_COUNT]TokenBucket
+
+	throttle_count int
+	throttle_tm    time.Time
+}
+
+//返回被限流的范围, -1表示通过
+func (l *ConnLimiter) Allow(appid int64, uid int64, class int) int {
+	now := time.Now()
+	limit := config.rate_limits[LIMIT_SCOPE_CONN][class]
+	if limit.rate > 0 && !l.buckets[class].Allow(limit, now) {
+		return LIMIT_SCOPE_CONN
+	}
+	//未认证的连接只按连接限制
+	if uid == 0 {
+		return -1
+	}
+	if !uid_buckets.Allow(BucketKey{appid, uid, class}, now) {
+		return LIMIT_SCOPE_UID
+	}
+	if !app_buckets.Allow(BucketKey{appid, 0, class}, now) {
+		return LIMIT_SCOPE_APP
+	}
+	return -1
+}
+
+//记录一次限流, 窗口内次数超过阈值返回true
+func (l *ConnLimiter) Throttle() bool {
+	now := time.Now()
+	if now.Sub(l.throttle_tm) > THROTTLE_WINDOW * time.Second {
+		l.throttle_tm = now
+		l.throttle_count = 0
+	}
+	l.throttle_count++
+	return config.rate_limit_kick_threshold > 0 && l.throttle_count >= config.rate_limit_kick_threshold
+}
+
+func RateLimitGCLoop() {
+	for {
+		time.Sleep(LIMITER_GC_INTERVAL * time.Second)
+		now := time.Now()
+		uid_buckets.GC(now)
+		app_buckets.GC(now)
+	}
+}
+
+//超过限制的消息返回ACK_THROTTLED, 多次超过限制的连接被踢下线
+func (client *Client) AllowMessage(msg *Message) bool {
+	if client.kicked {
+		return false
+	}
+	class := LimitClass(msg.cmd)
+	if class == -1 {
+		return true
+	}
+	scope := client.limiter.Allow(client.appid, client.uid, class)
+	if scope == -1 {
+		return true
+	}
+
+	metric_throttled.WithLabelValues(limit_class_names[class], limit_scope_names[scope]).Inc()
+	log.Infof("throttle appid:%d uid:%d cmd:%s scope:%s", client.appid, client.uid, Command(msg.cmd), limit_scope_names[scope])
+	client.wt <- &Message{cmd: MSG_ACK, body: &MessageACK{int32(msg.seq), ACK_THROTTLED}}
+
+	//app范围的令牌桶由所有用户共享, 不计入单个连接的限流次数
+	if scope != LIMIT_SCOPE_APP && client.limiter.Throttle() {
+		log.Warningf("kick client appid:%d uid:%d, throttled %d times", client.appid, client.uid, client.limiter.throttle_count)
+		metric_rate_limit_kicks.Inc()
+		client.kicked = true
+		client.wt <- &Message{cmd: MSG_KICK, body: &Kick{reason:KICK_REASON_THROTTLE}}
+	}
+	return false
+}
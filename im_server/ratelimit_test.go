@@ -0,0 +1,160 @@
+package main
+
+import "time"
+import "testing"
+
+func Test_ParseRateLimit(t *testing.T) {
+	l, err := ParseRateLimit("10/20")
+	if err != nil || l.rate != 10 || l.burst != 20 {
+		t.Error("parse rate/burst error:", l, err)
+	}
+	l, err = ParseRateLimit("5")
+	if err != nil || l.rate != 5 || l.burst != 5 {
+		t.Error("burst isn't rate:", l, err)
+	}
+	l, err = ParseRateLimit("")
+	if err != nil || l.rate != 0 {
+		t.Error("empty limit isn't unlimited:", l, err)
+	}
+	for _, s := range []string{"a", "-1", "1/0.5", "1/b"} {
+		if _, err := ParseRateLimit(s); err == nil {
+			t.Error("invalid limit parsed:", s)
+		}
+	}
+}
+
+func Test_TokenBucket(t *testing.T) {
+	l := RateLimit{rate:2, burst:3}
+	b := &TokenBucket{}
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if !b.Allow(l, now) {
+			t.Fatal("burst isn't allowed:", i)
+		}
+	}
+	if b.Allow(l, now) {
+		t.Fatal("allowed after burst")
+	}
+
+	//每秒补充2个
+	now = now.Add(500 * time.Millisecond)
+	if !b.Allow(l, now) {
+		t.Fatal("token isn't refilled")
+	}
+	if b.Allow(l, now) {
+		t.Fatal("refilled too many tokens")
+	}
+
+	//最多累积burst个
+	now = now.Add(time.Minute)
+	if !b.Full(l, now) {
+		t.Error("bucket isn't full")
+	}
+	n := 0
+	for b.Allow(l, now) {
+		n++
+	}
+	if n != 3 {
+		t.Error("tokens exceed burst:", n)
+	}
+}
+
+func Test_ConnLimiter(t *testing.T) {
+	config = &Config{}
+	config.rate_limits[LIMIT_SCOPE_CONN][LIMIT_CLASS_MESSAGE] = RateLimit{rate:1, burst:2}
+	config.rate_limits[LIMIT_SCOPE_UID][LIMIT_CLASS_MESSAGE] = RateLimit{rate:1, burst:3}
+	config.rate_limit_kick_threshold = 2
+
+	//同一个uid的两个连接共享uid的令牌桶
+	l1 := &ConnLimiter{}
+	l2 := &ConnLimiter{}
+	scopes := []int{
+		l1.Allow(1, 100, LIMIT_CLASS_MESSAGE),
+		l1.Allow(1, 100, LIMIT_CLASS_MESSAGE),
+		l1.Allow(1, 100, LIMIT_CLASS_MESSAGE),
+		l2.Allow(1, 100, LIMIT_CLASS_MESSAGE),
+		l2.Allow(1, 100, LIMIT_CLASS_MESSAGE),
+	}
+	expect := []int{-1, -1, LIMIT_SCOPE_CONN, -1, LIMIT_SCOPE_UID}
+	for i := range expect {
+		if scopes[i] != expect[i] {
+			t.Errorf("allow:%d scope:%d expect:%d", i, scopes[i], expect[i])
+		}
+	}
+
+	//没有配置的分类不限制
+	for i := 0; i < 10; i++ {
+		if l1.Allow(1, 100, LIMIT_CLASS_RT) != -1 {
+			t.Fatal("unlimited class is limited")
+		}
+	}
+
+	if l1.Throttle() {
+		t.Error("kicked before threshold")
+	}
+	if !l1.Throttle() {
+		t.Error("not kicked at threshold")
+	}
+}
+
+func Test_AllowMessage(t *testing.T) {
+	config = &Config{}
+	config.rate_limits[LIMIT_SCOPE_APP][LIMIT_CLASS_MESSAGE] = RateLimit{rate:1, burst:1}
+	config.rate_limit_kick_threshold = 1
+	app_buckets = NewSharedBuckets(LIMIT_SCOPE_APP)
+
+	client := &Client{}
+	client.wt = make(chan *Message, 10)
+	client.appid, client.uid = 1, 100
+	msg := &Message{cmd:MSG_IM, seq:1}
+	if !client.AllowMessage(msg) {
+		t.Fatal("message isn't allowed")
+	}
+
+	//app的令牌桶耗尽时不踢下线
+	for i := 0; i < 3; i++ {
+		if client.AllowMessage(msg) {
+			t.Fatal("app limit isn't applied")
+		}
+	}
+	if client.kicked {
+		t.Error("client is kicked by app limit")
+	}
+	for i := 0; i < 3; i++ {
+		m := <-client.wt
+		if m.cmd != MSG_ACK || m.body.(*MessageACK).status != ACK_THROTTLED {
+			t.Error("throttled ack error:", m.cmd)
+		}
+	}
+}
+
+func Test_SharedBucketsGC(t *testing.T) {
+	config = &Config{}
+	config.rate_limits[LIMIT_SCOPE_APP][LIMIT_CLASS_MESSAGE] = RateLimit{rate:1, burst:1}
+
+	s := NewSharedBuckets(LIMIT_SCOPE_APP)
+	key := BucketKey{appid:1, class:LIMIT_CLASS_MESSAGE}
+	now := time.Now()
+	if !s.Allow(key, now) || s.Allow(key, now) {
+		t.Fatal("shared bucket isn't limited")
+	}
+
+	//令牌未补满的桶不回收
+	s.GC(now)
+	if len(s.buckets) != 1 {
+		t.Fatal("bucket is collected before full")
+	}
+	s.GC(now.Add(2 * time.Second))
+	if len(s.buckets) != 0 {
+		t.Fatal("full bucket isn't collected")
+	}
+}
+
+func Test_LimitClass(t *testing.T) {
+	if LimitClass(MSG_IM) != LIMIT_CLASS_MESSAGE || LimitClass(MSG_INPUTING) != LIMIT_CLASS_INPUTING {
+		t.Error("invalid limit class")
+	}
+	if LimitClass(MSG_HEARTBEAT) != -1 {
+		t.Error("heartbeat is limited")
+	}
+}
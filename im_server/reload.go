@@ -30,6 +30,7 @@ import (
     "net/http"
     "strconv"
     "reflect"
+    "crypto/tls"
 	log "github.com/golang/glog"
 )
 
@@ -140,6 +141,17 @@ func shutdown() {
     lock.Unlock()
 }
 
+// close listeners but keep the admin api
+func shutdownExcept(laddr string) {
+    lock.Lock()
+    for _, listener := range (listeners) {
+        if listener.laddr != laddr {
+            listener.Close()
+        }
+    }
+    lock.Unlock()
+}
+
 func gracefulShutdown() {
     shutdown()
     listenerWaitGroup.Wait()
@@ -154,24 +166,38 @@ func waitSignal() error {
     syscall.SIGINT,
     syscall.SIGQUIT,
     syscall.SIGTERM,
+    syscall.SIGUSR1,
     )
     for {
-        sig := <-ch
+        var sig os.Signal
+        select {
+        case sig = <-ch:
+        case <-drain_ch:
+            sig = syscall.SIGUSR1
+        }
         log.Info("singal:", sig.String())
         switch sig {
             //TERM, INT	Quick shutdown
             case syscall.SIGTERM, syscall.SIGINT:
+            ReleaseLease()
             shutdown()
             return nil
             //QUIT	Graceful shutdown
             case syscall.SIGQUIT:
             gracefulShutdown()
+            ReleaseLease()
             return nil
-            //HUP	reload
+            //HUP	reload, the new process inherits the listeners
             case syscall.SIGHUP:
             restart(sig)
             gracefulShutdown()
             return nil
+            //USR1	drain and exit
+            case syscall.SIGUSR1:
+            Drain()
+            ReleaseLease()
+            shutdown()
+            return nil
         }
     }
     return nil // It'll never get here.
@@ -237,12 +263,21 @@ func getInitListener(laddr string) (net.Listener, error) {
 
 // socket service
 func Serve(laddr string, handler func(net.Conn)) {
+    ServeTLS(laddr, handler, nil)
+}
+
+// socket service, tls_config is nil for plain tcp
+func ServeTLS(laddr string, handler func(net.Conn), tls_config *tls.Config) {
     l, err := getInitListener(laddr)
     if err != nil {
         log.Fatalf("start fail: %v", err)
     }
     theStoppable := newStoppable(l, laddr)
-    serve(theStoppable, handler)
+    if tls_config != nil {
+        serve(tls.NewListener(theStoppable, tls_config), handler)
+    } else {
+        serve(theStoppable, handler)
+    }
     log.Infof("%s wait all connection close...", laddr)
     theStoppable.wg.Wait()
     listenerWaitGroup.Done()
@@ -267,6 +302,11 @@ func serve(l net.Listener, handle func(net.Conn)) {
 
 // HTTP service
 func ListenAndServe(laddr string, handler http.Handler) {
+    ListenAndServeTLS(laddr, handler, nil)
+}
+
+// HTTP service, tls_config is nil for plain http
+func ListenAndServeTLS(laddr string, handler http.Handler, tls_config *tls.Config) {
     var err error
     var l net.Listener
     l, err = getInitListener(laddr)
@@ -274,9 +314,14 @@ func ListenAndServe(laddr string, handler http.Handler) {
         log.Fatalf("start fail: %v", err)
     }
     theStoppable := newStoppable(l, laddr)
-    log.Infof("Serving on http://%s/", laddr)
     server := &http.Server{Handler: handler}
-    err = server.Serve(theStoppable)
+    if tls_config != nil {
+        log.Infof("Serving on https://%s/", laddr)
+        err = server.Serve(tls.NewListener(theStoppable, tls_config))
+    } else {
+        log.Infof("Serving on http://%s/", laddr)
+        err = server.Serve(theStoppable)
+    }
     if err != nil {
         log.Info("ListenAndServe: ", err)
     }
@@ -293,6 +338,13 @@ func TCPService(laddr string, handler func(net.Conn)) {
     }()
 }
 
+// TLS service
+func TLSService(laddr string, handler func(net.Conn), tls_config *tls.Config) {
+    go func() {
+        ServeTLS(laddr, handler, tls_config)
+    }()
+}
+
 // HTTP service
 func HTTPService(laddr string, handler http.Handler) {
     go func() {
@@ -300,6 +352,13 @@ func HTTPService(laddr string, handler http.Handler) {
     }()
 }
 
+// HTTPS service
+func HTTPSService(laddr string, handler http.Handler, tls_config *tls.Config) {
+    go func() {
+        ListenAndServeTLS(laddr, handler, tls_config)
+    }()
+}
+
 // single HTTP service
 func SingleHTTPService(laddr string, handler http.Handler) {
     HTTPService(laddr, handler)
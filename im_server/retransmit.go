@@ -0,0 +1,101 @@
+/**
+ * Copyright (c) 2014-2015, GoBelieve
+ * All rights reserved.
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place, Suite 330, Boston, MA  02111-1307  USA
+ */
+
+
+package main
+
+import "time"
+import log "github.com/golang/glog"
+
+//等待客户端ack的消息
+type Retransmit struct {
+	emsg     *EMessage
+	first    time.Time
+	next     time.Time
+	attempts int
+}
+
+//第n次重发的等待时间为retransmit_interval*2^n
+func RetransmitBackoff(attempts int) time.Duration {
+	return time.Duration(config.retransmit_interval) * time.Second << uint(attempts)
+}
+
+func (client *Connection) addRetransmit(emsg *EMessage, seq int) {
+	now := time.Now()
+	r := &Retransmit{emsg:emsg, first:now, next:now.Add(RetransmitBackoff(0))}
+	client.retransmits[seq] = r
+}
+
+func (client *Connection) removeRetransmit(seq int) {
+	if r, ok := client.retransmits[seq]; ok {
+		metric_ack_latency.Observe(time.Since(r.first).Seconds())
+		delete(client.retransmits, seq)
+	}
+}
+
+//返回最早需要重发的时间, 没有等待ack的消息或者不重发时返回nil
+func (client *Connection) NextRetransmit() <-chan time.Time {
+	if config.retransmit_max <= 0 {
+		return nil
+	}
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	var next time.Time
+	for _, r := range client.retransmits {
+		if next.IsZero() || r.next.Before(next) {
+			next = r.next
+		}
+	}
+	if next.IsZero() {
+		return nil
+	}
+	return time.After(time.Until(next))
+}
+
+//在写协程中调用, 使用原来的seq重发, 超过重发次数返回false
+func (client *Connection) Retransmit() bool {
+	now := time.Now()
+	var msgs []*Message
+
+	client.mutex.Lock()
+	for seq, r := range client.retransmits {
+		if r.next.After(now) {
+			continue
+		}
+		if r.attempts >= config.retransmit_max {
+			client.mutex.Unlock()
+			log.Warningf("uid:%d msgid:%d seq:%d unacked after %d retransmits",
+				client.uid, r.emsg.msgid, seq, r.attempts)
+			metric_retransmit_timeouts.Inc()
+			return false
+		}
+		r.attempts++
+		r.next = now.Add(RetransmitBackoff(r.attempts))
+		msgs = append(msgs, &Message{r.emsg.msg.cmd, seq, client.version, r.emsg.msg.body})
+	}
+	client.mutex.Unlock()
+
+	for _, msg := range msgs {
+		log.Infof("uid:%d retransmit seq:%d msg:%s", client.uid, msg.seq, Command(msg.cmd))
+		client.send(msg)
+		metric_retransmits.Inc()
+	}
+	return true
+}
@@ -22,6 +22,7 @@ package main
 import log "github.com/golang/glog"
 import "unsafe"
 import "sync"
+import "time"
 
 type RoomClient struct {
 	*Connection
@@ -34,7 +35,7 @@ func (client *RoomClient) Logout(route *Route) {
 	defer client.room_mutex.Unlock()
 	
 	for room_id, _ := range client.room_ids {
-		OpRemoveRoomMember(room_id, client.uid)
+		OpRemoveRoomMember(client.appid, room_id, client.uid)
 		delete(client.room_ids, room_id)
 	}
 }
@@ -49,6 +50,8 @@ func (client *RoomClient) HandleMessage(msg *Message) {
 		client.HandleRoomIM(msg.body.(*RoomMessage), msg.seq)
 	case MSG_TRANSMIT_ROOM:
 		client.HandleTransmitRoom(msg.body.(*RoomMessage), msg.seq)
+	case MSG_ROOM_HISTORY_QUERY:
+		client.HandleRoomHistoryQuery(msg.body.(*RoomHistoryQuery))
 	}
 }
 
@@ -73,7 +76,33 @@ func (client *RoomClient) HandleEnterRoom(room *Room){
 
 	client.room_ids[room_id] = struct{}{}
 	
-	OpAddRoomMember(room_id, client.uid)
+	OpAddRoomMember(client.appid, room_id, client.uid)
+
+	//旧版本客户端不发送历史消息
+	if client.HasCapability(CAP_ROOM_HISTORY) && config.room_history_enter > 0 {
+		h := GetRoomHistory(client.appid, room_id, 0, config.room_history_enter)
+		if len(h.messages) > 0 {
+			client.wt <- &Message{cmd:MSG_ROOM_HISTORY, body:h}
+		}
+	}
+}
+
+func (client *RoomClient) HandleRoomHistoryQuery(q *RoomHistoryQuery) {
+	if client.uid == 0 {
+		log.Warning("client has't been authenticated")
+		return
+	}
+
+	client.room_mutex.Lock()
+	_, ok := client.room_ids[q.room_id]
+	client.room_mutex.Unlock()
+	if !ok {
+		log.Warningf("room id:%d is't client's room\n", q.room_id)
+		return
+	}
+
+	h := GetRoomHistory(client.appid, q.room_id, q.before_id, int(q.limit))
+	client.wt <- &Message{cmd:MSG_ROOM_HISTORY, body:h}
 }
 
 func (client *RoomClient) Client() *Client {
@@ -99,7 +128,7 @@ func (client *RoomClient) HandleLeaveRoom(room *Room) {
 		return
 	}
 	
-	OpRemoveRoomMember(room_id, client.uid)
+	OpRemoveRoomMember(client.appid, room_id, client.uid)
 	
 	delete(client.room_ids, room_id)
 }
@@ -115,13 +144,25 @@ func (client *RoomClient) HandleRoomIM(room_im *RoomMessage, seq int) {
 		return
 	}
 
+	content, status := InterceptMessage(client.appid, MSG_ROOM_IM, room_im.sender, room_id, room_im.content)
+	if status == ACK_REJECTED {
+		client.wt <- &Message{cmd: MSG_ACK, body: &MessageACK{int32(seq), status}}
+		return
+	}
+	room_im.content = content
+
+	SaveRoomHistory(client.appid, room_im)
+
 	m := &Message{cmd:MSG_ROOM_IM, body:room_im}
 
 	amsg := &AppMessage{appid:client.appid, receiver:room_id, msg:m}
 	channel := GetRouteChannel()
 	channel.PublishRoom(amsg)
 
-	client.wt <- &Message{cmd: MSG_ACK, body: &MessageACK{int32(seq)}}
+	client.wt <- &Message{cmd: MSG_ACK, body: &MessageACK{int32(seq), status}}
+
+	im := &IMMessage{sender:room_im.sender, receiver:room_id, timestamp:int32(time.Now().Unix()), content:room_im.content}
+	PostMessageEvent(client.appid, WEBHOOK_EVENT_ROOM_MESSAGE, im, 0)
 }
 
 func (client *RoomClient) HandleTransmitRoom(room_im *RoomMessage, seq int) {
@@ -141,5 +182,5 @@ func (client *RoomClient) HandleTransmitRoom(room_im *RoomMessage, seq int) {
 	channel := GetRouteChannel()
 	channel.PublishRoom(amsg)
 
-	client.wt <- &Message{cmd: MSG_ACK, body: &MessageACK{int32(seq)}}
+	client.wt <- &Message{cmd: MSG_ACK, body: &MessageACK{int32(seq), ACK_SUCCESS}}
 }
\ No newline at end of file
@@ -0,0 +1,118 @@
+/**
+ * Copyright (c) 2014-2015, GoBelieve
+ * All rights reserved.
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place, Suite 330, Boston, MA  02111-1307  USA
+ */
+
+
+package main
+
+import "fmt"
+import "time"
+import log "github.com/golang/glog"
+import "github.com/garyburd/redigo/redis"
+
+//聊天室消息保存在redis的有序集合中, 分值是消息id, 所有im服务器都可以读取
+//按照room_history_size条数和room_history_age时间保留
+
+func OpSaveRoomHistory(appid int64, room_id int64, sender int64, content string) {
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	id_key := fmt.Sprintf("room_history_id_%d_%d", appid, room_id)
+	id, err := redis.Int64(conn.Do("INCR", id_key))
+	if err != nil {
+		log.Infoln(err)
+		return
+	}
+
+	item := &RoomHistoryItem{id, int32(time.Now().Unix()), sender, content}
+	key := fmt.Sprintf("room_history_%d_%d", appid, room_id)
+	conn.Send("MULTI")
+	conn.Send("ZADD", key, id, item.ToData())
+	conn.Send("ZREMRANGEBYRANK", key, 0, -config.room_history_size - 1)
+	conn.Send("EXPIRE", key, config.room_history_age)
+	conn.Send("EXPIRE", id_key, config.room_history_age)
+	_, err = conn.Do("EXEC")
+	if err != nil {
+		log.Infoln(err)
+	}
+}
+
+//返回id小于before_id的最多limit条消息, 从新到旧
+func OpGetRoomHistory(appid int64, room_id int64, before_id int64, limit int) ([]*RoomHistoryItem, bool) {
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	max := "+inf"
+	if before_id > 0 {
+		max = fmt.Sprintf("(%d", before_id)
+	}
+	key := fmt.Sprintf("room_history_%d_%d", appid, room_id)
+	values, err := redis.ByteSlices(conn.Do("ZREVRANGEBYSCORE", key, max, "-inf", "LIMIT", 0, limit + 1))
+	if err != nil {
+		log.Infoln(err)
+		return nil, false
+	}
+
+	now := time.Now().Unix()
+	items := make([]*RoomHistoryItem, 0, len(values))
+	for _, v := range values {
+		item := &RoomHistoryItem{}
+		if !item.FromData(v) {
+			continue
+		}
+		//按照时间从新到旧, 后面的消息都已经过期
+		if now - int64(item.timestamp) > int64(config.room_history_age) {
+			break
+		}
+		items = append(items, item)
+	}
+
+	more := len(items) > limit
+	if more {
+		items = items[:limit]
+	}
+	return items, more
+}
+
+func IsRoomHistoryEnabled() bool {
+	return config.room_history_size > 0
+}
+
+func SaveRoomHistory(appid int64, room_im *RoomMessage) {
+	if !IsRoomHistoryEnabled() {
+		return
+	}
+	OpSaveRoomHistory(appid, room_im.receiver, room_im.sender, room_im.content)
+}
+
+//limit不超过保留的消息数
+func GetRoomHistory(appid int64, room_id int64, before_id int64, limit int) *RoomHistory {
+	h := &RoomHistory{room_id:room_id, messages:make([]*RoomHistoryItem, 0)}
+	if !IsRoomHistoryEnabled() || limit <= 0 {
+		return h
+	}
+	if limit > config.room_history_size {
+		limit = config.room_history_size
+	}
+	items, more := OpGetRoomHistory(appid, room_id, before_id, limit)
+	if items != nil {
+		h.messages = items
+		h.more = more
+	}
+	return h
+}
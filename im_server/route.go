@@ -39,25 +39,38 @@ func (set ClientSet) Clone() ClientSet {
 	return n
 }
 
+//不同app的uid可能相同
+type UserKey struct {
+	appid int64
+	uid   int64
+}
+
 type Route struct {
 	mutex   sync.Mutex
-	clients map[int64]ClientSet
+	clients map[UserKey]ClientSet
 	room_clients map[int64]ClientSet
+	//每个app的连接数
+	app_clients map[int64]int
 }
 
 func NewRoute() *Route {
 	route := new(Route)
-	route.clients = make(map[int64]ClientSet)
+	route.clients = make(map[UserKey]ClientSet)
+	route.app_clients = make(map[int64]int)
 	return route
 }
 
 func (route *Route) AddClient(client *Client) {
 	route.mutex.Lock()
 	defer route.mutex.Unlock()
-	set, ok := route.clients[client.uid]; 
+	key := UserKey{client.appid, client.uid}
+	set, ok := route.clients[key]; 
 	if !ok {
 		set = NewClientSet()
-		route.clients[client.uid] = set
+		route.clients[key] = set
+	}
+	if !set.IsMember(client) {
+		route.app_clients[client.appid]++
 	}
 	set.Add(client)
 }
@@ -65,10 +78,17 @@ func (route *Route) AddClient(client *Client) {
 func (route *Route) RemoveClient(client *Client) bool {
 	route.mutex.Lock()
 	defer route.mutex.Unlock()
-	if set, ok := route.clients[client.uid]; ok {
+	key := UserKey{client.appid, client.uid}
+	if set, ok := route.clients[key]; ok {
+		if set.IsMember(client) {
+			route.app_clients[client.appid]--
+			if route.app_clients[client.appid] == 0 {
+				delete(route.app_clients, client.appid)
+			}
+		}
 		set.Remove(client)
 		if set.Count() == 0 {
-			delete(route.clients, client.uid)
+			delete(route.clients, key)
 		}
 		return true
 	}
@@ -76,11 +96,11 @@ func (route *Route) RemoveClient(client *Client) bool {
 	return false
 }
 
-func (route *Route) FindClientSet(uid int64) ClientSet {
+func (route *Route) FindClientSet(appid int64, uid int64) ClientSet {
 	route.mutex.Lock()
 	defer route.mutex.Unlock()
 
-	set, ok := route.clients[uid]
+	set, ok := route.clients[UserKey{appid, uid}]
 	if ok {
 		return set.Clone()
 	} else {
@@ -88,14 +108,64 @@ func (route *Route) FindClientSet(uid int64) ClientSet {
 	}
 }
 
-func (route *Route) IsOnline(uid int64) bool {
+func (route *Route) IsOnline(appid int64, uid int64) bool {
 	route.mutex.Lock()
 	defer route.mutex.Unlock()
 
-	set, ok := route.clients[uid]
+	set, ok := route.clients[UserKey{appid, uid}]
 	if ok {
 		return len(set) > 0
 	}
 	return false
 }
 
+func (route *Route) AllClients() []*Client {
+	route.mutex.Lock()
+	defer route.mutex.Unlock()
+
+	clients := make([]*Client, 0, len(route.clients))
+	for _, set := range route.clients {
+		for c := range set {
+			clients = append(clients, c)
+		}
+	}
+	return clients
+}
+
+func (route *Route) Users() []UserKey {
+	route.mutex.Lock()
+	defer route.mutex.Unlock()
+
+	users := make([]UserKey, 0, len(route.clients))
+	for key := range route.clients {
+		users = append(users, key)
+	}
+	return users
+}
+
+//所有连接中最长的发送队列
+func (route *Route) MaxQueueDepth() (int, int) {
+	route.mutex.Lock()
+	defer route.mutex.Unlock()
+
+	max_wt, max_ewt := 0, 0
+	for _, set := range route.clients {
+		for c := range set {
+			wt, ewt := c.QueueDepth()
+			if wt > max_wt {
+				max_wt = wt
+			}
+			if ewt > max_ewt {
+				max_ewt = ewt
+			}
+		}
+	}
+	return max_wt, max_ewt
+}
+
+func (route *Route) AppClientCount(appid int64) int {
+	route.mutex.Lock()
+	defer route.mutex.Unlock()
+
+	return route.app_clients[appid]
+}
@@ -0,0 +1,450 @@
+/**
+ * Copyright (c) 2014-2015, GoBelieve
+ * All rights reserved.
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place, Suite 330, Boston, MA  02111-1307  USA
+ */
+
+
+package main
+
+import "fmt"
+import "sync"
+import "time"
+import "sync/atomic"
+import "github.com/garyburd/redigo/redis"
+import log "github.com/golang/glog"
+
+//断线后保留的会话, 宽限期内重连(可以是其它im服务器)时不需要重新认证和加载全部离线消息
+type Session struct {
+	appid       int64
+	uid         int64
+	device_id   string
+	device_ID   int64
+	platform_id int8
+	//有未ack消息或者挂起期间收到消息的群组
+	gids        []int64
+	//最后ack的点对点消息
+	last_msgid  int64
+
+	//认证token的摘要和过期时间, 第一次认证的时间
+	token_digest  string
+	token_expires int64
+	login_tm      int64
+}
+
+//本机上挂起的会话, 挂起期间客户端仍然留在route中, 用来记录收到消息的群组
+var sessions_mutex sync.Mutex
+var sessions = make(map[string]*Client)
+
+//挂起期间收到群组消息的会话, 由FlushSessionGroupsLoop写入redis
+//分发消息的协程不能访问redis
+var dirty_sessions = make(map[string]struct{})
+var session_flush_ch = make(chan struct{}, 1)
+
+func NewResumeToken() string {
+	return NewDeliveryID()
+}
+
+func (client *Client) IsSuspended() bool {
+	return atomic.LoadInt32(&client.suspended) != 0
+}
+
+//被踢或者消息被丢弃的连接不能恢复, 必须重新加载离线消息
+func (client *Connection) DisableResume() {
+	atomic.StoreInt32(&client.no_resume, 1)
+}
+
+//记录投递过存储消息的群组, 恢复会话时只需要加载这些群组的离线消息
+func (client *Connection) AddPendingGroup(gid int64) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	client.pending_groups[gid] = struct{}{}
+}
+
+func (client *Connection) SetLastAcked(msgid int64) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if msgid > client.last_acked_msgid {
+		client.last_acked_msgid = msgid
+	}
+}
+
+func (client *Connection) LastAcked() int64 {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.last_acked_msgid
+}
+
+func (client *Connection) PendingGroups() []int64 {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	gids := make([]int64, 0, len(client.pending_groups))
+	for gid := range client.pending_groups {
+		gids = append(gids, gid)
+	}
+	return gids
+}
+
+//连接断开时挂起会话, 返回false时按原来的流程清理
+func (client *Client) SuspendSession() bool {
+	if len(client.resume_token) == 0 || config.resume_grace <= 0 {
+		return false
+	}
+	if atomic.LoadInt32(&client.no_resume) != 0 {
+		return false
+	}
+
+	session := &Session{
+		appid:       client.appid,
+		uid:         client.uid,
+		device_id:   client.device_id,
+		device_ID:   client.device_ID,
+		platform_id: client.platform_id,
+		gids:        client.PendingGroups(),
+		last_msgid:  client.LastAcked(),
+
+		token_digest:  client.token_digest,
+		token_expires: client.token_expires,
+		login_tm:      client.login_tm,
+	}
+	if !OpSaveSession(client.resume_token, session) {
+		return false
+	}
+
+	token := client.resume_token
+	atomic.StoreInt32(&client.suspended, 1)
+	sessions_mutex.Lock()
+	sessions[token] = client
+	sessions_mutex.Unlock()
+	time.AfterFunc(time.Duration(config.resume_grace) * time.Second, func() {
+		ExpireSession(token)
+	})
+
+	log.Infof("suspend session uid:%d platform:%d device:%s", client.uid, client.platform_id, client.device_id)
+	metric_sessions.WithLabelValues("suspended").Inc()
+	return true
+}
+
+func TakeSession(token string) *Client {
+	sessions_mutex.Lock()
+	defer sessions_mutex.Unlock()
+	client, ok := sessions[token]
+	if !ok {
+		return nil
+	}
+	delete(sessions, token)
+	return client
+}
+
+//宽限期结束, 会话没有在其它连接上恢复时当作下线处理
+func ExpireSession(token string) {
+	client := TakeSession(token)
+	if client == nil {
+		return
+	}
+	resumed := OpIsSessionResumed(token)
+	if !resumed {
+		log.Infof("session expired uid:%d platform:%d", client.uid, client.platform_id)
+		metric_sessions.WithLabelValues("expired").Inc()
+	}
+	client.Cleanup(resumed)
+}
+
+//挂起期间分发给该连接的消息, 记录群组后丢弃
+func (client *Client) HandleSuspendedMessage(amsg *AppMessage) {
+	if amsg.msg.cmd == MSG_KICK {
+		if TakeSession(client.resume_token) != nil {
+			log.Infof("suspended session kicked uid:%d", client.uid)
+			go func() {
+				OpDeleteSession(client.resume_token)
+				client.Cleanup(false)
+			}()
+		}
+		return
+	}
+	if amsg.msgid > 0 && amsg.msg.cmd == MSG_GROUP_IM {
+		im := amsg.msg.body.(*IMMessage)
+		client.AddPendingGroup(im.receiver)
+
+		sessions_mutex.Lock()
+		dirty_sessions[client.resume_token] = struct{}{}
+		sessions_mutex.Unlock()
+		select {
+		case session_flush_ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+//在其它服务器上恢复时, 刚收到的群组可能还没有写入, 这些群组的消息在下次完整登录时加载
+func FlushSessionGroupsLoop() {
+	for range session_flush_ch {
+		sessions_mutex.Lock()
+		tokens := dirty_sessions
+		dirty_sessions = make(map[string]struct{})
+		clients := make(map[string]*Client)
+		for token := range tokens {
+			if client, ok := sessions[token]; ok {
+				clients[token] = client
+			}
+		}
+		sessions_mutex.Unlock()
+
+		for token, client := range clients {
+			OpAddSessionGroups(token, client.PendingGroups())
+		}
+	}
+}
+
+//恢复成功后不再走认证流程, 失败时返回false使用token重新认证
+func (client *Client) ResumeSession(login *AuthenticationToken, version int) bool {
+	if config.resume_grace <= 0 {
+		return false
+	}
+	session := OpClaimSession(login.resume_token, login.platform_id, login.device_id)
+	if session == nil {
+		log.Info("session can't be resumed")
+		metric_sessions.WithLabelValues("rejected").Inc()
+		return false
+	}
+
+	client.appid = session.appid
+	client.uid = session.uid
+	client.version = version
+	client.device_id = session.device_id
+	client.device_ID = session.device_ID
+	client.platform_id = session.platform_id
+	client.token_digest = session.token_digest
+	client.token_expires = session.token_expires
+	client.login_tm = session.login_tm
+	client.tm = time.Now()
+
+	//恢复的连接同样受app连接数的限制, 本机挂起的连接不重复计算
+	old := TakeSession(login.resume_token)
+	count := route.AppClientCount(client.appid)
+	if old != nil {
+		count--
+	}
+	if GetAppConfig(client.appid).IsConnectionFull(count) {
+		log.Warningf("appid:%d connections exceed quota, session can't be resumed", client.appid)
+		metric_sessions.WithLabelValues("rejected").Inc()
+		if old != nil {
+			OpDeleteSession(login.resume_token)
+			old.Cleanup(false)
+		} else {
+			//原来的服务器在宽限期结束后按下线处理
+			OpReleaseSession(login.resume_token)
+		}
+		client.appid, client.uid = 0, 0
+		client.wt <- &Message{cmd: MSG_AUTH_STATUS, version:version, body: &AuthenticationStatus{status:1}}
+		return true
+	}
+
+	client.NegotiateCapabilities(login)
+	if client.HasCapability(CAP_RESUME) {
+		client.resume_token = NewResumeToken()
+	}
+	log.Infof("resume session appid:%d uid:%d device id:%s:%d groups:%d",
+		client.appid, client.uid, client.device_id, client.device_ID, len(session.gids))
+
+	status := &AuthenticationStatus{0, client.public_ip, client.capabilities, client.max_frame_size, client.resume_token}
+	client.wt <- &Message{cmd: MSG_AUTH_STATUS, version:version, body: status}
+
+	//登录点没有删除, 只需要把本机加入用户所在的服务器
+	OpAddUserServer(client.appid, client.uid, server_id)
+	client.AddClient()
+	gids := session.gids
+	if old != nil {
+		//本机挂起的会话, 包括还没有写入redis的群组
+		gids = MergeGroups(gids, old.PendingGroups())
+		old.Cleanup(true)
+	}
+	//挂起期间其它设备可能已经登录
+	client.HandleLoginPolicy()
+
+	//只加载最后一次ack之后的消息, ack可能还没有写入storage
+	client.SetLastAcked(session.last_msgid)
+	client.IMClient.LoadOfflineAfter(session.last_msgid)
+	client.IMClient.LoadGroupsOffline(gids)
+	close(client.owt)
+
+	metric_sessions.WithLabelValues("resumed").Inc()
+	atomic.AddInt64(&server_summary.nclients, 1)
+	metric_connections.WithLabelValues(PlatformName(0)).Dec()
+	metric_connections.WithLabelValues(PlatformName(client.platform_id)).Inc()
+	metric_clients.WithLabelValues(PlatformName(client.platform_id)).Inc()
+	return true
+}
+
+func MergeGroups(a []int64, b []int64) []int64 {
+	set := make(map[int64]struct{})
+	gids := make([]int64, 0, len(a) + len(b))
+	for _, gid := range append(a, b...) {
+		if _, ok := set[gid]; !ok {
+			set[gid] = struct{}{}
+			gids = append(gids, gid)
+		}
+	}
+	return gids
+}
+
+func OpSaveSession(token string, session *Session) bool {
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	key := fmt.Sprintf("sessions_%s", token)
+	gkey := fmt.Sprintf("session_groups_%s", token)
+	conn.Send("MULTI")
+	conn.Send("HMSET", key, "appid", session.appid, "uid", session.uid, "device_id", session.device_id,
+		"device_ID", session.device_ID, "platform_id", session.platform_id,
+		"token_digest", session.token_digest, "token_expires", session.token_expires,
+		"login_tm", session.login_tm, "last_msgid", session.last_msgid)
+	conn.Send("EXPIRE", key, config.resume_grace)
+	for _, gid := range session.gids {
+		conn.Send("SADD", gkey, gid)
+	}
+	conn.Send("EXPIRE", gkey, config.resume_grace)
+	_, err := conn.Do("EXEC")
+	if err != nil {
+		log.Infoln(err)
+		return false
+	}
+	return true
+}
+
+func OpAddSessionGroups(token string, gids []int64) {
+	if len(gids) == 0 {
+		return
+	}
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	key := fmt.Sprintf("session_groups_%s", token)
+	conn.Send("MULTI")
+	for _, gid := range gids {
+		conn.Send("SADD", key, gid)
+	}
+	conn.Send("EXPIRE", key, config.resume_grace)
+	_, err := conn.Do("EXEC")
+	if err != nil {
+		log.Infoln(err)
+	}
+}
+
+//一个会话只能被同一设备恢复一次, 宽限期过后返回nil
+//认证的token过期, 被吊销或者超过resume_max_age时也不能恢复
+func OpClaimSession(token string, platform_id int8, device_id string) *Session {
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	key := fmt.Sprintf("sessions_%s", token)
+	reply, err := redis.Values(conn.Do("HMGET", key, "appid", "uid", "device_id", "device_ID", "platform_id",
+		"token_digest", "token_expires", "login_tm", "last_msgid"))
+	if err != nil {
+		log.Infoln(err)
+		return nil
+	}
+	if reply[0] == nil {
+		return nil
+	}
+
+	session := &Session{}
+	var platform int
+	_, err = redis.Scan(reply, &session.appid, &session.uid, &session.device_id, &session.device_ID, &platform,
+		&session.token_digest, &session.token_expires, &session.login_tm, &session.last_msgid)
+	if err != nil {
+		log.Infoln(err)
+		return nil
+	}
+	session.platform_id = int8(platform)
+	if session.platform_id != platform_id || session.device_id != device_id {
+		log.Warningf("session device mismatch uid:%d", session.uid)
+		return nil
+	}
+
+	now := time.Now().Unix()
+	if len(session.token_digest) == 0 || session.login_tm == 0 {
+		return nil
+	}
+	if session.token_expires > 0 && now >= session.token_expires {
+		log.Infof("session token expired uid:%d", session.uid)
+		return nil
+	}
+	if config.resume_max_age > 0 && now - session.login_tm >= int64(config.resume_max_age) {
+		log.Infof("session exceeds max age uid:%d", session.uid)
+		return nil
+	}
+	//redis出错时不恢复, 客户端使用token重新认证
+	revoked, err := redis.Bool(conn.Do("EXISTS", fmt.Sprintf("revoked_token_%s", session.token_digest)))
+	if err != nil || revoked {
+		log.Infof("session token revoked uid:%d", session.uid)
+		return nil
+	}
+
+	rkey := fmt.Sprintf("session_resumed_%s", token)
+	ok, err := redis.String(conn.Do("SET", rkey, server_id, "EX", config.resume_grace, "NX"))
+	if err != nil {
+		if err != redis.ErrNil {
+			log.Infoln(err)
+		}
+		return nil
+	}
+	if ok != "OK" {
+		return nil
+	}
+
+	gids, err := redis.Int64s(conn.Do("SMEMBERS", fmt.Sprintf("session_groups_%s", token)))
+	if err != nil {
+		log.Infoln(err)
+		return nil
+	}
+	session.gids = gids
+	return session
+}
+
+//恢复失败时释放, 原来的服务器不再认为会话已经恢复
+func OpReleaseSession(token string) {
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("DEL", fmt.Sprintf("session_resumed_%s", token))
+	if err != nil {
+		log.Infoln(err)
+	}
+}
+
+func OpIsSessionResumed(token string) bool {
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	key := fmt.Sprintf("session_resumed_%s", token)
+	exists, err := redis.Bool(conn.Do("EXISTS", key))
+	if err != nil {
+		log.Infoln(err)
+		return false
+	}
+	return exists
+}
+
+func OpDeleteSession(token string) {
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("DEL", fmt.Sprintf("sessions_%s", token), fmt.Sprintf("session_groups_%s", token))
+	if err != nil {
+		log.Infoln(err)
+	}
+}
@@ -45,7 +45,7 @@ func (s *SIOServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	s.server.ServeHTTP(w, req)
 }
 
-func StartSocketIO(socket_io_address string) {
+func StartSocketIO(socket_io_address string, tls_address string) {
 	server, err := engineio.NewServer(nil)
 	if err != nil {
 		log.Fatal(err)
@@ -63,6 +63,10 @@ func StartSocketIO(socket_io_address string) {
 
 	mux := http.NewServeMux()
 	mux.Handle("/engine.io/", &SIOServer{server})
+	if len(tls_address) > 0 {
+		log.Infof("EngineIO Serving at %s with tls...", tls_address)
+		HTTPSService(tls_address, mux, tls_certs.ServerConfig())
+	}
 	log.Infof("EngineIO Serving at %s...", socket_io_address)
 	HTTPService(socket_io_address, mux)
 
@@ -73,14 +77,13 @@ func handlerEngineIOClient(conn engineio.Conn) {
 	client.Run()
 }
 
-func SendEngineIOBinaryMessage(conn engineio.Conn, msg *Message) {
-	w, err := conn.NextWriter(engineio.MessageBinary)
+func SendEngineIOMessage(conn engineio.Conn, t engineio.MessageType, b []byte) {
+	w, err := conn.NextWriter(t)
 	if err != nil {
 		log.Info("get next writer fail")
 		return
 	}
-	log.Info("message version:", msg.version)
-	err = SendMessage(w, msg)
+	_, err = w.Write(b)
 	if err != nil {
 		log.Info("engine io write error")
 		return
@@ -88,21 +91,22 @@ func SendEngineIOBinaryMessage(conn engineio.Conn, msg *Message) {
 	w.Close()
 }
 
-func ReadEngineIOMessage(conn engineio.Conn) *Message {
+//文本消息使用json编码
+func ReadEngineIOMessage(conn engineio.Conn) (*Message, int) {
 	t, r, err := conn.NextReader()
 	if err != nil {
-		return nil
+		return nil, CODEC_BINARY
 	}
 	b, err := ioutil.ReadAll(r)
 	if err != nil {
 		log.Info("ReadEngineIOMessage error:", err)
-		return nil
+		return nil, CODEC_BINARY
 	}
 	r.Close()
 	if t == engineio.MessageText {
-		return nil
+		return DecodeJSONMessage(b), CODEC_JSON
 	} else {
-		return ReadBinaryMesage(b)
+		return ReadBinaryMesage(b), CODEC_BINARY
 	}
 }
 
@@ -51,7 +51,7 @@ func (sc *StorageChannel) Register() {
 	sc.wt <- m
 }
 
-func (sc *StorageChannel) RunOnce(conn *net.TCPConn) {
+func (sc *StorageChannel) RunOnce(conn net.Conn) {
 	defer conn.Close()
 
 	closed_ch := make(chan bool)
@@ -59,7 +59,7 @@ func (sc *StorageChannel) RunOnce(conn *net.TCPConn) {
 
 	go func() {
 		for {
-			msg := ReceiveMessage(conn)
+			msg := ReceiveServerMessage(conn)
 			if msg == nil {
 				close(closed_ch)
 				return
@@ -91,7 +91,7 @@ func (sc *StorageChannel) RunOnce(conn *net.TCPConn) {
 func (sc *StorageChannel) Run() {
 	nsleep := 100
 	for {
-		conn, err := net.Dial("tcp", sc.addr)
+		conn, err := DialCluster(sc.addr)
 		if err != nil {
 			mutex.Lock()
 			_, ok := storage_channels_map[sc.addr]
@@ -109,12 +109,9 @@ func (sc *StorageChannel) Run() {
 			time.Sleep(time.Duration(nsleep) * time.Millisecond)
 			continue
 		}
-		tconn := conn.(*net.TCPConn)
-		tconn.SetKeepAlive(true)
-		tconn.SetKeepAlivePeriod(time.Duration(10 * 60 * time.Second))
 		log.Info("storage channel connected")
 		nsleep = 100
-		sc.RunOnce(tconn)
+		sc.RunOnce(conn)
 	}
 }
 
@@ -30,6 +30,7 @@ import log "github.com/golang/glog"
 
 type StorageConn struct {
 	conn net.Conn
+	addr string
 	e    bool
 }
 
@@ -39,12 +40,13 @@ func NewStorageConn() *StorageConn {
 }
 
 func (client *StorageConn) Dial(addr string) error {
-	conn, err := net.Dial("tcp", addr)
+	conn, err := DialCluster(addr)
 	if err != nil {
 		client.e = true
 		return err
 	}
 	client.conn = conn
+	client.addr = addr
 	return nil
 }
 
@@ -67,8 +69,15 @@ func (client *StorageConn) SaveAndEnqueueMessage(sae *SAEMessage) (int64, error)
 }
 
 func (client *StorageConn) saveAndEnqueueMessage(msg *Message) (int64, error) {
+	begin := time.Now()
+	msgid, err := client.saveAndEnqueue(msg)
+	ObserveStorageRPC(msg.cmd, client.addr, begin, err)
+	return msgid, err
+}
+
+func (client *StorageConn) saveAndEnqueue(msg *Message) (int64, error) {
 	SendMessage(client.conn, msg)
-	r := ReceiveMessage(client.conn)
+	r := ReceiveServerMessage(client.conn)
 	if r == nil {
 		client.e = true
 		return 0, errors.New("error connection")
@@ -92,29 +101,23 @@ func (client *StorageConn) saveAndEnqueueMessage(msg *Message) (int64, error) {
 
 func (client *StorageConn) DequeueGroupMessage(dq *DQGroupMessage) error {
 	msg := &Message{cmd:MSG_DEQUEUE_GROUP, body:dq}
-
-	SendMessage(client.conn, msg)
-	r := ReceiveMessage(client.conn)
-	if r == nil {
-		client.e = true
-		return errors.New("error connection")
-	}
-	if r.cmd != MSG_RESULT {
-		return errors.New("error cmd")
-	}
-	result := r.body.(*MessageResult)
-	if result.status != 0 {
-		return errors.New("error status")
-	}
-
-	return nil
+	begin := time.Now()
+	err := client.dequeue(msg)
+	ObserveStorageRPC(msg.cmd, client.addr, begin, err)
+	return err
 }
 
 func (client *StorageConn) DequeueMessage(dq *DQMessage) error {
 	msg := &Message{cmd:MSG_DEQUEUE, body:dq}
+	begin := time.Now()
+	err := client.dequeue(msg)
+	ObserveStorageRPC(msg.cmd, client.addr, begin, err)
+	return err
+}
 
+func (client *StorageConn) dequeue(msg *Message) error {
 	SendMessage(client.conn, msg)
-	r := ReceiveMessage(client.conn)
+	r := ReceiveServerMessage(client.conn)
 	if r == nil {
 		client.e = true
 		return errors.New("error connection")
@@ -138,7 +141,7 @@ func (client *StorageConn) ReadEMessage(buf []byte) *EMessage {
 	buffer := bytes.NewBuffer(buf)
 	binary.Read(buffer, binary.BigEndian, &emsg.msgid)
 	binary.Read(buffer, binary.BigEndian, &emsg.device_id)
-	emsg.msg = ReceiveMessage(buffer)
+	emsg.msg = ReceiveServerMessage(buffer)
 	if emsg.msg == nil {
 		return nil
 	}
@@ -146,7 +149,7 @@ func (client *StorageConn) ReadEMessage(buf []byte) *EMessage {
 }
 
 func (client *StorageConn) ReceiveMessages() ([]*EMessage, error) {
-	r := ReceiveMessage(client.conn)
+	r := ReceiveServerMessage(client.conn)
 	if r == nil {
 		client.e = true
 		return nil, errors.New("error connection")
@@ -160,21 +163,24 @@ func (client *StorageConn) ReceiveMessages() ([]*EMessage, error) {
 	}
 
 	buffer := bytes.NewBuffer(result.content)
-	if buffer.Len() < 2 {
+	if buffer.Len() < 4 {
 		return nil, errors.New("error length")
 	}
 
-	var count int16
+	var count int32
 	binary.Read(buffer, binary.BigEndian, &count)
+	if count < 0 {
+		return nil, errors.New("error count")
+	}
 	
 	messages := make([]*EMessage, count)
 	for i := 0; i < int(count); i++ {
-		var size int16
+		var size int32
 		err := binary.Read(buffer, binary.BigEndian, &size)
 		if err != nil {
 			return nil, err
 		}
-		if buffer.Len() < int(size) {
+		if size < 0 || buffer.Len() < int(size) {
 			return nil, errors.New("error size")
 		}
 		msg_buf := make([]byte, size)
@@ -188,15 +194,21 @@ func (client *StorageConn) ReceiveMessages() ([]*EMessage, error) {
 func (client *StorageConn) LoadGroupOfflineMessage(appid int64, gid int64, uid int64, device_id int64)([]*EMessage, error) {
 	id := &LoadGroupOffline{appid:appid, uid:uid, gid:gid, device_id:device_id}
 	msg := &Message{cmd:MSG_LOAD_GROUP_OFFLINE, body:id}
+	begin := time.Now()
 	SendMessage(client.conn, msg)
-	return client.ReceiveMessages()
+	messages, err := client.ReceiveMessages()
+	ObserveStorageRPC(msg.cmd, client.addr, begin, err)
+	return messages, err
 }
 
-func (client *StorageConn) LoadOfflineMessage(appid int64, uid int64, device_id int64) ([]*EMessage, error) {
-	id := &LoadOffline{appid:appid, uid:uid, device_id:device_id}
+func (client *StorageConn) LoadOfflineMessage(appid int64, uid int64, device_id int64, last_msgid int64) ([]*EMessage, error) {
+	id := &LoadOffline{appid:appid, uid:uid, device_id:device_id, last_msgid:last_msgid}
 	msg := &Message{cmd:MSG_LOAD_OFFLINE, body:id}
+	begin := time.Now()
 	SendMessage(client.conn, msg)
-	return client.ReceiveMessages()
+	messages, err := client.ReceiveMessages()
+	ObserveStorageRPC(msg.cmd, client.addr, begin, err)
+	return messages, err
 }
 
 func (client *StorageConn) LoadLatestMessage(appid int64, uid int64, limit int32) ([]*EMessage, error) {
@@ -206,8 +218,11 @@ func (client *StorageConn) LoadLatestMessage(appid int64, uid int64, limit int32
 	lh.app_uid.uid = uid
 
 	msg := &Message{cmd:MSG_LOAD_HISTORY, body:lh}
+	begin := time.Now()
 	SendMessage(client.conn, msg)
-	return client.ReceiveMessages()
+	messages, err := client.ReceiveMessages()
+	ObserveStorageRPC(msg.cmd, client.addr, begin, err)
+	return messages, err
 }
 
 var nowFunc = time.Now // for testing
@@ -319,6 +334,12 @@ func (p *StorageConnPool) Get() (*StorageConn, error) {
 	return c, err
 }
 
+func (p *StorageConnPool) Stats() (int, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.active, p.idle.Len()
+}
+
 func (p *StorageConnPool) Release(c *StorageConn) {
 	defer func() {
 		p.sem <- 0
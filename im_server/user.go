@@ -23,19 +23,18 @@ import "fmt"
 import "time"
 import log "github.com/golang/glog"
 import "github.com/garyburd/redigo/redis"
-import "errors"
 import "database/sql"
 import _ "github.com/go-sql-driver/mysql"
 import "encoding/json"
 import "im_service/common"
 import "strconv"
 
-func OpGetUserGroups(uid int64) []int64 {
+func OpGetUserGroups(appid int64, uid int64) []int64 {
 	conn := redis_pool.Get()
 	defer conn.Close()
 	
 	gids := make([]int64, 0, 4)
-	key := fmt.Sprintf("user_groups_%d", uid)
+	key := fmt.Sprintf("user_groups_%d_%d", appid, uid)
 	groups, err := redis.Ints(conn.Do("SMEMBERS", key))
 	if err != nil {
 		return gids
@@ -48,21 +47,21 @@ func OpGetUserGroups(uid int64) []int64 {
 	return gids
 }
 
-func OpRemoveUserFriend(db *sql.DB, uid int64, fid int64) bool {
+func OpRemoveUserFriend(db *sql.DB, appid int64, uid int64, fid int64) bool {
 	conn := redis_pool.Get()
 	defer conn.Close()
 	
-	if !FriendRemove(db, uid, fid) {
+	if !FriendRemove(db, appid, uid, fid) {
 		return false
 	}
 	
-	key := fmt.Sprintf("user_friends_%d", uid)
+	key := fmt.Sprintf("user_friends_%d_%d", appid, uid)
 	_, err := conn.Do("SREM", key, fid)
 	if err != nil {
 		log.Infoln(err)
 	}
 	
-	key = fmt.Sprintf("user_friends_%d", fid)
+	key = fmt.Sprintf("user_friends_%d_%d", appid, fid)
 	_, err = conn.Do("SREM", key, uid)
 	if err != nil {
 		log.Infoln(err)
@@ -71,21 +70,21 @@ func OpRemoveUserFriend(db *sql.DB, uid int64, fid int64) bool {
 	return true
 }
 
-func OpAddUserFriend(db *sql.DB, uid int64, fid int64) bool {
+func OpAddUserFriend(db *sql.DB, appid int64, uid int64, fid int64) bool {
 	conn := redis_pool.Get()
 	defer conn.Close()
 	
-	if !FriendAdd(db, uid, fid) {
+	if !FriendAdd(db, appid, uid, fid) {
 		return false
 	}
 	
-	key := fmt.Sprintf("user_friends_%d", uid)
+	key := fmt.Sprintf("user_friends_%d_%d", appid, uid)
 	_, err := conn.Do("SADD", key, fid)
 	if err != nil {
 		log.Infoln(err)
 	}
 	
-	key = fmt.Sprintf("user_friends_%d", fid)
+	key = fmt.Sprintf("user_friends_%d_%d", appid, fid)
 	_, err = conn.Do("SADD", key, uid)
 	if err != nil {
 		log.Infoln(err)
@@ -94,15 +93,15 @@ func OpAddUserFriend(db *sql.DB, uid int64, fid int64) bool {
 	return true
 }
 
-func OpAddUserBlack(db *sql.DB, uid int64, bid int64) bool {
+func OpAddUserBlack(db *sql.DB, appid int64, uid int64, bid int64) bool {
 	conn := redis_pool.Get()
 	defer conn.Close()
 	
-	if !BlackAdd(db, uid, bid) {
+	if !BlackAdd(db, appid, uid, bid) {
 		return false
 	}
 	
-	key := fmt.Sprintf("user_blacks_%d", uid)
+	key := fmt.Sprintf("user_blacks_%d_%d", appid, uid)
 	_, err := conn.Do("SADD", key, bid)
 	if err != nil {
 		log.Infoln(err)
@@ -111,15 +110,15 @@ func OpAddUserBlack(db *sql.DB, uid int64, bid int64) bool {
 	return true
 }
 
-func OpRemoveUserBlack(db *sql.DB, uid int64, bid int64) bool {
+func OpRemoveUserBlack(db *sql.DB, appid int64, uid int64, bid int64) bool {
 	conn := redis_pool.Get()
 	defer conn.Close()
 	
-	if !BlackRemove(db, uid, bid) {
+	if !BlackRemove(db, appid, uid, bid) {
 		return false
 	}
 	
-	key := fmt.Sprintf("user_blacks_%d", uid)
+	key := fmt.Sprintf("user_blacks_%d_%d", appid, uid)
 	_, err := conn.Do("SREM", key, bid)
 	if err != nil {
 		log.Infoln(err)
@@ -128,11 +127,11 @@ func OpRemoveUserBlack(db *sql.DB, uid int64, bid int64) bool {
 	return true
 }
 
-func OpIsUserBlack(uid int64, bid int64) bool {
+func OpIsUserBlack(appid int64, uid int64, bid int64) bool {
 	conn := redis_pool.Get()
 	defer conn.Close()
 	
-	key := fmt.Sprintf("user_blacks_%d", uid)
+	key := fmt.Sprintf("user_blacks_%d_%d", appid, uid)
 	isBlack, err := redis.Bool(conn.Do("SISMEMBER", key, bid))
 	if err != nil {
 		log.Infoln(err)
@@ -142,11 +141,11 @@ func OpIsUserBlack(uid int64, bid int64) bool {
 	return isBlack
 }
 
-func OpIsUserFriend(uid int64, fid int64) bool {
+func OpIsUserFriend(appid int64, uid int64, fid int64) bool {
 	conn := redis_pool.Get()
 	defer conn.Close()
 	
-	key := fmt.Sprintf("user_friends_%d", uid)
+	key := fmt.Sprintf("user_friends_%d_%d", appid, uid)
 	isFriend, err := redis.Bool(conn.Do("SISMEMBER", key, fid))
 	if err != nil {
 		log.Infoln(err)
@@ -156,11 +155,11 @@ func OpIsUserFriend(uid int64, fid int64) bool {
 	return isFriend
 }
 
-func OpAddUserLoginPoint(uid int64, platform_id int8, device_id string) {
+func OpAddUserLoginPoint(appid int64, uid int64, platform_id int8, device_id string) {
 	conn := redis_pool.Get()
 	defer conn.Close()
 	
-	key := fmt.Sprintf("user_loginpoints_%d", uid)
+	key := fmt.Sprintf("user_loginpoints_%d_%d", appid, uid)
 	v := fmt.Sprintf("%d_%s", platform_id, device_id)
 	_, err := conn.Do("SADD", key, v)
 	if err != nil {
@@ -168,11 +167,11 @@ func OpAddUserLoginPoint(uid int64, platform_id int8, device_id string) {
 	}
 }
 
-func OpRemoveUserLoginPoint(uid int64, platform_id int8, device_id string) {
+func OpRemoveUserLoginPoint(appid int64, uid int64, platform_id int8, device_id string) {
 	conn := redis_pool.Get()
 	defer conn.Close()
 	
-	key := fmt.Sprintf("user_loginpoints_%d", uid)
+	key := fmt.Sprintf("user_loginpoints_%d_%d", appid, uid)
 	v := fmt.Sprintf("%d_%s", platform_id, device_id)
 	_, err := conn.Do("SREM", key, v)
 	if err != nil {
@@ -180,92 +179,231 @@ func OpRemoveUserLoginPoint(uid int64, platform_id int8, device_id string) {
 	}
 }
 
-func OpAddUserServer(uid int64, serverId string) {
+//im_server_users_记录服务器上的用户, 服务器崩溃后用来清理user_servers_
+func OpAddUserServer(appid int64, uid int64, serverId string) {
 	conn := redis_pool.Get()
 	defer conn.Close()
 	
-	key := fmt.Sprintf("user_servers_%d", uid)
-	_, err := conn.Do("SADD", key, serverId)
+	key := fmt.Sprintf("user_servers_%d_%d", appid, uid)
+	users_key := fmt.Sprintf("im_server_users_%s", serverId)
+	conn.Send("MULTI")
+	conn.Send("SADD", key, serverId)
+	conn.Send("SADD", users_key, fmt.Sprintf("%d_%d", appid, uid))
+	_, err := conn.Do("EXEC")
 	if err != nil {
 		log.Infoln(err)
 	}
 }
 
-func OpRemoveUserServer(uid int64, serverId string) {
+func OpRemoveUserServer(appid int64, uid int64, serverId string) {
 	conn := redis_pool.Get()
 	defer conn.Close()
 	
-	key := fmt.Sprintf("user_servers_%d", uid)
-	_, err := conn.Do("SREM", key, serverId)
+	key := fmt.Sprintf("user_servers_%d_%d", appid, uid)
+	users_key := fmt.Sprintf("im_server_users_%s", serverId)
+	conn.Send("MULTI")
+	conn.Send("SREM", key, serverId)
+	conn.Send("SREM", users_key, fmt.Sprintf("%d_%d", appid, uid))
+	_, err := conn.Do("EXEC")
 	if err != nil {
 		log.Infoln(err)
 	}
 }
 
-func OpLoadUserAccessToken(token string) (int64, int64, string, error) {
+//用户是否有在线的客户端(任意一台租约有效的im服务器)
+func OpIsUserOnline(appid int64, uid int64) bool {
 	conn := redis_pool.Get()
 	defer conn.Close()
+	
+	key := fmt.Sprintf("user_servers_%d_%d", appid, uid)
+	servers, err := redis.Strings(conn.Do("SMEMBERS", key))
+	if err != nil {
+		log.Infoln(err)
+		return false
+	}
+	if len(servers) == 0 {
+		return false
+	}
+
+	conn.Send("SMEMBERS", IM_SERVER_LEASES_KEY)
+	for _, id := range servers {
+		conn.Send("EXISTS", fmt.Sprintf("im_server_lease_%s", id))
+	}
+	err = conn.Flush()
+	if err != nil {
+		log.Infoln(err)
+		return false
+	}
+	leased, err := redis.Strings(conn.Receive())
+	if err != nil {
+		log.Infoln(err)
+		return false
+	}
+	leased_set := make(map[string]bool)
+	for _, id := range leased {
+		leased_set[id] = true
+	}
+
+	online := false
+	for _, id := range servers {
+		exists, err := redis.Bool(conn.Receive())
+		if err != nil {
+			log.Infoln(err)
+			continue
+		}
+		//没有持有过租约的旧版本服务器视为在线
+		if exists || !leased_set[id] {
+			online = true
+		}
+	}
+	return online
+}
+
+//离线推送使用的设备token, route_server读取
+//客户端认证时登录点带上的token, 或者app服务器通过接口绑定的token
+//登录点在连接断开时删除, 所以token按登录点保存在push_tokens_<appid>_<uid>, field为<platform_id>_<device_id>
+func OpBindDeviceToken(appid int64, uid int64, platform_id int8, device_id string, provider string, token string) bool {
+	conn := redis_pool.Get()
+	defer conn.Close()
+	
+	obj := make(map[string]interface{})
+	obj["provider"] = provider
+	obj["token"] = token
+	v, err := json.Marshal(obj)
+	if err != nil {
+		log.Infoln(err)
+		return false
+	}
+	
+	key := fmt.Sprintf("push_tokens_%d_%d", appid, uid)
+	field := fmt.Sprintf("%d_%s", platform_id, device_id)
+	_, err = conn.Do("HSET", key, field, v)
+	if err != nil {
+		log.Infoln(err)
+		return false
+	}
+	return true
+}
 
-	key := fmt.Sprintf("access_token_%s", token)
+func OpUnbindDeviceToken(appid int64, uid int64, platform_id int8, device_id string) bool {
+	conn := redis_pool.Get()
+	defer conn.Close()
+	
+	key := fmt.Sprintf("push_tokens_%d_%d", appid, uid)
+	field := fmt.Sprintf("%d_%s", platform_id, device_id)
+	_, err := conn.Do("HDEL", key, field)
+	if err != nil {
+		log.Infoln(err)
+		return false
+	}
+	return true
+}
+
+//客户端提供appid时使用access_token_<appid>_<token>, 否则使用旧的access_token_<token>
+//token所属的app由hash中的app_id决定, 和客户端提供的appid不一致时拒绝
+//hash字段expires为空时token不过期
+func OpLoadUserAccessToken(hint_appid int64, token string) (*AuthInfo, error) {
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	var key string
+	if hint_appid > 0 {
+		key = fmt.Sprintf("access_token_%d_%s", hint_appid, token)
+	} else {
+		key = fmt.Sprintf("access_token_%s", token)
+	}
 	var uid int64
 	var appid int64
 	var uname string
+	var expires int64
 
 	exists, err := redis.Bool(conn.Do("EXISTS", key))
 	if err != nil {
-		return 0, 0, "", err
+		return nil, err
 	}
 	if !exists {
-		appid, uid, uname, err = LoadUserInfoByAccessToken(token)
+		appid, uid, uname, err = LoadUserInfoByAccessToken(hint_appid, token)
+		if err == nil && hint_appid > 0 && appid != hint_appid {
+			return nil, ErrInvalidToken
+		}
 		if err == nil {
 			conn.Do("HMSET", key, "user_id", uid, "app_id", appid, "user_name", uname)
-			return appid, uid, uname, nil
+			return &AuthInfo{appid, uid, uname, 0}, nil
+		}
+		if err == sql.ErrNoRows {
+			return nil, ErrUnknownToken
 		}
-		return 0, 0, "", errors.New("token non exists")
+		return nil, err
 	}
 
-	reply, err := redis.Values(conn.Do("HMGET", key, "user_id", "app_id", "user_name"))
+	reply, err := redis.Values(conn.Do("HMGET", key, "user_id", "app_id", "user_name", "expires"))
 	if err != nil {
 		log.Info("hmget error:", err)
-		return 0, 0, "", err
+		return nil, err
 	}
 
-	_, err = redis.Scan(reply, &uid, &appid, &uname)
+	_, err = redis.Scan(reply[:3], &uid, &appid, &uname)
 	if err != nil {
 		log.Warning("scan error:", err)
-		return 0, 0, "", err
+		return nil, err
 	}
-	return appid, uid, uname, nil	
+	if hint_appid > 0 && appid != hint_appid {
+		return nil, ErrInvalidToken
+	}
+	if reply[3] != nil {
+		expires, err = redis.Int64(reply[3], nil)
+		if err != nil {
+			log.Warning("invalid expires:", err)
+			return nil, err
+		}
+	}
+	return &AuthInfo{appid, uid, uname, expires}, nil
 }
 
-func LoadUserInfoByAccessToken(token string) (int64, int64, string, error) {
+//appid为0时token必须只属于一个app
+func LoadUserInfoByAccessToken(appid int64, token string) (int64, int64, string, error) {
 	db, err := sql.Open("mysql", config.mysqldb_appdatasource)
 	if err != nil {
 		log.Info("error:", err)
 		return 0, 0, "", err
 	}
 	defer db.Close()
-	
-	stmt, err := db.Prepare("SELECT id, username FROM user_app WHERE access_token=?")
+
+	var rows *sql.Rows
+	if appid > 0 {
+		rows, err = db.Query("SELECT appid, id, username FROM user_app WHERE appid=? AND access_token=?", appid, token)
+	} else {
+		rows, err = db.Query("SELECT appid, id, username FROM user_app WHERE access_token=? LIMIT 2", token)
+	}
 	if err != nil {
 		log.Info("error:", err)
 		return 0, 0, "", err
 	}
+	defer rows.Close()
 
-	defer stmt.Close()
-	
 	var id int64
 	var uname string
-	err = stmt.QueryRow(token).Scan(&id, &uname)
-	if err != nil {
-		return 0, 0, "", err
+	count := 0
+	for rows.Next() {
+		err = rows.Scan(&appid, &id, &uname)
+		if err != nil {
+			log.Info("error:", err)
+			return 0, 0, "", err
+		}
+		count++
 	}
-	
-	return 1, id, uname, nil
+	if count == 0 {
+		return 0, 0, "", sql.ErrNoRows
+	}
+	if count > 1 {
+		log.Warning("access token belongs to multiple apps")
+		return 0, 0, "", ErrInvalidToken
+	}
+	return appid, id, uname, nil
 }
 
-func OpHasUserInfoById(db *sql.DB, id int64) bool {	
-	stmt, err := db.Prepare("SELECT id, username FROM user_app WHERE id=?")
+func OpHasUserInfoById(db *sql.DB, appid int64, id int64) bool {	
+	stmt, err := db.Prepare("SELECT id, username FROM user_app WHERE appid=? AND id=?")
 	if err != nil {
 		log.Info("error:", err)
 		return false
@@ -275,7 +413,7 @@ func OpHasUserInfoById(db *sql.DB, id int64) bool {
 	
 	var uid int64
 	var uname string
-	err = stmt.QueryRow(id).Scan(&uid, &uname)
+	err = stmt.QueryRow(appid, id).Scan(&uid, &uname)
 	if err != nil {
 		return false
 	}
@@ -284,25 +422,32 @@ func OpHasUserInfoById(db *sql.DB, id int64) bool {
 }
 
 
+//mysql中的用户按appid区分
+type AppUser struct {
+	appid int64
+	uid   int64
+}
+
 func OpLoadAllFriends(db *sql.DB) {
 	//加载用户好友列表
-	friends := make(map[int64]common.IntSet)
+	friends := make(map[AppUser]common.IntSet)
 	
 	i := 0
 	for ; i < 10; i++ {
-		sql := fmt.Sprintf("SELECT user_id, friend_id FROM user_friends_0%d", i)
+		sql := fmt.Sprintf("SELECT appid, user_id, friend_id FROM user_friends_0%d", i)
 		stmt, err := db.Prepare(sql)
 		if err == nil {
 			rows, _ := stmt.Query()
 			for rows.Next() {
-				var uid, fid int64
-				rows.Scan(&uid, &fid)
-				log.Infof("load friend from db: uid=%d, fid=%d", uid, fid)
-				if _, ok := friends[uid]; !ok {
-					friends[uid] = common.NewIntSet()
+				var appid, uid, fid int64
+				rows.Scan(&appid, &uid, &fid)
+				log.Infof("load friend from db: appid=%d uid=%d, fid=%d", appid, uid, fid)
+				u := AppUser{appid, uid}
+				if _, ok := friends[u]; !ok {
+					friends[u] = common.NewIntSet()
 				}
 				
-				friends[uid].Add(fid)
+				friends[u].Add(fid)
 			}
 		} else {
 			return 
@@ -312,8 +457,8 @@ func OpLoadAllFriends(db *sql.DB) {
 	conn := redis_pool.Get()
 	defer conn.Close()
 	
-	for uid, fids := range friends {
-		key := fmt.Sprintf("user_friends_%d", uid)
+	for u, fids := range friends {
+		key := fmt.Sprintf("user_friends_%d_%d", u.appid, u.uid)
 		b, err := redis.Bool(conn.Do("EXISTS", key))
 		if err != nil {
 			log.Infoln(err)
@@ -335,31 +480,33 @@ func OpLoadAllFriends(db *sql.DB) {
 
 func OpLoadAllBlacks(db *sql.DB) {
 	//加载用户黑名单列表
-	blacks := make(map[int64]common.IntSet)
+	blacks := make(map[AppUser]common.IntSet)
 	
-	stmt, err := db.Prepare("SELECT user_id, blacks FROM user_blacks")
+	stmt, err := db.Prepare("SELECT appid, user_id, blacks FROM user_blacks")
 	if err != nil {
 		return
 	}
 	
 	rows, err := stmt.Query()
 	for rows.Next() {
+		var appid int64
 		var uid int64
 		var blacksStr string
 		
-		err = rows.Scan(&uid, &blacksStr)
+		err = rows.Scan(&appid, &uid, &blacksStr)
 		if err == nil {
 			var black_ids []string
 			err = json.Unmarshal([]byte(blacksStr), &black_ids)
 			
 			if err == nil {
+				u := AppUser{appid, uid}
 				for _, b := range black_ids {
 					bid, _ := strconv.ParseInt(b, 10, 64)
-					if _, ok := blacks[uid]; !ok {
-						blacks[uid] = common.NewIntSet()
+					if _, ok := blacks[u]; !ok {
+						blacks[u] = common.NewIntSet()
 					}
-					log.Infof("load black from db: uid=%d, bid=%d", uid, bid)
-					blacks[uid].Add(bid)
+					log.Infof("load black from db: appid=%d uid=%d, bid=%d", appid, uid, bid)
+					blacks[u].Add(bid)
 				}
 			} else {
 				log.Errorf("1 load black error: %s", err)
@@ -372,8 +519,8 @@ func OpLoadAllBlacks(db *sql.DB) {
 	conn := redis_pool.Get()
 	defer conn.Close()
 	
-	for uid, bids := range blacks {
-		key := fmt.Sprintf("user_blacks_%d", uid)
+	for u, bids := range blacks {
+		key := fmt.Sprintf("user_blacks_%d_%d", u.appid, u.uid)
 		b, err := redis.Bool(conn.Do("EXISTS", key))
 		if err != nil {
 			log.Infoln(err)
@@ -393,7 +540,7 @@ func OpLoadAllBlacks(db *sql.DB) {
 	}
 }
 
-func FriendAdd(db *sql.DB, uid int64, fid int64) bool {
+func FriendAdd(db *sql.DB, appid int64, uid int64, fid int64) bool {
 	var stmt1, stmt2 *sql.Stmt
 
 	tx, err := db.Begin()
@@ -402,8 +549,8 @@ func FriendAdd(db *sql.DB, uid int64, fid int64) bool {
 		return false
 	}
 
-	sql := fmt.Sprintf("INSERT INTO `user_friends_0%d` ( `user_id`, `friend_id`, `create_time`) select %d, %d, %d from dual where not exists(select * from user_friends_0%d where user_id=%d and friend_id=%d)",
-			uid % 10, uid, fid, time.Now().Unix(), uid % 10, uid, fid)
+	sql := fmt.Sprintf("INSERT INTO `user_friends_0%d` ( `appid`, `user_id`, `friend_id`, `create_time`) select %d, %d, %d, %d from dual where not exists(select * from user_friends_0%d where appid=%d and user_id=%d and friend_id=%d)",
+			uid % 10, appid, uid, fid, time.Now().Unix(), uid % 10, appid, uid, fid)
 	stmt1, err = tx.Prepare(sql)
 	if err != nil {
 		log.Info("error:", err)
@@ -416,8 +563,8 @@ func FriendAdd(db *sql.DB, uid int64, fid int64) bool {
 		goto ROLLBACK
 	}
 
-	sql = fmt.Sprintf("INSERT INTO `user_friends_0%d` ( `user_id`, `friend_id`, `create_time`) select %d, %d, %d from dual where not exists(select * from user_friends_0%d where user_id=%d and friend_id=%d)",
-			fid % 10, fid, uid, time.Now().Unix(), fid % 10, fid, uid)
+	sql = fmt.Sprintf("INSERT INTO `user_friends_0%d` ( `appid`, `user_id`, `friend_id`, `create_time`) select %d, %d, %d, %d from dual where not exists(select * from user_friends_0%d where appid=%d and user_id=%d and friend_id=%d)",
+			fid % 10, appid, fid, uid, time.Now().Unix(), fid % 10, appid, fid, uid)
 	stmt2, err = tx.Prepare(sql)
 	if err != nil {
 		log.Info("error:", err)
@@ -438,7 +585,7 @@ ROLLBACK:
 	return false
 }
 
-func FriendRemove(db *sql.DB, uid int64, fid int64) bool {
+func FriendRemove(db *sql.DB, appid int64, uid int64, fid int64) bool {
 	var stmt1, stmt2 *sql.Stmt
 
 	tx, err := db.Begin()
@@ -447,27 +594,27 @@ func FriendRemove(db *sql.DB, uid int64, fid int64) bool {
 		return false
 	}
 
-	sql := fmt.Sprintf("delete from user_friends_0%d where user_id=? and friend_id=?", uid % 10);
+	sql := fmt.Sprintf("delete from user_friends_0%d where appid=? and user_id=? and friend_id=?", uid % 10);
 	stmt1, err = tx.Prepare(sql)
 	if err != nil {
 		log.Info("error:", err)
 		goto ROLLBACK
 	}
 	defer stmt1.Close()
-	_, err = stmt1.Exec(uid, fid)
+	_, err = stmt1.Exec(appid, uid, fid)
 	if err != nil {
 		log.Info("error:", err)
 		goto ROLLBACK
 	}
 
-	sql = fmt.Sprintf("delete from user_friends_0%d where user_id=? and friend_id=?", uid % 10);
+	sql = fmt.Sprintf("delete from user_friends_0%d where appid=? and user_id=? and friend_id=?", fid % 10);
 	stmt2, err = tx.Prepare(sql)
 	if err != nil {
 		log.Info("error:", err)
 		goto ROLLBACK
 	}
 	defer stmt2.Close()
-	_, err = stmt2.Exec(fid, uid)
+	_, err = stmt2.Exec(appid, fid, uid)
 	if err != nil {
 		log.Info("error:", err)
 		goto ROLLBACK
@@ -481,8 +628,8 @@ ROLLBACK:
 	return false
 }
 
-func BlackAdd(db *sql.DB, uid int64, bid int64) bool {
-	stmt, err := db.Prepare("SELECT blacks FROM user_blacks WHERE user_id=?")
+func BlackAdd(db *sql.DB, appid int64, uid int64, bid int64) bool {
+	stmt, err := db.Prepare("SELECT blacks FROM user_blacks WHERE appid=? AND user_id=?")
 	if err != nil {
 		return false
 	}
@@ -491,7 +638,7 @@ func BlackAdd(db *sql.DB, uid int64, bid int64) bool {
 	insert := 0;
 	blacks := make([]string, 0, 4)
 	var blacksStr string
-	err = stmt.QueryRow(uid).Scan(&blacksStr)
+	err = stmt.QueryRow(appid, uid).Scan(&blacksStr)
 	if err == sql.ErrNoRows {
 		insert = 1;
 		blacks = append(blacks, strconv.FormatInt(bid, 10))
@@ -522,31 +669,31 @@ func BlackAdd(db *sql.DB, uid int64, bid int64) bool {
 	blacksStr = string(bs)
 	
 	if insert == 1 {
-		stmt, err := db.Prepare("INSERT INTO user_blacks (user_id, blacks, update_time) VALUES (?, ?, ?)")
+		stmt, err := db.Prepare("INSERT INTO user_blacks (appid, user_id, blacks, update_time) VALUES (?, ?, ?, ?)")
 		if err != nil {
 			return false
 		}
-		stmt.Exec(uid, blacksStr, time.Now().Unix())
+		stmt.Exec(appid, uid, blacksStr, time.Now().Unix())
 	} else {
-		stmt, err := db.Prepare("UPDATE user_blacks SET blacks=?, update_time=? WHERE user_id=?")
+		stmt, err := db.Prepare("UPDATE user_blacks SET blacks=?, update_time=? WHERE appid=? AND user_id=?")
 		if err != nil {
 			return false
 		}
-		stmt.Exec(blacksStr, time.Now().Unix(), uid)
+		stmt.Exec(blacksStr, time.Now().Unix(), appid, uid)
 	}
 	
 	return true
 }
 
-func BlackRemove(db *sql.DB, uid int64, bid int64) bool {
-	stmt, err := db.Prepare("SELECT blacks FROM user_blacks WHERE user_id=?")
+func BlackRemove(db *sql.DB, appid int64, uid int64, bid int64) bool {
+	stmt, err := db.Prepare("SELECT blacks FROM user_blacks WHERE appid=? AND user_id=?")
 	if err != nil {
 		return false
 	}
 	defer stmt.Close()
 	
 	var blacksStr string
-	err = stmt.QueryRow(uid).Scan(&blacksStr)
+	err = stmt.QueryRow(appid, uid).Scan(&blacksStr)
 	if err == sql.ErrNoRows {
 		return true
 	} else if err != nil {
@@ -574,11 +721,11 @@ func BlackRemove(db *sql.DB, uid int64, bid int64) bool {
 	
 	blacksStr = string(bs)
 	
-	stmt, err = db.Prepare("UPDATE user_blacks SET blacks=?, update_time=? WHERE user_id=?")
+	stmt, err = db.Prepare("UPDATE user_blacks SET blacks=?, update_time=? WHERE appid=? AND user_id=?")
 	if err != nil {
 		return false
 	}
-	stmt.Exec(blacksStr, time.Now().Unix(), uid)
+	stmt.Exec(blacksStr, time.Now().Unix(), appid, uid)
 	
 	return true
 }
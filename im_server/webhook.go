@@ -0,0 +1,488 @@
+/**
+ * Copyright (c) 2014-2015, GoBelieve
+ * All rights reserved.
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place, Suite 330, Boston, MA  02111-1307  USA
+ */
+
+package main
+
+import "fmt"
+import "bytes"
+import "sync"
+import "time"
+import "strings"
+import "net/http"
+import "crypto/hmac"
+import "crypto/rand"
+import "crypto/sha256"
+import "encoding/hex"
+import "encoding/json"
+import log "github.com/golang/glog"
+import "github.com/garyburd/redigo/redis"
+
+const WEBHOOK_EVENT_PEER_MESSAGE = "message.peer"
+const WEBHOOK_EVENT_GROUP_MESSAGE = "message.group"
+const WEBHOOK_EVENT_ROOM_MESSAGE = "message.room"
+const WEBHOOK_EVENT_FRIEND_ADD = "friend.add"
+const WEBHOOK_EVENT_FRIEND_REMOVE = "friend.remove"
+const WEBHOOK_EVENT_GROUP_CREATE = "group.create"
+const WEBHOOK_EVENT_GROUP_JOIN = "group.join"
+const WEBHOOK_EVENT_GROUP_LEAVE = "group.leave"
+const WEBHOOK_EVENT_GROUP_DISSOLVE = "group.dissolve"
+const WEBHOOK_EVENT_USER_ONLINE = "user.online"
+const WEBHOOK_EVENT_USER_OFFLINE = "user.offline"
+
+//待发送的事件队列, 所有im服务器共用
+const WEBHOOK_QUEUE = "webhook_queue"
+//等待重试的事件, score为下次发送的时间
+const WEBHOOK_RETRY = "webhook_retry"
+//超过重试次数的事件
+const WEBHOOK_DEAD = "webhook_dead"
+const WEBHOOK_DEAD_LIMIT = 10000
+
+//app配置缓存时间
+const WEBHOOK_CACHE_TIMEOUT = 60
+
+const WEBHOOK_TIMEOUT = 5 * time.Second
+
+//redis写入失败时本地暂存的事件数, 满了之后丢弃
+const WEBHOOK_SPILL_LIMIT = 100000
+
+type Webhook struct {
+	url    string
+	secret string
+	events map[string]struct{}  //为空时发送所有事件
+
+	tm     time.Time
+}
+
+func (hook *Webhook) Accept(event string) bool {
+	if len(hook.url) == 0 {
+		return false
+	}
+	if len(hook.events) == 0 {
+		return true
+	}
+	_, ok := hook.events[event]
+	return ok
+}
+
+//队列中的一次投递
+type WebhookDelivery struct {
+	ID       string                 `json:"id"`
+	AppID    int64                  `json:"appid"`
+	Event    string                 `json:"event"`
+	Time     int64                  `json:"timestamp"`
+	Data     map[string]interface{} `json:"data"`
+	Attempts int                    `json:"attempts"`
+}
+
+var webhook_mutex sync.Mutex
+var webhooks map[int64]*Webhook
+
+var webhook_client = &http.Client{Timeout: WEBHOOK_TIMEOUT}
+
+var webhook_spill_mutex sync.Mutex
+var webhook_spill [][]byte
+
+func init() {
+	webhooks = make(map[int64]*Webhook)
+}
+
+func OpSetWebhook(appid int64, url string, secret string, events []string) bool {
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	key := fmt.Sprintf("app_webhook_%d", appid)
+	_, err := conn.Do("HMSET", key, "url", url, "secret", secret, "events", strings.Join(events, ","))
+	if err != nil {
+		log.Infoln(err)
+		return false
+	}
+
+	webhook_mutex.Lock()
+	delete(webhooks, appid)
+	webhook_mutex.Unlock()
+	return true
+}
+
+func OpDelWebhook(appid int64) bool {
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	key := fmt.Sprintf("app_webhook_%d", appid)
+	_, err := conn.Do("DEL", key)
+	if err != nil {
+		log.Infoln(err)
+		return false
+	}
+
+	webhook_mutex.Lock()
+	delete(webhooks, appid)
+	webhook_mutex.Unlock()
+	return true
+}
+
+func LoadWebhook(appid int64) *Webhook {
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	key := fmt.Sprintf("app_webhook_%d", appid)
+	reply, err := redis.Values(conn.Do("HMGET", key, "url", "secret", "events"))
+	if err != nil {
+		log.Info("hmget error:", err)
+		return nil
+	}
+
+	var url, secret, events string
+	_, err = redis.Scan(reply, &url, &secret, &events)
+	if err != nil {
+		log.Warning("scan error:", err)
+		return nil
+	}
+
+	hook := &Webhook{url:url, secret:secret, tm:time.Now()}
+	hook.events = make(map[string]struct{})
+	for _, e := range strings.Split(events, ",") {
+		if len(e) > 0 {
+			hook.events[e] = struct{}{}
+		}
+	}
+	return hook
+}
+
+//app的webhook配置, 本地缓存一分钟
+func GetWebhook(appid int64) *Webhook {
+	webhook_mutex.Lock()
+	hook, ok := webhooks[appid]
+	webhook_mutex.Unlock()
+	if ok && time.Since(hook.tm) < WEBHOOK_CACHE_TIMEOUT * time.Second {
+		return hook
+	}
+
+	hook = LoadWebhook(appid)
+	if hook == nil {
+		return nil
+	}
+	webhook_mutex.Lock()
+	webhooks[appid] = hook
+	webhook_mutex.Unlock()
+	return hook
+}
+
+func NewDeliveryID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+//写入redis队列, 由PushWebhookLoop投递
+func PostWebhookEvent(appid int64, event string, data map[string]interface{}) {
+	//读取配置失败时仍然写入队列, 投递时再检查
+	hook := GetWebhook(appid)
+	if hook != nil && !hook.Accept(event) {
+		return
+	}
+
+	d := &WebhookDelivery{
+		ID: NewDeliveryID(),
+		AppID: appid,
+		Event: event,
+		Time: time.Now().Unix(),
+		Data: data,
+	}
+	b, err := json.Marshal(d)
+	if err != nil {
+		log.Info("json marshal:", err)
+		metric_webhook_dropped.WithLabelValues("marshal").Inc()
+		return
+	}
+
+	if !OpPushWebhook(b) {
+		SpillWebhook(b)
+	}
+}
+
+func OpPushWebhook(b []byte) bool {
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("LPUSH", WEBHOOK_QUEUE, b)
+	if err != nil {
+		log.Info("lpush webhook error:", err)
+		return false
+	}
+	return true
+}
+
+//redis出错时暂存在本地, 由RetryWebhookLoop重新写入
+func SpillWebhook(b []byte) {
+	webhook_spill_mutex.Lock()
+	defer webhook_spill_mutex.Unlock()
+	if len(webhook_spill) >= WEBHOOK_SPILL_LIMIT {
+		log.Warning("webhook spill full, drop event:", string(b))
+		metric_webhook_dropped.WithLabelValues("spill_full").Inc()
+		return
+	}
+	webhook_spill = append(webhook_spill, b)
+}
+
+func FlushWebhookSpill(conn redis.Conn) {
+	webhook_spill_mutex.Lock()
+	spill := webhook_spill
+	webhook_spill = nil
+	webhook_spill_mutex.Unlock()
+
+	for i, b := range spill {
+		_, err := conn.Do("LPUSH", WEBHOOK_QUEUE, b)
+		if err != nil {
+			log.Info("lpush webhook error:", err)
+			for _, b := range spill[i:] {
+				SpillWebhook(b)
+			}
+			return
+		}
+	}
+	if len(spill) > 0 {
+		log.Infof("flush %d spilled webhook events", len(spill))
+	}
+}
+
+func SignWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func SendWebhook(hook *Webhook, d *WebhookDelivery) error {
+	obj := make(map[string]interface{})
+	obj["id"] = d.ID
+	obj["appid"] = d.AppID
+	obj["event"] = d.Event
+	obj["timestamp"] = d.Time
+	obj["data"] = d.Data
+	body, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", hook.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	ts := fmt.Sprintf("%d", time.Now().Unix())
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-IM-Event", d.Event)
+	req.Header.Set("X-IM-Delivery", d.ID)
+	req.Header.Set("X-IM-Timestamp", ts)
+	req.Header.Set("X-IM-Signature", SignWebhook(hook.secret, ts, body))
+
+	resp, err := webhook_client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook status:%d", resp.StatusCode)
+	}
+	return nil
+}
+
+//1s, 2s, 4s..., 最长10分钟
+func WebhookBackoff(attempts int) time.Duration {
+	if attempts > 10 {
+		return 10 * time.Minute
+	}
+	d := time.Duration(1 << uint(attempts - 1)) * time.Second
+	if d > 10 * time.Minute {
+		d = 10 * time.Minute
+	}
+	return d
+}
+
+func DeliverWebhook(conn redis.Conn, b []byte, d *WebhookDelivery) {
+	hook := GetWebhook(d.AppID)
+	if hook == nil {
+		//读取配置失败, 稍后重新投递
+		SpillWebhook(b)
+		return
+	}
+	if !hook.Accept(d.Event) {
+		log.Infof("webhook of app:%d removed, skip event:%s", d.AppID, d.Event)
+		return
+	}
+
+	d.Attempts++
+	err := SendWebhook(hook, d)
+	if err == nil {
+		log.Infof("webhook appid:%d event:%s id:%s delivered", d.AppID, d.Event, d.ID)
+		return
+	}
+
+	b, e := json.Marshal(d)
+	if e != nil {
+		log.Info("json marshal:", e)
+		metric_webhook_dropped.WithLabelValues("marshal").Inc()
+		return
+	}
+
+	if d.Attempts >= config.webhook_max_retries {
+		log.Warningf("webhook appid:%d event:%s id:%s dead after %d attempts:%s", d.AppID, d.Event, d.ID, d.Attempts, err)
+		metric_webhook_dropped.WithLabelValues("dead").Inc()
+		conn.Send("LPUSH", WEBHOOK_DEAD, b)
+		conn.Send("LTRIM", WEBHOOK_DEAD, 0, WEBHOOK_DEAD_LIMIT - 1)
+		_, e = conn.Do("")
+	} else {
+		next := time.Now().Add(WebhookBackoff(d.Attempts))
+		log.Infof("webhook appid:%d event:%s id:%s error:%s, retry at %s", d.AppID, d.Event, d.ID, err, next)
+		_, e = conn.Do("ZADD", WEBHOOK_RETRY, next.Unix(), b)
+	}
+	if e != nil {
+		log.Info("redis error:", e)
+		SpillWebhook(b)
+	}
+}
+
+//每台服务器一个处理中队列, 重启后放回待发送队列
+func WebhookProcessingQueue() string {
+	return fmt.Sprintf("webhook_processing_%s", server_id)
+}
+
+func PushWebhookLoop() {
+	processing := WebhookProcessingQueue()
+	for {
+		conn := redis_pool.Get()
+		b, err := redis.Bytes(conn.Do("BRPOPLPUSH", WEBHOOK_QUEUE, processing, 5))
+		if err == redis.ErrNil {
+			conn.Close()
+			continue
+		}
+		if err != nil {
+			log.Info("brpoplpush error:", err)
+			conn.Close()
+			time.Sleep(time.Second)
+			continue
+		}
+
+		d := &WebhookDelivery{}
+		err = json.Unmarshal(b, d)
+		if err != nil {
+			log.Warning("invalid webhook delivery:", string(b))
+		} else {
+			DeliverWebhook(conn, b, d)
+		}
+
+		_, err = conn.Do("LREM", processing, 1, b)
+		if err != nil {
+			log.Info("lrem error:", err)
+		}
+		conn.Close()
+	}
+}
+
+//到期的重试事件放回待发送队列
+func RetryWebhookLoop() {
+	for {
+		time.Sleep(time.Second)
+
+		conn := redis_pool.Get()
+		now := time.Now().Unix()
+		items, err := redis.Values(conn.Do("ZRANGEBYSCORE", WEBHOOK_RETRY, "-inf", now, "LIMIT", 0, 100))
+		if err != nil {
+			log.Info("zrangebyscore error:", err)
+			conn.Close()
+			continue
+		}
+		for _, item := range items {
+			//多台服务器同时处理时, 只有ZREM成功的一台放回队列
+			n, err := redis.Int(conn.Do("ZREM", WEBHOOK_RETRY, item))
+			if err != nil || n == 0 {
+				continue
+			}
+			_, err = conn.Do("LPUSH", WEBHOOK_QUEUE, item)
+			if err != nil {
+				log.Info("lpush webhook error:", err)
+				if b, ok := item.([]byte); ok {
+					SpillWebhook(b)
+				}
+			}
+		}
+		FlushWebhookSpill(conn)
+		conn.Close()
+	}
+}
+
+func RecoverWebhookQueue() {
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	processing := WebhookProcessingQueue()
+	for {
+		_, err := redis.Bytes(conn.Do("RPOPLPUSH", processing, WEBHOOK_QUEUE))
+		if err != nil {
+			if err != redis.ErrNil {
+				log.Info("rpoplpush error:", err)
+			}
+			break
+		}
+	}
+}
+
+func StartWebhook() {
+	RecoverWebhookQueue()
+	for i := 0; i < config.webhook_workers; i++ {
+		go PushWebhookLoop()
+	}
+	go RetryWebhookLoop()
+}
+
+func PostMessageEvent(appid int64, event string, im *IMMessage, msgid int64) {
+	data := make(map[string]interface{})
+	data["sender"] = im.sender
+	data["receiver"] = im.receiver
+	data["msgid"] = msgid
+	data["timestamp"] = im.timestamp
+	data["content"] = im.content
+	PostWebhookEvent(appid, event, data)
+}
+
+func PostFriendEvent(appid int64, event string, uid int64, friend_uid int64) {
+	data := make(map[string]interface{})
+	data["uid"] = uid
+	data["friend_uid"] = friend_uid
+	PostWebhookEvent(appid, event, data)
+}
+
+//operator为执行操作的用户
+func PostGroupEvent(appid int64, event string, gid int64, operator int64, members []int64) {
+	data := make(map[string]interface{})
+	data["group_id"] = gid
+	data["operator"] = operator
+	if members != nil {
+		data["members"] = members
+	}
+	PostWebhookEvent(appid, event, data)
+}
+
+func PostUserEvent(appid int64, event string, uid int64, platform_id int8, device_id string) {
+	data := make(map[string]interface{})
+	data["uid"] = uid
+	data["platform_id"] = platform_id
+	data["device_id"] = device_id
+	PostWebhookEvent(appid, event, data)
+}
@@ -0,0 +1,138 @@
+/**
+ * Copyright (c) 2014-2015, GoBelieve
+ * All rights reserved.
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place, Suite 330, Boston, MA  02111-1307  USA
+ */
+
+package main
+
+import "time"
+import "net/http"
+import "net/url"
+import "strings"
+import log "github.com/golang/glog"
+import "github.com/gorilla/websocket"
+
+//服务端发送ping的间隔, 客户端超时时间同tcp连接
+const WS_PING_PERIOD = 60 * time.Second
+const WS_WRITE_TIMEOUT = 10 * time.Second
+
+//一个websocket二进制消息对应一个完整的消息帧, 包括16字节的头部
+//文本消息是一个json对象
+const WS_MAX_MESSAGE_SIZE = JSON_MAX_LINE
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     CheckWebSocketOrigin,
+}
+
+//ws_allowed_origins为空时只允许同一个host的页面, 否则只允许列表中的host
+func CheckWebSocketOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if len(origin) == 0 {
+		//浏览器总是带上Origin, 没有Origin的是非浏览器客户端
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if len(config.ws_allowed_origins) == 0 {
+		if strings.EqualFold(u.Host, req.Host) {
+			return true
+		}
+		log.Infof("websocket origin:%s host:%s not allowed", origin, req.Host)
+		return false
+	}
+	for _, o := range config.ws_allowed_origins {
+		if strings.EqualFold(o, u.Host) || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	log.Infof("websocket origin:%s not allowed", origin)
+	return false
+}
+
+func ServeWebSocket(w http.ResponseWriter, req *http.Request) {
+	conn, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		log.Info("websocket upgrade error:", err)
+		return
+	}
+	conn.SetReadLimit(WS_MAX_MESSAGE_SIZE)
+
+	client := NewClient(conn)
+	//pong在读协程中处理, 收到pong说明连接仍然可用
+	//认证之前不延长, 否则只回复pong的连接永远不会超时
+	conn.SetPongHandler(func(string) error {
+		if client.uid == 0 {
+			return nil
+		}
+		client.deadline = time.Now().Add(client.IdleTimeout())
+		conn.SetReadDeadline(client.deadline)
+		return nil
+	})
+	client.Run()
+	go WebSocketPingLoop(conn)
+}
+
+//WriteControl可以和其它写操作并发调用, 连接关闭后写失败退出
+func WebSocketPingLoop(conn *websocket.Conn) {
+	ticker := time.NewTicker(WS_PING_PERIOD)
+	defer ticker.Stop()
+	for range ticker.C {
+		err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(WS_WRITE_TIMEOUT))
+		if err != nil {
+			return
+		}
+	}
+}
+
+func StartWebSocket(address string, tls_address string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", ServeWebSocket)
+	if len(tls_address) > 0 {
+		log.Infof("WebSocket Serving at %s with tls...", tls_address)
+		HTTPSService(tls_address, mux, tls_certs.ServerConfig())
+	}
+	if len(address) > 0 {
+		log.Infof("WebSocket Serving at %s...", address)
+		HTTPService(address, mux)
+	}
+}
+
+func SendWebSocketMessage(conn *websocket.Conn, t int, b []byte) {
+	conn.SetWriteDeadline(time.Now().Add(WS_WRITE_TIMEOUT))
+	err := conn.WriteMessage(t, b)
+	if err != nil {
+		log.Info("websocket write error:", err)
+	}
+}
+
+//文本消息使用json编码
+func ReadWebSocketMessage(conn *websocket.Conn, deadline time.Time) (*Message, int) {
+	conn.SetReadDeadline(deadline)
+	t, b, err := conn.ReadMessage()
+	if err != nil {
+		log.Info("websocket read error:", err)
+		return nil, CODEC_BINARY
+	}
+	if t == websocket.TextMessage {
+		return DecodeJSONMessage(b), CODEC_JSON
+	}
+	return ReadBinaryMesage(b), CODEC_BINARY
+}
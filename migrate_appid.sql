@@ -0,0 +1,80 @@
+#已有数据库增加appid列, 原有数据归属到一个app
+#执行前把@default_appid改为原有app的appid
+use im;
+
+SET @default_appid = 0;
+
+ALTER TABLE `group` ADD COLUMN appid BIGINT NOT NULL DEFAULT 0 AFTER id, ADD KEY(appid);
+UPDATE `group` SET appid=@default_appid;
+
+ALTER TABLE user_app ADD COLUMN appid BIGINT NOT NULL DEFAULT 0 AFTER id;
+UPDATE user_app SET appid=@default_appid;
+ALTER TABLE user_app ADD KEY(appid, id);
+
+ALTER TABLE user_blacks ADD COLUMN appid BIGINT NOT NULL DEFAULT 0 FIRST;
+UPDATE user_blacks SET appid=@default_appid;
+#user_blacks原来以user_id为主键, 不同app的同一uid会冲突
+ALTER TABLE user_blacks DROP PRIMARY KEY, ADD PRIMARY KEY(appid, user_id);
+
+ALTER TABLE group_members_00 ADD COLUMN appid BIGINT NOT NULL DEFAULT 0 FIRST;
+UPDATE group_members_00 SET appid=@default_appid;
+ALTER TABLE group_members_01 ADD COLUMN appid BIGINT NOT NULL DEFAULT 0 FIRST;
+UPDATE group_members_01 SET appid=@default_appid;
+ALTER TABLE group_members_02 ADD COLUMN appid BIGINT NOT NULL DEFAULT 0 FIRST;
+UPDATE group_members_02 SET appid=@default_appid;
+ALTER TABLE group_members_03 ADD COLUMN appid BIGINT NOT NULL DEFAULT 0 FIRST;
+UPDATE group_members_03 SET appid=@default_appid;
+ALTER TABLE group_members_04 ADD COLUMN appid BIGINT NOT NULL DEFAULT 0 FIRST;
+UPDATE group_members_04 SET appid=@default_appid;
+ALTER TABLE group_members_05 ADD COLUMN appid BIGINT NOT NULL DEFAULT 0 FIRST;
+UPDATE group_members_05 SET appid=@default_appid;
+ALTER TABLE group_members_06 ADD COLUMN appid BIGINT NOT NULL DEFAULT 0 FIRST;
+UPDATE group_members_06 SET appid=@default_appid;
+ALTER TABLE group_members_07 ADD COLUMN appid BIGINT NOT NULL DEFAULT 0 FIRST;
+UPDATE group_members_07 SET appid=@default_appid;
+ALTER TABLE group_members_08 ADD COLUMN appid BIGINT NOT NULL DEFAULT 0 FIRST;
+UPDATE group_members_08 SET appid=@default_appid;
+ALTER TABLE group_members_09 ADD COLUMN appid BIGINT NOT NULL DEFAULT 0 FIRST;
+UPDATE group_members_09 SET appid=@default_appid;
+
+ALTER TABLE user_groups_00 ADD COLUMN appid BIGINT NOT NULL DEFAULT 0 FIRST;
+UPDATE user_groups_00 SET appid=@default_appid;
+ALTER TABLE user_groups_01 ADD COLUMN appid BIGINT NOT NULL DEFAULT 0 FIRST;
+UPDATE user_groups_01 SET appid=@default_appid;
+ALTER TABLE user_groups_02 ADD COLUMN appid BIGINT NOT NULL DEFAULT 0 FIRST;
+UPDATE user_groups_02 SET appid=@default_appid;
+ALTER TABLE user_groups_03 ADD COLUMN appid BIGINT NOT NULL DEFAULT 0 FIRST;
+UPDATE user_groups_03 SET appid=@default_appid;
+ALTER TABLE user_groups_04 ADD COLUMN appid BIGINT NOT NULL DEFAULT 0 FIRST;
+UPDATE user_groups_04 SET appid=@default_appid;
+ALTER TABLE user_groups_05 ADD COLUMN appid BIGINT NOT NULL DEFAULT 0 FIRST;
+UPDATE user_groups_05 SET appid=@default_appid;
+ALTER TABLE user_groups_06 ADD COLUMN appid BIGINT NOT NULL DEFAULT 0 FIRST;
+UPDATE user_groups_06 SET appid=@default_appid;
+ALTER TABLE user_groups_07 ADD COLUMN appid BIGINT NOT NULL DEFAULT 0 FIRST;
+UPDATE user_groups_07 SET appid=@default_appid;
+ALTER TABLE user_groups_08 ADD COLUMN appid BIGINT NOT NULL DEFAULT 0 FIRST;
+UPDATE user_groups_08 SET appid=@default_appid;
+ALTER TABLE user_groups_09 ADD COLUMN appid BIGINT NOT NULL DEFAULT 0 FIRST;
+UPDATE user_groups_09 SET appid=@default_appid;
+
+ALTER TABLE user_friends_00 ADD COLUMN appid BIGINT NOT NULL DEFAULT 0 FIRST;
+UPDATE user_friends_00 SET appid=@default_appid;
+ALTER TABLE user_friends_01 ADD COLUMN appid BIGINT NOT NULL DEFAULT 0 FIRST;
+UPDATE user_friends_01 SET appid=@default_appid;
+ALTER TABLE user_friends_02 ADD COLUMN appid BIGINT NOT NULL DEFAULT 0 FIRST;
+UPDATE user_friends_02 SET appid=@default_appid;
+ALTER TABLE user_friends_03 ADD COLUMN appid BIGINT NOT NULL DEFAULT 0 FIRST;
+UPDATE user_friends_03 SET appid=@default_appid;
+ALTER TABLE user_friends_04 ADD COLUMN appid BIGINT NOT NULL DEFAULT 0 FIRST;
+UPDATE user_friends_04 SET appid=@default_appid;
+ALTER TABLE user_friends_05 ADD COLUMN appid BIGINT NOT NULL DEFAULT 0 FIRST;
+UPDATE user_friends_05 SET appid=@default_appid;
+ALTER TABLE user_friends_06 ADD COLUMN appid BIGINT NOT NULL DEFAULT 0 FIRST;
+UPDATE user_friends_06 SET appid=@default_appid;
+ALTER TABLE user_friends_07 ADD COLUMN appid BIGINT NOT NULL DEFAULT 0 FIRST;
+UPDATE user_friends_07 SET appid=@default_appid;
+ALTER TABLE user_friends_08 ADD COLUMN appid BIGINT NOT NULL DEFAULT 0 FIRST;
+UPDATE user_friends_08 SET appid=@default_appid;
+ALTER TABLE user_friends_09 ADD COLUMN appid BIGINT NOT NULL DEFAULT 0 FIRST;
+UPDATE user_friends_09 SET appid=@default_appid;
@@ -0,0 +1,310 @@
+/**
+ * Copyright (c) 2014-2015, GoBelieve
+ * All rights reserved.
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place, Suite 330, Boston, MA  02111-1307  USA
+ */
+
+
+package main
+
+import "fmt"
+import "net"
+import "sync"
+import "time"
+import "crypto/tls"
+import log "github.com/golang/glog"
+import "github.com/garyburd/redigo/redis"
+import "im_service/common"
+
+//route节点存活标记的过期时间和刷新间隔
+const ROUTE_NODE_TTL = 15
+const ROUTE_NODE_REFRESH = 5
+
+//im服务器所连接的route节点的缓存时间
+const SERVER_ROUTES_CACHE = time.Second
+
+//发往其它route节点的消息队列长度, 队列满时丢弃
+const PEER_QUEUE_SIZE = 1000
+
+//route节点之间的连接, 只用于转发消息
+type Peer struct {
+	addr string
+	wt   chan *Message
+}
+
+type ServerRoutes struct {
+	addrs []string
+	ts    time.Time
+}
+
+var peers map[string]*Peer = make(map[string]*Peer)
+var peers_mutex sync.Mutex
+
+var server_routes map[string]*ServerRoutes = make(map[string]*ServerRoutes)
+var server_routes_mutex sync.Mutex
+
+func IsClusterEnabled() bool {
+	return len(config.advertise_address) > 0
+}
+
+func OpAddServerRoute(server_id string) {
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	key := fmt.Sprintf("im_server_routes_%s", server_id)
+	_, err := conn.Do("SADD", key, config.advertise_address)
+	if err != nil {
+		log.Infoln(err)
+	}
+}
+
+func OpRemoveServerRoute(server_id string) {
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	key := fmt.Sprintf("im_server_routes_%s", server_id)
+	_, err := conn.Do("SREM", key, config.advertise_address)
+	if err != nil {
+		log.Infoln(err)
+	}
+}
+
+func OpRefreshRouteNode() {
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	key := fmt.Sprintf("route_node_alive_%s", config.advertise_address)
+	_, err := conn.Do("SET", key, time.Now().Unix(), "EX", ROUTE_NODE_TTL)
+	if err != nil {
+		log.Infoln(err)
+	}
+}
+
+//返回连接着该im服务器并且仍然存活的route节点
+func OpGetServerRoutes(server_id string) ([]string, error) {
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	key := fmt.Sprintf("im_server_routes_%s", server_id)
+	addrs, err := redis.Strings(conn.Do("SMEMBERS", key))
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return addrs, nil
+	}
+
+	keys := make([]interface{}, len(addrs))
+	for i, addr := range addrs {
+		keys[i] = fmt.Sprintf("route_node_alive_%s", addr)
+	}
+	values, err := redis.Values(conn.Do("MGET", keys...))
+	if err != nil {
+		return nil, err
+	}
+
+	alive := make([]string, 0, len(addrs))
+	for i, v := range values {
+		if v != nil {
+			alive = append(alive, addrs[i])
+		}
+	}
+	return alive, nil
+}
+
+func GetServerRoutes(server_id string) []string {
+	server_routes_mutex.Lock()
+	r, ok := server_routes[server_id]
+	server_routes_mutex.Unlock()
+	if ok && time.Since(r.ts) < SERVER_ROUTES_CACHE {
+		return r.addrs
+	}
+
+	addrs, err := OpGetServerRoutes(server_id)
+	if err != nil {
+		log.Info("get server routes error:", err)
+		if ok {
+			return r.addrs
+		}
+		return nil
+	}
+
+	server_routes_mutex.Lock()
+	server_routes[server_id] = &ServerRoutes{addrs, time.Now()}
+	server_routes_mutex.Unlock()
+	return addrs
+}
+
+//im服务器连接在本节点时直接发送, 否则转发给连接着它的route节点
+func DeliverToServer(server_id string, msg *Message) {
+	if DeliverLocal(server_id, msg) {
+		return
+	}
+	if !IsClusterEnabled() {
+		return
+	}
+
+	for _, addr := range GetServerRoutes(server_id) {
+		if addr == config.advertise_address {
+			continue
+		}
+		forward := &RouteForward{server_id:server_id, msg:msg}
+		GetPeer(addr).Forward(&Message{cmd:MSG_ROUTE_FORWARD, body:forward})
+		return
+	}
+	log.Infof("im server:%s has no route", server_id)
+	metric_forward.WithLabelValues("no_route").Inc()
+}
+
+func DeliverLocal(server_id string, msg *Message) bool {
+	clients_mutex.Lock()
+	defer clients_mutex.Unlock()
+
+	c, ok := clients[server_id]
+	if !ok {
+		return false
+	}
+	c.wt <- msg
+	return true
+}
+
+//其它route节点转发过来的消息不再继续转发, 避免环路
+func (client *Client) HandleForward(forward *RouteForward) {
+	if DeliverLocal(forward.server_id, forward.msg) {
+		metric_forward.WithLabelValues("received").Inc()
+		return
+	}
+	log.Infof("forward to im server:%s not connected", forward.server_id)
+	metric_forward.WithLabelValues("lost").Inc()
+}
+
+func GetPeer(addr string) *Peer {
+	peers_mutex.Lock()
+	defer peers_mutex.Unlock()
+
+	if peer, ok := peers[addr]; ok {
+		return peer
+	}
+	peer := &Peer{addr:addr, wt:make(chan *Message, PEER_QUEUE_SIZE)}
+	peers[addr] = peer
+	go peer.Run()
+	return peer
+}
+
+func (peer *Peer) Forward(msg *Message) {
+	select {
+	case peer.wt <- msg:
+		metric_forward.WithLabelValues("forwarded").Inc()
+	default:
+		log.Warningf("route peer:%s queue full, drop message", peer.addr)
+		metric_forward.WithLabelValues("dropped").Inc()
+	}
+}
+
+func (peer *Peer) dial() (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", peer.addr, 10 * time.Second)
+	if err != nil {
+		return nil, err
+	}
+	tconn := conn.(*net.TCPConn)
+	tconn.SetKeepAlive(true)
+	tconn.SetKeepAlivePeriod(time.Duration(10 * 60 * time.Second))
+	if cluster_certs == nil {
+		return conn, nil
+	}
+	return common.ClientHandshake(conn, PeerTLSConfig(peer.addr))
+}
+
+func PeerTLSConfig(addr string) *tls.Config {
+	server_name := config.cluster_server_name
+	if len(server_name) == 0 {
+		host, _, err := net.SplitHostPort(addr)
+		if err == nil {
+			server_name = host
+		}
+	}
+	return cluster_certs.ClientConfig(server_name)
+}
+
+func (peer *Peer) RunOnce(conn net.Conn) {
+	defer conn.Close()
+
+	//对端不会发送消息, 读失败说明连接已经断开
+	closed_ch := make(chan bool)
+	go func() {
+		for {
+			msg := ReceiveServerMessage(conn)
+			if msg == nil {
+				close(closed_ch)
+				return
+			}
+		}
+	}()
+
+	seq := 0
+	for {
+		select {
+		case <-closed_ch:
+			log.Infof("route peer:%s closed", peer.addr)
+			return
+		case msg := <-peer.wt:
+			seq++
+			msg.seq = seq
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			err := SendMessage(conn, msg)
+			if err != nil {
+				log.Infof("send to route peer:%s error:%s", peer.addr, err)
+				return
+			}
+		}
+	}
+}
+
+//对端不可用时队列中的消息会被丢弃, 由存活检查把它从路由中去掉
+func (peer *Peer) Run() {
+	nsleep := 100
+	for {
+		conn, err := peer.dial()
+		if err != nil {
+			log.Infof("connect route peer:%s error:%s", peer.addr, err)
+			nsleep *= 2
+			if nsleep > 10*1000 {
+				nsleep = 10 * 1000
+			}
+			time.Sleep(time.Duration(nsleep) * time.Millisecond)
+			continue
+		}
+		log.Infof("route peer:%s connected", peer.addr)
+		nsleep = 100
+		peer.RunOnce(conn)
+	}
+}
+
+func RefreshRouteNodeLoop() {
+	for {
+		OpRefreshRouteNode()
+		time.Sleep(ROUTE_NODE_REFRESH * time.Second)
+	}
+}
+
+func StartCluster() {
+	if !IsClusterEnabled() {
+		return
+	}
+	log.Infof("route cluster advertise address:%s", config.advertise_address)
+	OpRefreshRouteNode()
+	go RefreshRouteNodeLoop()
+}
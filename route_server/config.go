@@ -20,6 +20,7 @@
 package main
 
 import "log"
+import "strconv"
 import "github.com/richmonkey/cfg"
 
 type RouteConfig struct {
@@ -28,6 +29,21 @@ type RouteConfig struct {
 	redis_password		string
 	mysqldb_datasource string
 	mysqldb_appdatasource string
+	http_listen_address string
+
+	//离线推送
+	push_workers int
+	push_max_retries int
+	push_mock bool
+
+	//im_server, route_server, storage_server之间的双向tls认证
+	cluster_cert_file string
+	cluster_key_file  string
+	cluster_ca_file   string
+	cluster_server_name string
+
+	//其它节点和im服务器连接本节点的地址, 为空时不和其它route节点组成集群
+	advertise_address string
 }
 
 func get_string(app_cfg map[string]string, key string) string {
@@ -38,6 +54,26 @@ func get_string(app_cfg map[string]string, key string) string {
 	return concurrency
 }
 
+func get_opt_string(app_cfg map[string]string, key string) string {
+	concurrency, present := app_cfg[key]
+	if !present {
+		return ""
+	}
+	return concurrency
+}
+
+func get_opt_int(app_cfg map[string]string, key string, def int) int {
+	concurrency, present := app_cfg[key]
+	if !present {
+		return def
+	}
+	n, err := strconv.Atoi(concurrency)
+	if err != nil {
+		log.Fatalf("key:%s is't integer", key)
+	}
+	return n
+}
+
 func read_route_cfg(cfg_path string) *RouteConfig {
 	config := new(RouteConfig)
 	app_cfg := make(map[string]string)
@@ -52,6 +88,17 @@ func read_route_cfg(cfg_path string) *RouteConfig {
 	
 	config.mysqldb_datasource = get_string(app_cfg, "mysqldb_source")
 	config.mysqldb_appdatasource = get_string(app_cfg, "mysqldb_appsource")
+	config.http_listen_address = get_opt_string(app_cfg, "http_listen_address")
+
+	config.push_workers = get_opt_int(app_cfg, "push_workers", 4)
+	config.push_max_retries = get_opt_int(app_cfg, "push_max_retries", 3)
+	config.push_mock = get_opt_int(app_cfg, "push_mock", 0) != 0
+
+	config.cluster_cert_file = get_opt_string(app_cfg, "cluster_cert_file")
+	config.cluster_key_file = get_opt_string(app_cfg, "cluster_key_file")
+	config.cluster_ca_file = get_opt_string(app_cfg, "cluster_ca_file")
+	config.cluster_server_name = get_opt_string(app_cfg, "cluster_server_name")
+	config.advertise_address = get_opt_string(app_cfg, "advertise_address")
 	
 	return config
 }
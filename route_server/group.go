@@ -28,20 +28,20 @@ import _ "github.com/go-sql-driver/mysql"
 import log "github.com/golang/glog"
 import "github.com/garyburd/redigo/redis"
 
-func OpGetGroupMembers(gid int64) []int64 {
+func OpGetGroupMembers(appid int64, gid int64) []int64 {
 	conn := redis_pool.Get()
 	defer conn.Close()
 	
-	uids := make([]int64, 4, 10)
+	uids := make([]int64, 0, 10)
 	
-	key := fmt.Sprintf("group_members_%d", gid)
+	key := fmt.Sprintf("group_members_%d_%d", appid, gid)
 	members, err := redis.Ints(conn.Do("SMEMBERS", key))
 	if err != nil {
 		return uids
 	}
 	
 	if len(members) == 0 {
-		ms, err := LoadGroupMember(gid)
+		ms, err := LoadGroupMember(appid, gid)
 		if err != nil {
 			return uids
 		}
@@ -63,12 +63,12 @@ func OpGetGroupMembers(gid int64) []int64 {
 	return uids
 }
 
-func OpGetRoomMembers(gid int64) []int64 {
+func OpGetRoomMembers(appid int64, gid int64) []int64 {
 	conn := redis_pool.Get()
 	defer conn.Close()
 	
-	uids := make([]int64, 4, 10)
-	key := fmt.Sprintf("room_members_%d", gid)
+	uids := make([]int64, 0, 10)
+	key := fmt.Sprintf("room_members_%d_%d", appid, gid)
 	members, err := redis.Ints(conn.Do("SMEMBERS", key))
 	if err != nil {
 		return uids
@@ -81,11 +81,11 @@ func OpGetRoomMembers(gid int64) []int64 {
 	return uids
 }
 
-func OpAddRoomMember(gid int64, uid int64) bool {
+func OpAddRoomMember(appid int64, gid int64, uid int64) bool {
 	conn := redis_pool.Get()
 	defer conn.Close()
 	
-	key := fmt.Sprintf("room_members_%d", gid)
+	key := fmt.Sprintf("room_members_%d_%d", appid, gid)
 	_, err := conn.Do("SADD", key, uid)
 	if err != nil {
 		log.Infoln(err)
@@ -95,11 +95,11 @@ func OpAddRoomMember(gid int64, uid int64) bool {
 	return true
 }
 
-func OpRemoveRoomMember(gid int64, uid int64) bool {
+func OpRemoveRoomMember(appid int64, gid int64, uid int64) bool {
 	conn := redis_pool.Get()
 	defer conn.Close()
 	
-	key := fmt.Sprintf("room_members_%d", gid)
+	key := fmt.Sprintf("room_members_%d_%d", appid, gid)
 	_, err := conn.Do("SREM", key, uid)
 	if err != nil {
 		log.Infoln(err)
@@ -109,15 +109,15 @@ func OpRemoveRoomMember(gid int64, uid int64) bool {
 	return true
 }
 
-func OpAddGroupMember(gid int64, uid int64, isOwner int) bool {
-	if !AddGroupMember(gid, uid, isOwner) {
+func OpAddGroupMember(appid int64, gid int64, uid int64, isOwner int) bool {
+	if !AddGroupMember(appid, gid, uid, isOwner) {
 		return false
 	}
 	
 	conn := redis_pool.Get()
 	defer conn.Close()
 	
-	key := fmt.Sprintf("group_members_%d", gid)
+	key := fmt.Sprintf("group_members_%d_%d", appid, gid)
 	_, err := conn.Do("SADD", key, uid)
 	if err != nil {
 		log.Infoln(err)
@@ -127,15 +127,15 @@ func OpAddGroupMember(gid int64, uid int64, isOwner int) bool {
 	return true
 }
 
-func OpRemoveGroupMember(gid int64, uid int64) bool {
-	if !RemoveGroupMember(gid, uid) {
+func OpRemoveGroupMember(appid int64, gid int64, uid int64) bool {
+	if !RemoveGroupMember(appid, gid, uid) {
 		return false
 	}
 	
 	conn := redis_pool.Get()
 	defer conn.Close()
 	
-	key := fmt.Sprintf("group_members_%d", gid)
+	key := fmt.Sprintf("group_members_%d_%d", appid, gid)
 	_, err := conn.Do("SREM", key, uid)
 	if err != nil {
 		log.Infoln(err)
@@ -145,7 +145,7 @@ func OpRemoveGroupMember(gid int64, uid int64) bool {
 	return true
 }
 
-func AddGroupMember(group_id int64, uid int64, isOwner int) bool {
+func AddGroupMember(appid int64, group_id int64, uid int64, isOwner int) bool {
 	db, err := sql.Open("mysql", config.mysqldb_appdatasource)
 	if err != nil {
 		log.Info("error:", err)
@@ -161,8 +161,8 @@ func AddGroupMember(group_id int64, uid int64, isOwner int) bool {
 		return false
 	}
 
-	sql := fmt.Sprintf("INSERT INTO `group_members_0%d` ( `group_id`, `user_id`, `create_time`, `update_time`) select '%d', %d, %d, %d from dual where not exists(select * from group_members_0%d where group_id='%d' and user_id=%d)",
-			group_id % 10, group_id, uid, time.Now().Unix(), time.Now().Unix(), group_id % 10, group_id, uid)
+	sql := fmt.Sprintf("INSERT INTO `group_members_0%d` ( `appid`, `group_id`, `user_id`, `create_time`, `update_time`) select %d, '%d', %d, %d, %d from dual where not exists(select * from group_members_0%d where appid=%d and group_id='%d' and user_id=%d)",
+			group_id % 10, appid, group_id, uid, time.Now().Unix(), time.Now().Unix(), group_id % 10, appid, group_id, uid)
 	stmt1, err = tx.Prepare(sql)
 	if err != nil {
 		log.Info("error:", err)
@@ -175,8 +175,8 @@ func AddGroupMember(group_id int64, uid int64, isOwner int) bool {
 		goto ROLLBACK
 	}
 
-	sql = fmt.Sprintf("INSERT INTO `user_groups_0%d` ( `group_id`, `user_id`, `isOwner`, `type`) select '%d', %d, %d, %d from dual where not exists(select * from user_groups_0%d where type=1 and group_id='%d' and user_id=%d)",
-			uid % 10, group_id, uid, isOwner, 1, uid % 10, group_id, uid)
+	sql = fmt.Sprintf("INSERT INTO `user_groups_0%d` ( `appid`, `group_id`, `user_id`, `isOwner`, `type`) select %d, '%d', %d, %d, %d from dual where not exists(select * from user_groups_0%d where type=1 and appid=%d and group_id='%d' and user_id=%d)",
+			uid % 10, appid, group_id, uid, isOwner, 1, uid % 10, appid, group_id, uid)
 	stmt2, err = tx.Prepare(sql)
 	if err != nil {
 		log.Info("error:", err)
@@ -197,7 +197,7 @@ ROLLBACK:
 	return false
 }
 
-func RemoveGroupMember(group_id int64, uid int64) bool {
+func RemoveGroupMember(appid int64, group_id int64, uid int64) bool {
 	db, err := sql.Open("mysql", config.mysqldb_appdatasource)
 	if err != nil {
 		log.Info("error:", err)
@@ -213,27 +213,27 @@ func RemoveGroupMember(group_id int64, uid int64) bool {
 		return false
 	}
 
-	sql := fmt.Sprintf("delete from group_members_0%d where group_id=? and user_id=?", group_id % 10);
+	sql := fmt.Sprintf("delete from group_members_0%d where appid=? and group_id=? and user_id=?", group_id % 10);
 	stmt1, err = tx.Prepare(sql)
 	if err != nil {
 		log.Info("error:", err)
 		goto ROLLBACK
 	}
 	defer stmt1.Close()
-	_, err = stmt1.Exec(group_id, uid)
+	_, err = stmt1.Exec(appid, group_id, uid)
 	if err != nil {
 		log.Info("error:", err)
 		goto ROLLBACK
 	}
 
-	sql = fmt.Sprintf("delete from user_groups_0%d where type=1 and group_id=? and user_id=?", uid % 10);
+	sql = fmt.Sprintf("delete from user_groups_0%d where type=1 and appid=? and group_id=? and user_id=?", uid % 10);
 	stmt2, err = tx.Prepare(sql)
 	if err != nil {
 		log.Info("error:", err)
 		goto ROLLBACK
 	}
 	defer stmt2.Close()
-	_, err = stmt2.Exec(group_id, uid)
+	_, err = stmt2.Exec(appid, group_id, uid)
 	if err != nil {
 		log.Info("error:", err)
 		goto ROLLBACK
@@ -247,7 +247,7 @@ ROLLBACK:
 	return false
 }
 
-func LoadGroupMember(group_id int64) ([]int64, error) {
+func LoadGroupMember(appid int64, group_id int64) ([]int64, error) {
 	db, err := sql.Open("mysql", config.mysqldb_appdatasource)
 	if err != nil {
 		log.Info("error:", err)
@@ -255,7 +255,7 @@ func LoadGroupMember(group_id int64) ([]int64, error) {
 	}
 	defer db.Close()
 	
-	sql := fmt.Sprintf("SELECT user_id FROM group_members_0%d WHERE group_id=?", group_id % 10)
+	sql := fmt.Sprintf("SELECT user_id FROM group_members_0%d WHERE appid=? AND group_id=?", group_id % 10)
 	stmtIns, err := db.Prepare(sql)
 	if err != nil {
 		log.Info("error:", err)
@@ -264,7 +264,7 @@ func LoadGroupMember(group_id int64) ([]int64, error) {
 
 	defer stmtIns.Close()
 	members := make([]int64, 0, 4)
-	rows, err := stmtIns.Query(group_id)
+	rows, err := stmtIns.Query(appid, group_id)
 	for rows.Next() {
 		var uid int64
 		rows.Scan(&uid)
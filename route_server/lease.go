@@ -0,0 +1,204 @@
+/**
+ * Copyright (c) 2014-2015, GoBelieve
+ * All rights reserved.
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place, Suite 330, Boston, MA  02111-1307  USA
+ */
+
+
+package main
+
+import "fmt"
+import "sync"
+import "time"
+import "strings"
+import "strconv"
+import log "github.com/golang/glog"
+import "github.com/garyburd/redigo/redis"
+
+//im服务器租约状态的刷新间隔(秒)
+const LEASE_REFRESH_INTERVAL = 2
+
+//janitor的执行间隔(秒), 多个route节点通过锁保证同一时间只有一个在执行
+const JANITOR_INTERVAL = 30
+
+const IM_SERVER_LEASES_KEY = "im_server_leases"
+const JANITOR_LOCK_KEY = "im_server_janitor"
+
+//持有过租约但已经过期的im服务器
+//没有持有过租约的服务器(旧版本)视为存活
+var expired_servers map[string]bool = make(map[string]bool)
+var expired_mutex sync.Mutex
+
+func OpGetExpiredServers() (map[string]bool, error) {
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	ids, err := redis.Strings(conn.Do("SMEMBERS", IM_SERVER_LEASES_KEY))
+	if err != nil {
+		return nil, err
+	}
+	expired := make(map[string]bool)
+	if len(ids) == 0 {
+		return expired, nil
+	}
+
+	keys := make([]interface{}, len(ids))
+	for i, id := range ids {
+		keys[i] = fmt.Sprintf("im_server_lease_%s", id)
+	}
+	values, err := redis.Values(conn.Do("MGET", keys...))
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range values {
+		if v == nil {
+			expired[ids[i]] = true
+		}
+	}
+	return expired, nil
+}
+
+//清除过期服务器上所有用户的记录
+//WATCH租约, 服务器在清理过程中重新续约时放弃清理
+func OpPurgeServer(id string) int {
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	lease_key := fmt.Sprintf("im_server_lease_%s", id)
+	key := fmt.Sprintf("im_server_users_%s", id)
+	_, err := conn.Do("WATCH", lease_key, key)
+	if err != nil {
+		log.Infoln(err)
+		return 0
+	}
+	defer conn.Do("UNWATCH")
+
+	//服务器已经重新启动
+	exists, err := redis.Bool(conn.Do("EXISTS", lease_key))
+	if err != nil || exists {
+		return 0
+	}
+
+	members, err := redis.Strings(conn.Do("SMEMBERS", key))
+	if err != nil {
+		log.Infoln(err)
+		return 0
+	}
+
+	count := 0
+	conn.Send("MULTI")
+	for _, member := range members {
+		ids := strings.SplitN(member, "_", 2)
+		if len(ids) != 2 {
+			continue
+		}
+		appid, err1 := strconv.ParseInt(ids[0], 10, 64)
+		uid, err2 := strconv.ParseInt(ids[1], 10, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		conn.Send("SREM", fmt.Sprintf("user_servers_%d_%d", appid, uid), id)
+		count++
+	}
+	conn.Send("DEL", key)
+	conn.Send("SREM", IM_SERVER_LEASES_KEY, id)
+	reply, err := conn.Do("EXEC")
+	if err != nil {
+		log.Infoln(err)
+		return 0
+	}
+	if reply == nil {
+		log.Infof("server:%s renewed lease during purge", id)
+		return 0
+	}
+	return count
+}
+
+func OpLockJanitor() bool {
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	reply, err := conn.Do("SET", JANITOR_LOCK_KEY, config.listen, "EX", JANITOR_INTERVAL, "NX")
+	if err != nil {
+		log.Infoln(err)
+		return false
+	}
+	return reply != nil
+}
+
+func RefreshExpiredServers() {
+	expired, err := OpGetExpiredServers()
+	if err != nil {
+		//保留上次的结果
+		log.Info("get expired servers error:", err)
+		return
+	}
+	expired_mutex.Lock()
+	expired_servers = expired
+	expired_mutex.Unlock()
+}
+
+//去掉租约过期的服务器
+func FilterLiveServers(servers []string) []string {
+	expired_mutex.Lock()
+	defer expired_mutex.Unlock()
+
+	live := make([]string, 0, len(servers))
+	for _, id := range servers {
+		if !expired_servers[id] {
+			live = append(live, id)
+		}
+	}
+	return live
+}
+
+func RefreshLeaseLoop() {
+	for {
+		time.Sleep(LEASE_REFRESH_INTERVAL * time.Second)
+		RefreshExpiredServers()
+	}
+}
+
+//过期的服务器重新续约后不再清理
+func RunJanitor() {
+	if !OpLockJanitor() {
+		return
+	}
+	expired, err := OpGetExpiredServers()
+	if err != nil {
+		log.Info("get expired servers error:", err)
+		return
+	}
+	for id := range expired {
+		n := OpPurgeServer(id)
+		log.Infof("janitor purge server:%s users:%d", id, n)
+		metric_janitor_purged.Add(float64(n))
+	}
+}
+
+func JanitorLoop() {
+	for {
+		time.Sleep(JANITOR_INTERVAL * time.Second)
+		RunJanitor()
+	}
+}
+
+//第一次读取完成之后再处理消息
+func StartLease() {
+	RefreshExpiredServers()
+	go RefreshLeaseLoop()
+	go JanitorLoop()
+}
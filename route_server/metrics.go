@@ -0,0 +1,119 @@
+/**
+ * Copyright (c) 2014-2015, GoBelieve
+ * All rights reserved.
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place, Suite 330, Boston, MA  02111-1307  USA
+ */
+
+package main
+
+import "net/http"
+import log "github.com/golang/glog"
+import "github.com/prometheus/client_golang/prometheus"
+import "github.com/prometheus/client_golang/prometheus/promhttp"
+
+var (
+	metric_messages_in = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "imr_messages_in_total",
+		Help: "Messages received from im servers.",
+	}, []string{"cmd"})
+
+	metric_messages_out = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "imr_messages_out_total",
+		Help: "Messages sent to im servers.",
+	}, []string{"cmd"})
+
+	metric_push = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "imr_push_total",
+		Help: "Offline push notifications by provider and result.",
+	}, []string{"provider", "result"})
+
+	metric_forward = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "imr_forward_total",
+		Help: "Messages forwarded between route nodes by result.",
+	}, []string{"result"})
+
+	metric_janitor_purged = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "imr_janitor_purged_users_total",
+		Help: "User server entries removed for im servers with expired leases.",
+	})
+
+	desc_servers = prometheus.NewDesc("imr_servers",
+		"Registered im servers.", nil, nil)
+	desc_queue = prometheus.NewDesc("imr_server_queue_length",
+		"Messages waiting to be written to the im server.", []string{"server_id"}, nil)
+)
+
+func init() {
+	prometheus.MustRegister(metric_messages_in)
+	prometheus.MustRegister(metric_messages_out)
+	prometheus.MustRegister(metric_push)
+	prometheus.MustRegister(metric_forward)
+	prometheus.MustRegister(metric_janitor_purged)
+	prometheus.MustRegister(&ClientCollector{})
+}
+
+func CommandName(cmd int) string {
+	if desc, ok := message_descriptions[cmd]; ok {
+		return desc
+	}
+	return "unknown"
+}
+
+//publish消息按照被转发的消息统计
+func MessageCommandName(msg *Message) string {
+	if amsg, ok := msg.body.(*AppMessage); ok && amsg.msg != nil {
+		return CommandName(amsg.msg.cmd)
+	}
+	if mamsg, ok := msg.body.(*MultiAppMessage); ok && mamsg.msg != nil {
+		return CommandName(mamsg.msg.cmd)
+	}
+	if forward, ok := msg.body.(*RouteForward); ok && forward.msg != nil {
+		return MessageCommandName(forward.msg)
+	}
+	return CommandName(msg.cmd)
+}
+
+type ClientCollector struct {
+}
+
+func (c *ClientCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- desc_servers
+	ch <- desc_queue
+}
+
+func (c *ClientCollector) Collect(ch chan<- prometheus.Metric) {
+	clients_mutex.Lock()
+	defer clients_mutex.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(desc_servers, prometheus.GaugeValue, float64(len(clients)))
+	for server_id, client := range clients {
+		ch <- prometheus.MustNewConstMetric(desc_queue, prometheus.GaugeValue, float64(len(client.wt)), server_id)
+	}
+}
+
+func StartHttpServer(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	if config.push_mock {
+		mux.Handle("/mock_pushes", mock_provider)
+	}
+
+	log.Infof("Serving on http://%s/", addr)
+	err := http.ListenAndServe(addr, mux)
+	if err != nil {
+		log.Error("http server error:", err)
+	}
+}
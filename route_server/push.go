@@ -0,0 +1,396 @@
+/**
+ * Copyright (c) 2014-2015, GoBelieve
+ * All rights reserved.
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place, Suite 330, Boston, MA  02111-1307  USA
+ */
+
+package main
+
+import "fmt"
+import "errors"
+import "strings"
+import "strconv"
+import "sync"
+import "time"
+import "net/http"
+import "encoding/json"
+import log "github.com/golang/glog"
+import "github.com/garyburd/redigo/redis"
+
+const PUSH_QUEUE_SIZE = 10000
+
+//mock provider保留的最近推送数
+const MOCK_PUSH_LIMIT = 1000
+
+//推送的默认文本
+const PUSH_DEFAULT_ALERT = "你收到了一条新消息"
+
+//app推送证书的缓存时间
+const APP_PUSH_CACHE_TIMEOUT = 60
+
+//app_push_<appid>中的字段
+var APP_PUSH_FIELDS = []string{"apns_key", "apns_key_id", "apns_team_id", "apns_topic", "apns_sandbox",
+	"fcm_service_account", "xiaomi_app_secret", "xiaomi_package_name"}
+
+//设备token已经失效,不需要重试
+var ErrInvalidToken = errors.New("invalid device token")
+
+type PushNotification struct {
+	appid       int64
+	uid         int64
+	platform_id int
+	device_id   string
+	provider    string
+	token       string
+	alert       string
+	msgid       int64
+
+	attempts    int
+}
+
+type PushProvider interface {
+	Name() string
+	Push(n *PushNotification) error
+}
+
+//im_server绑定的设备token, field:platform_device
+type DeviceToken struct {
+	Provider string `json:"provider"`
+	Token    string `json:"token"`
+}
+
+//每个app使用自己的推送证书
+type AppPush struct {
+	fields    map[string]string
+	providers map[string]PushProvider
+	tm        time.Time
+}
+
+var push_c chan *PushNotification
+
+var app_push_mutex sync.Mutex
+var app_pushes map[int64]*AppPush = make(map[int64]*AppPush)
+
+func InitPush() {
+	push_c = make(chan *PushNotification, PUSH_QUEUE_SIZE)
+	for i := 0; i < config.push_workers; i++ {
+		go PushLoop()
+	}
+}
+
+func OpLoadAppPush(appid int64) (map[string]string, error) {
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	key := fmt.Sprintf("app_push_%d", appid)
+	args := redis.Args{}.Add(key).AddFlat(APP_PUSH_FIELDS)
+	values, err := redis.Strings(conn.Do("HMGET", args...))
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]string)
+	for i, v := range values {
+		if len(v) > 0 {
+			fields[APP_PUSH_FIELDS[i]] = v
+		}
+	}
+	return fields, nil
+}
+
+//证书错误的provider不启用, 其它provider不受影响
+func NewAppPushProviders(appid int64, fields map[string]string) map[string]PushProvider {
+	providers := make(map[string]PushProvider)
+	if len(fields["apns_key"]) > 0 {
+		p, err := NewAPNSProvider([]byte(fields["apns_key"]), fields["apns_key_id"],
+			fields["apns_team_id"], fields["apns_topic"], fields["apns_sandbox"] == "1")
+		if err != nil {
+			log.Warningf("init apns appid:%d error:%s", appid, err)
+		} else {
+			providers[p.Name()] = p
+		}
+	}
+	if len(fields["fcm_service_account"]) > 0 {
+		p, err := NewFCMProvider([]byte(fields["fcm_service_account"]))
+		if err != nil {
+			log.Warningf("init fcm appid:%d error:%s", appid, err)
+		} else {
+			providers[p.Name()] = p
+		}
+	}
+	if len(fields["xiaomi_app_secret"]) > 0 {
+		p := NewXiaomiProvider(fields["xiaomi_app_secret"], fields["xiaomi_package_name"])
+		providers[p.Name()] = p
+	}
+	log.Infof("app:%d push providers:%d", appid, len(providers))
+	return providers
+}
+
+func IsSameFields(a map[string]string, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+//证书没有变化时继续使用原来的provider, 保留已经获取的access token
+func GetPushProviders(appid int64) map[string]PushProvider {
+	if config.push_mock {
+		return map[string]PushProvider{mock_provider.Name(): mock_provider}
+	}
+
+	app_push_mutex.Lock()
+	ap, ok := app_pushes[appid]
+	app_push_mutex.Unlock()
+	if ok && time.Since(ap.tm) < APP_PUSH_CACHE_TIMEOUT * time.Second {
+		return ap.providers
+	}
+
+	fields, err := OpLoadAppPush(appid)
+	if err != nil {
+		log.Info("hmget error:", err)
+		if ok {
+			return ap.providers
+		}
+		return nil
+	}
+
+	var providers map[string]PushProvider
+	if ok && IsSameFields(ap.fields, fields) {
+		providers = ap.providers
+	} else {
+		providers = NewAppPushProviders(appid, fields)
+	}
+
+	app_push_mutex.Lock()
+	app_pushes[appid] = &AppPush{fields:fields, providers:providers, tm:time.Now()}
+	app_push_mutex.Unlock()
+	return providers
+}
+
+//token未指明provider时按平台选择
+func DefaultPushProvider(platform_id int) string {
+	switch platform_id {
+	case PLATFORM_IOS:
+		return "apns"
+	case PLATFORM_ANDROID:
+		return "fcm"
+	default:
+		return ""
+	}
+}
+
+func LoadDeviceTokens(appid int64, uid int64) map[string]*DeviceToken {
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	key := fmt.Sprintf("push_tokens_%d_%d", appid, uid)
+	values, err := redis.StringMap(conn.Do("HGETALL", key))
+	if err != nil {
+		log.Info("hgetall error:", err)
+		return nil
+	}
+
+	tokens := make(map[string]*DeviceToken)
+	for field, v := range values {
+		t := &DeviceToken{}
+		err := json.Unmarshal([]byte(v), t)
+		if err != nil {
+			log.Warningf("invalid device token uid:%d %s", uid, field)
+			continue
+		}
+		tokens[field] = t
+	}
+	return tokens
+}
+
+func RemoveDeviceToken(appid int64, uid int64, platform_id int, device_id string) {
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	key := fmt.Sprintf("push_tokens_%d_%d", appid, uid)
+	field := fmt.Sprintf("%d_%s", platform_id, device_id)
+	_, err := conn.Do("HDEL", key, field)
+	if err != nil {
+		log.Infoln(err)
+	}
+}
+
+//消息内容为{"text":"..."}时推送文本,其它类型的消息推送默认文本
+func PushAlert(content string) string {
+	obj := make(map[string]interface{})
+	err := json.Unmarshal([]byte(content), &obj)
+	if err != nil {
+		return PUSH_DEFAULT_ALERT
+	}
+	if text, ok := obj["text"].(string); ok && len(text) > 0 {
+		return text
+	}
+	return PUSH_DEFAULT_ALERT
+}
+
+//接收者不在线, 推送给用户的所有设备
+func PushOfflineMessage(amsg *AppMessage) {
+	if push_c == nil {
+		return
+	}
+	if amsg.msg.cmd != MSG_IM && amsg.msg.cmd != MSG_GROUP_IM {
+		return
+	}
+	im := amsg.msg.body.(*IMMessage)
+	//发送者自己的消息副本
+	if im.sender == amsg.receiver {
+		return
+	}
+
+	if len(GetPushProviders(amsg.appid)) == 0 {
+		return
+	}
+
+	tokens := LoadDeviceTokens(amsg.appid, amsg.receiver)
+	for field, t := range tokens {
+		s := strings.SplitN(field, "_", 2)
+		if len(s) != 2 {
+			continue
+		}
+		platform_id, err := strconv.Atoi(s[0])
+		if err != nil || platform_id == PLATFORM_WEB {
+			continue
+		}
+		provider := t.Provider
+		if len(provider) == 0 {
+			provider = DefaultPushProvider(platform_id)
+		}
+		if config.push_mock {
+			provider = mock_provider.Name()
+		}
+
+		n := &PushNotification{
+			appid : amsg.appid,
+			uid : amsg.receiver,
+			platform_id : platform_id,
+			device_id : s[1],
+			provider : provider,
+			token : t.Token,
+			alert : PushAlert(im.content),
+			msgid : amsg.msgid,
+		}
+		EnqueuePush(n)
+	}
+}
+
+func EnqueuePush(n *PushNotification) {
+	select {
+	case push_c <- n:
+	default:
+		log.Warningf("push queue full, drop notification uid:%d provider:%s", n.uid, n.provider)
+		metric_push.WithLabelValues(n.provider, "dropped").Inc()
+	}
+}
+
+func PushLoop() {
+	for n := range push_c {
+		SendPush(n)
+	}
+}
+
+func SendPush(n *PushNotification) {
+	p, ok := GetPushProviders(n.appid)[n.provider]
+	if !ok {
+		log.Warningf("push provider:%s not configured appid:%d uid:%d", n.provider, n.appid, n.uid)
+		metric_push.WithLabelValues(n.provider, "dropped").Inc()
+		return
+	}
+
+	n.attempts++
+	err := p.Push(n)
+	if err == nil {
+		log.Infof("push uid:%d provider:%s msgid:%d", n.uid, n.provider, n.msgid)
+		metric_push.WithLabelValues(n.provider, "success").Inc()
+		return
+	}
+
+	if err == ErrInvalidToken {
+		log.Infof("push invalid token uid:%d provider:%s device:%s", n.uid, n.provider, n.device_id)
+		metric_push.WithLabelValues(n.provider, "invalid_token").Inc()
+		RemoveDeviceToken(n.appid, n.uid, n.platform_id, n.device_id)
+		return
+	}
+
+	if n.attempts > config.push_max_retries {
+		log.Warningf("push uid:%d provider:%s failed after %d attempts:%s", n.uid, n.provider, n.attempts, err)
+		metric_push.WithLabelValues(n.provider, "failed").Inc()
+		return
+	}
+
+	//1s, 2s, 4s...
+	delay := time.Duration(1 << uint(n.attempts - 1)) * time.Second
+	log.Infof("push uid:%d provider:%s error:%s, retry after %s", n.uid, n.provider, err, delay)
+	metric_push.WithLabelValues(n.provider, "retry").Inc()
+	time.AfterFunc(delay, func() {
+		EnqueuePush(n)
+	})
+}
+
+//本地测试使用,记录推送而不发送, 通过http接口/mock_pushes查看
+type MockProvider struct {
+	mutex         sync.Mutex
+	notifications []*PushNotification
+}
+
+var mock_provider = &MockProvider{}
+
+func (p *MockProvider) Name() string {
+	return "mock"
+}
+
+func (p *MockProvider) Push(n *PushNotification) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	log.Infof("mock push uid:%d device:%s token:%s alert:%s", n.uid, n.device_id, n.token, n.alert)
+	p.notifications = append(p.notifications, n)
+	if len(p.notifications) > MOCK_PUSH_LIMIT {
+		p.notifications = p.notifications[len(p.notifications) - MOCK_PUSH_LIMIT:]
+	}
+	return nil
+}
+
+func (p *MockProvider) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	objs := make([]map[string]interface{}, 0, len(p.notifications))
+	for _, n := range p.notifications {
+		obj := make(map[string]interface{})
+		obj["appid"] = n.appid
+		obj["uid"] = n.uid
+		obj["platform_id"] = n.platform_id
+		obj["device_id"] = n.device_id
+		obj["token"] = n.token
+		obj["alert"] = n.alert
+		obj["msgid"] = n.msgid
+		objs = append(objs, obj)
+	}
+	b, _ := json.Marshal(objs)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
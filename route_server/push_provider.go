@@ -0,0 +1,392 @@
+/**
+ * Copyright (c) 2014-2015, GoBelieve
+ * All rights reserved.
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place, Suite 330, Boston, MA  02111-1307  USA
+ */
+
+package main
+
+import "fmt"
+import "errors"
+import "bytes"
+import "sync"
+import "time"
+import "strings"
+import "io/ioutil"
+import "net/http"
+import "net/url"
+import "math/big"
+import "crypto"
+import "crypto/rand"
+import "crypto/ecdsa"
+import "crypto/rsa"
+import "crypto/sha256"
+import "crypto/x509"
+import "encoding/pem"
+import "encoding/json"
+import "encoding/base64"
+
+const PUSH_TIMEOUT = 10 * time.Second
+
+var push_http_client = &http.Client{Timeout: PUSH_TIMEOUT}
+
+func ParsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid pem")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported private key")
+	}
+	return signer, nil
+}
+
+func EncodeSegment(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+//ES256或RS256签名的jwt
+func SignJWT(key crypto.Signer, header map[string]interface{}, claims map[string]interface{}) (string, error) {
+	h, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	input := EncodeSegment(h) + "." + EncodeSegment(c)
+	digest := sha256.Sum256([]byte(input))
+
+	var sig []byte
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			return "", err
+		}
+		//jwt要求r和s定长拼接
+		sig = make([]byte, 64)
+		PadBigInt(sig[:32], r)
+		PadBigInt(sig[32:], s)
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		if err != nil {
+			return "", err
+		}
+	default:
+		return "", errors.New("unsupported private key")
+	}
+	return input + "." + EncodeSegment(sig), nil
+}
+
+func PadBigInt(dst []byte, n *big.Int) {
+	b := n.Bytes()
+	copy(dst[len(dst) - len(b):], b)
+}
+
+//apns http/2 接口, 使用token认证
+type APNSProvider struct {
+	key     crypto.Signer
+	key_id  string
+	team_id string
+	topic   string
+	host    string
+
+	mutex   sync.Mutex
+	jwt     string
+	jwt_tm  time.Time
+}
+
+//data为p8私钥内容
+func NewAPNSProvider(data []byte, key_id string, team_id string, topic string, sandbox bool) (*APNSProvider, error) {
+	key, err := ParsePrivateKey(data)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := key.(*ecdsa.PrivateKey); !ok {
+		return nil, errors.New("apns key must be ecdsa")
+	}
+
+	p := &APNSProvider{key:key, key_id:key_id, team_id:team_id, topic:topic}
+	if sandbox {
+		p.host = "https://api.sandbox.push.apple.com"
+	} else {
+		p.host = "https://api.push.apple.com"
+	}
+	return p, nil
+}
+
+func (p *APNSProvider) Name() string {
+	return "apns"
+}
+
+//token有效期1小时, 提前刷新
+func (p *APNSProvider) Token() (string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := time.Now()
+	if len(p.jwt) > 0 && now.Sub(p.jwt_tm) < 50 * time.Minute {
+		return p.jwt, nil
+	}
+
+	header := map[string]interface{}{"alg": "ES256", "kid": p.key_id}
+	claims := map[string]interface{}{"iss": p.team_id, "iat": now.Unix()}
+	token, err := SignJWT(p.key, header, claims)
+	if err != nil {
+		return "", err
+	}
+	p.jwt = token
+	p.jwt_tm = now
+	return token, nil
+}
+
+func (p *APNSProvider) Push(n *PushNotification) error {
+	token, err := p.Token()
+	if err != nil {
+		return err
+	}
+
+	aps := map[string]interface{}{"alert": n.alert, "sound": "default"}
+	payload := map[string]interface{}{"aps": aps, "msgid": n.msgid}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	u := fmt.Sprintf("%s/3/device/%s", p.host, url.PathEscape(n.token))
+	req, err := http.NewRequest("POST", u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("authorization", "bearer " + token)
+	req.Header.Set("apns-topic", p.topic)
+	req.Header.Set("apns-push-type", "alert")
+
+	resp, err := push_http_client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 200 {
+		return nil
+	}
+
+	var r struct {
+		Reason string `json:"reason"`
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	json.Unmarshal(b, &r)
+	if resp.StatusCode == 410 || r.Reason == "BadDeviceToken" || r.Reason == "Unregistered" {
+		return ErrInvalidToken
+	}
+	return fmt.Errorf("apns status:%d reason:%s", resp.StatusCode, r.Reason)
+}
+
+//fcm http v1 接口, 使用service account换取access token
+type FCMProvider struct {
+	key          crypto.Signer
+	client_email string
+	project_id   string
+	token_uri    string
+
+	mutex        sync.Mutex
+	access_token string
+	expires      time.Time
+}
+
+//data为service account json内容
+func NewFCMProvider(data []byte) (*FCMProvider, error) {
+	var account struct {
+		ProjectID   string `json:"project_id"`
+		PrivateKey  string `json:"private_key"`
+		ClientEmail string `json:"client_email"`
+		TokenURI    string `json:"token_uri"`
+	}
+	err := json.Unmarshal(data, &account)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParsePrivateKey([]byte(account.PrivateKey))
+	if err != nil {
+		return nil, err
+	}
+	if len(account.TokenURI) == 0 {
+		account.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+
+	p := &FCMProvider{
+		key : key,
+		client_email : account.ClientEmail,
+		project_id : account.ProjectID,
+		token_uri : account.TokenURI,
+	}
+	return p, nil
+}
+
+func (p *FCMProvider) Name() string {
+	return "fcm"
+}
+
+func (p *FCMProvider) Token() (string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := time.Now()
+	if len(p.access_token) > 0 && now.Before(p.expires) {
+		return p.access_token, nil
+	}
+
+	header := map[string]interface{}{"alg": "RS256", "typ": "JWT"}
+	claims := map[string]interface{}{
+		"iss": p.client_email,
+		"scope": "https://www.googleapis.com/auth/firebase.messaging",
+		"aud": p.token_uri,
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	}
+	assertion, err := SignJWT(p.key, header, claims)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+	form.Set("assertion", assertion)
+	resp, err := push_http_client.PostForm(p.token_uri, form)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("fcm token status:%d", resp.StatusCode)
+	}
+
+	var r struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&r)
+	if err != nil {
+		return "", err
+	}
+	p.access_token = r.AccessToken
+	p.expires = now.Add(time.Duration(r.ExpiresIn - 60) * time.Second)
+	return p.access_token, nil
+}
+
+func (p *FCMProvider) Push(n *PushNotification) error {
+	token, err := p.Token()
+	if err != nil {
+		return err
+	}
+
+	message := map[string]interface{}{
+		"token": n.token,
+		"notification": map[string]interface{}{"body": n.alert},
+		"data": map[string]interface{}{"msgid": fmt.Sprintf("%d", n.msgid)},
+	}
+	body, err := json.Marshal(map[string]interface{}{"message": message})
+	if err != nil {
+		return err
+	}
+
+	u := fmt.Sprintf("https://fcm.googleapis.com/v1/projects/%s/messages:send", p.project_id)
+	req, err := http.NewRequest("POST", u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer " + token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := push_http_client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 200 {
+		return nil
+	}
+
+	b, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode == 404 || strings.Contains(string(b), "UNREGISTERED") {
+		return ErrInvalidToken
+	}
+	return fmt.Errorf("fcm status:%d", resp.StatusCode)
+}
+
+//小米推送, 国内android厂商通道
+type XiaomiProvider struct {
+	app_secret   string
+	package_name string
+}
+
+func NewXiaomiProvider(app_secret string, package_name string) *XiaomiProvider {
+	return &XiaomiProvider{app_secret:app_secret, package_name:package_name}
+}
+
+func (p *XiaomiProvider) Name() string {
+	return "xiaomi"
+}
+
+func (p *XiaomiProvider) Push(n *PushNotification) error {
+	form := url.Values{}
+	form.Set("registration_id", n.token)
+	form.Set("restricted_package_name", p.package_name)
+	form.Set("title", PUSH_DEFAULT_ALERT)
+	form.Set("description", n.alert)
+	form.Set("payload", fmt.Sprintf("%d", n.msgid))
+	form.Set("pass_through", "0")
+	form.Set("notify_type", "-1")
+
+	req, err := http.NewRequest("POST", "https://api.xmpush.xiaomi.com/v3/message/regid", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "key=" + p.app_secret)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := push_http_client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("xiaomi status:%d", resp.StatusCode)
+	}
+
+	var r struct {
+		Result string `json:"result"`
+		Code   int    `json:"code"`
+		Reason string `json:"reason"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&r)
+	if err != nil {
+		return err
+	}
+	if r.Result == "ok" {
+		return nil
+	}
+	return fmt.Errorf("xiaomi code:%d reason:%s", r.Code, r.Reason)
+}
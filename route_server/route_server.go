@@ -30,9 +30,11 @@ import "bytes"
 import "encoding/binary"
 import log "github.com/golang/glog"
 import "github.com/garyburd/redigo/redis"
+import "im_service/common"
 
 var config *RouteConfig
 var redis_pool *redis.Pool
+var cluster_certs *common.CertReloader
 var clients map[string]*Client
 var clients_mutex sync.Mutex
 
@@ -40,10 +42,10 @@ type Client struct {
 	wt     chan *Message
 	
 	serverId string
-	conn   *net.TCPConn
+	conn   net.Conn
 }
 
-func NewClient(conn *net.TCPConn) *Client {
+func NewClient(conn net.Conn) *Client {
 	client := new(Client)
 	client.conn = conn 
 	client.wt = make(chan *Message, 10)
@@ -63,6 +65,7 @@ func (client *Client) Read() {
 
 func (client *Client) HandleMessage(msg *Message) {
 	log.Info("msg cmd:", Command(msg.cmd))
+	metric_messages_in.WithLabelValues(MessageCommandName(msg)).Inc()
 	switch msg.cmd {
 	case MSG_SERVER_REGISTER:
 		client.HandleRegister(msg.body.(*ServerID))
@@ -72,6 +75,8 @@ func (client *Client) HandleMessage(msg *Message) {
 		client.HandlePublishGroup(msg.body.(*AppMessage))
 	case MSG_PUBLISH_ROOM:
 		client.HandlePublishRoom(msg.body.(*AppMessage))
+	case MSG_ROUTE_FORWARD:
+		client.HandleForward(msg.body.(*RouteForward))
 	default:
 		log.Warning("unknown message cmd:", msg.cmd)
 	}
@@ -107,16 +112,16 @@ func (client *Client) IsROMApp(appid int64) bool {
 	return false
 }
 
+//im服务器重连后旧的连接可能还没有断开, 使用新的连接
 func (client *Client) HandleRegister(id *ServerID) {
 	clients_mutex.Lock()
-	defer clients_mutex.Unlock()
-	
-	if _, ok := clients[id.serverid]; ok {
-		return
-	}
-	
 	client.serverId = id.serverid
 	clients[id.serverid] = client
+	clients_mutex.Unlock()
+
+	if IsClusterEnabled() {
+		OpAddServerRoute(id.serverid)
+	}
 }
 
 
@@ -124,22 +129,17 @@ func (client *Client) HandlePublish(amsg *AppMessage) {
 	log.Infof("publish message appid:%d uid:%d msgid:%d cmd:%s", amsg.appid, amsg.receiver, amsg.msgid, Command(amsg.msg.cmd))
 	receiver := amsg.receiver
 	
-	servers := GetUserServers(receiver)
+	servers := GetUserServers(amsg.appid, receiver)
 
 	if servers == nil || len(servers) == 0 {
 		//用户不在线,推送消息到终端, 苹果apns
+		PushOfflineMessage(amsg)
 		return
 	}
 
 	msg := &Message{cmd:MSG_PUBLISH, body:amsg}
-	
-	clients_mutex.Lock()
-	defer clients_mutex.Unlock()
-	
 	for _, serverId := range servers {
-		if c, ok := clients[serverId]; ok {
-			c.wt <- msg
-		}
+		DeliverToServer(serverId, msg)
 	}
 }
 
@@ -147,74 +147,50 @@ func (client *Client) HandlePublishRoom(amsg *AppMessage) {
 	log.Infof("publish room message appid:%d room id:%d cmd:%s", amsg.appid, amsg.receiver, Command(amsg.msg.cmd))
 	receiver := amsg.receiver
 	
-	members := OpGetRoomMembers(receiver)
-	
-	for _, member := range members {		
-		servers := GetUserServers(member)
-		
-		if servers == nil || len(servers) == 0 {
-			return
-		}
-	
-		amsg1 := &AppMessage{
-			appid : amsg.appid,
-			receiver : member,
-			msgid : amsg.msgid,
-			device_id : amsg.device_id,
-			msg : amsg.msg,
-		}
-		msg := &Message{cmd:MSG_PUBLISH, body: amsg1}
-		
-		clients_mutex.Lock()
-		defer clients_mutex.Unlock()
-		
-		for _, serverId := range servers {
-			if c, ok := clients[serverId]; ok {
-				c.wt <- msg
-			}
-		}
-	}
+	members := OpGetRoomMembers(amsg.appid, receiver)
+	servers, _ := GetUsersServers(amsg.appid, members)
+	PublishMulti(amsg, servers)
 }
 
 func (client *Client) HandlePublishGroup(amsg *AppMessage) {
 	log.Infof("publish group message appid:%d group id:%d cmd:%s", amsg.appid, amsg.receiver, Command(amsg.msg.cmd))
 	receiver := amsg.receiver
 
-	members := OpGetGroupMembers(receiver)
-	
-	for _, member := range members {		
-		servers := GetUserServers(member)
-		
-		if servers == nil || len(servers) == 0 {
-			//用户不在线,推送消息到终端, 苹果apns
-			return
-		}
-	
-		amsg1 := &AppMessage{
+	members := OpGetGroupMembers(amsg.appid, receiver)
+	//不在线的成员暂不推送到终端
+	servers, _ := GetUsersServers(amsg.appid, members)
+	PublishMulti(amsg, servers)
+}
+
+//每台im服务器只发送一个消息, 由im服务器分发给各个接收者
+func PublishMulti(amsg *AppMessage, servers map[string][]int64) {
+	for serverId, receivers := range servers {
+		mamsg := &MultiAppMessage{
 			appid : amsg.appid,
-			receiver : member,
+			receivers : receivers,
 			msgid : amsg.msgid,
 			device_id : amsg.device_id,
 			msg : amsg.msg,
 		}
-		msg := &Message{cmd:MSG_PUBLISH, body: amsg1}
-		
-		clients_mutex.Lock()
-		defer clients_mutex.Unlock()
-		
-		for _, serverId := range servers {
-			if c, ok := clients[serverId]; ok {
-				c.wt <- msg
-			}
-		}
+		DeliverToServer(serverId, &Message{cmd:MSG_PUBLISH_MULTI, body:mamsg})
 	}
 }
 
-func RemoveClient(serverId string) {
+func RemoveClient(client *Client) {
+	if len(client.serverId) == 0 {
+		return
+	}
+
 	clients_mutex.Lock()
-	defer clients_mutex.Unlock()
-	
-	delete(clients, serverId)
+	c, ok := clients[client.serverId]
+	if ok && c == client {
+		delete(clients, client.serverId)
+	}
+	clients_mutex.Unlock()
+
+	if ok && c == client && IsClusterEnabled() {
+		OpRemoveServerRoute(client.serverId)
+	}
 }
 
 func (client *Client) Write() {
@@ -222,7 +198,7 @@ func (client *Client) Write() {
 	for {
 		msg := <-client.wt
 		if msg == nil {
-			RemoveClient(client.serverId)
+			RemoveClient(client)
 			client.close()
 			log.Infof("client socket closed")
 			break
@@ -230,6 +206,7 @@ func (client *Client) Write() {
 		seq++
 		msg.seq = seq
 		client.send(msg)
+		metric_messages_out.WithLabelValues(MessageCommandName(msg)).Inc()
 	}
 }
 
@@ -240,7 +217,7 @@ func (client *Client) Run() {
 
 
 func (client *Client) read() *Message {
-	return ReceiveMessage(client.conn)
+	return ReceiveServerMessage(client.conn)
 }
 
 func (client *Client) send(msg *Message) {
@@ -254,8 +231,22 @@ func (client *Client) close() {
 func handle_client(conn *net.TCPConn) {
 	conn.SetKeepAlive(true)
 	conn.SetKeepAlivePeriod(time.Duration(10 * 60 * time.Second))
-	client := NewClient(conn)
-	client.Run()
+	if cluster_certs == nil {
+		client := NewClient(conn)
+		client.Run()
+		return
+	}
+
+	//握手不阻塞accept
+	go func() {
+		tconn, err := common.ServerHandshake(conn, cluster_certs.ServerConfig())
+		if err != nil {
+			log.Warningf("tls handshake with %s error:%s", conn.RemoteAddr(), err)
+			return
+		}
+		client := NewClient(tconn)
+		client.Run()
+	}()
 }
 
 func Listen(f func(*net.TCPConn), listen_addr string) {
@@ -316,8 +307,17 @@ func main() {
 	log.Infof("listen:%s redis:%s\n", config.listen, config.redis_address)
 
 	redis_pool = NewRedisPool(config.redis_address, config.redis_password)
+	cluster_certs = common.NewClusterCertReloader(config.cluster_cert_file,
+		config.cluster_key_file, config.cluster_ca_file)
 	
 	clients = make(map[string]*Client)
 
+	InitPush()
+	StartCluster()
+	StartLease()
+
+	if len(config.http_listen_address) > 0 {
+		go StartHttpServer(config.http_listen_address)
+	}
 	ListenClient()
 }
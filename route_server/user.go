@@ -4,18 +4,54 @@ import (
 	"fmt"
 )
 
+import log "github.com/golang/glog"
 import "github.com/garyburd/redigo/redis"
 
-//获取用户客户端设备连接机器
-func GetUserServers(uid int64) []string {
+//使用pipeline一次获取多个用户连接的机器, 返回每台机器上的用户和不在线的用户
+func GetUsersServers(appid int64, uids []int64) (map[string][]int64, []int64) {
+	conn := redis_pool.Get()
+	defer conn.Close()
+
+	servers := make(map[string][]int64)
+	offline := make([]int64, 0)
+	for _, uid := range uids {
+		key := fmt.Sprintf("user_servers_%d_%d", appid, uid)
+		conn.Send("SMEMBERS", key)
+	}
+	err := conn.Flush()
+	if err != nil {
+		log.Info("redis flush error:", err)
+		return servers, offline
+	}
+
+	for _, uid := range uids {
+		ids, err := redis.Strings(conn.Receive())
+		if err != nil {
+			log.Info("redis receive error:", err)
+			continue
+		}
+		ids = FilterLiveServers(ids)
+		if len(ids) == 0 {
+			offline = append(offline, uid)
+			continue
+		}
+		for _, id := range ids {
+			servers[id] = append(servers[id], uid)
+		}
+	}
+	return servers, offline
+}
+
+//获取用户客户端设备连接机器, 不包括租约过期的机器
+func GetUserServers(appid int64, uid int64) []string {
 	conn := redis_pool.Get()
 	defer conn.Close()
 	
-	key := fmt.Sprintf("user_servers_%d", uid)
+	key := fmt.Sprintf("user_servers_%d_%d", appid, uid)
 	servers, err := redis.Strings(conn.Do("SMEMBERS", key))
 	if err != nil {
 		return nil
 	}
 	
-	return servers
+	return FilterLiveServers(servers)
 }
\ No newline at end of file
@@ -33,6 +33,13 @@ type StorageConfig struct {
 	ots_accessid string
 	ots_accesskey string
 	ots_instancename string
+
+	http_listen_address string
+
+	//im_server, route_server, storage_server之间的双向tls认证
+	cluster_cert_file string
+	cluster_key_file  string
+	cluster_ca_file   string
 }
 
 func get_int(app_cfg map[string]string, key string) int {
@@ -82,5 +89,11 @@ func read_storage_cfg(cfg_path string) *StorageConfig {
 	config.ots_accesskey = get_string(app_cfg, "ots_accesskey")
 	config.ots_instancename = get_string(app_cfg, "ots_instancename")
 
+	config.http_listen_address = get_opt_string(app_cfg, "http_listen_address")
+
+	config.cluster_cert_file = get_opt_string(app_cfg, "cluster_cert_file")
+	config.cluster_key_file = get_opt_string(app_cfg, "cluster_key_file")
+	config.cluster_ca_file = get_opt_string(app_cfg, "cluster_ca_file")
+
 	return config
 }
@@ -20,7 +20,6 @@
 package main
 
 import "sync"
-import "encoding/json"
 import log "github.com/golang/glog"
 import ots2 "github.com/GiterLab/goots"
 //import "github.com/GiterLab/goots/log"
@@ -37,7 +36,7 @@ func NewGroupStorage(ots2_client *ots2.OTSClient) *GroupStorage {
 	return storage
 }
 
-func (storage *GroupStorage) saveMessage(msg *Message) int64 {
+func (storage *GroupStorage) saveMessage(appid int64, gid int64, msg *Message) int64 {
 	m := msg.body.(*IMMessage)
 	
 	msgid, err := iw.NextId()
@@ -48,21 +47,16 @@ func (storage *GroupStorage) saveMessage(msg *Message) int64 {
 	m.msgid = msgid
 	
 	primaryKey := &OTSPrimaryKey{
-		"gid" : m.receiver,
+		"appid" : appid,
+		"gid" : gid,
 		"msgid" : m.msgid,
 	}
 	
-	bs, err := json.Marshal(*m)
-	if err != nil {
-		log.Infoln(err)
-		return 0
-	}
-	
 	attributeColumns := &OTSAttribute{
 		"cmd" : msg.cmd,
 		"seq" : msg.seq,
 		"version" : msg.version,
-		"body" : string(bs),
+		"body" : EncodeMessageBody(msg),
 	}
 	
 	condition := OTSCondition_EXPECT_NOT_EXIST
@@ -75,7 +69,7 @@ func (storage *GroupStorage) SaveGroupMessage(appid int64, gid int64, device_id
 	storage.mutex.Lock()
 	defer storage.mutex.Unlock()
 
-	msgid := storage.saveMessage(msg)
+	msgid := storage.saveMessage(appid, gid, msg)
 
 	storage.setLastGroupMessageID(appid, gid, msgid)
 	return msgid
@@ -83,6 +77,7 @@ func (storage *GroupStorage) SaveGroupMessage(appid int64, gid int64, device_id
 
 func (storage *GroupStorage) setLastGroupMessageID(appid int64, gid int64, msgid int64) {
 	primaryKey := &OTSPrimaryKey{
+		"appid" : appid,
 		"gid" : gid,
 	}
 	
@@ -103,6 +98,7 @@ func (storage *GroupStorage) SetLastGroupMessageID(appid int64, gid int64, msgid
 
 func (storage *GroupStorage) getLastGroupMessageID(appid int64, gid int64) (int64, error) {
 	primaryKey := &OTSPrimaryKey{
+		"appid" : appid,
 		"gid" : gid,
 	}
 	
@@ -134,6 +130,7 @@ func (storage *GroupStorage) GetLastGroupMessageID(appid int64, gid int64) (int6
 
 func (storage *GroupStorage) setLastGroupReceivedID(appid int64, gid int64, uid int64, did int64, msgid int64) {
 	primaryKey := &OTSPrimaryKey{
+		"appid" : appid,
 		"gid" : gid,
 		"uid" : uid,
 		"deviceid" : did,
@@ -156,6 +153,7 @@ func (storage *GroupStorage) SetLastGroupReceivedID(appid int64, gid int64, uid
 
 func (storage *GroupStorage) getLastGroupReceivedID(appid int64, gid int64, uid int64, did int64) (int64, error) {
 	primaryKey := &OTSPrimaryKey{
+		"appid" : appid,
 		"gid" : gid,
 		"uid" : uid,
 		"deviceid" : did,
@@ -186,13 +184,15 @@ func (storage *GroupStorage) GetLastGroupReceivedID(appid int64, gid int64, uid
 	return storage.getLastGroupReceivedID(appid, gid, uid, device_id)
 }
 
-func (storage *GroupStorage) loadRangeMessages(gid int64, minid int64, maxid int64) []*Message {
+func (storage *GroupStorage) loadRangeMessages(appid int64, gid int64, minid int64, maxid int64) []*Message {
 	startPrimaryKey := &OTSPrimaryKey{
+		"appid" : appid,
 		"gid" : gid,
 		"msgid" : minid,
 	}
 	
 	endPrimaryKey := &OTSPrimaryKey{
+		"appid" : appid,
 		"gid" : gid,
 		"msgid" : maxid+1,
 	}
@@ -220,16 +220,9 @@ func (storage *GroupStorage) loadRangeMessages(gid int64, minid int64, maxid int
 			version := attributeColumns.Get("version").(int)
 			body := attributeColumns.Get("body").(string)
 			
-			immsg := IMMessage{}
-			json_err := json.Unmarshal([]byte(body), &immsg)
-			if json_err == nil {
-				msg := Message{}
-				msg.cmd = cmd
-				msg.seq = seq
-				msg.version = version
-				msg.body = &immsg
-				
-				msgs = append(msgs, &msg)
+			msg := DecodeMessageBody(cmd, seq, version, body)
+			if msg != nil {
+				msgs = append(msgs, msg)
 			}
 		}
 	}
@@ -237,11 +230,11 @@ func (storage *GroupStorage) loadRangeMessages(gid int64, minid int64, maxid int
 	return msgs
 }
 
-func (storage *GroupStorage) LoadRangeMessages(gid int64, minid int64, maxid int64) []*Message {
+func (storage *GroupStorage) LoadRangeMessages(appid int64, gid int64, minid int64, maxid int64) []*Message {
 	storage.mutex.Lock()
 	defer storage.mutex.Unlock()
 	
-	return storage.loadRangeMessages(gid, minid, maxid)
+	return storage.loadRangeMessages(appid, gid, minid, maxid)
 }
 
 func (storage *GroupStorage) LoadGroupOfflineMessage(appid int64, gid int64, uid int64, device_id int64) []*EMessage {
@@ -252,7 +245,7 @@ func (storage *GroupStorage) LoadGroupOfflineMessage(appid int64, gid int64, uid
 	}
 
 	last_received_id, _ := storage.GetLastGroupReceivedID(appid, gid, uid, device_id)
-	msgs := storage.LoadRangeMessages(gid, last_received_id, last_id)
+	msgs := storage.LoadRangeMessages(appid, gid, last_received_id, last_id)
 
 	c := make([]*EMessage, 0, 10)
 	for _, msg := range msgs {
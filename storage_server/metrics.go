@@ -0,0 +1,93 @@
+/**
+ * Copyright (c) 2014-2015, GoBelieve
+ * All rights reserved.
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 59 Temple Place, Suite 330, Boston, MA  02111-1307  USA
+ */
+
+package main
+
+import "net/http"
+import "strconv"
+import "time"
+import log "github.com/golang/glog"
+import "github.com/prometheus/client_golang/prometheus"
+import "github.com/prometheus/client_golang/prometheus/promhttp"
+
+var (
+	metric_messages_in = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ims_messages_in_total",
+		Help: "Requests received from im servers.",
+	}, []string{"cmd"})
+
+	metric_messages_out = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ims_messages_out_total",
+		Help: "Messages published to route servers.",
+	}, []string{"cmd"})
+
+	//包括在分片队列中等待的时间
+	metric_save_duration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ims_save_duration_seconds",
+		Help:    "Latency of saving a message, per shard.",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+	}, []string{"shard", "type"})
+
+	desc_route_clients = prometheus.NewDesc("ims_route_clients",
+		"Registered route channels of im servers.", nil, nil)
+)
+
+func init() {
+	prometheus.MustRegister(metric_messages_in)
+	prometheus.MustRegister(metric_messages_out)
+	prometheus.MustRegister(metric_save_duration)
+	prometheus.MustRegister(&ClientCollector{})
+}
+
+func CommandName(cmd int) string {
+	if desc, ok := message_descriptions[cmd]; ok {
+		return desc
+	}
+	return "unknown"
+}
+
+func ObserveSave(id int64, t string, begin time.Time) {
+	shard := strconv.FormatInt(id % GROUP_C_COUNT, 10)
+	metric_save_duration.WithLabelValues(shard, t).Observe(time.Since(begin).Seconds())
+}
+
+type ClientCollector struct {
+}
+
+func (c *ClientCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- desc_route_clients
+}
+
+func (c *ClientCollector) Collect(ch chan<- prometheus.Metric) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(desc_route_clients, prometheus.GaugeValue, float64(len(route_clients)))
+}
+
+func StartHttpServer(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	log.Infof("Serving on http://%s/", addr)
+	err := http.ListenAndServe(addr, mux)
+	if err != nil {
+		log.Error("http server error:", err)
+	}
+}
@@ -20,7 +20,6 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"sync"
 )
@@ -41,7 +40,8 @@ func NewPeerStorage(ots2_client *ots2.OTSClient) *PeerStorage {
 	return storage
 }
 
-func (storage *PeerStorage) saveMessage(msg *Message) int64 {
+//消息保存在uid的消息队列, 发送者自己的副本receiver不是uid
+func (storage *PeerStorage) saveMessage(appid int64, uid int64, msg *Message) int64 {
 	m := msg.body.(*IMMessage)
 	
 	msgid, err := iw.NextId()
@@ -52,21 +52,16 @@ func (storage *PeerStorage) saveMessage(msg *Message) int64 {
 	m.msgid = msgid
 	
 	primaryKey := &OTSPrimaryKey{
-		"uid" : m.receiver,
+		"appid" : appid,
+		"uid" : uid,
 		"msgid" : m.msgid,
 	}
 	
-	bs, err := json.Marshal(*m)
-	if err != nil {
-		log.Infoln(err)
-		return 0
-	}
-	
 	attributeColumns := &OTSAttribute{
 		"cmd" : msg.cmd,
 		"seq" : msg.seq,
 		"version" : msg.version,
-		"body" : string(bs),
+		"body" : EncodeMessageBody(msg),
 	}
 	
 	condition := OTSCondition_EXPECT_NOT_EXIST
@@ -79,7 +74,7 @@ func (storage *PeerStorage) SavePeerMessage(appid int64, uid int64, device_id in
 	storage.mutex.Lock()
 	defer storage.mutex.Unlock()
 	//写入message
-	msgid := storage.saveMessage(msg)
+	msgid := storage.saveMessage(appid, uid, msg)
 
 	//设置用户最近一条消息id
 	storage.setLastMessageID(appid, uid, msgid)
@@ -88,6 +83,7 @@ func (storage *PeerStorage) SavePeerMessage(appid int64, uid int64, device_id in
 
 func (storage *PeerStorage) getLastMessageID(appid int64, receiver int64) (int64, error) {
 	primaryKey := &OTSPrimaryKey{
+		"appid" : appid,
 		"uid" : receiver,
 	}
 	
@@ -120,6 +116,7 @@ func (storage *PeerStorage) GetLastMessageID(appid int64, receiver int64) (int64
 
 func (storage *PeerStorage) setLastMessageID(appid int64, receiver int64, msgid int64) {
 	primaryKey := &OTSPrimaryKey{
+		"appid" : appid,
 		"uid" : receiver,
 	}
 	
@@ -141,6 +138,7 @@ func (storage *PeerStorage) SetLastMessageID(appid int64, receiver int64, msgid
 
 func (storage *PeerStorage) setLastReceivedID(appid int64, uid int64, did int64, msgid int64) {
 	primaryKey := &OTSPrimaryKey{
+		"appid" : appid,
 		"uid" : uid,
 		"deviceid" : did,
 	}
@@ -163,6 +161,7 @@ func (storage *PeerStorage) SetLastReceivedID(appid int64, uid int64, did int64,
 
 func (storage *PeerStorage) getLastReceivedID(appid int64, uid int64, did int64) (int64, error) {
 	primaryKey := &OTSPrimaryKey{
+		"appid" : appid,
 		"uid" : uid,
 		"deviceid" : did,
 	}
@@ -197,13 +196,15 @@ func (storage *PeerStorage) GetLastReceivedID(appid int64, uid int64, did int64)
 	return storage.getLastReceivedID(appid, uid, did)
 }
 
-func (storage *PeerStorage) loadRangeMessages(uid int64, minid int64, maxid int64) []*Message {
+func (storage *PeerStorage) loadRangeMessages(appid int64, uid int64, minid int64, maxid int64) []*Message {
 	startPrimaryKey := &OTSPrimaryKey{
+		"appid" : appid,
 		"uid" : uid,
 		"msgid" : minid,
 	}
 	
 	endPrimaryKey := &OTSPrimaryKey{
+		"appid" : appid,
 		"uid" : uid,
 		"msgid" : maxid+1,
 	}
@@ -231,16 +232,9 @@ func (storage *PeerStorage) loadRangeMessages(uid int64, minid int64, maxid int6
 			version := attributeColumns.Get("version").(int)
 			body := attributeColumns.Get("body").(string)
 			
-			immsg := IMMessage{}
-			err := json.Unmarshal([]byte(body), &immsg)
-			if err == nil {
-				msg := Message{}
-				msg.cmd = cmd
-				msg.seq = seq
-				msg.version = version
-				msg.body = &immsg
-				
-				msgs = append(msgs, &msg)
+			msg := DecodeMessageBody(cmd, seq, version, body)
+			if msg != nil {
+				msgs = append(msgs, msg)
 			}
 		}
 	}
@@ -248,24 +242,27 @@ func (storage *PeerStorage) loadRangeMessages(uid int64, minid int64, maxid int6
 	return msgs
 }
 
-func (storage *PeerStorage) LoadRangeMessages(uid int64, minid int64, maxid int64) []*Message {
+func (storage *PeerStorage) LoadRangeMessages(appid int64, uid int64, minid int64, maxid int64) []*Message {
 	storage.mutex.Lock()
 	defer storage.mutex.Unlock()
 	
-	return storage.loadRangeMessages(uid, minid, maxid)
+	return storage.loadRangeMessages(appid, uid, minid, maxid)
 }
 
-//读取离线消息
-func (storage *PeerStorage) LoadOfflineMessage(appid int64, uid int64, did int64) []*EMessage {
+//读取离线消息, last_msgid是恢复会话时客户端最后ack的消息, ack可能还没有写入
+func (storage *PeerStorage) LoadOfflineMessage(appid int64, uid int64, did int64, last_msgid int64) []*EMessage {
 	last_id, err := storage.GetLastMessageID(appid, uid)
 	if err != nil {
 		return nil
 	}
 
 	last_received_id, _ := storage.GetLastReceivedID(appid, uid, did)
+	if last_msgid > last_received_id {
+		last_received_id = last_msgid
+	}
 
 	log.Infof("last id:%d last received id:%d", last_id, last_received_id)
-	msgs := storage.LoadRangeMessages(uid, last_received_id, last_id)
+	msgs := storage.LoadRangeMessages(appid, uid, last_received_id, last_id)
 	
 	c := make([]*EMessage, 0, 10)
 	for _, msg := range msgs {
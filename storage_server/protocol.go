@@ -25,6 +25,11 @@ import "encoding/binary"
 import log "github.com/golang/glog"
 import "fmt"
 import "errors"
+import "io/ioutil"
+import "compress/flate"
+import "im_service/common"
+import "encoding/json"
+import "encoding/base64"
 
 //deprecated
 const MSG_HEARTBEAT = 1
@@ -72,6 +77,19 @@ const MSG_TRANSMIT_USER = 24
 const MSG_TRANSMIT_GROUP = 25
 const MSG_TRANSMIT_ROOM = 26
 
+//服务器踢出连接,客户端收到后连接被关闭
+const MSG_KICK = 27
+
+//超过帧长度的消息拆分成多个分片
+const MSG_FRAGMENT = 28
+
+//服务器下线前通知客户端重连, 客户端收到后连接被关闭
+const MSG_RECONNECT = 29
+
+//聊天室最近的消息, 进入聊天室时发送, 也可以分页查询
+const MSG_ROOM_HISTORY_QUERY = 30
+const MSG_ROOM_HISTORY = 31
+
 const MSG_VOIP_CONTROL = 64
 
 //路由服务器消息
@@ -91,6 +109,12 @@ const MSG_PUBLISH_ROOM = 138
 const MSG_SERVER_REGISTER = 139
 const MSG_SERVER_REGISTER_STORAGE = 140
 
+//同一条群组/聊天室消息发送给一台im服务器上的多个用户
+const MSG_PUBLISH_MULTI = 141
+
+//route_server之间转发, 由连接目标im服务器的route节点投递
+const MSG_ROUTE_FORWARD = 142
+
 //存储服务器消息
 const MSG_SAVE_AND_ENQUEUE = 200
 const MSG_DEQUEUE = 201
@@ -146,10 +170,46 @@ const MSG_GROUP_QUIT_RESP = 10309
 const MSG_GROUP_DEL = 10310 //解散
 const MSG_GROUP_DEL_RESP = 10311
 
+//消息ack的状态
+const ACK_SUCCESS = 0
+const ACK_REJECTED = 1 //包含敏感内容,消息未发送
+const ACK_MASKED = 2   //敏感内容被替换后发送
+const ACK_FLAGGED = 3  //消息已发送,等待人工审核
+const ACK_THROTTLED = 4 //发送过快,消息被丢弃
+
+//版本号不小于这个值的客户端才能解析ack中的status
+const ACK_STATUS_VERSION = 2
+
+//被踢下线的原因
+const KICK_REASON_API = 0       //后台接口踢出
+const KICK_REASON_LOGIN = 1     //其它设备登录
+const KICK_REASON_THROTTLE = 2  //多次超过限流
+
+//客户端能力, 认证时协商, 旧版本客户端为0
+const CAP_SYNC = 1 << 0        //消息同步
+const CAP_RECALL = 1 << 1      //消息撤回
+const CAP_RECEIPT = 1 << 2     //已读回执
+const CAP_COMPRESSION = 1 << 3 //消息压缩
+const CAP_KICK = 1 << 4        //MSG_KICK
+const CAP_ACK_STATUS = 1 << 5  //MessageACK中的status
+const CAP_FRAGMENT = 1 << 6    //MSG_FRAGMENT
+const CAP_RESUME = 1 << 7      //断线重连时恢复会话
+const CAP_RECONNECT = 1 << 8   //MSG_RECONNECT
+const CAP_ROOM_HISTORY = 1 << 9 //MSG_ROOM_HISTORY
+
+//消息帧编码后的最大长度, 包括头部
+const MAX_FRAME_SIZE = 32*1024
+
+//服务器之间消息的最大长度
+const MAX_SERVER_MESSAGE_SIZE = 16*1024*1024
+
+//消息头部第14个字节的标志位
+const MESSAGE_FLAG_COMPRESSED = 0x01 //消息体使用deflate压缩
+
 //平台号
-const PLATFORM_IOS = 1
-const PLATFORM_ANDROID = 2
-const PLATFORM_WEB = 3
+const PLATFORM_IOS = common.PLATFORM_IOS
+const PLATFORM_ANDROID = common.PLATFORM_ANDROID
+const PLATFORM_WEB = common.PLATFORM_WEB
 
 const DEFAULT_VERSION = 1
 
@@ -196,6 +256,11 @@ func init() {
 	message_creators[MSG_AUTH_TOKEN] = func() IMessage { return new(AuthenticationToken) }
 
 	message_creators[MSG_LOGIN_POINT] = func() IMessage { return new(LoginPoint) }
+	message_creators[MSG_KICK] = func() IMessage { return new(Kick) }
+	message_creators[MSG_FRAGMENT] = func() IMessage { return new(Fragment) }
+	message_creators[MSG_RECONNECT] = func() IMessage { return new(Reconnect) }
+	message_creators[MSG_ROOM_HISTORY_QUERY] = func() IMessage { return new(RoomHistoryQuery) }
+	message_creators[MSG_ROOM_HISTORY] = func() IMessage { return new(RoomHistory) }
 	message_creators[MSG_RT] = func() IMessage { return new(RTMessage) }
 	message_creators[MSG_ENTER_ROOM] = func() IMessage { return new(Room) }
 	message_creators[MSG_LEAVE_ROOM] = func() IMessage { return new(Room) }
@@ -228,6 +293,8 @@ func init() {
 	
 	message_creators[MSG_SERVER_REGISTER] = func() IMessage { return new(ServerID) }
 	message_creators[MSG_SERVER_REGISTER_STORAGE] = func() IMessage { return new(ServerID) }
+	message_creators[MSG_PUBLISH_MULTI] = func() IMessage { return new(MultiAppMessage) }
+	message_creators[MSG_ROUTE_FORWARD] = func() IMessage { return new(RouteForward) }
 	
 	message_creators[MSG_CONTACT_ACCEPT] = func() IMessage { return new(ContactAccept) }
 	message_creators[MSG_CONTACT_ACCEPT_RESP] = func() IMessage { return new(ContactAcceptResp) }
@@ -329,6 +396,11 @@ func init() {
 	message_descriptions[MSG_TRANSMIT_USER] = "MSG_TRANSMIT_USER"
 	message_descriptions[MSG_TRANSMIT_GROUP] = "MSG_TRANSMIT_GROUP"
 	message_descriptions[MSG_TRANSMIT_ROOM] = "MSG_TRANSMIT_ROOM"
+	message_descriptions[MSG_KICK] = "MSG_KICK"
+	message_descriptions[MSG_FRAGMENT] = "MSG_FRAGMENT"
+	message_descriptions[MSG_RECONNECT] = "MSG_RECONNECT"
+	message_descriptions[MSG_ROOM_HISTORY_QUERY] = "MSG_ROOM_HISTORY_QUERY"
+	message_descriptions[MSG_ROOM_HISTORY] = "MSG_ROOM_HISTORY"
 	
 	message_descriptions[MSG_CONTACT_ACCEPT] = "MSG_CONTACT_ACCEPT"
 	message_descriptions[MSG_CONTACT_ACCEPT_RESP] = "MSG_CONTACT_ACCEPT_RESP"
@@ -356,6 +428,8 @@ func init() {
 	message_descriptions[MSG_GROUP_DEL] = "MSG_GROUP_DEL"
 	message_descriptions[MSG_GROUP_DEL_RESP] = "MSG_GROUP_DEL_RESP"
 	message_descriptions[MSG_SERVER_REGISTER_STORAGE] = "MSG_SERVER_REGISTER_STORAGE"
+	message_descriptions[MSG_PUBLISH_MULTI] = "MSG_PUBLISH_MULTI"
+	message_descriptions[MSG_ROUTE_FORWARD] = "MSG_ROUTE_FORWARD"
 }
 
 type Command int
@@ -379,6 +453,145 @@ type IVersionMessage interface {
 	FromData(version int, buff []byte) bool
 }
 
+//客户端使用的json编码, 字段名和结构体的字段名相同
+type IJSONMessage interface {
+	ToJSON() interface{}
+	FromJSON(data interface{}) error
+}
+
+//数字需要用json.Decoder.UseNumber解析, 缺少的字段为零值
+func ParseJSONInt(v interface{}, bits uint) (int64, error) {
+	if v == nil {
+		return 0, nil
+	}
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, errors.New("invalid number")
+	}
+	i, err := n.Int64()
+	if err != nil {
+		return 0, err
+	}
+	if bits < 64 {
+		limit := int64(1) << (bits - 1)
+		if i < -limit || i >= limit {
+			return 0, fmt.Errorf("number overflow:%d", i)
+		}
+	}
+	return i, nil
+}
+
+//nil编码为空数组
+func JSONInt64s(a []int64) []int64 {
+	if a == nil {
+		return []int64{}
+	}
+	return a
+}
+
+//读取json对象的字段, 只保留第一个错误
+type JSONReader struct {
+	obj map[string]interface{}
+	err error
+}
+
+func NewJSONReader(data interface{}) *JSONReader {
+	if data == nil {
+		return &JSONReader{}
+	}
+	obj, ok := data.(map[string]interface{})
+	if !ok {
+		return &JSONReader{err:errors.New("expect object")}
+	}
+	return &JSONReader{obj:obj}
+}
+
+func (r *JSONReader) Error() error {
+	return r.err
+}
+
+func (r *JSONReader) fail(name string, err error) {
+	if r.err == nil {
+		r.err = fmt.Errorf("%s: %s", name, err)
+	}
+}
+
+func (r *JSONReader) Int(name string, bits uint) int64 {
+	if r.err != nil {
+		return 0
+	}
+	n, err := ParseJSONInt(r.obj[name], bits)
+	if err != nil {
+		r.fail(name, err)
+		return 0
+	}
+	return n
+}
+
+func (r *JSONReader) String(name string) string {
+	if r.err != nil || r.obj[name] == nil {
+		return ""
+	}
+	s, ok := r.obj[name].(string)
+	if !ok {
+		r.fail(name, errors.New("expect string"))
+	}
+	return s
+}
+
+func (r *JSONReader) Bool(name string) bool {
+	if r.err != nil || r.obj[name] == nil {
+		return false
+	}
+	b, ok := r.obj[name].(bool)
+	if !ok {
+		r.fail(name, errors.New("expect bool"))
+	}
+	return b
+}
+
+//[]byte编码为base64字符串
+func (r *JSONReader) Bytes(name string) []byte {
+	s := r.String(name)
+	if r.err != nil || len(s) == 0 {
+		return nil
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		r.fail(name, err)
+		return nil
+	}
+	return b
+}
+
+func (r *JSONReader) Array(name string) []interface{} {
+	if r.err != nil || r.obj[name] == nil {
+		return nil
+	}
+	array, ok := r.obj[name].([]interface{})
+	if !ok {
+		r.fail(name, errors.New("expect array"))
+	}
+	return array
+}
+
+func (r *JSONReader) Int64s(name string) []int64 {
+	array := r.Array(name)
+	if r.err != nil {
+		return nil
+	}
+	a := make([]int64, 0, len(array))
+	for _, v := range array {
+		n, err := ParseJSONInt(v, 64)
+		if err != nil {
+			r.fail(name, err)
+			return nil
+		}
+		a = append(a, n)
+	}
+	return a
+}
+
 type Message struct {
 	cmd     int
 	seq     int
@@ -457,7 +670,7 @@ func (emsg *EMessage) ToData() []byte {
 	mbuffer := new(bytes.Buffer)
 	WriteMessage(mbuffer, emsg.msg)
 	msg_buf := mbuffer.Bytes()
-	var l int16 = int16(len(msg_buf))
+	var l int32 = int32(len(msg_buf))
 	binary.Write(buffer, binary.BigEndian, l)
 	buffer.Write(msg_buf)
 	buf := buffer.Bytes()
@@ -466,16 +679,16 @@ func (emsg *EMessage) ToData() []byte {
 }
 
 func (emsg *EMessage) FromData(buff []byte) bool {
-	if len(buff) < 18 {
+	if len(buff) < 20 {
 		return false
 	}
 
 	buffer := bytes.NewBuffer(buff)
 	binary.Read(buffer, binary.BigEndian, &emsg.msgid)
 	binary.Read(buffer, binary.BigEndian, &emsg.device_id)
-	var l int16
+	var l int32
 	binary.Read(buffer, binary.BigEndian, &l)
-	if int(l) > buffer.Len() {
+	if l < 0 || int(l) > buffer.Len() {
 		return false
 	}
 
@@ -483,7 +696,7 @@ func (emsg *EMessage) FromData(buff []byte) bool {
 	buffer.Read(msg_buf)
 	mbuffer := bytes.NewBuffer(msg_buf)
 	//recusive
-	msg := ReceiveMessage(mbuffer)
+	msg := ReceiveServerMessage(mbuffer)
 	if msg == nil {
 		return false
 	}
@@ -527,7 +740,7 @@ func (batch *MessageBatch) FromData(buff []byte) bool {
 
 	batch.msgs = make([]*Message, 0, count)
 	for i := 0; i < int(count); i++ {
-		msg := ReceiveMessage(buffer)
+		msg := ReceiveServerMessage(buffer)
 		if msg == nil {
 			return false
 		}
@@ -723,7 +936,7 @@ func (sae *SAEMessage) ToData() []byte {
 	mbuffer := new(bytes.Buffer)
 	WriteMessage(mbuffer, sae.msg)
 	msg_buf := mbuffer.Bytes()
-	var l int16 = int16(len(msg_buf))
+	var l int32 = int32(len(msg_buf))
 	binary.Write(buffer, binary.BigEndian, l)
 	buffer.Write(msg_buf)
 
@@ -740,9 +953,9 @@ func (sae *SAEMessage) FromData(buff []byte) bool {
 	}
 
 	buffer := bytes.NewBuffer(buff)
-	var l int16
+	var l int32
 	binary.Read(buffer, binary.BigEndian, &l)
-	if int(l) > buffer.Len() {
+	if l < 0 || int(l) > buffer.Len() {
 		return false
 	}
 
@@ -750,7 +963,7 @@ func (sae *SAEMessage) FromData(buff []byte) bool {
 	buffer.Read(msg_buf)
 	mbuffer := bytes.NewBuffer(msg_buf)
 	//recusive
-	msg := ReceiveMessage(mbuffer)
+	msg := ReceiveServerMessage(mbuffer)
 	if msg == nil {
 		return false
 	}
@@ -818,13 +1031,19 @@ type LoadOffline struct {
 	appid  int64
 	uid    int64
 	device_id int64
+	//恢复会话时客户端最后ack的消息, 只加载之后的消息
+	last_msgid int64
 }
 
+//last_msgid为0时和旧版本保持一致,不写入
 func (lo *LoadOffline) ToData() []byte {
 	buffer := new(bytes.Buffer)
 	binary.Write(buffer, binary.BigEndian, lo.appid)
 	binary.Write(buffer, binary.BigEndian, lo.uid)
 	binary.Write(buffer, binary.BigEndian, lo.device_id)
+	if lo.last_msgid > 0 {
+		binary.Write(buffer, binary.BigEndian, lo.last_msgid)
+	}
 	buf := buffer.Bytes()
 	return buf
 }
@@ -837,6 +1056,9 @@ func (lo *LoadOffline) FromData(buff []byte) bool {
 	binary.Read(buffer, binary.BigEndian, &lo.appid)
 	binary.Read(buffer, binary.BigEndian, &lo.uid)
 	binary.Read(buffer, binary.BigEndian, &lo.device_id)
+	if buffer.Len() >= 8 {
+		binary.Read(buffer, binary.BigEndian, &lo.last_msgid)
+	}
 	return true
 }
 
@@ -912,6 +1134,22 @@ func (rt *RTMessage) FromData(buff []byte) bool {
 	return true
 }
 
+func (rt *RTMessage) ToJSON() interface{} {
+	obj := make(map[string]interface{})
+	obj["sender"] = rt.sender
+	obj["receiver"] = rt.receiver
+	obj["content"] = rt.content
+	return obj
+}
+
+func (rt *RTMessage) FromJSON(data interface{}) error {
+	r := NewJSONReader(data)
+	rt.sender = r.Int("sender", 64)
+	rt.receiver = r.Int("receiver", 64)
+	rt.content = r.String("content")
+	return r.Error()
+}
+
 type IMMessage struct {
 	sender    int64
 	receiver  int64
@@ -982,6 +1220,26 @@ func (im *IMMessage) FromData(version int, buff []byte) bool {
 	}
 }
 
+func (im *IMMessage) ToJSON() interface{} {
+	obj := make(map[string]interface{})
+	obj["sender"] = im.sender
+	obj["receiver"] = im.receiver
+	obj["timestamp"] = im.timestamp
+	obj["msgid"] = im.msgid
+	obj["content"] = im.content
+	return obj
+}
+
+func (im *IMMessage) FromJSON(data interface{}) error {
+	r := NewJSONReader(data)
+	im.sender = r.Int("sender", 64)
+	im.receiver = r.Int("receiver", 64)
+	im.timestamp = int32(r.Int("timestamp", 32))
+	im.msgid = r.Int("msgid", 64)
+	im.content = r.String("content")
+	return r.Error()
+}
+
 type Authentication struct {
 	uid int64
 }
@@ -1002,10 +1260,32 @@ func (auth *Authentication) FromData(buff []byte) bool {
 	return true
 }
 
+func (auth *Authentication) ToJSON() interface{} {
+	obj := make(map[string]interface{})
+	obj["uid"] = auth.uid
+	return obj
+}
+
+func (auth *Authentication) FromJSON(data interface{}) error {
+	r := NewJSONReader(data)
+	auth.uid = r.Int("uid", 64)
+	return r.Error()
+}
+
 type AuthenticationToken struct {
 	token       string
 	platform_id int8
 	device_id   string
+
+	//旧版本客户端没有以下字段
+	capabilities   int32
+	max_frame_size int32
+	//上次认证返回的会话, 为空时不写入
+	resume_token   string
+	//token所属的appid, 为0时不写入, 服务器按token查找appid
+	appid          int64
+	//离线推送使用的设备token, 为空时不写入, 长度用int16表示
+	device_token   string
 }
 
 func (auth *AuthenticationToken) ToData() []byte {
@@ -1022,6 +1302,22 @@ func (auth *AuthenticationToken) ToData() []byte {
 	binary.Write(buffer, binary.BigEndian, l)
 	buffer.Write([]byte(auth.device_id))
 
+	binary.Write(buffer, binary.BigEndian, auth.capabilities)
+	binary.Write(buffer, binary.BigEndian, auth.max_frame_size)
+
+	if len(auth.resume_token) > 0 || auth.appid != 0 || len(auth.device_token) > 0 {
+		l = int8(len(auth.resume_token))
+		binary.Write(buffer, binary.BigEndian, l)
+		buffer.Write([]byte(auth.resume_token))
+	}
+	if auth.appid != 0 || len(auth.device_token) > 0 {
+		binary.Write(buffer, binary.BigEndian, auth.appid)
+	}
+	if len(auth.device_token) > 0 {
+		binary.Write(buffer, binary.BigEndian, int16(len(auth.device_token)))
+		buffer.Write([]byte(auth.device_token))
+	}
+
 	buf := buffer.Bytes()
 	return buf
 }
@@ -1049,14 +1345,76 @@ func (auth *AuthenticationToken) FromData(buff []byte) bool {
 	device_id := make([]byte, l)
 	buffer.Read(device_id)
 
+	if buffer.Len() >= 8 {
+		binary.Read(buffer, binary.BigEndian, &auth.capabilities)
+		binary.Read(buffer, binary.BigEndian, &auth.max_frame_size)
+	}
+
+	if buffer.Len() >= 1 {
+		binary.Read(buffer, binary.BigEndian, &l)
+		if int(l) > buffer.Len() || int(l) < 0 {
+			return false
+		}
+		resume_token := make([]byte, l)
+		buffer.Read(resume_token)
+		auth.resume_token = string(resume_token)
+	}
+
+	if buffer.Len() >= 8 {
+		binary.Read(buffer, binary.BigEndian, &auth.appid)
+	}
+
+	if buffer.Len() >= 2 {
+		var n int16
+		binary.Read(buffer, binary.BigEndian, &n)
+		if int(n) > buffer.Len() || int(n) < 0 {
+			return false
+		}
+		device_token := make([]byte, n)
+		buffer.Read(device_token)
+		auth.device_token = string(device_token)
+	}
+
 	auth.token = string(token)
 	auth.device_id = string(device_id)
 	return true
 }
 
+func (auth *AuthenticationToken) ToJSON() interface{} {
+	obj := make(map[string]interface{})
+	obj["token"] = auth.token
+	obj["platform_id"] = auth.platform_id
+	obj["device_id"] = auth.device_id
+	obj["capabilities"] = auth.capabilities
+	obj["max_frame_size"] = auth.max_frame_size
+	obj["resume_token"] = auth.resume_token
+	obj["appid"] = auth.appid
+	obj["device_token"] = auth.device_token
+	return obj
+}
+
+func (auth *AuthenticationToken) FromJSON(data interface{}) error {
+	r := NewJSONReader(data)
+	auth.token = r.String("token")
+	auth.platform_id = int8(r.Int("platform_id", 8))
+	auth.device_id = r.String("device_id")
+	auth.capabilities = int32(r.Int("capabilities", 32))
+	auth.max_frame_size = int32(r.Int("max_frame_size", 32))
+	auth.resume_token = r.String("resume_token")
+	auth.appid = r.Int("appid", 64)
+	auth.device_token = r.String("device_token")
+	return r.Error()
+}
+
 type AuthenticationStatus struct {
 	status int32
 	ip     int32 //兼容版本0
+
+	//服务器接受的能力, 都为0时不写入, 和旧版本保持一致
+	capabilities   int32
+	max_frame_size int32
+	//断线后可以用来恢复会话, 客户端支持CAP_RESUME时才有
+	resume_token   string
 }
 
 func (auth *AuthenticationStatus) ToData(version int) []byte {
@@ -1065,6 +1423,14 @@ func (auth *AuthenticationStatus) ToData(version int) []byte {
 	if version == 0 {
 		binary.Write(buffer, binary.BigEndian, auth.ip)
 	}
+	if auth.capabilities != 0 || auth.max_frame_size != 0 || len(auth.resume_token) > 0 {
+		binary.Write(buffer, binary.BigEndian, auth.capabilities)
+		binary.Write(buffer, binary.BigEndian, auth.max_frame_size)
+	}
+	if len(auth.resume_token) > 0 {
+		binary.Write(buffer, binary.BigEndian, int8(len(auth.resume_token)))
+		buffer.Write([]byte(auth.resume_token))
+	}
 	buf := buffer.Bytes()
 	return buf
 }
@@ -1081,9 +1447,43 @@ func (auth *AuthenticationStatus) FromData(version int, buff []byte) bool {
 		}
 		binary.Read(buffer, binary.BigEndian, &auth.ip)
 	}
+	if buffer.Len() >= 8 {
+		binary.Read(buffer, binary.BigEndian, &auth.capabilities)
+		binary.Read(buffer, binary.BigEndian, &auth.max_frame_size)
+	}
+	if buffer.Len() >= 1 {
+		var l int8
+		binary.Read(buffer, binary.BigEndian, &l)
+		if int(l) > buffer.Len() || int(l) < 0 {
+			return false
+		}
+		resume_token := make([]byte, l)
+		buffer.Read(resume_token)
+		auth.resume_token = string(resume_token)
+	}
 	return true
 }
 
+func (auth *AuthenticationStatus) ToJSON() interface{} {
+	obj := make(map[string]interface{})
+	obj["status"] = auth.status
+	obj["ip"] = auth.ip
+	obj["capabilities"] = auth.capabilities
+	obj["max_frame_size"] = auth.max_frame_size
+	obj["resume_token"] = auth.resume_token
+	return obj
+}
+
+func (auth *AuthenticationStatus) FromJSON(data interface{}) error {
+	r := NewJSONReader(data)
+	auth.status = int32(r.Int("status", 32))
+	auth.ip = int32(r.Int("ip", 32))
+	auth.capabilities = int32(r.Int("capabilities", 32))
+	auth.max_frame_size = int32(r.Int("max_frame_size", 32))
+	auth.resume_token = r.String("resume_token")
+	return r.Error()
+}
+
 type LoginPoint struct {
 	up_timestamp int32
 	platform_id  int8
@@ -1111,13 +1511,342 @@ func (point *LoginPoint) FromData(buff []byte) bool {
 	return true
 }
 
+func (point *LoginPoint) ToJSON() interface{} {
+	obj := make(map[string]interface{})
+	obj["up_timestamp"] = point.up_timestamp
+	obj["platform_id"] = point.platform_id
+	obj["device_id"] = point.device_id
+	return obj
+}
+
+func (point *LoginPoint) FromJSON(data interface{}) error {
+	r := NewJSONReader(data)
+	point.up_timestamp = int32(r.Int("up_timestamp", 32))
+	point.platform_id = int8(r.Int("platform_id", 8))
+	point.device_id = r.String("device_id")
+	return r.Error()
+}
+
+//消息体为空时踢出用户的所有设备
+type Kick struct {
+	reason      int8
+	platform_id int8
+	device_id   string
+}
+
+func (kick *Kick) ToData() []byte {
+	buffer := new(bytes.Buffer)
+	binary.Write(buffer, binary.BigEndian, kick.reason)
+	binary.Write(buffer, binary.BigEndian, kick.platform_id)
+	buffer.Write([]byte(kick.device_id))
+	buf := buffer.Bytes()
+	return buf
+}
+
+func (kick *Kick) FromData(buff []byte) bool {
+	if len(buff) == 0 {
+		return true
+	}
+	if len(buff) < 2 {
+		return false
+	}
+
+	buffer := bytes.NewBuffer(buff)
+	binary.Read(buffer, binary.BigEndian, &kick.reason)
+	binary.Read(buffer, binary.BigEndian, &kick.platform_id)
+	kick.device_id = string(buff[2:])
+	return true
+}
+
+func (kick *Kick) ToJSON() interface{} {
+	obj := make(map[string]interface{})
+	obj["reason"] = kick.reason
+	obj["platform_id"] = kick.platform_id
+	obj["device_id"] = kick.device_id
+	return obj
+}
+
+func (kick *Kick) FromJSON(data interface{}) error {
+	r := NewJSONReader(data)
+	kick.reason = int8(r.Int("reason", 8))
+	kick.platform_id = int8(r.Int("platform_id", 8))
+	kick.device_id = r.String("device_id")
+	return r.Error()
+}
+
+//一个完整的消息帧按顺序拆分, 同一个id的分片全部收到后拼接成原来的帧
+type Fragment struct {
+	id    int32
+	index int16
+	count int16
+	data  []byte
+}
+
+func (f *Fragment) ToData() []byte {
+	buffer := new(bytes.Buffer)
+	binary.Write(buffer, binary.BigEndian, f.id)
+	binary.Write(buffer, binary.BigEndian, f.index)
+	binary.Write(buffer, binary.BigEndian, f.count)
+	buffer.Write(f.data)
+	buf := buffer.Bytes()
+	return buf
+}
+
+func (f *Fragment) FromData(buff []byte) bool {
+	if len(buff) < 8 {
+		return false
+	}
+
+	buffer := bytes.NewBuffer(buff)
+	binary.Read(buffer, binary.BigEndian, &f.id)
+	binary.Read(buffer, binary.BigEndian, &f.index)
+	binary.Read(buffer, binary.BigEndian, &f.count)
+	f.data = buff[8:]
+	return true
+}
+
+func (f *Fragment) ToJSON() interface{} {
+	obj := make(map[string]interface{})
+	obj["id"] = f.id
+	obj["index"] = f.index
+	obj["count"] = f.count
+	obj["data"] = base64.StdEncoding.EncodeToString(f.data)
+	return obj
+}
+
+func (f *Fragment) FromJSON(data interface{}) error {
+	r := NewJSONReader(data)
+	f.id = int32(r.Int("id", 32))
+	f.index = int16(r.Int("index", 16))
+	f.count = int16(r.Int("count", 16))
+	f.data = r.Bytes("data")
+	return r.Error()
+}
+
+//delay是客户端重连前等待的毫秒数, address为空时重连原来的地址
+type Reconnect struct {
+	delay   int32
+	address string
+}
+
+func (r *Reconnect) ToData() []byte {
+	buffer := new(bytes.Buffer)
+	binary.Write(buffer, binary.BigEndian, r.delay)
+	buffer.Write([]byte(r.address))
+	buf := buffer.Bytes()
+	return buf
+}
+
+func (r *Reconnect) FromData(buff []byte) bool {
+	if len(buff) < 4 {
+		return false
+	}
+	buffer := bytes.NewBuffer(buff)
+	binary.Read(buffer, binary.BigEndian, &r.delay)
+	r.address = string(buff[4:])
+	return true
+}
+
+func (r *Reconnect) ToJSON() interface{} {
+	obj := make(map[string]interface{})
+	obj["delay"] = r.delay
+	obj["address"] = r.address
+	return obj
+}
+
+func (r *Reconnect) FromJSON(data interface{}) error {
+	reader := NewJSONReader(data)
+	r.delay = int32(reader.Int("delay", 32))
+	r.address = reader.String("address")
+	return reader.Error()
+}
+
+//before_id为0时从最新的消息开始
+type RoomHistoryQuery struct {
+	room_id   int64
+	before_id int64
+	limit     int32
+}
+
+func (q *RoomHistoryQuery) ToData() []byte {
+	buffer := new(bytes.Buffer)
+	binary.Write(buffer, binary.BigEndian, q.room_id)
+	binary.Write(buffer, binary.BigEndian, q.before_id)
+	binary.Write(buffer, binary.BigEndian, q.limit)
+	buf := buffer.Bytes()
+	return buf
+}
+
+func (q *RoomHistoryQuery) FromData(buff []byte) bool {
+	if len(buff) < 20 {
+		return false
+	}
+	buffer := bytes.NewBuffer(buff)
+	binary.Read(buffer, binary.BigEndian, &q.room_id)
+	binary.Read(buffer, binary.BigEndian, &q.before_id)
+	binary.Read(buffer, binary.BigEndian, &q.limit)
+	return true
+}
+
+func (q *RoomHistoryQuery) ToJSON() interface{} {
+	obj := make(map[string]interface{})
+	obj["room_id"] = q.room_id
+	obj["before_id"] = q.before_id
+	obj["limit"] = q.limit
+	return obj
+}
+
+func (q *RoomHistoryQuery) FromJSON(data interface{}) error {
+	r := NewJSONReader(data)
+	q.room_id = r.Int("room_id", 64)
+	q.before_id = r.Int("before_id", 64)
+	q.limit = int32(r.Int("limit", 32))
+	return r.Error()
+}
+
+type RoomHistoryItem struct {
+	id        int64
+	timestamp int32
+	sender    int64
+	content   string
+}
+
+func (item *RoomHistoryItem) ToData() []byte {
+	buffer := new(bytes.Buffer)
+	binary.Write(buffer, binary.BigEndian, item.id)
+	binary.Write(buffer, binary.BigEndian, item.timestamp)
+	binary.Write(buffer, binary.BigEndian, item.sender)
+	buffer.Write([]byte(item.content))
+	buf := buffer.Bytes()
+	return buf
+}
+
+func (item *RoomHistoryItem) FromData(buff []byte) bool {
+	if len(buff) < 20 {
+		return false
+	}
+	buffer := bytes.NewBuffer(buff)
+	binary.Read(buffer, binary.BigEndian, &item.id)
+	binary.Read(buffer, binary.BigEndian, &item.timestamp)
+	binary.Read(buffer, binary.BigEndian, &item.sender)
+	item.content = string(buff[20:])
+	return true
+}
+
+func (item *RoomHistoryItem) ToJSON() interface{} {
+	obj := make(map[string]interface{})
+	obj["id"] = item.id
+	obj["timestamp"] = item.timestamp
+	obj["sender"] = item.sender
+	obj["content"] = item.content
+	return obj
+}
+
+func (item *RoomHistoryItem) FromJSON(data interface{}) error {
+	r := NewJSONReader(data)
+	item.id = r.Int("id", 64)
+	item.timestamp = int32(r.Int("timestamp", 32))
+	item.sender = r.Int("sender", 64)
+	item.content = r.String("content")
+	return r.Error()
+}
+
+//messages按照id从新到旧排列, more表示还有更早的消息
+type RoomHistory struct {
+	room_id  int64
+	more     bool
+	messages []*RoomHistoryItem
+}
+
+func (h *RoomHistory) ToData() []byte {
+	buffer := new(bytes.Buffer)
+	binary.Write(buffer, binary.BigEndian, h.room_id)
+	binary.Write(buffer, binary.BigEndian, h.more)
+	var count int32 = int32(len(h.messages))
+	binary.Write(buffer, binary.BigEndian, count)
+	for _, item := range h.messages {
+		data := item.ToData()
+		var l int32 = int32(len(data))
+		binary.Write(buffer, binary.BigEndian, l)
+		buffer.Write(data)
+	}
+	buf := buffer.Bytes()
+	return buf
+}
+
+func (h *RoomHistory) FromData(buff []byte) bool {
+	if len(buff) < 13 {
+		return false
+	}
+	buffer := bytes.NewBuffer(buff)
+	binary.Read(buffer, binary.BigEndian, &h.room_id)
+	binary.Read(buffer, binary.BigEndian, &h.more)
+
+	var count int32
+	binary.Read(buffer, binary.BigEndian, &count)
+	if count < 0 || int(count) * 4 > buffer.Len() {
+		return false
+	}
+	h.messages = make([]*RoomHistoryItem, 0, count)
+	for i := 0; i < int(count); i++ {
+		var l int32
+		err := binary.Read(buffer, binary.BigEndian, &l)
+		if err != nil || l < 0 || int(l) > buffer.Len() {
+			return false
+		}
+		item := &RoomHistoryItem{}
+		if !item.FromData(buffer.Next(int(l))) {
+			return false
+		}
+		h.messages = append(h.messages, item)
+	}
+	return true
+}
+
+func (h *RoomHistory) ToJSON() interface{} {
+	messages := make([]interface{}, 0, len(h.messages))
+	for _, item := range h.messages {
+		messages = append(messages, item.ToJSON())
+	}
+	obj := make(map[string]interface{})
+	obj["room_id"] = h.room_id
+	obj["more"] = h.more
+	obj["messages"] = messages
+	return obj
+}
+
+func (h *RoomHistory) FromJSON(data interface{}) error {
+	r := NewJSONReader(data)
+	h.room_id = r.Int("room_id", 64)
+	h.more = r.Bool("more")
+	array := r.Array("messages")
+	if r.Error() != nil {
+		return r.Error()
+	}
+	h.messages = make([]*RoomHistoryItem, 0, len(array))
+	for _, v := range array {
+		item := &RoomHistoryItem{}
+		err := item.FromJSON(v)
+		if err != nil {
+			return fmt.Errorf("messages: %s", err)
+		}
+		h.messages = append(h.messages, item)
+	}
+	return nil
+}
+
 type MessageACK struct {
 	seq int32
+	status int8
 }
 
+//status为0时和旧版本保持一致,不写入
 func (ack *MessageACK) ToData() []byte {
 	buffer := new(bytes.Buffer)
 	binary.Write(buffer, binary.BigEndian, ack.seq)
+	if ack.status != ACK_SUCCESS {
+		binary.Write(buffer, binary.BigEndian, ack.status)
+	}
 	buf := buffer.Bytes()
 	return buf
 }
@@ -1125,9 +1854,26 @@ func (ack *MessageACK) ToData() []byte {
 func (ack *MessageACK) FromData(buff []byte) bool {
 	buffer := bytes.NewBuffer(buff)
 	binary.Read(buffer, binary.BigEndian, &ack.seq)
+	if buffer.Len() > 0 {
+		binary.Read(buffer, binary.BigEndian, &ack.status)
+	}
 	return true
 }
 
+func (ack *MessageACK) ToJSON() interface{} {
+	obj := make(map[string]interface{})
+	obj["seq"] = ack.seq
+	obj["status"] = ack.status
+	return obj
+}
+
+func (ack *MessageACK) FromJSON(data interface{}) error {
+	r := NewJSONReader(data)
+	ack.seq = int32(r.Int("seq", 32))
+	ack.status = int8(r.Int("status", 8))
+	return r.Error()
+}
+
 type MessagePeerACK struct {
 	sender   int64
 	receiver int64
@@ -1154,6 +1900,22 @@ func (ack *MessagePeerACK) FromData(buff []byte) bool {
 	return true
 }
 
+func (ack *MessagePeerACK) ToJSON() interface{} {
+	obj := make(map[string]interface{})
+	obj["sender"] = ack.sender
+	obj["receiver"] = ack.receiver
+	obj["msgid"] = ack.msgid
+	return obj
+}
+
+func (ack *MessagePeerACK) FromJSON(data interface{}) error {
+	r := NewJSONReader(data)
+	ack.sender = r.Int("sender", 64)
+	ack.receiver = r.Int("receiver", 64)
+	ack.msgid = int32(r.Int("msgid", 32))
+	return r.Error()
+}
+
 type MessageInputing struct {
 	sender   int64
 	receiver int64
@@ -1177,6 +1939,20 @@ func (inputing *MessageInputing) FromData(buff []byte) bool {
 	return true
 }
 
+func (inputing *MessageInputing) ToJSON() interface{} {
+	obj := make(map[string]interface{})
+	obj["sender"] = inputing.sender
+	obj["receiver"] = inputing.receiver
+	return obj
+}
+
+func (inputing *MessageInputing) FromJSON(data interface{}) error {
+	r := NewJSONReader(data)
+	inputing.sender = r.Int("sender", 64)
+	inputing.receiver = r.Int("receiver", 64)
+	return r.Error()
+}
+
 type MessageUnreadCount struct {
 	count int32
 }
@@ -1197,6 +1973,18 @@ func (u *MessageUnreadCount) FromData(buff []byte) bool {
 	return true
 }
 
+func (u *MessageUnreadCount) ToJSON() interface{} {
+	obj := make(map[string]interface{})
+	obj["count"] = u.count
+	return obj
+}
+
+func (u *MessageUnreadCount) FromJSON(data interface{}) error {
+	r := NewJSONReader(data)
+	u.count = int32(r.Int("count", 32))
+	return r.Error()
+}
+
 type SystemMessage struct {
 	notification string
 }
@@ -1210,6 +1998,18 @@ func (sys *SystemMessage) FromData(buff []byte) bool {
 	return true
 }
 
+func (sys *SystemMessage) ToJSON() interface{} {
+	obj := make(map[string]interface{})
+	obj["notification"] = sys.notification
+	return obj
+}
+
+func (sys *SystemMessage) FromJSON(data interface{}) error {
+	r := NewJSONReader(data)
+	sys.notification = r.String("notification")
+	return r.Error()
+}
+
 type CustomerServiceMessage struct {
 	customer_id int64 //普通用户id
 	sender      int64
@@ -1252,6 +2052,26 @@ func (cs *CustomerServiceMessage) FromData(buff []byte) bool {
 	return true
 }
 
+func (cs *CustomerServiceMessage) ToJSON() interface{} {
+	obj := make(map[string]interface{})
+	obj["customer_id"] = cs.customer_id
+	obj["sender"] = cs.sender
+	obj["receiver"] = cs.receiver
+	obj["timestamp"] = cs.timestamp
+	obj["content"] = cs.content
+	return obj
+}
+
+func (cs *CustomerServiceMessage) FromJSON(data interface{}) error {
+	r := NewJSONReader(data)
+	cs.customer_id = r.Int("customer_id", 64)
+	cs.sender = r.Int("sender", 64)
+	cs.receiver = r.Int("receiver", 64)
+	cs.timestamp = int32(r.Int("timestamp", 32))
+	cs.content = r.String("content")
+	return r.Error()
+}
+
 type GroupNotification struct {
 	notification string
 }
@@ -1265,6 +2085,18 @@ func (notification *GroupNotification) FromData(buff []byte) bool {
 	return true
 }
 
+func (notification *GroupNotification) ToJSON() interface{} {
+	obj := make(map[string]interface{})
+	obj["notification"] = notification.notification
+	return obj
+}
+
+func (notification *GroupNotification) FromJSON(data interface{}) error {
+	r := NewJSONReader(data)
+	notification.notification = r.String("notification")
+	return r.Error()
+}
+
 type Room int64
 
 func (room *Room) ToData() []byte {
@@ -1283,6 +2115,20 @@ func (room *Room) FromData(buff []byte) bool {
 	return true
 }
 
+//消息体是一个数字
+func (room *Room) ToJSON() interface{} {
+	return int64(*room)
+}
+
+func (room *Room) FromJSON(data interface{}) error {
+	n, err := ParseJSONInt(data, 64)
+	if err != nil {
+		return err
+	}
+	*room = Room(n)
+	return nil
+}
+
 func (room *Room) RoomID() int64 {
 	return int64(*room)
 }
@@ -1314,6 +2160,20 @@ func (state *MessageOnlineState) FromData(buff []byte) bool {
 	return true
 }
 
+func (state *MessageOnlineState) ToJSON() interface{} {
+	obj := make(map[string]interface{})
+	obj["sender"] = state.sender
+	obj["online"] = state.online
+	return obj
+}
+
+func (state *MessageOnlineState) FromJSON(data interface{}) error {
+	r := NewJSONReader(data)
+	state.sender = r.Int("sender", 64)
+	state.online = int32(r.Int("online", 32))
+	return r.Error()
+}
+
 type MessageSubscribeState struct {
 	uids []int64
 }
@@ -1333,6 +2193,18 @@ func (sub *MessageSubscribeState) FromData(buff []byte) bool {
 	return true
 }
 
+func (sub *MessageSubscribeState) ToJSON() interface{} {
+	obj := make(map[string]interface{})
+	obj["uids"] = JSONInt64s(sub.uids)
+	return obj
+}
+
+func (sub *MessageSubscribeState) FromJSON(data interface{}) error {
+	r := NewJSONReader(data)
+	sub.uids = r.Int64s("uids")
+	return r.Error()
+}
+
 type VOIPControl struct {
 	sender   int64
 	receiver int64
@@ -1360,6 +2232,22 @@ func (ctl *VOIPControl) FromData(buff []byte) bool {
 	return true
 }
 
+func (ctl *VOIPControl) ToJSON() interface{} {
+	obj := make(map[string]interface{})
+	obj["sender"] = ctl.sender
+	obj["receiver"] = ctl.receiver
+	obj["content"] = base64.StdEncoding.EncodeToString(ctl.content)
+	return obj
+}
+
+func (ctl *VOIPControl) FromJSON(data interface{}) error {
+	r := NewJSONReader(data)
+	ctl.sender = r.Int("sender", 64)
+	ctl.receiver = r.Int("receiver", 64)
+	ctl.content = r.Bytes("content")
+	return r.Error()
+}
+
 type AppUserID struct {
 	appid int64
 	uid   int64
@@ -1460,7 +2348,7 @@ func (amsg *AppMessage) ToData() []byte {
 	mbuffer := new(bytes.Buffer)
 	WriteMessage(mbuffer, amsg.msg)
 	msg_buf := mbuffer.Bytes()
-	var l int16 = int16(len(msg_buf))
+	var l int32 = int32(len(msg_buf))
 	binary.Write(buffer, binary.BigEndian, l)
 	buffer.Write(msg_buf)
 
@@ -1469,7 +2357,7 @@ func (amsg *AppMessage) ToData() []byte {
 }
 
 func (amsg *AppMessage) FromData(buff []byte) bool {
-	if len(buff) < 34 {
+	if len(buff) < 36 {
 		return false
 	}
 
@@ -1479,9 +2367,9 @@ func (amsg *AppMessage) FromData(buff []byte) bool {
 	binary.Read(buffer, binary.BigEndian, &amsg.msgid)
 	binary.Read(buffer, binary.BigEndian, &amsg.device_id)
 
-	var l int16
+	var l int32
 	binary.Read(buffer, binary.BigEndian, &l)
-	if int(l) > buffer.Len() {
+	if l < 0 || int(l) > buffer.Len() {
 		return false
 	}
 
@@ -1490,7 +2378,80 @@ func (amsg *AppMessage) FromData(buff []byte) bool {
 
 	mbuffer := bytes.NewBuffer(msg_buf)
 	//recusive
-	msg := ReceiveMessage(mbuffer)
+	msg := ReceiveServerMessage(mbuffer)
+	if msg == nil {
+		return false
+	}
+	amsg.msg = msg
+
+	return true
+}
+
+//im服务器收到后展开成每个接收者的AppMessage
+type MultiAppMessage struct {
+	appid     int64
+	receivers []int64
+	msgid     int64
+	device_id int64
+	msg       *Message
+}
+
+func (amsg *MultiAppMessage) ToData() []byte {
+	if amsg.msg == nil {
+		return nil
+	}
+
+	buffer := new(bytes.Buffer)
+	binary.Write(buffer, binary.BigEndian, amsg.appid)
+	binary.Write(buffer, binary.BigEndian, amsg.msgid)
+	binary.Write(buffer, binary.BigEndian, amsg.device_id)
+	var count int32 = int32(len(amsg.receivers))
+	binary.Write(buffer, binary.BigEndian, count)
+	for _, receiver := range amsg.receivers {
+		binary.Write(buffer, binary.BigEndian, receiver)
+	}
+	mbuffer := new(bytes.Buffer)
+	WriteMessage(mbuffer, amsg.msg)
+	msg_buf := mbuffer.Bytes()
+	var l int32 = int32(len(msg_buf))
+	binary.Write(buffer, binary.BigEndian, l)
+	buffer.Write(msg_buf)
+
+	buf := buffer.Bytes()
+	return buf
+}
+
+func (amsg *MultiAppMessage) FromData(buff []byte) bool {
+	if len(buff) < 32 {
+		return false
+	}
+
+	buffer := bytes.NewBuffer(buff)
+	binary.Read(buffer, binary.BigEndian, &amsg.appid)
+	binary.Read(buffer, binary.BigEndian, &amsg.msgid)
+	binary.Read(buffer, binary.BigEndian, &amsg.device_id)
+
+	var count int32
+	binary.Read(buffer, binary.BigEndian, &count)
+	if count < 0 || int(count) * 8 + 4 > buffer.Len() {
+		return false
+	}
+	amsg.receivers = make([]int64, count)
+	for i := 0; i < int(count); i++ {
+		binary.Read(buffer, binary.BigEndian, &amsg.receivers[i])
+	}
+
+	var l int32
+	binary.Read(buffer, binary.BigEndian, &l)
+	if l < 0 || int(l) > buffer.Len() {
+		return false
+	}
+
+	msg_buf := make([]byte, l)
+	buffer.Read(msg_buf)
+
+	mbuffer := bytes.NewBuffer(msg_buf)
+	msg := ReceiveServerMessage(mbuffer)
 	if msg == nil {
 		return false
 	}
@@ -1499,6 +2460,64 @@ func (amsg *AppMessage) FromData(buff []byte) bool {
 	return true
 }
 
+//msg是MSG_PUBLISH或者MSG_PUBLISH_MULTI
+type RouteForward struct {
+	server_id string
+	msg       *Message
+}
+
+func (forward *RouteForward) ToData() []byte {
+	if forward.msg == nil || len(forward.server_id) > 127 {
+		return nil
+	}
+
+	buffer := new(bytes.Buffer)
+	var l int8 = int8(len(forward.server_id))
+	binary.Write(buffer, binary.BigEndian, l)
+	buffer.Write([]byte(forward.server_id))
+
+	mbuffer := new(bytes.Buffer)
+	WriteMessage(mbuffer, forward.msg)
+	msg_buf := mbuffer.Bytes()
+	var ml int32 = int32(len(msg_buf))
+	binary.Write(buffer, binary.BigEndian, ml)
+	buffer.Write(msg_buf)
+
+	buf := buffer.Bytes()
+	return buf
+}
+
+func (forward *RouteForward) FromData(buff []byte) bool {
+	if len(buff) < 5 {
+		return false
+	}
+
+	buffer := bytes.NewBuffer(buff)
+	var l int8
+	binary.Read(buffer, binary.BigEndian, &l)
+	if l < 0 || int(l) + 4 > buffer.Len() {
+		return false
+	}
+	server_id := make([]byte, l)
+	buffer.Read(server_id)
+
+	var ml int32
+	binary.Read(buffer, binary.BigEndian, &ml)
+	if ml < 0 || int(ml) > buffer.Len() {
+		return false
+	}
+	msg_buf := make([]byte, ml)
+	buffer.Read(msg_buf)
+
+	msg := ReceiveServerMessage(bytes.NewBuffer(msg_buf))
+	if msg == nil {
+		return false
+	}
+	forward.server_id = string(server_id)
+	forward.msg = msg
+	return true
+}
+
 //邀请好友
 type ContactInvite struct {
 	sender int64
@@ -1528,6 +2547,22 @@ func (contactInvite *ContactInvite) FromData(buff []byte) bool {
 	return true
 }
 
+func (contactInvite *ContactInvite) ToJSON() interface{} {
+	obj := make(map[string]interface{})
+	obj["sender"] = contactInvite.sender
+	obj["receiver"] = contactInvite.receiver
+	obj["reason"] = contactInvite.reason
+	return obj
+}
+
+func (contactInvite *ContactInvite) FromJSON(data interface{}) error {
+	r := NewJSONReader(data)
+	contactInvite.sender = r.Int("sender", 64)
+	contactInvite.receiver = r.Int("receiver", 64)
+	contactInvite.reason = r.String("reason")
+	return r.Error()
+}
+
 type ContactInviteResp struct {
 	status int32
 	sender int64
@@ -1556,6 +2591,22 @@ func (contactInviteResp *ContactInviteResp) FromData(buff []byte) bool {
 	return true
 }
 
+func (contactInviteResp *ContactInviteResp) ToJSON() interface{} {
+	obj := make(map[string]interface{})
+	obj["status"] = contactInviteResp.status
+	obj["sender"] = contactInviteResp.sender
+	obj["receiver"] = contactInviteResp.receiver
+	return obj
+}
+
+func (contactInviteResp *ContactInviteResp) FromJSON(data interface{}) error {
+	r := NewJSONReader(data)
+	contactInviteResp.status = int32(r.Int("status", 32))
+	contactInviteResp.sender = r.Int("sender", 64)
+	contactInviteResp.receiver = r.Int("receiver", 64)
+	return r.Error()
+}
+
 //接受好友请求
 type ContactAccept struct {
 	sender int64
@@ -1582,6 +2633,20 @@ func (contactAccept *ContactAccept) FromData(buff []byte) bool {
 	return true
 }
 
+func (contactAccept *ContactAccept) ToJSON() interface{} {
+	obj := make(map[string]interface{})
+	obj["sender"] = contactAccept.sender
+	obj["receiver"] = contactAccept.receiver
+	return obj
+}
+
+func (contactAccept *ContactAccept) FromJSON(data interface{}) error {
+	r := NewJSONReader(data)
+	contactAccept.sender = r.Int("sender", 64)
+	contactAccept.receiver = r.Int("receiver", 64)
+	return r.Error()
+}
+
 type ContactAcceptResp struct {
 	status int32
 	sender int64
@@ -1610,6 +2675,22 @@ func (contactAcceptResp *ContactAcceptResp) FromData(buff []byte) bool {
 	return true
 }
 
+func (contactAcceptResp *ContactAcceptResp) ToJSON() interface{} {
+	obj := make(map[string]interface{})
+	obj["status"] = contactAcceptResp.status
+	obj["sender"] = contactAcceptResp.sender
+	obj["receiver"] = contactAcceptResp.receiver
+	return obj
+}
+
+func (contactAcceptResp *ContactAcceptResp) FromJSON(data interface{}) error {
+	r := NewJSONReader(data)
+	contactAcceptResp.status = int32(r.Int("status", 32))
+	contactAcceptResp.sender = r.Int("sender", 64)
+	contactAcceptResp.receiver = r.Int("receiver", 64)
+	return r.Error()
+}
+
 //拒绝好友请求
 type ContactRefuse struct {
 	sender int64
@@ -1636,6 +2717,20 @@ func (contactRefuse *ContactRefuse) FromData(buff []byte) bool {
 	return true
 }
 
+func (contactRefuse *ContactRefuse) ToJSON() interface{} {
+	obj := make(map[string]interface{})
+	obj["sender"] = contactRefuse.sender
+	obj["receiver"] = contactRefuse.receiver
+	return obj
+}
+
+func (contactRefuse *ContactRefuse) FromJSON(data interface{}) error {
+	r := NewJSONReader(data)
+	contactRefuse.sender = r.Int("sender", 64)
+	contactRefuse.receiver = r.Int("receiver", 64)
+	return r.Error()
+}
+
 type ContactRefuseResp struct {
 	status int32
 	sender int64
@@ -1664,6 +2759,22 @@ func (contactRefuseResp *ContactRefuseResp) FromData(buff []byte) bool {
 	return true
 }
 
+func (contactRefuseResp *ContactRefuseResp) ToJSON() interface{} {
+	obj := make(map[string]interface{})
+	obj["status"] = contactRefuseResp.status
+	obj["sender"] = contactRefuseResp.sender
+	obj["receiver"] = contactRefuseResp.receiver
+	return obj
+}
+
+func (contactRefuseResp *ContactRefuseResp) FromJSON(data interface{}) error {
+	r := NewJSONReader(data)
+	contactRefuseResp.status = int32(r.Int("status", 32))
+	contactRefuseResp.sender = r.Int("sender", 64)
+	contactRefuseResp.receiver = r.Int("receiver", 64)
+	return r.Error()
+}
+
 //删除好友
 type ContactDel struct {
 	sender int64
@@ -1690,6 +2801,20 @@ func (contactDel *ContactDel) FromData(buff []byte) bool {
 	return true
 }
 
+func (contactDel *ContactDel) ToJSON() interface{} {
+	obj := make(map[string]interface{})
+	obj["sender"] = contactDel.sender
+	obj["receiver"] = contactDel.receiver
+	return obj
+}
+
+func (contactDel *ContactDel) FromJSON(data interface{}) error {
+	r := NewJSONReader(data)
+	contactDel.sender = r.Int("sender", 64)
+	contactDel.receiver = r.Int("receiver", 64)
+	return r.Error()
+}
+
 type ContactDelResp struct {
 	status int32
 	sender int64
@@ -1718,6 +2843,22 @@ func (contactDelResp *ContactDelResp) FromData(buff []byte) bool {
 	return true
 }
 
+func (contactDelResp *ContactDelResp) ToJSON() interface{} {
+	obj := make(map[string]interface{})
+	obj["status"] = contactDelResp.status
+	obj["sender"] = contactDelResp.sender
+	obj["receiver"] = contactDelResp.receiver
+	return obj
+}
+
+func (contactDelResp *ContactDelResp) FromJSON(data interface{}) error {
+	r := NewJSONReader(data)
+	contactDelResp.status = int32(r.Int("status", 32))
+	contactDelResp.sender = r.Int("sender", 64)
+	contactDelResp.receiver = r.Int("receiver", 64)
+	return r.Error()
+}
+
 type ContactBlack struct {
 	sender int64
 	receiver int64
@@ -1743,6 +2884,20 @@ func (contactBlack *ContactBlack) FromData(buff []byte) bool {
 	return true
 }
 
+func (contactBlack *ContactBlack) ToJSON() interface{} {
+	obj := make(map[string]interface{})
+	obj["sender"] = contactBlack.sender
+	obj["receiver"] = contactBlack.receiver
+	return obj
+}
+
+func (contactBlack *ContactBlack) FromJSON(data interface{}) error {
+	r := NewJSONReader(data)
+	contactBlack.sender = r.Int("sender", 64)
+	contactBlack.receiver = r.Int("receiver", 64)
+	return r.Error()
+}
+
 type ContactBlackResp struct {
 	status int32
 	sender int64
@@ -1771,6 +2926,22 @@ func (contactBlackResp *ContactBlackResp) FromData(buff []byte) bool {
 	return true
 }
 
+func (contactBlackResp *ContactBlackResp) ToJSON() interface{} {
+	obj := make(map[string]interface{})
+	obj["status"] = contactBlackResp.status
+	obj["sender"] = contactBlackResp.sender
+	obj["receiver"] = contactBlackResp.receiver
+	return obj
+}
+
+func (contactBlackResp *ContactBlackResp) FromJSON(data interface{}) error {
+	r := NewJSONReader(data)
+	contactBlackResp.status = int32(r.Int("status", 32))
+	contactBlackResp.sender = r.Int("sender", 64)
+	contactBlackResp.receiver = r.Int("receiver", 64)
+	return r.Error()
+}
+
 type ContactUnBlack struct {
 	sender int64
 	receiver int64
@@ -1796,6 +2967,20 @@ func (contactUnBlack *ContactUnBlack) FromData(buff []byte) bool {
 	return true
 }
 
+func (contactUnBlack *ContactUnBlack) ToJSON() interface{} {
+	obj := make(map[string]interface{})
+	obj["sender"] = contactUnBlack.sender
+	obj["receiver"] = contactUnBlack.receiver
+	return obj
+}
+
+func (contactUnBlack *ContactUnBlack) FromJSON(data interface{}) error {
+	r := NewJSONReader(data)
+	contactUnBlack.sender = r.Int("sender", 64)
+	contactUnBlack.receiver = r.Int("receiver", 64)
+	return r.Error()
+}
+
 type ContactUnBlackResp struct {
 	status int32
 	sender int64
@@ -1824,6 +3009,22 @@ func (contactUnBlackResp *ContactUnBlackResp) FromData(buff []byte) bool {
 	return true
 }
 
+func (contactUnBlackResp *ContactUnBlackResp) ToJSON() interface{} {
+	obj := make(map[string]interface{})
+	obj["status"] = contactUnBlackResp.status
+	obj["sender"] = contactUnBlackResp.sender
+	obj["receiver"] = contactUnBlackResp.receiver
+	return obj
+}
+
+func (contactUnBlackResp *ContactUnBlackResp) FromJSON(data interface{}) error {
+	r := NewJSONReader(data)
+	contactUnBlackResp.status = int32(r.Int("status", 32))
+	contactUnBlackResp.sender = r.Int("sender", 64)
+	contactUnBlackResp.receiver = r.Int("receiver", 64)
+	return r.Error()
+}
+
 type GroupCreate struct {
 	is_private int32
 	is_allow_invite int32
@@ -1886,6 +3087,26 @@ func (groupCreate *GroupCreate) FromData(buff []byte) bool {
 	return true
 }
 
+func (groupCreate *GroupCreate) ToJSON() interface{} {
+	obj := make(map[string]interface{})
+	obj["is_private"] = groupCreate.is_private
+	obj["is_allow_invite"] = groupCreate.is_allow_invite
+	obj["members"] = JSONInt64s(groupCreate.members)
+	obj["title"] = groupCreate.title
+	obj["desc"] = groupCreate.desc
+	return obj
+}
+
+func (groupCreate *GroupCreate) FromJSON(data interface{}) error {
+	r := NewJSONReader(data)
+	groupCreate.is_private = int32(r.Int("is_private", 32))
+	groupCreate.is_allow_invite = int32(r.Int("is_allow_invite", 32))
+	groupCreate.members = r.Int64s("members")
+	groupCreate.title = r.String("title")
+	groupCreate.desc = r.String("desc")
+	return r.Error()
+}
+
 type GroupCreateResp struct {
 	status int32
 	gid int64
@@ -1912,6 +3133,20 @@ func (groupCreateResp *GroupCreateResp) FromData(buff []byte) bool {
 	return true
 }
 
+func (groupCreateResp *GroupCreateResp) ToJSON() interface{} {
+	obj := make(map[string]interface{})
+	obj["status"] = groupCreateResp.status
+	obj["gid"] = groupCreateResp.gid
+	return obj
+}
+
+func (groupCreateResp *GroupCreateResp) FromJSON(data interface{}) error {
+	r := NewJSONReader(data)
+	groupCreateResp.status = int32(r.Int("status", 32))
+	groupCreateResp.gid = r.Int("gid", 64)
+	return r.Error()
+}
+
 type GroupSelfJoin struct {
 	gid int64
 }
@@ -1935,6 +3170,18 @@ func (groupSelfJoin *GroupSelfJoin) FromData(buff []byte) bool {
 	return true
 }
 
+func (groupSelfJoin *GroupSelfJoin) ToJSON() interface{} {
+	obj := make(map[string]interface{})
+	obj["gid"] = groupSelfJoin.gid
+	return obj
+}
+
+func (groupSelfJoin *GroupSelfJoin) FromJSON(data interface{}) error {
+	r := NewJSONReader(data)
+	groupSelfJoin.gid = r.Int("gid", 64)
+	return r.Error()
+}
+
 type GroupInviteJoin struct {
 	gid int64
 	members []int64
@@ -1977,6 +3224,20 @@ func (groupInviteJoin *GroupInviteJoin) FromData(buff []byte) bool {
 	return true
 }
 
+func (groupInviteJoin *GroupInviteJoin) ToJSON() interface{} {
+	obj := make(map[string]interface{})
+	obj["gid"] = groupInviteJoin.gid
+	obj["members"] = JSONInt64s(groupInviteJoin.members)
+	return obj
+}
+
+func (groupInviteJoin *GroupInviteJoin) FromJSON(data interface{}) error {
+	r := NewJSONReader(data)
+	groupInviteJoin.gid = r.Int("gid", 64)
+	groupInviteJoin.members = r.Int64s("members")
+	return r.Error()
+}
+
 type SimpleResp struct {
 	status int32
 }
@@ -2002,6 +3263,18 @@ func (simpleResp *SimpleResp) FromData(buff []byte) bool {
 	return true
 }
 
+func (simpleResp *SimpleResp) ToJSON() interface{} {
+	obj := make(map[string]interface{})
+	obj["status"] = simpleResp.status
+	return obj
+}
+
+func (simpleResp *SimpleResp) FromJSON(data interface{}) error {
+	r := NewJSONReader(data)
+	simpleResp.status = int32(r.Int("status", 32))
+	return r.Error()
+}
+
 type GroupQuit struct {
 	gid int64
 }
@@ -2025,6 +3298,18 @@ func (groupQuit *GroupQuit) FromData(buff []byte) bool {
 	return true
 }
 
+func (groupQuit *GroupQuit) ToJSON() interface{} {
+	obj := make(map[string]interface{})
+	obj["gid"] = groupQuit.gid
+	return obj
+}
+
+func (groupQuit *GroupQuit) FromJSON(data interface{}) error {
+	r := NewJSONReader(data)
+	groupQuit.gid = r.Int("gid", 64)
+	return r.Error()
+}
+
 type GroupDel struct {
 	gid int64
 }
@@ -2048,6 +3333,18 @@ func (groupDel *GroupDel) FromData(buff []byte) bool {
 	return true
 }
 
+func (groupDel *GroupDel) ToJSON() interface{} {
+	obj := make(map[string]interface{})
+	obj["gid"] = groupDel.gid
+	return obj
+}
+
+func (groupDel *GroupDel) FromJSON(data interface{}) error {
+	r := NewJSONReader(data)
+	groupDel.gid = r.Int("gid", 64)
+	return r.Error()
+}
+
 type GroupRemove struct {
 	gid int64
 	uid int64
@@ -2074,6 +3371,20 @@ func (groupRemove *GroupRemove) FromData(buff []byte) bool {
 	return true
 }
 
+func (groupRemove *GroupRemove) ToJSON() interface{} {
+	obj := make(map[string]interface{})
+	obj["gid"] = groupRemove.gid
+	obj["uid"] = groupRemove.uid
+	return obj
+}
+
+func (groupRemove *GroupRemove) FromJSON(data interface{}) error {
+	r := NewJSONReader(data)
+	groupRemove.gid = r.Int("gid", 64)
+	groupRemove.uid = r.Int("uid", 64)
+	return r.Error()
+}
+
 func WriteHeader(len int32, seq int32, cmd int32, version byte, buffer io.Writer) {
 	binary.Write(buffer, binary.BigEndian, len)
 	binary.Write(buffer, binary.BigEndian, seq)
@@ -2094,6 +3405,24 @@ func ReadHeader(buff []byte) (int, int, int, int) {
 	return int(length), int(seq), int(cmd), int(version)
 }
 
+func ReadHeaderFlag(buff []byte) int {
+	return int(buff[13])
+}
+
+//解压后的长度同样不能超过limit
+func InflateBody(buff []byte, limit int) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(buff))
+	defer r.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(r, int64(limit)))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) >= limit {
+		return nil, errors.New("inflated body too large")
+	}
+	return body, nil
+}
+
 func WriteMessage(w *bytes.Buffer, msg *Message) {
 	body := msg.ToData()
 	WriteHeader(int32(len(body)), int32(msg.seq), int32(msg.cmd), byte(msg.version), w)
@@ -2116,7 +3445,17 @@ func SendMessage(conn io.Writer, msg *Message) error {
 	return nil
 }
 
+//客户端消息
 func ReceiveMessage(conn io.Reader) *Message {
+	return ReceiveLimitMessage(conn, 32*1024)
+}
+
+//服务器之间的消息, 例如批量的离线消息
+func ReceiveServerMessage(conn io.Reader) *Message {
+	return ReceiveLimitMessage(conn, MAX_SERVER_MESSAGE_SIZE)
+}
+
+func ReceiveLimitMessage(conn io.Reader, limit int) *Message {
 	buff := make([]byte, 16)
 	_, err := io.ReadFull(conn, buff)
 	if err != nil {
@@ -2126,16 +3465,24 @@ func ReceiveMessage(conn io.Reader) *Message {
 
 	length, seq, cmd, version := ReadHeader(buff)
 	log.Infof("ReceiveMessage: length=%d, seq=%d, cmd=%d, version=%d", length, seq, cmd, version)
-	if length < 0 || length >= 32*1024 {
+	if length < 0 || int(length) >= limit {
 		log.Info("invalid len:", length)
 		return nil
 	}
+	flag := ReadHeaderFlag(buff)
 	buff = make([]byte, length)
 	_, err = io.ReadFull(conn, buff)
 	if err != nil {
 		log.Info("sock read error:", err)
 		return nil
 	}
+	if flag & MESSAGE_FLAG_COMPRESSED != 0 {
+		buff, err = InflateBody(buff, limit)
+		if err != nil {
+			log.Info("inflate error:", err)
+			return nil
+		}
+	}
 
 	message := new(Message)
 	message.cmd = cmd
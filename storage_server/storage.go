@@ -20,6 +20,8 @@
 package main
 
 import "fmt"
+import "encoding/base64"
+import log "github.com/golang/glog"
 
 import ots2 "github.com/GiterLab/goots"
 
@@ -39,3 +41,23 @@ func NewStorage(ots_endpoint string, ots_accessid string, ots_accesskey string,
 	gs := NewGroupStorage(ots2_client)
 	return &Storage{ots2_client, ps, gs}
 }
+
+//消息体以二进制格式保存, base64编码后写入ots的字符串列
+func EncodeMessageBody(msg *Message) string {
+	return base64.StdEncoding.EncodeToString(msg.ToData())
+}
+
+//旧版本以json格式保存的消息无法解码, 跳过并记录日志
+func DecodeMessageBody(cmd int, seq int, version int, body string) *Message {
+	buff, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		log.Warningf("decode message body cmd:%d seq:%d error:%s", cmd, seq, err)
+		return nil
+	}
+	msg := &Message{cmd:cmd, seq:seq, version:version}
+	if !msg.FromData(buff) {
+		log.Warningf("invalid message body cmd:%d seq:%d version:%d len:%d", cmd, seq, version, len(buff))
+		return nil
+	}
+	return msg
+}
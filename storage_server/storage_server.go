@@ -33,11 +33,13 @@ import "os/signal"
 import "syscall"
 import "github.com/garyburd/redigo/redis"
 import "github.com/zheng-ji/goSnowFlake"
+import "im_service/common"
 
 var storage *Storage
 var config *StorageConfig
 var mutex sync.Mutex
 var redis_pool *redis.Pool
+var cluster_certs *common.CertReloader
 var iw *goSnowFlake.IdWorker
 var route_clients map[string]*Client
 
@@ -64,13 +66,13 @@ func GetUserChan(uid int64) chan func() {
 }
 
 type Client struct {
-	conn *net.TCPConn
+	conn net.Conn
 
 	serverId string
 	wt        chan *Message
 }
 
-func NewClient(conn *net.TCPConn) *Client {
+func NewClient(conn net.Conn) *Client {
 	client := new(Client)
 	client.conn = conn
 
@@ -98,6 +100,9 @@ func (client *Client) Write() {
 			break
 		}
 		SendMessage(client.conn, msg)
+		if amsg, ok := msg.body.(*AppMessage); ok {
+			metric_messages_out.WithLabelValues(CommandName(amsg.msg.cmd)).Inc()
+		}
 	}
 }
 
@@ -156,9 +161,11 @@ func (client *Client) HandleSaveAndEnqueueGroup(sae *SAEMessage) {
 		t <- msgid
 	}
 
+	begin := time.Now()
 	c := GetGroupChan(gid)
 	c <- f
 	msgid := <-t
+	ObserveSave(gid, "group", begin)
 	log.Infoln(msgid)	
 	
 	result := &MessageResult{}
@@ -203,9 +210,11 @@ func (client *Client) HandleSaveAndEnqueue(sae *SAEMessage) {
 		t <- msgid
 	}
 
+	begin := time.Now()
 	c := GetUserChan(uid)
 	c <- f
 	msgid := <-t
+	ObserveSave(uid, "peer", begin)
 	log.Infoln(msgid)
 	
 	result := &MessageResult{}
@@ -235,11 +244,11 @@ func (client *Client) WriteEMessage(emsg *EMessage) []byte {
 }
 
 func (client *Client) HandleLoadOffline(id *LoadOffline) {
-	messages := storage.LoadOfflineMessage(id.appid, id.uid, id.device_id)
+	messages := storage.LoadOfflineMessage(id.appid, id.uid, id.device_id, id.last_msgid)
 	result := &MessageResult{status: 0}
 	buffer := new(bytes.Buffer)
 
-	var count int16 = 0
+	var count int32 = 0
 	for _, emsg := range messages {
 		if emsg.msg.cmd == MSG_IM ||
 			emsg.msg.cmd == MSG_GROUP_IM {
@@ -282,7 +291,7 @@ func (client *Client) HandleLoadOffline(id *LoadOffline) {
 		}
 
 		ebuf := client.WriteEMessage(emsg)
-		var size int16 = int16(len(ebuf))
+		var size int32 = int32(len(ebuf))
 		binary.Write(buffer, binary.BigEndian, size)
 		buffer.Write(ebuf)
 	}
@@ -296,7 +305,7 @@ func (client *Client) HandleLoadGroupOffline(lh *LoadGroupOffline) {
 	result := &MessageResult{status: 0}
 	buffer := new(bytes.Buffer)
 
-	var count int16 = 0
+	var count int32 = 0
 	for _, emsg := range messages {
 		if emsg.msg.cmd == MSG_GROUP_IM {
 			im := emsg.msg.body.(*IMMessage)
@@ -315,7 +324,7 @@ func (client *Client) HandleLoadGroupOffline(lh *LoadGroupOffline) {
 			}
 		}
 		ebuf := client.WriteEMessage(emsg)
-		var size int16 = int16(len(ebuf))
+		var size int32 = int32(len(ebuf))
 		binary.Write(buffer, binary.BigEndian, size)
 		buffer.Write(ebuf)
 	}
@@ -327,6 +336,7 @@ func (client *Client) HandleLoadGroupOffline(lh *LoadGroupOffline) {
 //指令处理
 func (client *Client) HandleMessage(msg *Message) {
 	log.Info("msg cmd:", Command(msg.cmd))
+	metric_messages_in.WithLabelValues(CommandName(msg.cmd)).Inc()
 	switch msg.cmd {
 	case MSG_SERVER_REGISTER:
 		client.HandleRegister(msg.body.(*ServerID))
@@ -355,7 +365,7 @@ func (client *Client) Run() {
 }
 
 func (client *Client) read() *Message {
-	return ReceiveMessage(client.conn)
+	return ReceiveServerMessage(client.conn)
 }
 
 func (client *Client) send(msg *Message) {
@@ -369,8 +379,22 @@ func (client *Client) close() {
 func handle_client(conn *net.TCPConn) {
 	conn.SetKeepAlive(true)
 	conn.SetKeepAlivePeriod(time.Duration(10 * 60 * time.Second))
-	client := NewClient(conn)
-	client.Run()
+	if cluster_certs == nil {
+		client := NewClient(conn)
+		client.Run()
+		return
+	}
+
+	//握手不阻塞accept
+	go func() {
+		tconn, err := common.ServerHandshake(conn, cluster_certs.ServerConfig())
+		if err != nil {
+			log.Warningf("tls handshake with %s error:%s", conn.RemoteAddr(), err)
+			return
+		}
+		client := NewClient(tconn)
+		client.Run()
+	}()
 }
 
 func Listen(f func(*net.TCPConn), listen_addr string) {
@@ -461,6 +485,8 @@ func main() {
 	log.Infof("listen:%s\n", config.listen)
 	//redis连接池
 	redis_pool = NewRedisPool(config.redis_address, config.redis_password)
+	cluster_certs = common.NewClusterCertReloader(config.cluster_cert_file,
+		config.cluster_key_file, config.cluster_ca_file)
 	for i := 0; i < GROUP_C_COUNT; i++ {
 		go GroupLoop(group_c[i])
 	}
@@ -478,6 +504,10 @@ func main() {
 
 	go waitSignal()
 
+	if len(config.http_listen_address) > 0 {
+		go StartHttpServer(config.http_listen_address)
+	}
+
 	//主机监听
 	ListenClient()
 }
\ No newline at end of file